@@ -0,0 +1,212 @@
+package hnsw
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// AddBatch inserts vectors using the given number of worker goroutines and
+// returns the assigned node IDs in input order. workers <= 0 means
+// runtime.GOMAXPROCS(0). Every vector is validated before any is inserted, so
+// on error the index is left unchanged, except for ErrUnreachable.
+//
+// Every inserted node keeps at least one in-edge when it is linked, but
+// concurrent inserts pruning the same neighbours can still cut it off, so
+// inserted nodes a search from the entry point cannot reach at level 0 are
+// relinked afterwards, for a few rounds. Nodes that are still unreachable are
+// reported with an error wrapping ErrUnreachable; they are inserted and ids is
+// valid, but searches may miss them until they are updated.
+func (h *HNSWIndex) AddBatch(vectors [][]float32, workers int) ([]int, error) {
+	if h.readOnly {
+		return nil, ErrReadOnly
+	}
+	for i, vector := range vectors {
+		if len(vector) != h.dimension {
+			return nil, fmt.Errorf("%w: vector %d has dimension %d, expected %d",
+				ErrDimensionMismatch, i, len(vector), h.dimension)
+		}
+	}
+	if len(vectors) == 0 {
+		return []int{}, nil
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// 层级按输入顺序生成，相同 seed 下与逐个 Add 得到的层级一致
+	levels := make([]int, len(vectors))
+	for i := range levels {
+		levels[i] = h.randomLevel()
+	}
+
+	// 一次性分配 ID 并发布所有节点。新节点在被连接之前不可达，
+	// 所以提前发布不会影响并发的搜索
+	h.globalLock.Lock()
+	start := len(h.nodes)
+	ids := make([]int, len(vectors))
+	newNodes := make([]*Node, len(vectors))
+	for i, vector := range vectors {
+		ids[i] = start + i
+		newNodes[i] = NewNode(ids[i], h.unitVector(vector), levels[i])
+		h.encode(newNodes[i])
+		h.nodes = append(h.nodes, newNodes[i])
+	}
+	h.publishNodes()
+
+	// 没有入口点时，第一个节点直接成为入口点
+	pending := newNodes
+	if h.entryPoint == -1 {
+		h.entryPoint = int32(ids[0])
+		h.maxLevel = int32(levels[0])
+		pending = pending[1:]
+	}
+	h.globalLock.Unlock()
+
+	workers = min(workers, len(pending))
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(pending) {
+					return
+				}
+				h.insert(pending[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	unreachable := h.reconnectUnreachable(pending)
+
+	for _, node := range newNodes {
+		h.dropVector(node)
+	}
+
+	if len(unreachable) > 0 {
+		return ids, fmt.Errorf("%w: nodes %v", ErrUnreachable, unreachable)
+	}
+	return ids, nil
+}
+
+// reconnectUnreachable 重新连接第0层无法从入口点到达的节点，返回重试后仍不可达的节点。
+// 同时插入的两个相近节点彼此看不到对方，共同邻居剪枝时可能只保留其中一个，
+// 另一个就失去了所有入边。此时重新 relink，它会找到刚才看不到的节点并互相连接。
+// 遍历只持有读锁，每个节点的 relink 单独持有写锁，期间其他写操作可以穿插执行
+func (h *HNSWIndex) reconnectUnreachable(batch []*Node) []int {
+	for round := 0; ; round++ {
+		h.globalLock.RLock()
+		reached := h.reachable(int(h.entryPoint))
+		h.globalLock.RUnlock()
+
+		var unreachable []*Node
+		for _, node := range batch {
+			if !reached[node.ID()] && !node.IsDeleted() {
+				unreachable = append(unreachable, node)
+			}
+		}
+		// relink 后新的入边仍可能被剪掉，最多重试几轮
+		if len(unreachable) == 0 || round == reconnectRounds {
+			ids := make([]int, len(unreachable))
+			for i, node := range unreachable {
+				ids[i] = node.ID()
+			}
+			return ids
+		}
+
+		for _, node := range unreachable {
+			h.globalLock.Lock()
+			// 遍历之后节点可能已被并发删除
+			if !node.IsDeleted() {
+				h.relink(node)
+			}
+			h.globalLock.Unlock()
+		}
+	}
+}
+
+// reconnectRounds 是 reconnectUnreachable 最多重新连接的轮数
+const reconnectRounds = 3
+
+// reachable 从 ep 出发在第0层做广度优先遍历，返回每个节点是否可达。调用方持有 globalLock
+func (h *HNSWIndex) reachable(ep int) []bool {
+	reached := make([]bool, len(h.nodes))
+	if ep < 0 {
+		return reached
+	}
+
+	reached[ep] = true
+	queue := []int{ep}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, neighborID := range h.nodes[current].GetConnections(0) {
+			if neighborID >= 0 && neighborID < len(reached) && !reached[neighborID] {
+				reached[neighborID] = true
+				queue = append(queue, neighborID)
+			}
+		}
+	}
+	return reached
+}
+
+// SearchBatch runs Search for every query using the given number of worker
+// goroutines and returns the results in query order. Each worker reuses its
+// own visited set and heaps across queries. workers <= 0 means
+// runtime.GOMAXPROCS(0); ef == 0 means max(efConstruction, k).
+func (h *HNSWIndex) SearchBatch(queries [][]float32, k int, ef int, workers int) ([][]SearchResult, error) {
+	for i, query := range queries {
+		if len(query) != h.dimension {
+			return nil, fmt.Errorf("%w: query %d has dimension %d, expected %d",
+				ErrDimensionMismatch, i, len(query), h.dimension)
+		}
+	}
+
+	if ef == 0 {
+		ef = max(h.efConstruction, k)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	h.globalLock.RLock()
+	if err := h.checkSearchable(); err != nil {
+		h.globalLock.RUnlock()
+		return nil, err
+	}
+	ep := int(h.entryPoint)
+	maxLvl := int(h.maxLevel)
+	numNodes := len(h.nodes)
+	h.globalLock.RUnlock()
+
+	results := make([][]SearchResult, len(queries))
+	workers = min(workers, len(queries))
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scratch := getSearchScratch()
+			defer putSearchScratch(scratch)
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(queries) {
+					return
+				}
+				// search 本身不会失败，参数已经在上面检查过
+				results[i], _ = h.searchDistinct(queries[i], k, ef, ep, maxLvl, numNodes, nil, scratch)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
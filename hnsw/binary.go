@@ -0,0 +1,238 @@
+package hnsw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Name of the built-in binary quantizer, as recorded in saved indexes.
+const QuantizerBinary = "binary"
+
+// BinaryVector is a bit-packed binary vector: bit i is bit i%64 of word i/64.
+// HNSWIndex.AddBinary indexes it as a BinaryQuantizer code.
+type BinaryVector []uint64
+
+// NewBinaryVector returns an all-zero binary vector of dimension bits.
+func NewBinaryVector(dimension int) BinaryVector {
+	return make(BinaryVector, (dimension+63)/64)
+}
+
+// PackBinary packs vector into a binary vector with bit i set when vector[i]
+// is positive, so embeddings given as 0/1 or as -1/+1 values keep their bits.
+func PackBinary(vector []float32) BinaryVector {
+	v := NewBinaryVector(len(vector))
+	for i, x := range vector {
+		if x > 0 {
+			v.Set(i)
+		}
+	}
+	return v
+}
+
+// UnpackBinary expands the first dimension bits of v into a vector of 0 and 1
+// values, the form HNSWIndex accepts for the Hamming distance.
+func UnpackBinary(v BinaryVector, dimension int) []float32 {
+	vector := make([]float32, dimension)
+	for i := range vector {
+		if v.Bit(i) {
+			vector[i] = 1
+		}
+	}
+	return vector
+}
+
+// Bit reports whether bit i is set.
+func (v BinaryVector) Bit(i int) bool {
+	return v[i/64]&(1<<(i%64)) != 0
+}
+
+// Set sets bit i.
+func (v BinaryVector) Set(i int) {
+	v[i/64] |= 1 << (i % 64)
+}
+
+// HammingBits returns the number of bits in which a and b differ.
+func HammingBits(a, b BinaryVector) int {
+	if len(a) != len(b) {
+		panic("vector dimensions mismatch")
+	}
+	b = b[:len(a)]
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return distance
+}
+
+// AddBinary inserts the packed binary embedding v and returns its ID. The
+// index must have been created with QuantizedOnly, DistanceHamming and a
+// BinaryQuantizer whose thresholds are all zero, so its codes hold the bits of
+// v unchanged: the packed codes are all the index keeps, dimension/8 bytes per
+// vector in memory and on disk, and searches compare them by XOR and popcount.
+func (h *HNSWIndex) AddBinary(v BinaryVector) (int, error) {
+	if err := h.checkBinary(v); err != nil {
+		return -1, err
+	}
+	return h.Add(UnpackBinary(v, h.dimension))
+}
+
+// SearchBinary is Search for a packed binary query; see AddBinary for the
+// index it requires. The distances are Hamming distances.
+func (h *HNSWIndex) SearchBinary(query BinaryVector, k int, ef int) ([]SearchResult, error) {
+	if err := h.checkBinary(query); err != nil {
+		return nil, err
+	}
+	return h.Search(UnpackBinary(query, h.dimension), k, ef)
+}
+
+// checkBinary 检查索引的量化器编码就是打包的位：阈值全为 0 时，
+// UnpackBinary 展开的 0/1 向量编码后与 v 逐位相同。索引还必须只保留编码并使用
+// 汉明距离，否则会用展开的 0/1 浮点向量按其他距离重排结果
+func (h *HNSWIndex) checkBinary(v BinaryVector) error {
+	q, ok := h.quantizer.(*BinaryQuantizer)
+	if !ok {
+		return fmt.Errorf("%w: packed binary vectors require a BinaryQuantizer", ErrInvalidParameter)
+	}
+	if !h.quantizedOnly || h.distName != DistanceHamming {
+		return fmt.Errorf("%w: packed binary vectors require QuantizedOnly and the %s distance", ErrInvalidParameter, DistanceHamming)
+	}
+	for _, threshold := range q.thresholds {
+		if threshold != 0 {
+			return fmt.Errorf("%w: packed binary vectors require a BinaryQuantizer with zero thresholds", ErrInvalidParameter)
+		}
+	}
+	if len(v) != (h.dimension+63)/64 {
+		return ErrDimensionMismatch
+	}
+	return nil
+}
+
+// HammingDistance computes the Hamming distance between two binary embeddings
+// stored one component per bit as float32 values: the number of positions
+// where one vector is positive and the other is not. It lets an HNSWIndex
+// index 1-bit embeddings directly; BinaryQuantizer packs full-precision
+// vectors into bits for traversal instead.
+func HammingDistance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("vector dimensions mismatch")
+	}
+	b = b[:len(a)]
+	distance := 0
+	for i := range a {
+		if (a[i] > 0) != (b[i] > 0) {
+			distance++
+		}
+	}
+	return float32(distance)
+}
+
+// BinaryQuantizer keeps one bit per dimension, set when the component exceeds
+// the dimension's threshold, so a vector is stored in dimension/8 bytes and
+// compared by Hamming distance with XOR and popcount. The bits are a coarse
+// first stage: an index with a BinaryQuantizer traverses the graph on Hamming
+// distances whatever its distance function, then re-ranks the candidates with
+// the full-precision vectors. Indexes created with QuantizedOnly have no
+// vectors to re-rank with and return Hamming distances.
+type BinaryQuantizer struct {
+	dimension  int
+	thresholds []float32 // Per-dimension threshold; components above it encode as 1.
+}
+
+// NewBinaryQuantizer creates a binary quantizer that thresholds every
+// dimension at zero, which suits embeddings centred on the origin; it needs
+// no training. Train moves the thresholds to the per-dimension means.
+func NewBinaryQuantizer(dimension int) *BinaryQuantizer {
+	if dimension <= 0 {
+		panic("dimension must be positive")
+	}
+	return &BinaryQuantizer{dimension: dimension, thresholds: make([]float32, dimension)}
+}
+
+// newBinaryQuantizerFromThresholds creates a quantizer from saved parameters.
+func newBinaryQuantizerFromThresholds(thresholds []float32) (*BinaryQuantizer, error) {
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("%w: binary quantizer has no thresholds", ErrInvalidParameter)
+	}
+	return &BinaryQuantizer{dimension: len(thresholds), thresholds: thresholds}, nil
+}
+
+func (q *BinaryQuantizer) Name() string   { return QuantizerBinary }
+func (q *BinaryQuantizer) Dimension() int { return q.dimension }
+func (q *BinaryQuantizer) Trained() bool  { return true }
+
+// Train sets the threshold of every dimension to its mean over vectors, so
+// each bit splits the sample roughly in half.
+func (q *BinaryQuantizer) Train(vectors [][]float32) error {
+	if len(vectors) == 0 {
+		return fmt.Errorf("%w: no training vectors", ErrInvalidParameter)
+	}
+
+	sums := make([]float64, q.dimension)
+	for _, vector := range vectors {
+		if len(vector) != q.dimension {
+			return ErrDimensionMismatch
+		}
+		for i, v := range vector {
+			sums[i] += float64(v)
+		}
+	}
+
+	thresholds := make([]float32, q.dimension)
+	for i, sum := range sums {
+		thresholds[i] = float32(sum / float64(len(vectors)))
+	}
+	q.thresholds = thresholds
+	return nil
+}
+
+// Encode packs the bits of vector into (dimension+7)/8 bytes, bit i in bit
+// i%8 of byte i/8; read as little-endian words the code is a BinaryVector.
+func (q *BinaryQuantizer) Encode(vector []float32) []byte {
+	code := make([]byte, (q.dimension+7)/8)
+	for i, v := range vector {
+		if v > q.thresholds[i] {
+			code[i/8] |= 1 << (i % 8)
+		}
+	}
+	return code
+}
+
+// Decode returns +1 for the set bits of code and -1 for the others. Only the
+// signs survive quantization, which HammingDistance and CosineDistance compare
+// meaningfully when the thresholds are zero.
+func (q *BinaryQuantizer) Decode(code []byte, dst []float32) []float32 {
+	dst = dst[:0]
+	for i := 0; i < q.dimension; i++ {
+		if code[i/8]&(1<<(i%8)) != 0 {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, -1)
+		}
+	}
+	return dst
+}
+
+// NewDistancer returns a distancer computing the Hamming distance between the
+// code of query and encoded vectors, whatever distFunc is.
+func (q *BinaryQuantizer) NewDistancer(query []float32, distFunc DistanceFunc, distName string) CodeDistancer {
+	return &hammingDistancer{query: q.Encode(query)}
+}
+
+// hammingDistancer 按 8 字节一组异或后统计不同的位数
+type hammingDistancer struct {
+	query []byte
+}
+
+func (d *hammingDistancer) Distance(code []byte) float32 {
+	a, b := d.query, code[:len(d.query)]
+	distance := 0
+	for len(a) >= 8 {
+		distance += bits.OnesCount64(binary.LittleEndian.Uint64(a) ^ binary.LittleEndian.Uint64(b))
+		a, b = a[8:], b[8:]
+	}
+	for i := range a {
+		distance += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return float32(distance)
+}
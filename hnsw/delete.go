@@ -0,0 +1,111 @@
+package hnsw
+
+import "fmt"
+
+// Delete marks the node with the given ID as a tombstone. The node stays in the
+// graph so searches can still route through it, but it is never returned as a
+// result. Its neighbours are reconnected among themselves and a new entry point
+// is chosen if the deleted node was the entry point.
+func (h *HNSWIndex) Delete(id int) error {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	h.globalLock.Lock()
+	defer h.globalLock.Unlock()
+
+	if id < 0 || id >= len(h.nodes) {
+		return fmt.Errorf("%w: id %d (valid range: [0, %d))", ErrNodeNotFound, id, len(h.nodes))
+	}
+
+	node := h.nodes[id]
+	if node.IsDeleted() {
+		return fmt.Errorf("%w: id %d", ErrNodeDeleted, id)
+	}
+
+	node.deleted.Store(true)
+	node.rowDirty.Store(true)
+	h.numDeleted++
+	if !node.key.IsZero() {
+		delete(h.keys, node.key)
+	}
+	if !node.doc.IsZero() {
+		h.removeFromDocument(node)
+	}
+
+	h.repairNeighbors(node)
+
+	if int(h.entryPoint) == id {
+		h.entryPoint, h.maxLevel = h.findEntryPoint()
+	}
+
+	return nil
+}
+
+// repairNeighbors 修复被删除节点的邻居：每个指向它的邻居从
+// “自身原有连接 + 被删除节点的连接” 中用索引的选择策略重新选择邻居，
+// 这样绕过墓碑节点的路径不会丢失。调用方持有 globalLock。
+func (h *HNSWIndex) repairNeighbors(deleted *Node) {
+	deletedID := deleted.ID()
+	nodes := h.snapshot()
+
+	for lc := 0; lc <= deleted.Level(); lc++ {
+		deletedConnections := deleted.GetConnections(lc)
+		maxConn := h.maxConnections(lc)
+
+		for _, neighborID := range deletedConnections {
+			neighbor := nodes[neighborID]
+			if neighbor.IsDeleted() {
+				continue
+			}
+
+			// 在邻居的锁内读-改-写，避免覆盖并发插入加上的连接
+			neighbor.updateConnections(lc, func(current []int) []int {
+				// 只有确实连向被删除节点的邻居才需要修复
+				if !containsID(current, deletedID) {
+					return current
+				}
+
+				// mergeCandidates 会去掉邻居自身和所有墓碑节点（包括 deletedID）
+				buf := getDecodeBuffer()
+				defer putDecodeBuffer(buf)
+				neighborVec := buf.vector(h, neighbor)
+				extra := make([]int, 0, len(current)+len(deletedConnections))
+				extra = append(append(extra, current...), deletedConnections...)
+				candidates := h.mergeCandidates(neighborVec, neighborID, nil, extra)
+
+				selected := h.selectNeighbors(neighborVec, neighborID, lc, candidates, maxConn, true)
+				selectedIDs := make([]int, len(selected))
+				for i, s := range selected {
+					selectedIDs[i] = s.ID
+				}
+				return selectedIDs
+			})
+		}
+	}
+}
+
+// findEntryPoint 选出层级最高的未删除节点作为新的入口点，索引中没有存活节点时返回 -1。
+// 调用方持有 globalLock。
+func (h *HNSWIndex) findEntryPoint() (entryPoint int32, maxLevel int32) {
+	entryPoint, maxLevel = -1, -1
+	for _, node := range h.nodes {
+		if node.IsDeleted() {
+			continue
+		}
+		if int32(node.Level()) > maxLevel {
+			entryPoint = int32(node.ID())
+			maxLevel = int32(node.Level())
+		}
+	}
+	return entryPoint, maxLevel
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
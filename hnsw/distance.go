@@ -1,20 +1,80 @@
 package hnsw
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
 
 type DistanceFunc func(a, b []float32) float32
 
+// Names of the built-in distance functions, as recorded in saved indexes.
+const (
+	DistanceL2           = "l2"
+	DistanceL2Sqrt       = "l2_sqrt"
+	DistanceInnerProduct = "inner_product"
+	DistanceCosine       = "cosine"
+	DistanceHamming      = "hamming"
+)
+
+// 距离函数注册表：保存索引时记录名字，加载时按名字找回函数
+var (
+	distanceMu       sync.RWMutex
+	distanceRegistry = map[string]DistanceFunc{
+		DistanceL2:           L2Distance,
+		DistanceL2Sqrt:       L2DistanceSqrt,
+		DistanceInnerProduct: InnerProductDistance,
+		DistanceCosine:       CosineDistance,
+		DistanceHamming:      HammingDistance,
+	}
+)
+
+// RegisterDistanceFunc registers a custom distance function under name so that
+// indexes built with it can be saved and loaded again.
+func RegisterDistanceFunc(name string, fn DistanceFunc) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("%w: distance name and function must be set", ErrInvalidParameter)
+	}
+
+	distanceMu.Lock()
+	defer distanceMu.Unlock()
+
+	if _, exists := distanceRegistry[name]; exists {
+		return fmt.Errorf("%w: distance %q already registered", ErrInvalidParameter, name)
+	}
+	distanceRegistry[name] = fn
+	return nil
+}
+
+// LookupDistanceFunc returns the distance function registered under name.
+func LookupDistanceFunc(name string) (DistanceFunc, error) {
+	distanceMu.RLock()
+	defer distanceMu.RUnlock()
+
+	fn, ok := distanceRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (registered: %v)", ErrUnknownDistance, name, registeredDistanceNames())
+	}
+	return fn, nil
+}
+
+// registeredDistanceNames returns the sorted registry keys; callers hold distanceMu.
+func registeredDistanceNames() []string {
+	names := make([]string, 0, len(distanceRegistry))
+	for name := range distanceRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // L2Distance computes the L2 (Euclidean) distance between two vectors.
 func L2Distance(a, b []float32) float32 {
 	if len(a) != len(b) {
 		panic("vector dimensions mismatch")
 	}
-	var sum float32
-	for i := range a {
-		diff := a[i] - b[i]
-		sum += diff * diff
-	}
-	return sum // Note: returning squared distance for efficiency
+	return squaredL2(a, b) // Note: returning squared distance for efficiency
 }
 
 // L2DistanceSqrt computes the square root of the L2 distance between two vectors.
@@ -24,19 +84,14 @@ func L2DistanceSqrt(a, b []float32) float32 {
 
 // InnerProductDistance computes the inner product distance between two vectors.
 // Note: Inner product is not a true distance metric, but is often used in similarity search.
-// Distance = 1 - InnerProduct
+// Distance = -InnerProduct
 func InnerProductDistance(a, b []float32) float32 {
 	if len(a) != len(b) {
 		panic("vector dimensions mismatch")
 	}
 
-	var sum float32
-	for i := range a {
-		sum += a[i] * b[i]
-	}
-
 	// We negate the inner product to convert it into a distance metric
-	return -sum
+	return -dot(a, b)
 }
 
 // CosineDistance computes the cosine distance between two vectors.
@@ -46,19 +101,21 @@ func CosineDistance(a, b []float32) float32 {
 		panic("vector dimensions mismatch")
 	}
 
-	var dotProduct, normA, normB float32
-
-	for i := range a {
-		dotProduct += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
-	}
-
+	dotProduct, normA, normB := dotNorms(a, b)
 	if normA == 0 || normB == 0 {
 		return 1.0
 	}
 
-	cosineSim := dotProduct / (float32(math.Sqrt(float64(normA))) * float32(math.Sqrt(float64(normB))))
+	cosineSim := dotProduct / float32(math.Sqrt(float64(normA)*float64(normB)))
 
 	return 1.0 - cosineSim
 }
+
+// unitCosineDistance 是单位向量之间的余弦距离，只需要计算内积。
+// Config.Normalize 且距离为余弦的索引使用它，名字仍然记录为 DistanceCosine
+func unitCosineDistance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		panic("vector dimensions mismatch")
+	}
+	return 1.0 - dot(a, b)
+}
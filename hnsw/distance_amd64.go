@@ -0,0 +1,66 @@
+//go:build amd64 && !purego
+
+package hnsw
+
+// useAVX2 报告 CPU 和操作系统是否支持 AVX2 和 FMA，不支持时使用通用实现
+var useAVX2 = hasAVX2FMA()
+
+func dot(a, b []float32) float32 {
+	if useAVX2 {
+		return dotAVX2(a, b)
+	}
+	return dotGeneric(a, b)
+}
+
+func squaredL2(a, b []float32) float32 {
+	if useAVX2 {
+		return squaredL2AVX2(a, b)
+	}
+	return squaredL2Generic(a, b)
+}
+
+func dotNorms(a, b []float32) (dot, normA, normB float32) {
+	if useAVX2 {
+		return dotNormsAVX2(a, b)
+	}
+	return dotNormsGeneric(a, b)
+}
+
+// hasAVX2FMA 通过 CPUID 检查 AVX2 和 FMA 指令，并通过 XGETBV 确认操作系统保存 YMM 寄存器
+func hasAVX2FMA() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	const (
+		fma     = 1 << 12
+		osxsave = 1 << 27
+		avx     = 1 << 28
+	)
+	if ecx1&(fma|osxsave|avx) != fma|osxsave|avx {
+		return false
+	}
+	// XCR0 第1、2位：XMM 和 YMM 状态
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx7&avx2 != 0
+}
+
+// 以下函数在 distance_amd64.s 中实现，调用方保证 len(a) == len(b)
+
+//go:noescape
+func dotAVX2(a, b []float32) float32
+
+//go:noescape
+func squaredL2AVX2(a, b []float32) float32
+
+//go:noescape
+func dotNormsAVX2(a, b []float32) (dot, normA, normB float32)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
@@ -0,0 +1,77 @@
+package hnsw
+
+import "math"
+
+// 距离计算的内核：dot、squaredL2 和 dotNorms 在 amd64 上使用 AVX2 汇编实现
+// （distance_amd64.s），其余平台和 purego 构建使用这里的纯 Go 版本。
+// 纯 Go 版本展开为4路并用4个累加器打断加法的依赖链，循环前截断 b 让编译器消除边界检查。
+// 调用方保证 len(a) == len(b)
+
+// dotGeneric 返回 a 和 b 的内积
+func dotGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	for len(a) >= 4 {
+		_ = b[3]
+		s0 += a[0] * b[0]
+		s1 += a[1] * b[1]
+		s2 += a[2] * b[2]
+		s3 += a[3] * b[3]
+		a, b = a[4:], b[4:]
+	}
+	for i := range a {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// squaredL2Generic 返回 a 和 b 的欧氏距离的平方
+func squaredL2Generic(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	for len(a) >= 4 {
+		_ = b[3]
+		d0 := a[0] - b[0]
+		d1 := a[1] - b[1]
+		d2 := a[2] - b[2]
+		d3 := a[3] - b[3]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+		a, b = a[4:], b[4:]
+	}
+	for i := range a {
+		d := a[i] - b[i]
+		s0 += d * d
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// dotNormsGeneric 一次遍历同时返回 a·b、a·a 和 b·b。三个累加器本身已经互不依赖，
+// 展开并不会更快
+func dotNormsGeneric(a, b []float32) (dot, normA, normB float32) {
+	b = b[:len(a)]
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	return dot, normA, normB
+}
+
+// Normalize scales v in place to unit L2 norm and returns it. Zero vectors are
+// left unchanged. For unit vectors the cosine distance equals one plus the
+// inner product distance, which is what indexes created with Config.Normalize
+// compute.
+func Normalize(v []float32) []float32 {
+	norm := dot(v, v)
+	if norm == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(float64(norm)))
+	for i := range v {
+		v[i] *= scale
+	}
+	return v
+}
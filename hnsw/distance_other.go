@@ -0,0 +1,15 @@
+//go:build !amd64 || purego
+
+package hnsw
+
+func dot(a, b []float32) float32 {
+	return dotGeneric(a, b)
+}
+
+func squaredL2(a, b []float32) float32 {
+	return squaredL2Generic(a, b)
+}
+
+func dotNorms(a, b []float32) (dot, normA, normB float32) {
+	return dotNormsGeneric(a, b)
+}
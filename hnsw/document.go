@@ -0,0 +1,193 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DocumentResult is a document returned by SearchDocuments.
+type DocumentResult struct {
+	Key      Key     // Document key.
+	Distance float32 // Sum over the query vectors of the distance to the closest vector of the document.
+	Matches  []int   // Matches[i] is the ID of the document vector closest to query vector i.
+}
+
+// AddDocument inserts vectors as the vectors of document doc, for example one
+// embedding per sentence, and returns their node IDs. Adding to a document
+// that already has vectors extends it. Search, SearchWithFilter, SearchBatch
+// and SearchRadius return a document once, as its vector closest to the query
+// with SearchResult.Document set, while SearchDocuments scores documents on
+// all their vectors. Every error is detected before the first vector is
+// inserted, so a failed call adds nothing.
+func (h *HNSWIndex) AddDocument(doc Key, vectors [][]float32) ([]int, error) {
+	if h.readOnly {
+		return nil, ErrReadOnly
+	}
+	if doc.IsZero() {
+		return nil, fmt.Errorf("%w: document key must be set", ErrInvalidParameter)
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("%w: document %v has no vectors", ErrInvalidParameter, doc)
+	}
+	for _, vector := range vectors {
+		if len(vector) != h.dimension {
+			return nil, ErrDimensionMismatch
+		}
+	}
+
+	// 上面已经检查了 add 的所有失败情况（没有外部键，不会冲突），插入不会中途失败
+	ids := make([]int, 0, len(vectors))
+	for _, vector := range vectors {
+		id, err := h.add(Key{}, doc, vector, nil)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// DocumentNodes returns the IDs of the live vectors of document doc, in the
+// order they were added, or nil if it has none.
+func (h *HNSWIndex) DocumentNodes(doc Key) []int {
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	ids := h.docs[doc]
+	if len(ids) == 0 {
+		return nil
+	}
+	return append([]int(nil), ids...)
+}
+
+// DeleteDocument deletes every vector of document doc as Delete does. It
+// returns ErrDocumentNotFound if the document has no live vectors.
+func (h *HNSWIndex) DeleteDocument(doc Key) error {
+	ids := h.DocumentNodes(doc)
+	if len(ids) == 0 {
+		return fmt.Errorf("%w: %v", ErrDocumentNotFound, doc)
+	}
+	for _, id := range ids {
+		// 并发删除可能已经删掉了其中的节点
+		if err := h.Delete(id); err != nil && !errors.Is(err, ErrNodeDeleted) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchDocuments scores the documents added with AddDocument against a query
+// made of one or more vectors by late interaction (MaxSim): every query vector
+// is matched with the closest vector of the document, and the document's
+// distance is the sum of those distances. With inner product or cosine
+// distance this ranks documents by their summed maximum similarity. The k
+// documents with the smallest distance are returned, closest first, each at
+// most once.
+//
+// Candidate documents are those owning one of the ef nearest neighbours of any
+// query vector; their distance is then computed over all their vectors. ef is
+// the search width as in Search; 0 means max(efConstruction, k).
+func (h *HNSWIndex) SearchDocuments(query [][]float32, k int, ef int) ([]DocumentResult, error) {
+	if len(query) == 0 || k <= 0 {
+		return nil, ErrInvalidParameter
+	}
+	for _, q := range query {
+		if len(q) != h.dimension {
+			return nil, ErrDimensionMismatch
+		}
+	}
+	if ef == 0 {
+		ef = max(h.efConstruction, k)
+	}
+
+	// 阶段1：每个查询向量在图中找 ef 个属于文档的近邻，收集它们所属的文档。
+	// 搜索期间可能有新节点加入，过滤器和结果都使用最新的快照
+	inDocument := FilterFunc(func(id int) bool { return !h.snapshot()[id].doc.IsZero() })
+	var candidates []Key
+	seen := make(map[Key]bool)
+	for _, q := range query {
+		results, err := h.SearchWithFilter(q, ef, ef, inDocument)
+		if err != nil {
+			return nil, err
+		}
+		nodes := h.snapshot()
+		for _, r := range results {
+			doc := nodes[r.ID].doc
+			if !seen[doc] {
+				seen[doc] = true
+				candidates = append(candidates, doc)
+			}
+		}
+	}
+
+	// 阶段2：在候选文档的所有向量上精确计算 MaxSim 距离
+	h.globalLock.RLock()
+	vectorIDs := make([][]int, len(candidates))
+	for i, doc := range candidates {
+		vectorIDs[i] = append([]int(nil), h.docs[doc]...)
+	}
+	h.globalLock.RUnlock()
+	nodes := h.snapshot()
+
+	units := make([][]float32, len(query))
+	for i, q := range query {
+		units[i] = h.unitQuery(q)
+	}
+	docs := make([]DocumentResult, 0, len(candidates))
+	for i, doc := range candidates {
+		// 搜索之后整个文档可能已被删除
+		if len(vectorIDs[i]) == 0 {
+			continue
+		}
+		docs = append(docs, h.maxSim(doc, units, vectorIDs[i], nodes))
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Distance < docs[j].Distance
+	})
+	if len(docs) > k {
+		docs = docs[:k]
+	}
+	return docs, nil
+}
+
+// maxSim 计算文档 doc 的晚交互距离：每个查询向量到文档最近向量的距离之和。
+// ids 是文档的存活向量，不能为空
+func (h *HNSWIndex) maxSim(doc Key, query [][]float32, ids []int, nodes []*Node) DocumentResult {
+	vectors := make([][]float32, len(ids))
+	for i, id := range ids {
+		vectors[i] = h.nodeVector(nodes[id])
+	}
+
+	result := DocumentResult{Key: doc, Matches: make([]int, len(query))}
+	for i, q := range query {
+		best := -1
+		var bestDistance float32
+		for j, vector := range vectors {
+			if d := h.distFunc(q, vector); best == -1 || d < bestDistance {
+				best, bestDistance = j, d
+			}
+		}
+		result.Distance += bestDistance
+		result.Matches[i] = ids[best]
+	}
+	return result
+}
+
+// removeFromDocument 把被删除的节点从所属文档的向量列表中移除，文档没有向量后删除该文档。
+// 调用方持有 globalLock 写锁
+func (h *HNSWIndex) removeFromDocument(node *Node) {
+	ids := h.docs[node.doc]
+	for i, id := range ids {
+		if id == node.ID() {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(h.docs, node.doc)
+		return
+	}
+	h.docs[node.doc] = ids
+}
@@ -11,4 +11,34 @@ var (
 
 	// ErrInvalidParameter 参数无效
 	ErrInvalidParameter = errors.New("invalid parameter")
+
+	// ErrNodeNotFound 节点不存在
+	ErrNodeNotFound = errors.New("node not found")
+
+	// ErrNodeDeleted 节点已被删除
+	ErrNodeDeleted = errors.New("node already deleted")
+
+	// ErrDuplicateKey 外部键已被其他节点使用
+	ErrDuplicateKey = errors.New("duplicate key")
+
+	// ErrDocumentNotFound 文档没有存活的向量
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrUnknownDistance 距离函数未注册
+	ErrUnknownDistance = errors.New("unknown distance function")
+
+	// ErrChecksumMismatch 索引文件与清单记录的校验和不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrCorruptIndex 索引结构损坏，由 Validate 报告
+	ErrCorruptIndex = errors.New("corrupt index")
+
+	// ErrUnreachable 批量插入后仍有节点无法从入口点到达
+	ErrUnreachable = errors.New("nodes unreachable from the entry point")
+
+	// ErrReadOnly 索引以只读方式打开，不能修改
+	ErrReadOnly = errors.New("index is read-only")
+
+	// ErrClosed 只读索引已经关闭，映射的文件已释放
+	ErrClosed = errors.New("index is closed")
 )
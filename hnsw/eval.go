@@ -0,0 +1,116 @@
+package hnsw
+
+import (
+	"fmt"
+	"time"
+)
+
+// EvalResult summarises search quality and cost of an HNSWIndex at one ef value.
+type EvalResult struct {
+	Ef                       int
+	Recall                   float64       // Mean recall@k against the ground truth.
+	MeanLatency              time.Duration // Mean wall-clock time per query.
+	MeanDistanceComputations float64       // Mean number of distance evaluations per query.
+}
+
+// GroundTruth returns the exact k nearest neighbours of every query.
+func GroundTruth(flat *FlatIndex, queries [][]float32, k int) ([][]SearchResult, error) {
+	truth := make([][]SearchResult, len(queries))
+	for i, query := range queries {
+		results, err := flat.Search(query, k, 0)
+		if err != nil {
+			return nil, fmt.Errorf("query %d: %w", i, err)
+		}
+		truth[i] = results
+	}
+	return truth, nil
+}
+
+// Recall returns the fraction of the IDs in truth that also appear in results.
+// An empty truth set counts as full recall.
+func Recall(results, truth []SearchResult) float64 {
+	if len(truth) == 0 {
+		return 1
+	}
+
+	found := make(map[int]bool, len(results))
+	for _, r := range results {
+		found[r.ID] = true
+	}
+
+	hits := 0
+	for _, r := range truth {
+		if found[r.ID] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(truth))
+}
+
+// Evaluate runs every query against the index once per ef value and compares
+// the top k results with groundTruth, typically computed by GroundTruth on a
+// FlatIndex with the same IDs. Queries run one at a time so the latencies are
+// comparable across ef values.
+func (h *HNSWIndex) Evaluate(queries [][]float32, groundTruth [][]SearchResult, k int, efs []int) ([]EvalResult, error) {
+	if k <= 0 || len(queries) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	if len(groundTruth) != len(queries) {
+		return nil, fmt.Errorf("%w: %d queries but %d ground truth sets",
+			ErrInvalidParameter, len(queries), len(groundTruth))
+	}
+	for i, query := range queries {
+		if len(query) != h.dimension {
+			return nil, fmt.Errorf("%w: query %d has dimension %d, expected %d",
+				ErrDimensionMismatch, i, len(query), h.dimension)
+		}
+	}
+
+	h.globalLock.RLock()
+	if err := h.checkSearchable(); err != nil {
+		h.globalLock.RUnlock()
+		return nil, err
+	}
+	ep := int(h.entryPoint)
+	maxLvl := int(h.maxLevel)
+	h.globalLock.RUnlock()
+
+	scratch := getSearchScratch()
+	defer putSearchScratch(scratch)
+
+	evals := make([]EvalResult, 0, len(efs))
+	for _, ef := range efs {
+		if ef <= 0 {
+			return nil, fmt.Errorf("%w: ef must be positive, got %d", ErrInvalidParameter, ef)
+		}
+
+		var totalRecall float64
+		var totalLatency time.Duration
+		var totalDistances int
+
+		for i, query := range queries {
+			scratch.distCount = 0
+			start := time.Now()
+			results, _ := h.search(query, k, ef, ep, maxLvl, nil, scratch)
+			totalLatency += time.Since(start)
+			totalDistances += scratch.distCount
+
+			// 只和真实结果的前 k 个比较
+			truth := groundTruth[i]
+			if len(truth) > k {
+				truth = truth[:k]
+			}
+			totalRecall += Recall(results, truth)
+		}
+
+		n := len(queries)
+		evals = append(evals, EvalResult{
+			Ef:                       ef,
+			Recall:                   totalRecall / float64(n),
+			MeanLatency:              totalLatency / time.Duration(n),
+			MeanDistanceComputations: float64(totalDistances) / float64(n),
+		})
+	}
+
+	return evals, nil
+}
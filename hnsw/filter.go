@@ -0,0 +1,79 @@
+package hnsw
+
+import "ollama-demo/lance/arrow"
+
+// Filter decides which node IDs may appear in search results. Nodes rejected by
+// the filter are still traversed, so filtering never disconnects the graph.
+type Filter interface {
+	Allow(id int) bool
+}
+
+// FilterFunc adapts an ordinary predicate to a Filter.
+type FilterFunc func(id int) bool
+
+// Allow calls f(id).
+func (f FilterFunc) Allow(id int) bool {
+	return f(id)
+}
+
+// BitmapFilter is an allow-list filter: node i is allowed iff bit i is set.
+// IDs beyond the bitmap length are rejected.
+type BitmapFilter struct {
+	bitmap *arrow.Bitmap
+}
+
+// NewBitmapFilter creates an allow-list filter backed by bitmap.
+func NewBitmapFilter(bitmap *arrow.Bitmap) *BitmapFilter {
+	return &BitmapFilter{bitmap: bitmap}
+}
+
+// Allow reports whether bit id is set.
+func (f *BitmapFilter) Allow(id int) bool {
+	return id >= 0 && id < f.bitmap.Len() && f.bitmap.IsSet(id)
+}
+
+// selectivity returns the fraction of numNodes allowed by the bitmap.
+func (f *BitmapFilter) selectivity(numNodes int) float64 {
+	if numNodes == 0 {
+		return 1
+	}
+	return float64(f.bitmap.CountSet()) / float64(numNodes)
+}
+
+// SearchWithFilter returns the k nearest neighbours of query among the nodes
+// allowed by filter. When the filter is very selective the search widens ef
+// until k results are found or the whole graph has been covered.
+func (h *HNSWIndex) SearchWithFilter(query []float32, k int, ef int, filter Filter) ([]SearchResult, error) {
+	if filter == nil {
+		return h.Search(query, k, ef)
+	}
+	if len(query) != h.dimension {
+		return nil, ErrDimensionMismatch
+	}
+
+	if ef == 0 {
+		ef = max(h.efConstruction, k)
+	}
+
+	h.globalLock.RLock()
+	if err := h.checkSearchable(); err != nil {
+		h.globalLock.RUnlock()
+		return nil, err
+	}
+	ep := h.entryPoint
+	maxLvl := h.maxLevel
+	numNodes := len(h.nodes)
+	h.globalLock.RUnlock()
+
+	// 位图可以预估选择率：只有 1% 的节点通过时，需要大约 100 倍的 ef 才能凑够结果
+	if bf, ok := filter.(*BitmapFilter); ok {
+		if s := bf.selectivity(numNodes); s > 0 && s < 1 {
+			ef = min(int(float64(ef)/s), numNodes)
+		}
+	}
+
+	// 重试之间复用同一份临时空间
+	scratch := getSearchScratch()
+	defer putSearchScratch(scratch)
+	return h.searchDistinct(query, k, ef, int(ep), int(maxLvl), numNodes, filter, scratch)
+}
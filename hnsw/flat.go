@@ -0,0 +1,145 @@
+package hnsw
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FlatIndex is an exact nearest-neighbour index that compares the query with
+// every stored vector. It is slow on large corpora but always correct, which
+// makes it the ground truth for measuring HNSWIndex recall. IDs are assigned
+// the same way as HNSWIndex, so the two indexes can be built side by side.
+type FlatIndex struct {
+	dimension int
+	distFunc  DistanceFunc
+	distName  string // Registered name of distFunc, persisted with the index.
+
+	vectors [][]float32
+	deleted []bool
+
+	mu sync.RWMutex
+}
+
+// NewFlatIndex creates an empty exact index. distFunc defaults to L2Distance.
+// Only indexes with the default distance can be saved; NewIndex with
+// Config.DistanceName creates a flat index with any registered distance.
+func NewFlatIndex(dimension int, distFunc DistanceFunc) *FlatIndex {
+	if dimension <= 0 {
+		panic("dimension must be positive")
+	}
+	distName := ""
+	if distFunc == nil {
+		distFunc = L2Distance
+		distName = DistanceL2
+	}
+	return &FlatIndex{
+		dimension: dimension,
+		distFunc:  distFunc,
+		distName:  distName,
+	}
+}
+
+// NewFlatIndexFromHNSW copies the vectors of h into a FlatIndex with the same
+// IDs, distance function and deleted nodes.
+func NewFlatIndexFromHNSW(h *HNSWIndex) *FlatIndex {
+	// 扁平索引不缩放查询，Normalize 模式下改用完整的余弦距离，对单位向量结果相同
+	distFunc := h.distFunc
+	if h.normalize {
+		distFunc = CosineDistance
+	}
+	f := NewFlatIndex(h.dimension, distFunc)
+	f.distName = h.distName
+
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	f.vectors = make([][]float32, len(h.nodes))
+	f.deleted = make([]bool, len(h.nodes))
+	for i, node := range h.nodes {
+		f.vectors[i] = append([]float32(nil), h.nodeVector(node)...)
+		f.deleted[i] = node.IsDeleted()
+	}
+	return f
+}
+
+// Add stores a copy of vector and returns its ID.
+func (f *FlatIndex) Add(vector []float32) (int, error) {
+	if len(vector) != f.dimension {
+		return -1, ErrDimensionMismatch
+	}
+
+	vectorCopy := make([]float32, len(vector))
+	copy(vectorCopy, vector)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.vectors = append(f.vectors, vectorCopy)
+	f.deleted = append(f.deleted, false)
+	return len(f.vectors) - 1, nil
+}
+
+// Delete removes the vector with the given ID from search results.
+func (f *FlatIndex) Delete(id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id < 0 || id >= len(f.vectors) {
+		return fmt.Errorf("%w: id %d (valid range: [0, %d))", ErrNodeNotFound, id, len(f.vectors))
+	}
+	if f.deleted[id] {
+		return fmt.Errorf("%w: id %d", ErrNodeDeleted, id)
+	}
+	f.deleted[id] = true
+	return nil
+}
+
+// Search returns the exact k nearest neighbours of query, closest first. ef is
+// ignored: every vector is compared with query.
+func (f *FlatIndex) Search(query []float32, k int, ef int) ([]SearchResult, error) {
+	if len(query) != f.dimension {
+		return nil, ErrDimensionMismatch
+	}
+	if k <= 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// 最大堆保留当前最近的 k 个，堆顶是其中最远的
+	best := candidateHeap{items: make([]candidate, 0, k+1), max: true}
+	for id, vector := range f.vectors {
+		if f.deleted[id] {
+			continue
+		}
+		dist := f.distFunc(query, vector)
+		if best.Len() < k || dist < best.Top().dist {
+			best.Push(candidate{id: id, dist: dist})
+			if best.Len() > k {
+				best.Pop()
+			}
+		}
+	}
+
+	results := make([]SearchResult, best.Len())
+	for i := best.Len() - 1; i >= 0; i-- {
+		item := best.Pop()
+		results[i] = SearchResult{ID: item.id, Distance: item.dist}
+	}
+	return results, nil
+}
+
+// Len returns the number of live vectors.
+func (f *FlatIndex) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	n := 0
+	for _, deleted := range f.deleted {
+		if !deleted {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+package hnsw
+
+// 以下堆类型是搜索改用 candidateHeap 之前的实现，索引内部已不再使用，
+// 为了不破坏外部调用方而保留
+
+// PriorityQueue is a min-heap of items ordered by priority, for use with
+// container/heap.
+//
+// Deprecated: the index no longer uses it; search keeps its candidates in
+// value heaps that do not allocate per item. It will be removed in a future
+// release.
+type PriorityQueue []*Item
+
+// Item is an entry of PriorityQueue and MaxHeap.
+//
+// Deprecated: see PriorityQueue.
+type Item struct {
+	value    int     // 节点ID
+	priority float32 // 距离（优先级）
+	index    int     // 在堆中的索引
+}
+
+func (pq PriorityQueue) Len() int { return len(pq) }
+
+func (pq PriorityQueue) Less(i, j int) bool {
+	return pq[i].priority < pq[j].priority // 最小堆
+}
+
+func (pq PriorityQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *PriorityQueue) Push(x interface{}) {
+	n := len(*pq)
+	item := x.(*Item)
+	item.index = n
+	*pq = append(*pq, item)
+}
+
+func (pq *PriorityQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*pq = old[0 : n-1]
+	return item
+}
+
+// MaxHeap is a max-heap of items ordered by priority, for use with
+// container/heap.
+//
+// Deprecated: see PriorityQueue.
+type MaxHeap []*Item
+
+func (h MaxHeap) Len() int { return len(h) }
+
+func (h MaxHeap) Less(i, j int) bool {
+	return h[i].priority > h[j].priority // 最大堆
+}
+
+func (h MaxHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *MaxHeap) Push(x interface{}) {
+	n := len(*h)
+	item := x.(*Item)
+	item.index = n
+	*h = append(*h, item)
+}
+
+func (h *MaxHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[0 : n-1]
+	return item
+}
+
+func (h *MaxHeap) Peek() interface{} {
+	if len(*h) == 0 {
+		return nil
+	}
+	return (*h)[0]
+}
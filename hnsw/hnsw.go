@@ -1,14 +1,30 @@
 package hnsw
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
+	"ollama-demo/lance/arrow"
+	"ollama-demo/lance/column"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // The main structure of the HNSW index.
 type HNSWIndex struct {
+	indexState
+
+	published atomic.Pointer[[]*Node] // Lock-free snapshot of nodes read by graph traversal.
+
+	globalLock sync.RWMutex // Protects nodes, entryPoint, maxLevel and numDeleted; never acquired while holding a Node lock.
+	persistMu  sync.Mutex   // Serialises saves and protects persistDir and persisted; acquired before globalLock.
+	mu         sync.Mutex   // Protects the RNG.
+}
+
+// indexState 是 HNSWIndex 中除锁和已发布快照以外的全部状态。
+// Load 整体替换它，以后新增的字段不会在重新加载时被遗漏
+type indexState struct {
 	// Core params
 	M              int     // Maximum number of connections per level.
 	Mmax           int     // The real value of the M.
@@ -18,25 +34,57 @@ type HNSWIndex struct {
 
 	dimension int // Dimensionality of the vectors.
 
-	nodes      []*Node // All nodes in the HNSW graph.
-	entryPoint int32   // Entry point node ID.
-	maxLevel   int32   // Maximum level in the HNSW hierarchy.
+	nodes      []*Node       // All nodes in the HNSW graph, appended under globalLock.
+	entryPoint int32         // Entry point node ID.
+	maxLevel   int32         // Maximum level in the HNSW hierarchy.
+	numDeleted int           // Number of tombstoned nodes.
+	keys       map[Key]int   // External key of every live keyed node to its ID.
+	docs       map[Key][]int // Document key to the IDs of its live vectors, see AddDocument.
+
+	payloadSchema *arrow.Schema // Fields of the per-node payloads, nil if the index has none.
+
+	distFunc  DistanceFunc // Distance function used for measuring similarity.
+	distName  string       // Registered name of distFunc, persisted with the index.
+	normalize bool         // Vectors and queries are scaled to unit length; distFunc is unitCosineDistance.
+	seed      int64        // Seed used for level generation, persisted with the index.
+
+	quantizer     Quantizer // Optional, fixed for the lifetime of the index; nil searches full-precision vectors.
+	quantizedOnly bool      // Drop full-precision vectors once a node is linked; only codes are kept.
+	float16       bool      // Keep vectors in half precision once a node is linked; distances are still computed in float32.
 
-	distFunc DistanceFunc // Distance function used for measuring similarity.
+	selector NeighborSelector // Strategy choosing the neighbours of a node, see Config.NeighborSelector.
 
-	globalLock sync.RWMutex // Protects the entire index during insertions.
+	maxFragments int    // Node fragments an incremental save appends to before compacting.
+	persistDir   string // Directory the last incremental save wrote to, empty if it must be compacted.
+	persisted    int    // Number of nodes written to persistDir.
+
+	readOnly bool             // Opened with LoadHNSWReadOnly; vectors and connections reference mappings.
+	mappings []*column.Reader // Mapped files of a read-only index, released by Close.
+	closed   bool             // Close released the mappings; searches and saves return ErrClosed.
 
 	rng *rand.Rand // Random number generator for level assignment.
-	mu  sync.Mutex // Protects the RNG.
 }
 
 // Config holds the configuration parameters for the HNSW index.
 type Config struct {
-	M              int          // Maximum number of connections per level, default 16.
-	EfConstruction int          // default 200.
-	Dimension      int          // Vector dimensionality.
-	DistanceFunc   DistanceFunc // default L2Distance.
-	Seed           int64        // Seed for random level generation.
+	M              int           // Maximum number of connections per level, default 16.
+	EfConstruction int           // default 200.
+	Dimension      int           // Vector dimensionality.
+	DistanceFunc   DistanceFunc  // default L2Distance; an index given only a DistanceFunc cannot be saved.
+	DistanceName   string        // Registered distance name, persisted with the index; takes precedence over DistanceFunc.
+	Seed           int64         // Seed for random level generation.
+	Quantizer      Quantizer     // Optional trained quantizer used for approximate search; with Normalize, train it on unit-length vectors.
+	QuantizedOnly  bool          // Keep only the quantized codes in memory and on disk; requires Quantizer.
+	PayloadSchema  *arrow.Schema // Optional fields of the payloads attached with AddWithPayload.
+	MaxFragments   int           // Node fragments SaveIncremental appends before compacting, default 16.
+	Normalize      bool          // Store vectors scaled to unit length so cosine distance costs one inner product; requires DistanceCosine. Vectors are scaled before they are quantized.
+	Float16Vectors bool          // Keep vectors in half precision in memory and on disk, halving their footprint; distances are still computed in float32.
+
+	// NeighborSelector chooses the neighbours of a node when it is linked and
+	// when a connection list is pruned, default HeuristicSelector with
+	// KeepPrunedConnections. The built-in selectors are persisted with the
+	// index; a loaded index with any other selector uses the default.
+	NeighborSelector NeighborSelector
 }
 
 func NewHNSW(config Config) *HNSWIndex {
@@ -49,17 +97,58 @@ func NewHNSW(config Config) *HNSWIndex {
 	if config.EfConstruction <= 0 {
 		config.EfConstruction = 200
 	}
+	if config.DistanceName != "" {
+		fn, err := LookupDistanceFunc(config.DistanceName)
+		if err != nil {
+			panic(err.Error())
+		}
+		config.DistanceFunc = fn
+	}
+	// 距离函数只按注册名记录：只给出函数时索引没有名字，保存时拒绝
 	if config.DistanceFunc == nil {
 		config.DistanceFunc = L2Distance
+		config.DistanceName = DistanceL2
+	}
+	if config.Quantizer != nil {
+		if !config.Quantizer.Trained() {
+			panic("quantizer must be trained before creating the index")
+		}
+		if config.Quantizer.Dimension() != config.Dimension {
+			panic("quantizer dimension does not match index dimension")
+		}
+	}
+	if config.Normalize {
+		if config.DistanceName != DistanceCosine {
+			panic("Normalize requires the cosine distance")
+		}
+		// 单位向量的余弦距离就是 1 减内积，不需要再计算范数
+		config.DistanceFunc = unitCosineDistance
+	}
+	if config.QuantizedOnly && config.Quantizer == nil {
+		panic("QuantizedOnly requires a Quantizer")
+	}
+	if config.QuantizedOnly && config.Float16Vectors {
+		panic("Float16Vectors cannot be combined with QuantizedOnly, which keeps no vectors")
+	}
+	if config.PayloadSchema != nil {
+		if err := validatePayloadSchema(config.PayloadSchema); err != nil {
+			panic(err.Error())
+		}
+	}
+	if config.MaxFragments <= 0 {
+		config.MaxFragments = defaultMaxFragments
 	}
 	if config.Seed == 0 {
 		config.Seed = time.Now().UnixNano()
 	}
+	if config.NeighborSelector == nil {
+		config.NeighborSelector = HeuristicSelector{KeepPrunedConnections: true}
+	}
 
 	// normalization factor for level generation
 	ml := 1.0 / math.Log(float64(config.M))
 
-	return &HNSWIndex{
+	return &HNSWIndex{indexState: indexState{
 		M:              config.M,
 		Mmax:           config.M,
 		Mmax0:          config.M * 2,
@@ -67,45 +156,88 @@ func NewHNSW(config Config) *HNSWIndex {
 		ml:             ml,
 		dimension:      config.Dimension,
 		nodes:          make([]*Node, 0, 10000),
+		keys:           make(map[Key]int),
+		docs:           make(map[Key][]int),
 		entryPoint:     -1, // -1 表示还没有节点
 		maxLevel:       -1,
 		distFunc:       config.DistanceFunc,
+		distName:       config.DistanceName,
+		normalize:      config.Normalize,
+		seed:           config.Seed,
+		quantizer:      config.Quantizer,
+		quantizedOnly:  config.QuantizedOnly,
+		float16:        config.Float16Vectors,
+		selector:       config.NeighborSelector,
+		payloadSchema:  config.PayloadSchema,
+		maxFragments:   config.MaxFragments,
 		rng:            rand.New(rand.NewSource(config.Seed)),
-	}
+	}}
 }
 
 // Add inserts a new vector into the HNSW index and returns its assigned node ID.
 func (h *HNSWIndex) Add(vector []float32) (int, error) {
+	return h.add(Key{}, Key{}, vector, nil)
+}
+
+// add 插入向量，key 为零值时节点没有外部键，doc 为零值时节点不属于任何文档，
+// payload 已经过 checkPayload 检查
+func (h *HNSWIndex) add(key Key, doc Key, vector []float32, payload Payload) (int, error) {
+	if h.readOnly {
+		return -1, ErrReadOnly
+	}
 	if len(vector) != h.dimension {
 		return -1, ErrDimensionMismatch
 	}
 
-	vectorCopy := make([]float32, len(vector))
-	copy(vectorCopy, vector)
+	vectorCopy := h.unitVector(vector)
 
 	// Generate a random level for the new node
 	level := h.randomLevel()
 
 	// Create the new node
 	h.globalLock.Lock()
+	if !key.IsZero() {
+		if existing, ok := h.keys[key]; ok {
+			h.globalLock.Unlock()
+			return -1, fmt.Errorf("%w: key %v is used by node %d", ErrDuplicateKey, key, existing)
+		}
+	}
 	nodeID := len(h.nodes)
 	newNode := NewNode(nodeID, vectorCopy, level)
+	newNode.key = key
+	newNode.doc = doc
+	newNode.payload = payload
+	if !key.IsZero() {
+		h.keys[key] = nodeID
+	}
+	if !doc.IsZero() {
+		h.docs[doc] = append(h.docs[doc], nodeID)
+	}
+	h.encode(newNode)
 	h.nodes = append(h.nodes, newNode)
-	h.globalLock.Unlock()
+	h.publishNodes()
 
-	if nodeID == 0 {
-		h.globalLock.Lock()
+	// 没有入口点（空索引，或所有节点都已删除）时，新节点直接成为入口点
+	if h.entryPoint == -1 {
 		h.entryPoint = int32(nodeID)
 		h.maxLevel = int32(level)
 		h.globalLock.Unlock()
+		h.dropVector(newNode)
 		return nodeID, nil
 	}
+	h.globalLock.Unlock()
 
 	h.insert(newNode)
+	h.dropVector(newNode)
 
 	return nodeID, nil
 }
 
+// Search returns the k nearest neighbours of query, closest first. ef is the
+// width of the search; 0 means max(efConstruction, k). Vectors added with
+// AddDocument are grouped by document: only the closest vector of each
+// document is returned, and the search widens until k results are found or
+// the whole graph has been searched.
 func (h *HNSWIndex) Search(query []float32, k int, ef int) ([]SearchResult, error) {
 	if len(query) != h.dimension {
 		return nil, ErrDimensionMismatch
@@ -116,23 +248,202 @@ func (h *HNSWIndex) Search(query []float32, k int, ef int) ([]SearchResult, erro
 	}
 
 	h.globalLock.RLock()
-	if h.entryPoint == -1 {
+	if err := h.checkSearchable(); err != nil {
+		h.globalLock.RUnlock()
+		return nil, err
+	}
+	ep := h.entryPoint
+	maxLvl := h.maxLevel
+	numNodes := len(h.nodes)
+	h.globalLock.RUnlock()
+
+	return h.searchDistinct(query, k, ef, int(ep), int(maxLvl), numNodes, nil, nil)
+}
+
+// SearchRadius returns every live node whose distance to query is at most radius,
+// sorted by distance. Indexes created with QuantizedOnly compare the radius with
+// approximate distances. The radius is in the units of the index's distance function
+// (squared distance for L2Distance). ef is the width of the beam used to reach
+// the query neighbourhood; 0 means efConstruction.
+func (h *HNSWIndex) SearchRadius(query []float32, radius float32, ef int) ([]SearchResult, error) {
+	if len(query) != h.dimension {
+		return nil, ErrDimensionMismatch
+	}
+	if radius < 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	if ef == 0 {
+		ef = h.efConstruction
+	}
+
+	h.globalLock.RLock()
+	if err := h.checkSearchable(); err != nil {
 		h.globalLock.RUnlock()
-		return nil, ErrEmptyIndex
+		return nil, err
 	}
 	ep := h.entryPoint
 	maxLvl := h.maxLevel
 	h.globalLock.RUnlock()
 
-	return h.search(query, k, ef, int(ep), int(maxLvl))
+	// 从顶层贪心下降到第1层，然后在第0层按半径扩展
+	query = h.unitQuery(query)
+	scratch := getSearchScratch()
+	defer putSearchScratch(scratch)
+	if h.quantizedOnly {
+		defer h.attachDistancer(scratch, query)()
+	}
+	currentNearest := h.descend(query, int(ep), int(maxLvl), 0, scratch)
 
+	results := collapseDocuments(h.searchLayerRadius(query, currentNearest, ef, radius, scratch), h.snapshot())
+	h.attachKeys(results)
+	return results, nil
 }
 
-// Len returns the number of nodes in the HNSW index.
+// checkSearchable 在搜索开始前检查索引是否已关闭或为空，调用方持有 globalLock 读锁
+func (h *HNSWIndex) checkSearchable() error {
+	if h.closed {
+		return ErrClosed
+	}
+	if h.entryPoint == -1 {
+		return ErrEmptyIndex
+	}
+	return nil
+}
+
+// Len returns the number of live (non-deleted) nodes in the HNSW index.
 func (h *HNSWIndex) Len() int {
 	h.globalLock.RLock()
 	defer h.globalLock.RUnlock()
-	return len(h.nodes)
+	return len(h.nodes) - h.numDeleted
+}
+
+// encode 按索引配置为节点生成半精度向量和量化编码，两者都没有配置时什么都不做
+func (h *HNSWIndex) encode(node *Node) {
+	if h.float16 {
+		if vector := node.vectorRef(); vector != nil {
+			node.setHalf(arrow.Float16sFromFloat32(nil, vector))
+		}
+	}
+	if h.quantizer != nil {
+		node.setCode(h.quantizer.Encode(h.nodeVector(node)))
+	}
+}
+
+// dropVector 在只保留量化编码或半精度向量的索引中丢弃节点的原始向量。
+// 只能在节点连接完成之后调用，插入时仍然使用原始向量
+func (h *HNSWIndex) dropVector(node *Node) {
+	if h.quantizedOnly || h.float16 {
+		node.setVector(nil)
+	}
+}
+
+// unitVector 返回 vector 的副本，Normalize 模式下缩放为单位长度
+func (h *HNSWIndex) unitVector(vector []float32) []float32 {
+	vectorCopy := make([]float32, len(vector))
+	copy(vectorCopy, vector)
+	if h.normalize {
+		Normalize(vectorCopy)
+	}
+	return vectorCopy
+}
+
+// unitQuery 在 Normalize 模式下返回缩放为单位长度的查询副本，否则原样返回 query
+func (h *HNSWIndex) unitQuery(query []float32) []float32 {
+	if !h.normalize {
+		return query
+	}
+	return h.unitVector(query)
+}
+
+// nodeVector 返回节点的向量；原始向量已被丢弃时返回由半精度向量或量化编码解码出的向量。
+// 返回值不能修改
+func (h *HNSWIndex) nodeVector(node *Node) []float32 {
+	if vector := node.vectorRef(); vector != nil {
+		return vector
+	}
+	if half := node.halfRef(); half != nil {
+		return arrow.Float16sToFloat32(nil, half)
+	}
+	return h.quantizer.Decode(node.codeRef(), nil)
+}
+
+// scratchVector 与 nodeVector 相同，但半精度向量解码到 scratch.vec 中，搜索时不用每次分配。
+// 返回值在下一次使用 scratch.vec 之前有效
+func (h *HNSWIndex) scratchVector(node *Node, scratch *searchScratch) []float32 {
+	if vector := node.vectorRef(); vector != nil {
+		return vector
+	}
+	if half := node.halfRef(); half != nil {
+		scratch.vec = arrow.Float16sToFloat32(scratch.vec, half)
+		return scratch.vec
+	}
+	scratch.vec = h.quantizer.Decode(node.codeRef(), scratch.vec)
+	return scratch.vec
+}
+
+// decodeBuffer 存放从半精度向量或量化编码解码出的向量。与 scratchVector 不同，
+// 解码出的多个向量同时有效，直到缓冲区放回池中，邻居选择和剪枝不用为每个向量分配
+type decodeBuffer struct {
+	values []float32
+	used   int
+}
+
+// decodePool 缓存 decodeBuffer，缓冲区的内存在多次插入和剪枝之间复用
+var decodePool = sync.Pool{
+	New: func() any { return &decodeBuffer{} },
+}
+
+func getDecodeBuffer() *decodeBuffer {
+	return decodePool.Get().(*decodeBuffer)
+}
+
+func putDecodeBuffer(b *decodeBuffer) {
+	b.used = 0
+	decodePool.Put(b)
+}
+
+// vector 与 nodeVector 相同，但需要解码的向量解码到缓冲区中，在缓冲区放回池中之前有效
+func (b *decodeBuffer) vector(h *HNSWIndex, node *Node) []float32 {
+	if vector := node.vectorRef(); vector != nil {
+		return vector
+	}
+	if b.used+h.dimension > len(b.values) {
+		// 已经返回的向量还引用着旧的内存，换一块更大的而不是扩容复制
+		b.values = make([]float32, max(2*len(b.values), 64*h.dimension))
+		b.used = 0
+	}
+	dst := b.values[b.used : b.used : b.used+h.dimension]
+	b.used += h.dimension
+	if half := node.halfRef(); half != nil {
+		return arrow.Float16sToFloat32(dst, half)
+	}
+	return h.quantizer.Decode(node.codeRef(), dst)
+}
+
+// publishNodes 发布 h.nodes 的当前快照。调用方持有 globalLock 写锁。
+// 已发布的元素之后不会再被修改，append 只写快照长度之外的位置，
+// 所以读者拿到快照后可以不加锁地访问其中的任意节点
+func (h *HNSWIndex) publishNodes() {
+	snapshot := h.nodes
+	h.published.Store(&snapshot)
+}
+
+// snapshot 返回已发布节点的只读视图，不需要持有任何锁。
+// 出现在任何连接表中的节点 ID 都在它被连接之前发布，所以一定在快照范围内
+func (h *HNSWIndex) snapshot() []*Node {
+	if p := h.published.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// maxConnections returns the connection limit for the given level.
+func (h *HNSWIndex) maxConnections(level int) int {
+	if level == 0 {
+		return h.Mmax0
+	}
+	return h.Mmax
 }
 
 // randomLevel generates a random level for a new node based on an exponential distribution.
@@ -162,6 +473,9 @@ func (h *HNSWIndex) randomLevel() int {
 // SearchResult represents a single search result with its ID and distance.
 type SearchResult struct {
 	ID       int
+	Key      Key     // External key of the node, zero if it was added without one.
+	Payload  Payload // Payload of the node, nil if it was added without one.
+	Document Key     // Document the node belongs to, zero unless it was added with AddDocument.
 	Distance float32
 }
 
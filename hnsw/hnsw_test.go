@@ -1,9 +1,13 @@
 package hnsw
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"ollama-demo/lance/arrow"
+	"reflect"
+	"slices"
 	"sort"
 	"sync"
 	"testing"
@@ -756,30 +760,211 @@ func BenchmarkHNSWSearchDifferentEf(b *testing.B) {
 }
 
 func BenchmarkDistanceFunctions(b *testing.B) {
-	a := make([]float32, 128)
-	vec := make([]float32, 128)
+	for _, dim := range []int{128, 768, 1536} {
+		a := make([]float32, dim)
+		vec := make([]float32, dim)
+		for i := range a {
+			a[i] = rand.Float32()
+			vec[i] = rand.Float32()
+		}
+
+		// Scalar 是逐分量累加的基准实现，Generic 是展开的纯 Go 内核，其余为实际使用的内核
+		var sink float32
+		benchmarks := []struct {
+			name string
+			fn   func(a, b []float32) float32
+		}{
+			{"L2Distance/Scalar", scalarL2},
+			{"L2Distance/Generic", squaredL2Generic},
+			{"L2Distance", L2Distance},
+			{"InnerProductDistance/Scalar", scalarDot},
+			{"InnerProductDistance/Generic", dotGeneric},
+			{"InnerProductDistance", InnerProductDistance},
+			{"CosineDistance/Scalar", scalarCosine},
+			{"CosineDistance/Generic", func(a, b []float32) float32 {
+				d, na, nb := dotNormsGeneric(a, b)
+				return 1 - d/float32(math.Sqrt(float64(na)*float64(nb)))
+			}},
+			{"CosineDistance", CosineDistance},
+			{"CosineDistance/Normalized", unitCosineDistance},
+		}
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("%s/dim=%d", bm.name, dim), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					sink += bm.fn(a, vec)
+				}
+			})
+		}
+		_ = sink
+	}
+}
+
+func scalarL2(a, b []float32) float32 {
+	var sum float32
 	for i := range a {
-		a[i] = rand.Float32()
-		vec[i] = rand.Float32()
+		diff := a[i] - b[i]
+		sum += diff * diff
 	}
+	return sum
+}
 
-	b.Run("L2Distance", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			L2Distance(a, vec)
+func scalarDot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func scalarCosine(a, b []float32) float32 {
+	var dotProduct, normA, normB float32
+	for i := range a {
+		dotProduct += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	return 1 - dotProduct/(float32(math.Sqrt(float64(normA)))*float32(math.Sqrt(float64(normB))))
+}
+
+func TestDistanceKernels(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+
+	// 覆盖各个循环的边界以及常见维度，与 float64 的参考值比较
+	lengths := []int{0, 1, 3, 4, 7, 8, 9, 15, 16, 17, 31, 32, 33, 63, 100, 128, 768, 1536}
+	for _, n := range lengths {
+		a := make([]float32, n)
+		b := make([]float32, n)
+		var refDot, refL2, refA, refB float64
+		for i := range a {
+			a[i] = rng.Float32()*2 - 1
+			b[i] = rng.Float32()*2 - 1
+			refDot += float64(a[i]) * float64(b[i])
+			refL2 += (float64(a[i]) - float64(b[i])) * (float64(a[i]) - float64(b[i]))
+			refA += float64(a[i]) * float64(a[i])
+			refB += float64(b[i]) * float64(b[i])
 		}
-	})
 
-	b.Run("InnerProductDistance", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			InnerProductDistance(a, vec)
+		check := func(name string, got float32, want float64) {
+			if math.Abs(float64(got)-want) > 1e-5*math.Max(1, math.Abs(want))*float64(max(n, 1)) {
+				t.Errorf("%s(len=%d) = %f, want %f", name, n, got, want)
+			}
 		}
-	})
+		check("dot", dot(a, b), refDot)
+		check("dotGeneric", dotGeneric(a, b), refDot)
+		check("squaredL2", squaredL2(a, b), refL2)
+		check("squaredL2Generic", squaredL2Generic(a, b), refL2)
+		for _, fn := range []func(a, b []float32) (float32, float32, float32){dotNorms, dotNormsGeneric} {
+			d, na, nb := fn(a, b)
+			check("dotNorms.dot", d, refDot)
+			check("dotNorms.normA", na, refA)
+			check("dotNorms.normB", nb, refB)
+		}
+	}
 
-	b.Run("CosineDistance", func(b *testing.B) {
-		for i := 0; i < b.N; i++ {
-			CosineDistance(a, vec)
+	// 子切片不从对齐的地址开始
+	a := make([]float32, 101)
+	b := make([]float32, 101)
+	for i := range a {
+		a[i] = float32(i)
+		b[i] = 1
+	}
+	if got := dot(a[1:], b[:100]); got != 5050 {
+		t.Errorf("Unaligned dot = %f, want 5050", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	v := Normalize([]float32{3, 4})
+	if abs(v[0]-0.6) > 1e-6 || abs(v[1]-0.8) > 1e-6 {
+		t.Errorf("Normalize([3 4]) = %v, want [0.6 0.8]", v)
+	}
+	if zero := Normalize([]float32{0, 0}); zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("Normalize of zero vector = %v, want unchanged", zero)
+	}
+
+	rng := rand.New(rand.NewSource(37))
+	numVectors := 500
+	vectors := make([][]float32, numVectors)
+	for i := range vectors {
+		vectors[i] = make([]float32, 16)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32() * 10
 		}
-	})
+	}
+
+	index := NewHNSW(Config{Dimension: 16, Seed: 42, DistanceName: DistanceCosine, Normalize: true})
+	if _, err := index.AddBatch(vectors[:250], 4); err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	for _, v := range vectors[250:] {
+		index.Add(v)
+	}
+	if norm := dot(index.nodes[0].Vector(), index.nodes[0].Vector()); abs(norm-1) > 1e-5 {
+		t.Errorf("Stored vector has squared norm %f, want 1", norm)
+	}
+
+	// 距离与在原始向量上计算的余弦距离一致，排序与精确搜索一致
+	query := vectors[3]
+	results, err := index.Search(query, 10, 100)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, r := range results {
+		if want := CosineDistance(query, vectors[r.ID]); abs(r.Distance-want) > 1e-5 {
+			t.Errorf("Node %d distance %f, want cosine distance %f", r.ID, r.Distance, want)
+		}
+	}
+	flat := NewFlatIndexFromHNSW(index)
+	truth, _ := flat.Search(query, 10, 0)
+	if recall := Recall(results, truth); recall < 0.9 {
+		t.Errorf("Recall against flat index %.2f, want >= 0.9", recall)
+	}
+
+	// 归一化模式随索引保存
+	dir := t.TempDir()
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.normalize || loaded.distName != DistanceCosine {
+		t.Errorf("Loaded index normalize=%v distance=%q", loaded.normalize, loaded.distName)
+	}
+	loadedResults, _ := loaded.Search(query, 10, 100)
+	if len(loadedResults) != len(results) || loadedResults[0].ID != results[0].ID {
+		t.Errorf("Loaded index returned %v, want %v", loadedResults, results)
+	}
+
+	// 量化器在归一化后的向量上训练，编码覆盖索引实际存储的取值范围
+	units := make([][]float32, len(vectors))
+	for i, v := range vectors {
+		units[i] = Normalize(append([]float32(nil), v...))
+	}
+	sq := NewScalarQuantizer(16)
+	if err := sq.Train(units); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	quantized := NewHNSW(Config{Dimension: 16, Seed: 42, DistanceName: DistanceCosine, Normalize: true,
+		Quantizer: sq, QuantizedOnly: true})
+	if _, err := quantized.AddBatch(vectors, 4); err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	quantizedResults, err := quantized.Search(query, 10, 100)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if recall := Recall(quantizedResults, truth); recall < 0.8 {
+		t.Errorf("Recall of quantized normalized index %.2f, want >= 0.8", recall)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Normalize with L2 distance to panic")
+		}
+	}()
+	NewHNSW(Config{Dimension: 4, Normalize: true})
 }
 
 // ==================== 特殊距离函数测试 ====================
@@ -806,3 +991,1932 @@ func TestCosineDistance(t *testing.T) {
 		t.Errorf("Cosine distance of opposite vectors should be ~2, got %f", dist)
 	}
 }
+
+// ==================== 删除测试 ====================
+
+func TestDelete(t *testing.T) {
+	config := Config{
+		M:              16,
+		EfConstruction: 200,
+		Dimension:      32,
+		Seed:           42,
+	}
+
+	index := NewHNSW(config)
+
+	rng := rand.New(rand.NewSource(42))
+	numVectors := 1000
+	vectors := make([][]float32, numVectors)
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+		index.Add(vector)
+	}
+
+	// 删除前 100 个节点
+	deleted := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		if err := index.Delete(i); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+		deleted[i] = true
+	}
+
+	if index.Len() != numVectors-100 {
+		t.Errorf("Expected Len %d after delete, got %d", numVectors-100, index.Len())
+	}
+
+	// 已删除节点不能出现在结果中，召回率按存活节点计算
+	liveVectors := make([][]float32, 0, numVectors-100)
+	liveIDs := make([]int, 0, numVectors-100)
+	for i, v := range vectors {
+		if !deleted[i] {
+			liveVectors = append(liveVectors, v)
+			liveIDs = append(liveIDs, i)
+		}
+	}
+
+	totalRecall := 0.0
+	numQueries := 50
+	for q := 0; q < numQueries; q++ {
+		query := vectors[q] // 查询被删除的向量本身
+		results, err := index.Search(query, 10, 100)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, r := range results {
+			if deleted[r.ID] {
+				t.Fatalf("Search returned deleted node %d", r.ID)
+			}
+		}
+
+		groundTruth := bruteForceSearch(query, liveVectors, 10)
+		for i := range groundTruth {
+			groundTruth[i].ID = liveIDs[groundTruth[i].ID]
+		}
+		totalRecall += calculateRecall(results, groundTruth)
+	}
+
+	avgRecall := totalRecall / float64(numQueries)
+	t.Logf("Recall@10 after deleting 10%%: %.2f%%", avgRecall*100)
+	if avgRecall < 0.90 {
+		t.Errorf("Recall too low after delete: %.2f%%", avgRecall*100)
+	}
+
+	// 错误情况
+	if err := index.Delete(0); !errors.Is(err, ErrNodeDeleted) {
+		t.Errorf("Expected ErrNodeDeleted, got %v", err)
+	}
+	if err := index.Delete(numVectors); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+func TestDeleteEntryPoint(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 8, Seed: 1})
+
+	for i := 0; i < 200; i++ {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = rand.Float32()
+		}
+		index.Add(vector)
+	}
+
+	// 反复删除入口点，入口点必须始终指向存活节点
+	for i := 0; i < 50; i++ {
+		ep := int(index.entryPoint)
+		if err := index.Delete(ep); err != nil {
+			t.Fatalf("Delete entry point %d failed: %v", ep, err)
+		}
+		if index.entryPoint == -1 || index.nodes[index.entryPoint].IsDeleted() {
+			t.Fatalf("Entry point %d is invalid after deleting %d", index.entryPoint, ep)
+		}
+		if int32(index.nodes[index.entryPoint].Level()) != index.maxLevel {
+			t.Errorf("maxLevel %d does not match entry point level %d",
+				index.maxLevel, index.nodes[index.entryPoint].Level())
+		}
+	}
+
+	results, err := index.Search(make([]float32, 8), 10, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 10 {
+		t.Errorf("Expected 10 results, got %d", len(results))
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 4})
+
+	for i := 0; i < 10; i++ {
+		index.Add([]float32{float32(i), 0, 0, 0})
+	}
+	for i := 0; i < 10; i++ {
+		if err := index.Delete(i); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+	}
+
+	if _, err := index.Search([]float32{0, 0, 0, 0}, 1, 0); err != ErrEmptyIndex {
+		t.Errorf("Expected ErrEmptyIndex after deleting all nodes, got %v", err)
+	}
+
+	// 删除所有节点后仍可继续添加
+	id, err := index.Add([]float32{1, 1, 1, 1})
+	if err != nil {
+		t.Fatalf("Add after delete-all failed: %v", err)
+	}
+	results, err := index.Search([]float32{1, 1, 1, 1}, 5, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != id {
+		t.Errorf("Expected only node %d, got %+v", id, results)
+	}
+}
+
+// ==================== 更新测试 ====================
+
+func TestUpdate(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 16, Seed: 42})
+
+	rng := rand.New(rand.NewSource(7))
+	numVectors := 500
+	vectors := make([][]float32, numVectors)
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+		index.Add(vector)
+	}
+
+	// 把 20% 的向量替换成新的随机向量
+	for i := 0; i < numVectors; i += 5 {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		if err := index.Update(i, vector); err != nil {
+			t.Fatalf("Update(%d) failed: %v", i, err)
+		}
+		vectors[i] = vector
+	}
+
+	if index.Len() != numVectors {
+		t.Errorf("Update must not change Len: got %d, want %d", index.Len(), numVectors)
+	}
+
+	// 用新向量查询，应该找到同一个 ID
+	for i := 0; i < numVectors; i += 5 {
+		results, err := index.Search(vectors[i], 1, 100)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) == 0 || results[0].ID != i {
+			t.Errorf("Expected updated node %d as top result, got %+v", i, results)
+		}
+	}
+
+	// 整体召回率不下降
+	totalRecall := 0.0
+	numQueries := 50
+	for q := 0; q < numQueries; q++ {
+		query := make([]float32, 16)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		results, _ := index.Search(query, 10, 100)
+		totalRecall += calculateRecall(results, bruteForceSearch(query, vectors, 10))
+	}
+	avgRecall := totalRecall / float64(numQueries)
+	t.Logf("Recall@10 after updating 20%%: %.2f%%", avgRecall*100)
+	if avgRecall < 0.90 {
+		t.Errorf("Recall too low after update: %.2f%%", avgRecall*100)
+	}
+
+	// 错误情况
+	if err := index.Update(0, []float32{1, 2}); err != ErrDimensionMismatch {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+	if err := index.Update(numVectors, vectors[0]); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+	index.Delete(1)
+	if err := index.Update(1, vectors[0]); !errors.Is(err, ErrNodeDeleted) {
+		t.Errorf("Expected ErrNodeDeleted, got %v", err)
+	}
+}
+
+func TestUpdateEntryPoint(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 4, Seed: 5})
+	for i := 0; i < 100; i++ {
+		index.Add([]float32{float32(i), float32(i % 7), 0, 1})
+	}
+
+	ep := int(index.entryPoint)
+	target := []float32{1000, 1000, 1000, 1000}
+	if err := index.Update(ep, target); err != nil {
+		t.Fatalf("Update entry point failed: %v", err)
+	}
+
+	for level := 0; level <= index.nodes[ep].Level(); level++ {
+		if containsID(index.nodes[ep].GetConnections(level), ep) {
+			t.Errorf("Node %d links to itself at level %d", ep, level)
+		}
+	}
+
+	results, err := index.Search(target, 1, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != ep {
+		t.Errorf("Expected entry point %d as top result, got %d", ep, results[0].ID)
+	}
+
+	results, _ = index.Search([]float32{50, 1, 0, 1}, 5, 50)
+	if len(results) != 5 {
+		t.Errorf("Expected 5 results, got %d", len(results))
+	}
+}
+
+// ==================== 过滤搜索测试 ====================
+
+func TestSearchWithFilter(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 32, Seed: 42})
+
+	rng := rand.New(rand.NewSource(11))
+	numVectors := 2000
+	vectors := make([][]float32, numVectors)
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+		index.Add(vector)
+	}
+
+	// 只允许偶数 ID
+	even := FilterFunc(func(id int) bool { return id%2 == 0 })
+
+	evenVectors := make([][]float32, 0, numVectors/2)
+	for i := 0; i < numVectors; i += 2 {
+		evenVectors = append(evenVectors, vectors[i])
+	}
+
+	totalRecall := 0.0
+	numQueries := 50
+	for q := 0; q < numQueries; q++ {
+		query := vectors[2*q+1] // 奇数向量本身不允许返回
+		results, err := index.SearchWithFilter(query, 10, 100, even)
+		if err != nil {
+			t.Fatalf("SearchWithFilter failed: %v", err)
+		}
+		for _, r := range results {
+			if r.ID%2 != 0 {
+				t.Fatalf("Filtered search returned disallowed node %d", r.ID)
+			}
+		}
+
+		groundTruth := bruteForceSearch(query, evenVectors, 10)
+		for i := range groundTruth {
+			groundTruth[i].ID *= 2
+		}
+		totalRecall += calculateRecall(results, groundTruth)
+	}
+
+	avgRecall := totalRecall / float64(numQueries)
+	t.Logf("Filtered Recall@10 (50%% selectivity): %.2f%%", avgRecall*100)
+	if avgRecall < 0.90 {
+		t.Errorf("Filtered recall too low: %.2f%%", avgRecall*100)
+	}
+}
+
+func TestSearchWithBitmapFilterSelective(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 16, Seed: 42})
+
+	rng := rand.New(rand.NewSource(12))
+	numVectors := 2000
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+
+	// 只允许 0.5% 的节点：ef 需要自适应扩大才能凑够 k 个结果
+	allowed := arrow.NewBitmap(numVectors)
+	for i := 0; i < numVectors; i += 200 {
+		allowed.Set(i)
+	}
+
+	query := make([]float32, 16)
+	results, err := index.SearchWithFilter(query, 5, 10, NewBitmapFilter(allowed))
+	if err != nil {
+		t.Fatalf("SearchWithFilter failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results from selective filter, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.ID%200 != 0 {
+			t.Errorf("Bitmap filter returned disallowed node %d", r.ID)
+		}
+	}
+
+	// 允许列表为空时返回空结果而不是报错
+	results, err = index.SearchWithFilter(query, 5, 10, NewBitmapFilter(arrow.NewBitmap(numVectors)))
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected no results for empty allow-list, got %v, %v", results, err)
+	}
+}
+
+// ==================== 半径搜索测试 ====================
+
+func TestSearchRadius(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 16, Seed: 42})
+
+	rng := rand.New(rand.NewSource(13))
+	numVectors := 2000
+	vectors := make([][]float32, numVectors)
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+		index.Add(vector)
+	}
+
+	totalFound, totalExpected := 0, 0
+	for q := 0; q < 20; q++ {
+		query := vectors[q]
+
+		// 以第 30 近邻的距离作为半径，期望约 30 个结果
+		radius := bruteForceSearch(query, vectors, 30)[29].Distance
+
+		results, err := index.SearchRadius(query, radius, 50)
+		if err != nil {
+			t.Fatalf("SearchRadius failed: %v", err)
+		}
+
+		for i, r := range results {
+			if r.Distance > radius {
+				t.Fatalf("Result %d outside radius: %f > %f", r.ID, r.Distance, radius)
+			}
+			if i > 0 && results[i-1].Distance > r.Distance {
+				t.Fatalf("Results not sorted by distance at %d", i)
+			}
+		}
+
+		expected := 0
+		for _, v := range vectors {
+			if L2Distance(query, v) <= radius {
+				expected++
+			}
+		}
+		totalFound += len(results)
+		totalExpected += expected
+	}
+
+	recall := float64(totalFound) / float64(totalExpected)
+	t.Logf("Range search recall: %.2f%%", recall*100)
+	if recall < 0.90 {
+		t.Errorf("Range search recall too low: %.2f%%", recall*100)
+	}
+
+	// 近似重复检测：半径 0 只返回完全相同的向量
+	results, err := index.SearchRadius(vectors[7], 0, 0)
+	if err != nil {
+		t.Fatalf("SearchRadius failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != 7 {
+		t.Errorf("Expected only node 7 within radius 0, got %+v", results)
+	}
+
+	if _, err := index.SearchRadius(vectors[0], -1, 0); err != ErrInvalidParameter {
+		t.Errorf("Expected ErrInvalidParameter for negative radius, got %v", err)
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 32, Seed: 42})
+
+	rng := rand.New(rand.NewSource(17))
+	numVectors := 3000
+	vectors := make([][]float32, numVectors)
+	for i := range vectors {
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	// 第一批建立初始图，第二批在已有图上并发插入
+	ids, err := index.AddBatch(vectors[:1000], 8)
+	if err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	moreIDs, err := index.AddBatch(vectors[1000:], 8)
+	if err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	ids = append(ids, moreIDs...)
+
+	for i, id := range ids {
+		if id != i {
+			t.Fatalf("Expected ID %d at position %d, got %d", i, i, id)
+		}
+	}
+	if index.Len() != numVectors {
+		t.Fatalf("Expected %d nodes, got %d", numVectors, index.Len())
+	}
+
+	// 每一层的连接数都不超过上限，且不包含自环
+	for _, node := range index.nodes {
+		for lc := 0; lc <= node.Level(); lc++ {
+			conns := node.GetConnections(lc)
+			if len(conns) > index.maxConnections(lc) {
+				t.Fatalf("Node %d has %d connections at level %d, limit %d",
+					node.ID(), len(conns), lc, index.maxConnections(lc))
+			}
+			if containsID(conns, node.ID()) {
+				t.Fatalf("Node %d links to itself at level %d", node.ID(), lc)
+			}
+		}
+	}
+
+	// 没有丢失的连接：第0层从入口点出发可以到达所有节点
+	reached := 0
+	for _, ok := range index.reachable(int(index.entryPoint)) {
+		if ok {
+			reached++
+		}
+	}
+	if reached != numVectors {
+		t.Errorf("Only %d of %d nodes reachable from entry point", reached, numVectors)
+	}
+
+	// 入口点必须是层级最高的节点
+	for _, node := range index.nodes {
+		if node.Level() > int(index.maxLevel) {
+			t.Errorf("Node %d has level %d above maxLevel %d", node.ID(), node.Level(), index.maxLevel)
+		}
+	}
+	if index.nodes[index.entryPoint].Level() != int(index.maxLevel) {
+		t.Errorf("Entry point level %d does not match maxLevel %d",
+			index.nodes[index.entryPoint].Level(), index.maxLevel)
+	}
+
+	totalRecall := 0.0
+	numQueries := 50
+	for q := 0; q < numQueries; q++ {
+		query := vectors[rng.Intn(numVectors)]
+		results, err := index.Search(query, 10, 100)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		totalRecall += calculateRecall(results, bruteForceSearch(query, vectors, 10))
+	}
+	avgRecall := totalRecall / float64(numQueries)
+	t.Logf("AddBatch recall@10: %.2f%%", avgRecall*100)
+	if avgRecall < 0.90 {
+		t.Errorf("Recall too low: %.2f%%", avgRecall*100)
+	}
+
+	// 任意一个向量维度错误时整批拒绝，索引不变
+	_, err = index.AddBatch([][]float32{make([]float32, 32), make([]float32, 16)}, 2)
+	if !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+	if index.Len() != numVectors {
+		t.Errorf("Failed batch changed index size to %d", index.Len())
+	}
+}
+
+func TestInsertKeepsInEdge(t *testing.T) {
+	// 大量重复向量且连接上限很小时，邻居剪枝很容易把新节点去掉；
+	// 每个新节点插入后至少要有一条入边，否则搜索永远到达不了它
+	rng := rand.New(rand.NewSource(6))
+	index := NewHNSW(Config{Dimension: 4, M: 2, Seed: 42, NeighborSelector: SimpleSelector{}})
+	centers := make([][]float32, 5)
+	for i := range centers {
+		centers[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	for i := 0; i < 300; i++ {
+		id, err := index.Add(centers[i%len(centers)])
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if id == 0 {
+			continue
+		}
+		inEdges := 0
+		for _, node := range index.nodes {
+			if containsID(node.GetConnections(0), id) {
+				inEdges++
+			}
+		}
+		if inEdges == 0 {
+			t.Fatalf("Node %d has no in-edge at level 0 after insertion", id)
+		}
+	}
+}
+
+func BenchmarkHNSWAddBatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, 5000)
+	for i := range vectors {
+		vector := make([]float32, 128)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index := NewHNSW(Config{Dimension: 128, Seed: 42})
+			for _, vector := range vectors {
+				index.Add(vector)
+			}
+		}
+	})
+
+	for _, workers := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("Workers%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				index := NewHNSW(Config{Dimension: 128, Seed: 42})
+				index.AddBatch(vectors, workers)
+			}
+		})
+	}
+}
+
+func TestSearchBatch(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 32, Seed: 42})
+
+	rng := rand.New(rand.NewSource(23))
+	for i := 0; i < 2000; i++ {
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+
+	queries := make([][]float32, 200)
+	for i := range queries {
+		query := make([]float32, 32)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		queries[i] = query
+	}
+
+	batchResults, err := index.SearchBatch(queries, 10, 50, 4)
+	if err != nil {
+		t.Fatalf("SearchBatch failed: %v", err)
+	}
+	if len(batchResults) != len(queries) {
+		t.Fatalf("Expected %d result sets, got %d", len(queries), len(batchResults))
+	}
+
+	// 复用的临时空间不能影响结果：每个查询都应与单独调用 Search 完全一致
+	for i, query := range queries {
+		expected, err := index.Search(query, 10, 50)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(batchResults[i]) != len(expected) {
+			t.Fatalf("Query %d: expected %d results, got %d", i, len(expected), len(batchResults[i]))
+		}
+		for j := range expected {
+			if batchResults[i][j].ID != expected[j].ID || batchResults[i][j].Distance != expected[j].Distance {
+				t.Fatalf("Query %d result %d: expected %+v, got %+v", i, j, expected[j], batchResults[i][j])
+			}
+		}
+	}
+
+	if _, err := index.SearchBatch([][]float32{make([]float32, 16)}, 10, 0, 2); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+
+	empty := NewHNSW(Config{Dimension: 32})
+	if _, err := empty.SearchBatch(queries, 10, 0, 2); err != ErrEmptyIndex {
+		t.Errorf("Expected ErrEmptyIndex, got %v", err)
+	}
+}
+
+func BenchmarkHNSWSearchBatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	index := NewHNSW(Config{Dimension: 128, Seed: 42})
+	for i := 0; i < 10000; i++ {
+		vector := make([]float32, 128)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+
+	queries := make([][]float32, 500)
+	for i := range queries {
+		query := make([]float32, 128)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		queries[i] = query
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, query := range queries {
+				index.Search(query, 10, 100)
+			}
+		}
+	})
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("Workers%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index.SearchBatch(queries, 10, 100, workers)
+			}
+		})
+	}
+}
+
+// BenchmarkHNSWSearchQPS 报告单线程搜索的吞吐和每次查询的内存分配
+func BenchmarkHNSWSearchQPS(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	index := NewHNSW(Config{Dimension: 128, Seed: 42})
+	vectors := make([][]float32, 5000)
+	for i := range vectors {
+		vector := make([]float32, 128)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+	index.AddBatch(vectors, 0)
+
+	queries := make([][]float32, 100)
+	for i := range queries {
+		query := make([]float32, 128)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		queries[i] = query
+	}
+
+	for _, ef := range []int{50, 200} {
+		b.Run(fmt.Sprintf("ef=%d", ef), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Search(queries[i%len(queries)], 10, ef)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/s")
+		})
+	}
+}
+
+func TestSearchScratch(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+
+	minHeap := candidateHeap{}
+	maxHeap := candidateHeap{max: true}
+	dists := make([]float32, 500)
+	for i := range dists {
+		dists[i] = rng.Float32()
+		minHeap.Push(candidate{id: i, dist: dists[i]})
+		maxHeap.Push(candidate{id: i, dist: dists[i]})
+	}
+	sort.Slice(dists, func(i, j int) bool { return dists[i] < dists[j] })
+
+	for i := range dists {
+		if got := minHeap.Pop().dist; got != dists[i] {
+			t.Fatalf("Min heap pop %d: expected %f, got %f", i, dists[i], got)
+		}
+		if got := maxHeap.Pop().dist; got != dists[len(dists)-1-i] {
+			t.Fatalf("Max heap pop %d: expected %f, got %f", i, dists[len(dists)-1-i], got)
+		}
+	}
+
+	// 代数计数器回绕时必须清空旧标记
+	var visited visitedSet
+	visited.reset(10)
+	visited.visit(3)
+	visited.gen = math.MaxUint32
+	visited.marks[4] = 1
+	visited.reset(10)
+	if visited.visit(4) {
+		t.Error("Stale mark survived generation wraparound")
+	}
+	if !visited.visit(4) {
+		t.Error("Expected node 4 to be marked visited")
+	}
+}
+
+func TestFlatIndex(t *testing.T) {
+	flat := NewFlatIndex(16, nil)
+
+	rng := rand.New(rand.NewSource(29))
+	vectors := make([][]float32, 500)
+	for i := range vectors {
+		vector := make([]float32, 16)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+		if id, err := flat.Add(vector); err != nil || id != i {
+			t.Fatalf("Add returned (%d, %v), expected (%d, nil)", id, err, i)
+		}
+	}
+
+	query := vectors[42]
+	results, err := flat.Search(query, 10, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	expected := bruteForceSearch(query, vectors, 10)
+	for i := range expected {
+		if results[i].ID != expected[i].ID {
+			t.Errorf("Result %d: expected ID %d, got %d", i, expected[i].ID, results[i].ID)
+		}
+	}
+
+	if err := flat.Delete(42); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	results, _ = flat.Search(query, 10, 0)
+	for _, r := range results {
+		if r.ID == 42 {
+			t.Error("Deleted vector returned by search")
+		}
+	}
+	if flat.Len() != 499 {
+		t.Errorf("Expected 499 live vectors, got %d", flat.Len())
+	}
+
+	if _, err := flat.Search(make([]float32, 8), 10, 0); err != ErrDimensionMismatch {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 32, Seed: 42})
+
+	rng := rand.New(rand.NewSource(31))
+	for i := 0; i < 3000; i++ {
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+	index.Delete(7)
+
+	queries := make([][]float32, 50)
+	for i := range queries {
+		query := make([]float32, 32)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		queries[i] = query
+	}
+
+	flat := NewFlatIndexFromHNSW(index)
+	if flat.Len() != index.Len() {
+		t.Fatalf("Flat index has %d vectors, HNSW has %d", flat.Len(), index.Len())
+	}
+
+	truth, err := GroundTruth(flat, queries, 10)
+	if err != nil {
+		t.Fatalf("GroundTruth failed: %v", err)
+	}
+
+	evals, err := index.Evaluate(queries, truth, 10, []int{10, 50, 200})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(evals) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(evals))
+	}
+
+	for i, e := range evals {
+		t.Logf("ef=%d recall@10=%.3f latency=%v distances=%.0f",
+			e.Ef, e.Recall, e.MeanLatency, e.MeanDistanceComputations)
+
+		if e.MeanDistanceComputations <= 0 || e.MeanDistanceComputations >= 3000 {
+			t.Errorf("ef=%d: implausible distance count %.0f", e.Ef, e.MeanDistanceComputations)
+		}
+		if i > 0 && e.MeanDistanceComputations <= evals[i-1].MeanDistanceComputations {
+			t.Errorf("ef=%d: larger ef should compute more distances", e.Ef)
+		}
+	}
+	if evals[2].Recall < 0.95 {
+		t.Errorf("Recall at ef=200 too low: %.3f", evals[2].Recall)
+	}
+	if evals[2].Recall < evals[0].Recall {
+		t.Errorf("Recall decreased from %.3f to %.3f as ef grew", evals[0].Recall, evals[2].Recall)
+	}
+
+	if _, err := index.Evaluate(queries, truth[:1], 10, []int{10}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for mismatched ground truth, got %v", err)
+	}
+}
+
+func TestScalarQuantizer(t *testing.T) {
+	rng := rand.New(rand.NewSource(37))
+	vectors := make([][]float32, 3000)
+	for i := range vectors {
+		vector := make([]float32, 64)
+		for j := range vector {
+			vector[j] = rng.Float32()*4 - 2
+		}
+		vectors[i] = vector
+	}
+
+	quantizer := NewScalarQuantizer(64)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+
+	// 每个维度的重建误差不超过半个量化级
+	decoded := quantizer.Decode(quantizer.Encode(vectors[0]), nil)
+	for i, v := range vectors[0] {
+		if diff := math.Abs(float64(decoded[i] - v)); diff > float64(quantizer.step[i])/2+1e-6 {
+			t.Fatalf("Dimension %d: reconstruction error %f exceeds half step %f", i, diff, quantizer.step[i]/2)
+		}
+	}
+
+	// 超出训练范围的值被截断到边界
+	outOfRange := make([]float32, 64)
+	for i := range outOfRange {
+		outOfRange[i] = 100
+	}
+	for i, c := range quantizer.Encode(outOfRange) {
+		if c != 255 {
+			t.Fatalf("Dimension %d: expected clamped code 255, got %d", i, c)
+		}
+	}
+
+	for _, distName := range []string{DistanceL2, DistanceCosine} {
+		index := NewHNSW(Config{Dimension: 64, Seed: 42, DistanceName: distName, Quantizer: quantizer})
+		index.AddBatch(vectors, 0)
+		distFunc, _ := LookupDistanceFunc(distName)
+		flat := NewFlatIndexFromHNSW(index)
+
+		totalRecall := 0.0
+		numQueries := 20
+		for q := 0; q < numQueries; q++ {
+			query := vectors[rng.Intn(len(vectors))]
+			results, err := index.Search(query, 10, 100)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+
+			// 重排序后返回的是原始向量上的精确距离
+			for _, r := range results {
+				if exact := distFunc(query, vectors[r.ID]); r.Distance != exact {
+					t.Fatalf("%s: result %d distance %f, exact %f", distName, r.ID, r.Distance, exact)
+				}
+			}
+
+			truth, _ := flat.Search(query, 10, 0)
+			totalRecall += Recall(results, truth)
+		}
+		avgRecall := totalRecall / float64(numQueries)
+		t.Logf("%s with sq8 recall@10: %.2f%%", distName, avgRecall*100)
+		if avgRecall < 0.90 {
+			t.Errorf("%s: recall too low with quantization: %.2f%%", distName, avgRecall*100)
+		}
+	}
+}
+
+func TestProductQuantizer(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+
+	// 数据围绕 32 个簇中心分布，k-means 应该能很好地刻画
+	centers := make([][]float32, 32)
+	for i := range centers {
+		center := make([]float32, 32)
+		for j := range center {
+			center[j] = rng.Float32() * 10
+		}
+		centers[i] = center
+	}
+	vectors := make([][]float32, 2000)
+	for i := range vectors {
+		center := centers[rng.Intn(len(centers))]
+		vector := make([]float32, 32)
+		for j := range vector {
+			vector[j] = center[j] + float32(rng.NormFloat64())*0.1
+		}
+		vectors[i] = vector
+	}
+
+	quantizer := NewProductQuantizer(32, 8)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if quantizer.centroids != 256 {
+		t.Fatalf("Expected 256 centroids per subspace, got %d", quantizer.centroids)
+	}
+
+	code := quantizer.Encode(vectors[0])
+	if len(code) != 8 {
+		t.Fatalf("Expected 8-byte code, got %d bytes", len(code))
+	}
+	decoded := quantizer.Decode(code, nil)
+	if err := L2Distance(decoded, vectors[0]); err > 1 {
+		t.Errorf("Reconstruction error too large: %f", err)
+	}
+
+	// 查表距离等于到解码向量的距离
+	query := vectors[1]
+	for _, distName := range []string{DistanceL2, DistanceL2Sqrt, DistanceInnerProduct, DistanceCosine} {
+		distFunc, _ := LookupDistanceFunc(distName)
+		distancer := quantizer.NewDistancer(query, distFunc, distName)
+		for _, v := range vectors[:20] {
+			c := quantizer.Encode(v)
+			want := distFunc(query, quantizer.Decode(c, nil))
+			if got := distancer.Distance(c); math.Abs(float64(got-want)) > 1e-3*math.Max(1, math.Abs(float64(want))) {
+				t.Fatalf("%s: table distance %f, decoded distance %f", distName, got, want)
+			}
+		}
+	}
+
+	// 少于 256 个训练样本时，中心数等于样本数
+	small := NewProductQuantizer(32, 4)
+	if err := small.Train(vectors[:50]); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if small.centroids != 50 {
+		t.Errorf("Expected 50 centroids, got %d", small.centroids)
+	}
+
+	for _, quantizedOnly := range []bool{false, true} {
+		index := NewHNSW(Config{Dimension: 32, Seed: 42, Quantizer: quantizer, QuantizedOnly: quantizedOnly})
+		index.AddBatch(vectors, 0)
+
+		if quantizedOnly {
+			for _, node := range index.nodes {
+				if node.vectorRef() != nil {
+					t.Fatalf("Node %d kept its full-precision vector", node.ID())
+				}
+			}
+		}
+
+		// 只保留编码时没有重排序，和解码后向量上的精确搜索比较，检验图遍历本身
+		flat := NewFlatIndexFromHNSW(index)
+		if !quantizedOnly {
+			flat = NewFlatIndex(32, nil)
+			for _, v := range vectors {
+				flat.Add(v)
+			}
+		}
+
+		totalRecall := 0.0
+		numQueries := 30
+		for q := 0; q < numQueries; q++ {
+			query := make([]float32, 32)
+			center := centers[rng.Intn(len(centers))]
+			for j := range query {
+				query[j] = center[j] + float32(rng.NormFloat64())*0.1
+			}
+			results, err := index.Search(query, 10, 100)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			truth, _ := flat.Search(query, 10, 0)
+			totalRecall += Recall(results, truth)
+		}
+		avgRecall := totalRecall / float64(numQueries)
+		t.Logf("pq quantizedOnly=%v recall@10: %.2f%%", quantizedOnly, avgRecall*100)
+		if avgRecall < 0.9 {
+			t.Errorf("quantizedOnly=%v: recall too low: %.2f%%", quantizedOnly, avgRecall*100)
+		}
+	}
+}
+
+func TestAddWithKey(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 4, Seed: 1})
+
+	vectors := [][]float32{{0, 0, 0, 1}, {0, 0, 1, 0}, {0, 1, 0, 0}, {1, 0, 0, 0}}
+	keys := []Key{IntKey(100), StringKey("doc-a"), IntKey(-7), {}}
+	for i, vector := range vectors {
+		var err error
+		if keys[i].IsZero() {
+			_, err = index.Add(vector)
+		} else {
+			_, err = index.AddWithKey(keys[i], vector)
+		}
+		if err != nil {
+			t.Fatalf("Add %d failed: %v", i, err)
+		}
+	}
+
+	if _, err := index.AddWithKey(StringKey("doc-a"), vectors[0]); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Expected ErrDuplicateKey, got %v", err)
+	}
+	if _, err := index.AddWithKey(Key{}, vectors[0]); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for zero key, got %v", err)
+	}
+
+	// 搜索结果带上外部键
+	for i, vector := range vectors {
+		results, err := index.Search(vector, 1, 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].ID != i || results[0].Key != keys[i] {
+			t.Errorf("Expected ID %d with key %v, got ID %d with key %v", i, keys[i], results[0].ID, results[0].Key)
+		}
+	}
+
+	if id, ok := index.Lookup(IntKey(-7)); !ok || id != 2 {
+		t.Errorf("Lookup returned (%d, %v), expected (2, true)", id, ok)
+	}
+	if v, ok := keys[1].Str(); !ok || v != "doc-a" {
+		t.Errorf("Str returned (%q, %v)", v, ok)
+	}
+	if _, ok := keys[1].Int(); ok {
+		t.Error("Int reported ok for a string key")
+	}
+
+	// 删除后键可以被重新使用
+	if err := index.Delete(1); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := index.Lookup(StringKey("doc-a")); ok {
+		t.Error("Lookup found the key of a deleted node")
+	}
+	if id, err := index.AddWithKey(StringKey("doc-a"), vectors[1]); err != nil || id != 4 {
+		t.Errorf("Re-adding a freed key returned (%d, %v), expected (4, nil)", id, err)
+	}
+}
+
+func TestAddWithPayload(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		arrow.NewField("source", arrow.PrimString(), true),
+		arrow.NewField("chunk", arrow.PrimInt64(), true),
+		arrow.NewField("score", arrow.PrimFloat64(), true),
+	}, nil)
+	index := NewHNSW(Config{Dimension: 2, Seed: 1, PayloadSchema: schema})
+
+	id, err := index.AddWithPayload(StringKey("a"), []float32{0, 1}, Payload{"source": "a.md", "chunk": 3, "score": 0.5})
+	if err != nil {
+		t.Fatalf("AddWithPayload failed: %v", err)
+	}
+	// 负载字段可以省略，也可以不带负载添加
+	index.AddWithPayload(Key{}, []float32{1, 0}, Payload{"source": "b.md"})
+	index.Add([]float32{1, 1})
+
+	results, err := index.Search([]float32{0, 1}, 3, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != id || results[0].Payload["source"] != "a.md" || results[0].Payload["chunk"] != int64(3) {
+		t.Errorf("Unexpected first result %+v", results[0])
+	}
+	for _, r := range results[1:] {
+		if r.ID == 2 && r.Payload != nil {
+			t.Errorf("Expected nil payload for node added without one, got %v", r.Payload)
+		}
+	}
+
+	invalid := []Payload{
+		{"unknown": "x"},
+		{"chunk": "not a number"},
+		{"score": float32(1)},
+	}
+	for _, payload := range invalid {
+		if _, err := index.AddWithPayload(Key{}, []float32{0, 0}, payload); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("Expected ErrInvalidParameter for payload %v, got %v", payload, err)
+		}
+	}
+
+	noSchema := NewHNSW(Config{Dimension: 2})
+	if _, err := noSchema.AddWithPayload(Key{}, []float32{0, 0}, Payload{"source": "x"}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter without payload schema, got %v", err)
+	}
+}
+
+func TestStatsAndValidate(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 8, M: 8, Seed: 42})
+
+	rng := rand.New(rand.NewSource(29))
+	numVectors := 500
+	for i := 0; i < numVectors; i++ {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+	index.Delete(3)
+
+	if err := index.Validate(); err != nil {
+		t.Fatalf("Expected healthy index to validate, got %v", err)
+	}
+
+	stats := index.Stats()
+	if stats.Nodes != numVectors || stats.Deleted != 1 {
+		t.Errorf("Expected %d nodes and 1 deleted, got %d and %d", numVectors, stats.Nodes, stats.Deleted)
+	}
+	if len(stats.Levels) != stats.MaxLevel+1 || stats.Levels[0].Nodes != numVectors {
+		t.Fatalf("Unexpected levels for max level %d: %+v", stats.MaxLevel, stats.Levels)
+	}
+	for level, ls := range stats.Levels {
+		counted, edges := 0, 0
+		for degree, n := range ls.Degrees {
+			counted += n
+			edges += degree * n
+		}
+		if counted != ls.Nodes || edges != ls.Edges {
+			t.Errorf("Level %d: degree histogram covers %d nodes and %d edges, expected %d and %d",
+				level, counted, edges, ls.Nodes, ls.Edges)
+		}
+		if ls.MaxDegree > index.maxConnections(level) || ls.MinDegree > ls.MaxDegree {
+			t.Errorf("Level %d: unexpected degree range [%d, %d]", level, ls.MinDegree, ls.MaxDegree)
+		}
+		if level > 0 && ls.Nodes > stats.Levels[level-1].Nodes {
+			t.Errorf("Level %d has more nodes than the level below", level)
+		}
+	}
+	if len(stats.Unreachable) != 0 || len(stats.InvalidEdges) != 0 {
+		t.Errorf("Expected no unreachable nodes or invalid edges, got %v and %v", stats.Unreachable, stats.InvalidEdges)
+	}
+
+	// 删除指向某个节点的所有边，它就无法从入口点到达，但结构仍然有效
+	isolated := 10
+	if isolated == int(index.entryPoint) {
+		isolated++
+	}
+	for _, node := range index.nodes {
+		for level := 0; level <= node.Level(); level++ {
+			var kept []int
+			for _, neighborID := range node.GetConnections(level) {
+				if neighborID != isolated {
+					kept = append(kept, neighborID)
+				}
+			}
+			node.SetConnections(level, kept)
+		}
+	}
+	stats = index.Stats()
+	if len(stats.Unreachable) != 1 || stats.Unreachable[0] != isolated {
+		t.Errorf("Expected node %d to be unreachable, got %v", isolated, stats.Unreachable)
+	}
+	if stats.AsymmetricEdges == 0 {
+		t.Errorf("Expected the isolated node's out-edges to be asymmetric")
+	}
+	if err := index.Validate(); err != nil {
+		t.Errorf("Expected unreachable node to pass validation, got %v", err)
+	}
+
+	// 越界、自环和重复的邻居
+	neighbor := index.nodes[1].GetConnections(0)[0]
+	index.nodes[1].SetConnections(0, []int{999999, 1, neighbor, neighbor})
+	index.numDeleted = 0
+
+	err := index.Validate()
+	if !errors.Is(err, ErrCorruptIndex) {
+		t.Fatalf("Expected ErrCorruptIndex, got %v", err)
+	}
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected *ValidationError, got %T", err)
+	}
+	kinds := make(map[GraphErrorKind]bool)
+	for _, e := range validationErr.Errors {
+		kinds[e.Kind] = true
+	}
+	for _, kind := range []GraphErrorKind{NeighborOutOfRange, SelfLoop, DuplicateNeighbor, DeletedCountMismatch} {
+		if !kinds[kind] {
+			t.Errorf("Expected a %v error, got %v", kind, validationErr.Errors)
+		}
+	}
+	var graphErr *GraphError
+	if !errors.As(err, &graphErr) || graphErr.Node != 1 || graphErr.Neighbor != 999999 {
+		t.Errorf("Expected first graph error on node 1 -> 999999, got %v", graphErr)
+	}
+
+	stats = index.Stats()
+	if len(stats.InvalidEdges) != 2 {
+		t.Errorf("Expected 2 invalid edges, got %v", stats.InvalidEdges)
+	}
+}
+
+func TestBinaryVector(t *testing.T) {
+	a := PackBinary([]float32{1, 0, -1, 1, 0.5})
+	b := PackBinary([]float32{1, 1, 1, -1, 0.5})
+	if len(a) != 1 || !a.Bit(0) || a.Bit(1) || a.Bit(2) || !a.Bit(3) || !a.Bit(4) {
+		t.Errorf("Unexpected bits %b", a[0])
+	}
+	if d := HammingBits(a, b); d != 3 {
+		t.Errorf("HammingBits = %d, want 3", d)
+	}
+
+	// 跨越多个字的位向量
+	v := NewBinaryVector(130)
+	v.Set(0)
+	v.Set(64)
+	v.Set(129)
+	if len(v) != 3 || HammingBits(v, NewBinaryVector(130)) != 3 {
+		t.Errorf("Unexpected 130-bit vector %x", v)
+	}
+	unpacked := UnpackBinary(v, 130)
+	if unpacked[0] != 1 || unpacked[1] != 0 || unpacked[64] != 1 || unpacked[129] != 1 {
+		t.Errorf("UnpackBinary lost bits")
+	}
+	if d := HammingDistance(unpacked, UnpackBinary(PackBinary(unpacked), 130)); d != 0 {
+		t.Errorf("Round trip changed %v bits", d)
+	}
+
+	// 0/1 和 ±1 两种表示的汉明距离相同
+	if d := HammingDistance([]float32{1, 0, 1, 0}, []float32{1, -1, -1, 1}); d != 2 {
+		t.Errorf("HammingDistance = %f, want 2", d)
+	}
+	if fn, err := LookupDistanceFunc(DistanceHamming); err != nil || fn([]float32{1, 0}, []float32{0, 0}) != 1 {
+		t.Errorf("Hamming distance not registered: %v", err)
+	}
+
+	// 直接用汉明距离索引二值嵌入
+	rng := rand.New(rand.NewSource(43))
+	index := NewHNSW(Config{Dimension: 64, Seed: 42, DistanceName: DistanceHamming})
+	vectors := make([][]float32, 500)
+	for i := range vectors {
+		vectors[i] = make([]float32, 64)
+		for j := range vectors[i] {
+			vectors[i][j] = float32(rng.Intn(2))
+		}
+		index.Add(vectors[i])
+	}
+	results, err := index.Search(vectors[9], 5, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != 9 || results[0].Distance != 0 {
+		t.Errorf("Expected exact match first, got %+v", results[0])
+	}
+}
+
+func TestBinaryAdd(t *testing.T) {
+	// 打包的二值嵌入直接作为量化编码保存，只保留编码时按汉明距离搜索
+	rng := rand.New(rand.NewSource(53))
+	dim := 200
+	index := NewHNSW(Config{Dimension: dim, Seed: 42, DistanceName: DistanceHamming,
+		Quantizer: NewBinaryQuantizer(dim), QuantizedOnly: true})
+	packed := make([]BinaryVector, 500)
+	for i := range packed {
+		packed[i] = NewBinaryVector(dim)
+		for j := 0; j < dim; j++ {
+			if rng.Intn(2) == 1 {
+				packed[i].Set(j)
+			}
+		}
+		if _, err := index.AddBinary(packed[i]); err != nil {
+			t.Fatalf("AddBinary failed: %v", err)
+		}
+	}
+
+	code := index.nodes[3].codeRef()
+	if len(code) != (dim+7)/8 || index.nodes[3].vectorRef() != nil {
+		t.Fatalf("Expected only a %d byte code, got %d bytes and vector %v", (dim+7)/8, len(code), index.nodes[3].vectorRef())
+	}
+	for i := 0; i < dim; i++ {
+		if (code[i/8]&(1<<(i%8)) != 0) != packed[3].Bit(i) {
+			t.Fatalf("Code bit %d differs from the packed vector", i)
+		}
+	}
+
+	results, err := index.SearchBinary(packed[9], 10, 100)
+	if err != nil {
+		t.Fatalf("SearchBinary failed: %v", err)
+	}
+	if results[0].ID != 9 || results[0].Distance != 0 {
+		t.Errorf("Expected exact match first, got %+v", results[0])
+	}
+	for _, r := range results {
+		if want := HammingBits(packed[9], packed[r.ID]); int(r.Distance) != want {
+			t.Errorf("Node %d distance %f, want Hamming distance %d", r.ID, r.Distance, want)
+		}
+	}
+
+	if _, err := index.AddBinary(NewBinaryVector(dim + 64)); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+	trained := NewBinaryQuantizer(4)
+	trained.Train([][]float32{{1, 2, 3, 4}, {3, 4, 5, 6}})
+	for _, other := range []*HNSWIndex{
+		NewHNSW(Config{Dimension: 4, Seed: 42}),
+		NewHNSW(Config{Dimension: 4, Seed: 42, DistanceName: DistanceHamming, Quantizer: trained, QuantizedOnly: true}),
+		// 保留全精度向量或使用其他距离时，结果会按展开的 0/1 浮点向量重排
+		NewHNSW(Config{Dimension: 4, Seed: 42, DistanceName: DistanceHamming, Quantizer: NewBinaryQuantizer(4)}),
+		NewHNSW(Config{Dimension: 4, Seed: 42, Quantizer: NewBinaryQuantizer(4), QuantizedOnly: true}),
+	} {
+		if _, err := other.AddBinary(NewBinaryVector(4)); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("Expected ErrInvalidParameter, got %v", err)
+		}
+		if _, err := other.SearchBinary(NewBinaryVector(4), 1, 0); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("Expected ErrInvalidParameter, got %v", err)
+		}
+	}
+}
+
+func TestBinaryQuantizer(t *testing.T) {
+	// 聚类数据更接近真实的嵌入，各维度不以 0 为中心，需要训练阈值
+	rng := rand.New(rand.NewSource(47))
+	dim := 128
+	centers := make([][]float32, 30)
+	for i := range centers {
+		centers[i] = make([]float32, dim)
+		for j := range centers[i] {
+			centers[i][j] = float32(rng.NormFloat64()) + 1
+		}
+	}
+	vectors := make([][]float32, 3000)
+	for i := range vectors {
+		center := centers[rng.Intn(len(centers))]
+		vector := make([]float32, dim)
+		for j := range vector {
+			vector[j] = center[j] + float32(rng.NormFloat64())*0.5
+		}
+		vectors[i] = vector
+	}
+
+	quantizer := NewBinaryQuantizer(dim)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	var mean float64
+	for _, v := range vectors {
+		mean += float64(v[0])
+	}
+	mean /= float64(len(vectors))
+	if math.Abs(float64(quantizer.thresholds[0])-mean) > 1e-4 {
+		t.Errorf("Expected threshold at the mean %f, got %f", mean, quantizer.thresholds[0])
+	}
+
+	// 编码按小端序读作字就是 BinaryVector，距离计算器返回汉明距离
+	code := quantizer.Encode(vectors[0])
+	if len(code) != dim/8 {
+		t.Fatalf("Expected %d code bytes, got %d", dim/8, len(code))
+	}
+	centred := func(vector []float32) BinaryVector {
+		v := NewBinaryVector(dim)
+		for i, x := range vector {
+			if x > quantizer.thresholds[i] {
+				v.Set(i)
+			}
+		}
+		return v
+	}
+	distancer := quantizer.NewDistancer(vectors[1], L2Distance, DistanceL2)
+	if got, want := distancer.Distance(code), HammingBits(centred(vectors[0]), centred(vectors[1])); int(got) != want {
+		t.Errorf("Distancer returned %f, want Hamming distance %d", got, want)
+	}
+	for i, x := range quantizer.Decode(code, nil) {
+		if (x > 0) != centred(vectors[0]).Bit(i) {
+			t.Fatalf("Decode bit %d mismatch", i)
+		}
+	}
+
+	for _, distName := range []string{DistanceL2, DistanceCosine} {
+		index := NewHNSW(Config{Dimension: dim, Seed: 42, DistanceName: distName, Quantizer: quantizer})
+		index.AddBatch(vectors, 0)
+		distFunc, _ := LookupDistanceFunc(distName)
+		flat := NewFlatIndexFromHNSW(index)
+
+		totalRecall := 0.0
+		numQueries := 20
+		for q := 0; q < numQueries; q++ {
+			query := vectors[rng.Intn(len(vectors))]
+			results, err := index.Search(query, 10, 200)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+
+			// 汉明距离只用于遍历，返回的是原始向量上重新计算的距离
+			for _, r := range results {
+				if exact := distFunc(query, vectors[r.ID]); r.Distance != exact {
+					t.Fatalf("%s: result %d distance %f, exact %f", distName, r.ID, r.Distance, exact)
+				}
+			}
+
+			truth, _ := flat.Search(query, 10, 0)
+			totalRecall += Recall(results, truth)
+		}
+		avgRecall := totalRecall / float64(numQueries)
+		t.Logf("%s with binary quantization recall@10: %.2f%%", distName, avgRecall*100)
+		if avgRecall < 0.90 {
+			t.Errorf("%s: recall too low with binary quantization: %.2f%%", distName, avgRecall*100)
+		}
+	}
+}
+
+func TestFloat16Vectors(t *testing.T) {
+	rng := rand.New(rand.NewSource(23))
+	numVectors, dim := 500, 32
+	vectors := make([][]float32, numVectors)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()*2 - 1
+		}
+	}
+
+	index := NewHNSW(Config{Dimension: dim, Seed: 42, Float16Vectors: true})
+	if _, err := index.AddBatch(vectors[:250], 4); err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	for _, v := range vectors[250:] {
+		index.Add(v)
+	}
+
+	// 连接完成后只保留半精度向量
+	for id, node := range index.nodes {
+		if node.vectorRef() != nil {
+			t.Fatalf("Node %d keeps a float32 vector", id)
+		}
+		if len(node.halfRef()) != dim {
+			t.Fatalf("Node %d has %d half-precision components, want %d", id, len(node.halfRef()), dim)
+		}
+	}
+	if v := index.nodes[7].Vector(); abs(v[0]-vectors[7][0]) > 1e-3 {
+		t.Errorf("Vector()[0] = %f, want about %f", v[0], vectors[7][0])
+	}
+
+	// 距离在 float32 上计算，与原始向量的距离只差半精度的舍入误差
+	query := vectors[11]
+	results, err := index.Search(query, 10, 100)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != 11 {
+		t.Errorf("Expected node 11 first, got %d", results[0].ID)
+	}
+	for _, r := range results {
+		if want := L2Distance(query, vectors[r.ID]); abs(r.Distance-want) > 1e-2 {
+			t.Errorf("Node %d distance %f, want about %f", r.ID, r.Distance, want)
+		}
+	}
+
+	exact := NewFlatIndex(dim, L2Distance)
+	for _, v := range vectors {
+		exact.Add(v)
+	}
+	truth, _ := exact.Search(query, 10, 0)
+	if recall := Recall(results, truth); recall < 0.9 {
+		t.Errorf("Recall %.2f, want >= 0.9", recall)
+	}
+
+	// 更新后同样只保留新的半精度向量
+	if err := index.Update(5, vectors[6]); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if index.nodes[5].vectorRef() != nil || index.nodes[5].halfRef()[0] != arrow.NewFloat16(vectors[6][0]) {
+		t.Error("Updated node does not hold the new half-precision vector")
+	}
+	if err := index.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+
+	// 超出半精度范围的分量变成无穷大，Validate 报告出来
+	huge := make([]float32, dim)
+	huge[3] = 1e6
+	id, _ := index.Add(huge)
+	var verr *ValidationError
+	if err := index.Validate(); !errors.As(err, &verr) || verr.Errors[0].Kind != InvalidVector || verr.Errors[0].Node != id {
+		t.Errorf("Expected an invalid vector error for node %d, got %v", id, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Float16Vectors with QuantizedOnly to panic")
+		}
+	}()
+	NewHNSW(Config{Dimension: dim, Quantizer: NewBinaryQuantizer(dim), QuantizedOnly: true, Float16Vectors: true})
+}
+
+func TestFloat16VectorsPruneAllocs(t *testing.T) {
+	// 剪枝解码半精度向量到复用的缓冲区，分配次数与保留 float32 向量的索引相同
+	rng := rand.New(rand.NewSource(59))
+	dim := 32
+	vectors := make([][]float32, 300)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+
+	allocs := func(float16 bool) float64 {
+		index := NewHNSW(Config{Dimension: dim, Seed: 42, Float16Vectors: float16})
+		index.AddBatch(vectors, 1)
+		node := index.nodes[7]
+		extra := make([]int, 0, 60)
+		for id := 100; len(extra) < cap(extra); id++ {
+			extra = append(extra, id)
+		}
+		vec := index.nodeVector(node)
+		prune := func() {
+			candidates := index.mergeCandidates(vec, node.ID(), nil, extra)
+			index.selectNeighbors(vec, node.ID(), 0, candidates, index.Mmax0, true)
+		}
+		prune()
+		return testing.AllocsPerRun(50, prune)
+	}
+	if full, half := allocs(false), allocs(true); half > full {
+		t.Errorf("Pruning allocates %.0f times with float16 vectors, %.0f times with float32 vectors", half, full)
+	}
+}
+
+func TestSearchDocuments(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	dim := 16
+	randomVector := func() []float32 {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = rng.Float32()
+		}
+		return v
+	}
+
+	index := NewHNSW(Config{Dimension: dim, Seed: 42})
+	docs := make(map[Key][][]float32)
+	for d := 0; d < 60; d++ {
+		doc := IntKey(int64(d))
+		vectors := make([][]float32, 3+d%4)
+		for i := range vectors {
+			vectors[i] = randomVector()
+		}
+		ids, err := index.AddDocument(doc, vectors)
+		if err != nil {
+			t.Fatalf("AddDocument failed: %v", err)
+		}
+		if len(ids) != len(vectors) {
+			t.Fatalf("Expected %d IDs, got %d", len(vectors), len(ids))
+		}
+		docs[doc] = vectors
+	}
+	// 不属于任何文档的向量不参与文档搜索
+	for i := 0; i < 20; i++ {
+		index.Add(randomVector())
+	}
+
+	// 查询由文档 7 的两个向量加噪声组成
+	target := IntKey(7)
+	query := make([][]float32, 2)
+	for i := range query {
+		query[i] = append([]float32(nil), docs[target][i]...)
+		query[i][0] += 0.01
+	}
+
+	results, err := index.SearchDocuments(query, 5, 100)
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 documents, got %d", len(results))
+	}
+	if results[0].Key != target {
+		t.Errorf("Expected document %v first, got %v", target, results[0].Key)
+	}
+	targetIDs := index.DocumentNodes(target)
+	if results[0].Matches[0] != targetIDs[0] || results[0].Matches[1] != targetIDs[1] {
+		t.Errorf("Expected matches %v, got %v", targetIDs[:2], results[0].Matches)
+	}
+
+	// 与在所有文档上暴力计算的 MaxSim 一致，且每个文档只出现一次
+	type scored struct {
+		key      Key
+		distance float32
+	}
+	var exact []scored
+	for doc, vectors := range docs {
+		var total float32
+		for _, q := range query {
+			best := float32(math.MaxFloat32)
+			for _, v := range vectors {
+				if d := L2Distance(q, v); d < best {
+					best = d
+				}
+			}
+			total += best
+		}
+		exact = append(exact, scored{doc, total})
+	}
+	sort.Slice(exact, func(i, j int) bool { return exact[i].distance < exact[j].distance })
+	seen := make(map[Key]bool)
+	for i, r := range results {
+		if seen[r.Key] {
+			t.Errorf("Document %v returned twice", r.Key)
+		}
+		seen[r.Key] = true
+		if r.Key != exact[i].key || abs(r.Distance-exact[i].distance) > 1e-5 {
+			t.Errorf("Result %d: got %v (%f), want %v (%f)", i, r.Key, r.Distance, exact[i].key, exact[i].distance)
+		}
+	}
+
+	// 普通搜索返回最近的向量，并带有所属文档
+	hits, _ := index.Search(query[0], 1, 100)
+	if hits[0].ID != targetIDs[0] || hits[0].Document != target {
+		t.Errorf("Expected node %d of document %v, got %+v", targetIDs[0], target, hits[0])
+	}
+
+	// 同一文档的向量合并为一个结果：每个文档只返回最近的向量，ef 不足时自动扩大
+	nearest := make(map[Key]float32)
+	for doc, vectors := range docs {
+		for i, v := range vectors {
+			if d := L2Distance(query[0], v); i == 0 || d < nearest[doc] {
+				nearest[doc] = d
+			}
+		}
+	}
+	for _, ef := range []int{100, 10} {
+		hits, err := index.Search(query[0], 10, ef)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(hits) != 10 {
+			t.Fatalf("ef %d: expected 10 results, got %d", ef, len(hits))
+		}
+		seenDocs := make(map[Key]bool)
+		for _, hit := range hits {
+			if hit.Document.IsZero() {
+				continue
+			}
+			if seenDocs[hit.Document] {
+				t.Errorf("ef %d: document %v returned twice", ef, hit.Document)
+			}
+			seenDocs[hit.Document] = true
+			if hit.Distance != nearest[hit.Document] {
+				t.Errorf("ef %d: document %v at distance %f, its closest vector is at %f", ef, hit.Document, hit.Distance, nearest[hit.Document])
+			}
+		}
+	}
+	distinct, _ := index.Search(query[0], 10, 10)
+	batch, _ := index.SearchBatch([][]float32{query[0]}, 10, 10, 1)
+	filtered, _ := index.SearchWithFilter(query[0], 10, 10, FilterFunc(func(int) bool { return true }))
+	for _, results := range [][]SearchResult{batch[0], filtered} {
+		if fmt.Sprint(results) != fmt.Sprint(distinct) {
+			t.Errorf("Expected the same distinct results as Search, got %v", results)
+		}
+	}
+
+	// 追加向量扩展已有文档
+	extra, err := index.AddDocument(target, [][]float32{randomVector()})
+	if err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if nodes := index.DocumentNodes(target); len(nodes) != len(targetIDs)+1 || nodes[len(nodes)-1] != extra[0] {
+		t.Errorf("Expected document to be extended with node %d, got %v", extra[0], nodes)
+	}
+
+	if err := index.DeleteDocument(target); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	if index.DocumentNodes(target) != nil {
+		t.Error("Expected deleted document to have no vectors")
+	}
+	results, _ = index.SearchDocuments(query, 5, 100)
+	for _, r := range results {
+		if r.Key == target {
+			t.Error("Deleted document returned")
+		}
+	}
+	if err := index.DeleteDocument(target); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+
+	before := index.Len()
+	if _, err := index.AddDocument(IntKey(100), [][]float32{randomVector(), make([]float32, dim-1)}); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch, got %v", err)
+	}
+	if index.Len() != before {
+		t.Errorf("Expected no vectors inserted, Len went from %d to %d", before, index.Len())
+	}
+	if _, err := index.AddDocument(Key{}, [][]float32{randomVector()}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for the zero key, got %v", err)
+	}
+}
+
+func TestNeighborSelectors(t *testing.T) {
+	rng := rand.New(rand.NewSource(25))
+	dim := 16
+	vectors := make([][]float32, 1050)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+	vectors, queries := vectors[:1000], vectors[1000:]
+
+	selectors := map[string]NeighborSelector{
+		"default":          nil,
+		"simple":           SimpleSelector{},
+		"heuristic":        HeuristicSelector{},
+		"heuristic+keep":   HeuristicSelector{KeepPrunedConnections: true},
+		"heuristic+both":   HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true},
+		"heuristic+extend": HeuristicSelector{ExtendCandidates: true},
+		"rng":              RNGSelector{},
+		"rng-1.5":          RNGSelector{Alpha: 1.5},
+	}
+	edges := make(map[string]int)
+	for name, selector := range selectors {
+		index := NewHNSW(Config{Dimension: dim, M: 8, Seed: 42, NeighborSelector: selector})
+		for _, v := range vectors {
+			index.Add(v)
+		}
+		if err := index.Validate(); err != nil {
+			t.Fatalf("%s: Validate failed: %v", name, err)
+		}
+
+		truth, err := GroundTruth(NewFlatIndexFromHNSW(index), queries, 10)
+		if err != nil {
+			t.Fatalf("GroundTruth failed: %v", err)
+		}
+		evals, err := index.Evaluate(queries, truth, 10, []int{100})
+		if err != nil {
+			t.Fatalf("%s: Evaluate failed: %v", name, err)
+		}
+		stats := index.Stats()
+		edges[name] = stats.Levels[0].Edges
+		t.Logf("%s: recall@10=%.3f level-0 mean degree=%.2f", name, evals[0].Recall, stats.Levels[0].MeanDegree)
+		if evals[0].Recall < 0.9 {
+			t.Errorf("%s: recall@10 %.3f, want >= 0.9", name, evals[0].Recall)
+		}
+		if stats.Levels[0].MaxDegree > index.Mmax0 {
+			t.Errorf("%s: degree %d exceeds %d", name, stats.Levels[0].MaxDegree, index.Mmax0)
+		}
+	}
+
+	// 保留被剪掉的候选会填满连接，纯启发式剪掉的边更多；alpha 越大保留的长边越多
+	if edges["heuristic"] >= edges["heuristic+keep"] {
+		t.Errorf("heuristic kept %d edges, heuristic+keep %d; want fewer without keep", edges["heuristic"], edges["heuristic+keep"])
+	}
+	if edges["rng"] >= edges["rng-1.5"] {
+		t.Errorf("rng kept %d edges, rng-1.5 %d; want more with a larger alpha", edges["rng"], edges["rng-1.5"])
+	}
+
+	// 直接调用选择策略
+	index := NewHNSW(Config{Dimension: 2, Seed: 42})
+	for _, v := range [][]float32{{0, 0}, {1, 0}, {1.1, 0}, {0, 1}, {-1, 0}} {
+		index.Add(v)
+	}
+	g := &NeighborGraph{h: index, nodes: index.snapshot(), node: 0}
+	vec := []float32{0, 0}
+	candidates := []SearchResult{
+		{ID: 2, Distance: L2Distance(vec, []float32{1.1, 0})},
+		{ID: 1, Distance: 1},
+		{ID: 3, Distance: 1},
+		{ID: 4, Distance: 1},
+	}
+	// 距离相同的候选顺序不定，按 ID 排序后比较
+	ids := func(results []SearchResult) []int {
+		out := make([]int, len(results))
+		for i, r := range results {
+			out[i] = r.ID
+		}
+		sort.Ints(out)
+		return out
+	}
+
+	// 简单策略按距离取前 m 个
+	if got := ids(SimpleSelector{}.SelectNeighbors(g, vec, candidates, 3)); !reflect.DeepEqual(got, []int{1, 3, 4}) {
+		t.Errorf("SimpleSelector selected %v, want [1 3 4]", got)
+	}
+	// 节点 2 被节点 1 遮挡：纯启发式剪掉它，保留被剪掉的候选时补回
+	if got := ids(HeuristicSelector{}.SelectNeighbors(g, vec, candidates, 4)); !reflect.DeepEqual(got, []int{1, 3, 4}) {
+		t.Errorf("HeuristicSelector selected %v, want [1 3 4]", got)
+	}
+	if got := ids(HeuristicSelector{KeepPrunedConnections: true}.SelectNeighbors(g, vec, candidates, 4)); len(got) != 4 {
+		t.Errorf("HeuristicSelector with KeepPrunedConnections selected %v, want all 4 candidates", got)
+	}
+	// alpha 足够大时节点 2 不再被遮挡
+	if got := ids(RNGSelector{Alpha: 200}.SelectNeighbors(g, vec, candidates, 4)); len(got) != 4 {
+		t.Errorf("RNGSelector with a large alpha selected %v, want all 4 candidates", got)
+	}
+	// alpha 为 1 时与不补回被剪掉候选的启发式规则相同，包括距离相等的情况：
+	// (0.5, 1) 到节点 0 和到 (1, 0) 的距离都是 1.25，两种策略都保留它
+	ties := NewHNSW(Config{Dimension: 2, Seed: 42})
+	tieRNG := rand.New(rand.NewSource(61))
+	ties.Add([]float32{0, 0})
+	ties.Add([]float32{1, 0})
+	ties.Add([]float32{0.5, 1})
+	// 其余的整数点离原点足够远，不会先于 (0.5, 1) 被选中
+	coord := []float32{-4, -3, -2, 2, 3, 4}
+	for i := 0; i < 40; i++ {
+		ties.Add([]float32{coord[tieRNG.Intn(len(coord))], coord[tieRNG.Intn(len(coord))]})
+	}
+	for node := 0; node < 10; node++ {
+		nodes := ties.snapshot()
+		tieGraph := &NeighborGraph{h: ties, nodes: nodes, node: node}
+		query := nodes[node].Vector()
+		var all []SearchResult
+		for id, other := range nodes {
+			if id != node {
+				all = append(all, SearchResult{ID: id, Distance: L2Distance(query, other.Vector())})
+			}
+		}
+		heuristic := HeuristicSelector{}.SelectNeighbors(tieGraph, query, all, 6)
+		rngSelected := RNGSelector{Alpha: 1}.SelectNeighbors(tieGraph, query, all, 6)
+		if !reflect.DeepEqual(ids(rngSelected), ids(heuristic)) {
+			t.Errorf("Node %d: RNGSelector with alpha 1 selected %v, HeuristicSelector %v", node, ids(rngSelected), ids(heuristic))
+		}
+		if node == 0 && !slices.Contains(ids(rngSelected), 2) {
+			t.Errorf("RNGSelector with alpha 1 pruned a candidate at the same distance, selected %v", ids(rngSelected))
+		}
+	}
+
+	// 扩展候选会加入候选的邻居，但不会加入节点自身
+	for _, r := range (HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true}).SelectNeighbors(g, vec, candidates[:1], 4) {
+		if r.ID == 0 {
+			t.Errorf("Extended candidates contain the node itself")
+		}
+	}
+	if got := (HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true}).SelectNeighbors(g, vec, candidates[:1], 4); len(got) < 2 {
+		t.Errorf("ExtendCandidates selected %v, want the neighbours of node 2 as well", ids(got))
+	}
+
+	// 自定义策略：按 ID 选择
+	var calls int
+	byID := NeighborSelectorFunc(func(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+		calls++
+		sorted := append([]SearchResult(nil), candidates...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+		return sorted
+	})
+	custom := NewHNSW(Config{Dimension: dim, M: 4, Seed: 42, NeighborSelector: byID})
+	for _, v := range vectors[:200] {
+		custom.Add(v)
+	}
+	if calls == 0 {
+		t.Errorf("Custom selector was never called")
+	}
+	if stats := custom.Stats(); stats.Levels[0].MaxDegree > custom.Mmax0 {
+		t.Errorf("Custom selector: degree %d exceeds %d", stats.Levels[0].MaxDegree, custom.Mmax0)
+	}
+}
+
+func BenchmarkNeighborSelectors(b *testing.B) {
+	rng := rand.New(rand.NewSource(25))
+	dim := 64
+	vectors := make([][]float32, 5000)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+	queries := vectors[:100]
+
+	selectors := []struct {
+		name     string
+		selector NeighborSelector
+	}{
+		{"simple", SimpleSelector{}},
+		{"heuristic", HeuristicSelector{}},
+		{"heuristic+keep", HeuristicSelector{KeepPrunedConnections: true}},
+		{"heuristic+extend+keep", HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true}},
+		{"rng-1.2", RNGSelector{Alpha: 1.2}},
+	}
+	for _, s := range selectors {
+		b.Run(s.name, func(b *testing.B) {
+			var index *HNSWIndex
+			for i := 0; i < b.N; i++ {
+				index = NewHNSW(Config{Dimension: dim, Seed: 42, NeighborSelector: s.selector})
+				for _, v := range vectors {
+					index.Add(v)
+				}
+			}
+			b.StopTimer()
+
+			truth, err := GroundTruth(NewFlatIndexFromHNSW(index), queries, 10)
+			if err != nil {
+				b.Fatal(err)
+			}
+			evals, err := index.Evaluate(queries, truth, 10, []int{50})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(evals[0].Recall, "recall@10")
+			b.ReportMetric(index.Stats().Levels[0].MeanDegree, "degree")
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package hnsw
+
+import (
+	"fmt"
+	"ollama-demo/lance/format"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 增量持久化：节点和连接以片段文件的形式追加，片段列表记录在清单（format.Manifest）中。
+// 清单的 DataFiles 是节点片段，IndexFiles 是连接片段，按顺序加载，后面的片段覆盖前面的：
+//   - 节点片段中的行按 ID 追加或替换节点（新增、删除或更新过的节点）
+//   - 连接片段中的每行 (node_id, layer, neighbors) 替换该层的连接表；
+//     旧版本逐边存储的片段中同组的边替换该层，空表写一行 neighbor_id = -1
+// 入口点和最大层级写在清单的元数据中，替换清单文件就是一次提交。
+// 所有文件都按写出它们的清单版本命名，元数据和量化参数的文件名记录在清单元数据中；
+// 新文件在清单提交前不被引用，不再引用的文件在提交后删除。
+
+const (
+	emptyConnections    = -1 // neighbor_id marking an empty connection list in edge-per-row fragments.
+	defaultMaxFragments = 16 // Node fragments accumulated before an incremental save compacts.
+)
+
+// SaveIncremental saves the index to baseDir as Lance fragments tracked by a
+// manifest. Only the nodes added, deleted or updated and the adjacency lists
+// changed since the previous save to baseDir are appended, so the cost of a
+// save depends on the amount of change rather than the index size. The first
+// save to a directory, and every save once Config.MaxFragments node fragments
+// have accumulated, compacts the index instead. LoadHNSWFromLance and LoadIndex
+// load every layout written by SaveToLance and SaveIncremental.
+func (h *HNSWIndex) SaveIncremental(baseDir string) error {
+	h.persistMu.Lock()
+	defer h.persistMu.Unlock()
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	if err := h.checkSavable(); err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(baseDir)
+	if err != nil {
+		return fmt.Errorf("load manifest failed: %w", err)
+	}
+	if manifest == nil || h.persistDir != baseDir || len(manifest.DataFiles) >= h.maxFragments {
+		return h.compact(baseDir, manifest)
+	}
+	return h.appendFragments(baseDir, manifest)
+}
+
+// Compact rewrites the index in baseDir as a single node fragment and a single
+// connection fragment, then removes the fragments they replace.
+func (h *HNSWIndex) Compact(baseDir string) error {
+	return h.SaveToLance(baseDir)
+}
+
+// compact 把整个索引写成以新清单版本命名的文件并记录校验和，替换清单完成提交，
+// 然后删除新清单不再引用的文件。新文件在提交前不被任何清单引用，中途崩溃时
+// 旧清单和它引用的文件保持不变。调用方持有 persistMu 和 globalLock 读锁
+func (h *HNSWIndex) compact(baseDir string, old *format.Manifest) error {
+	manifest := format.NewManifest(nextManifestVersion(old))
+
+	// 先清除脏标记再读取节点，保存期间的并发修改会在下次保存时写出
+	for _, node := range h.nodes {
+		node.rowDirty.Store(false)
+		node.connsDirty.Store(false)
+	}
+	// 任何一步失败都不能再在旧片段上追加，下次保存重新压缩
+	h.persistDir = ""
+
+	nodesFile := versionedFile("nodes", manifest.Version)
+	connectionsFile := versionedFile("connections", manifest.Version)
+	metadataFile := versionedFile("metadata", manifest.Version)
+
+	// 保存节点数据
+	if err := h.saveNodes(filepath.Join(baseDir, nodesFile), h.nodes); err != nil {
+		return fmt.Errorf("save nodes failed: %w", err)
+	}
+
+	// 保存连接数据
+	if err := h.saveConnections(filepath.Join(baseDir, connectionsFile), h.nodes); err != nil {
+		return fmt.Errorf("save connections failed: %w", err)
+	}
+
+	// 保存元数据
+	if err := h.saveMetadata(filepath.Join(baseDir, metadataFile)); err != nil {
+		return fmt.Errorf("save metadata failed: %w", err)
+	}
+	files := []string{nodesFile, connectionsFile, metadataFile}
+	manifest.Metadata[metadataFileKey] = metadataFile
+
+	// 保存量化参数（可选）
+	if h.quantizer != nil {
+		quantizerFile := versionedFile("quantizer", manifest.Version)
+		if err := h.saveQuantizer(filepath.Join(baseDir, quantizerFile)); err != nil {
+			return fmt.Errorf("save quantizer failed: %w", err)
+		}
+		files = append(files, quantizerFile)
+		manifest.Metadata[quantizerFileKey] = quantizerFile
+	}
+
+	manifest.AddDataFile(nodesFile)
+	manifest.AddIndexFile(connectionsFile)
+	for _, name := range files {
+		if err := recordChecksum(manifest, baseDir, name); err != nil {
+			return fmt.Errorf("checksum %s failed: %w", name, err)
+		}
+	}
+	// 新文件的目录项先落盘，清单才能引用它们
+	syncDir(baseDir)
+
+	if err := h.commitManifest(baseDir, manifest); err != nil {
+		return fmt.Errorf("save manifest failed: %w", err)
+	}
+
+	// 新清单生效后才删除它不再引用的文件：旧版本的文件、增量片段、
+	// 旧目录中固定命名的文件，以及之前中断的保存留下的文件
+	if err := removeUnreferenced(baseDir, manifest); err != nil {
+		return fmt.Errorf("remove stale files failed: %w", err)
+	}
+
+	h.persistDir = baseDir
+	h.persisted = len(h.nodes)
+	return nil
+}
+
+// appendFragments 只写出上次保存后新增或修改过的节点和连接表，追加到清单中。
+// 片段按清单版本命名，提交前不被任何清单引用，写到一半失败也不影响已有数据。
+// 调用方持有 persistMu 和 globalLock 读锁
+func (h *HNSWIndex) appendFragments(baseDir string, old *format.Manifest) error {
+	manifest := format.NewManifest(nextManifestVersion(old))
+	manifest.DataFiles = append(manifest.DataFiles, old.DataFiles...)
+	manifest.IndexFiles = append(manifest.IndexFiles, old.IndexFiles...)
+	// 校验和与元数据、量化参数文件的记录原样保留，其余元数据提交时重新写入
+	for key, value := range old.Metadata {
+		if strings.HasPrefix(key, checksumPrefix) || key == metadataFileKey || key == quantizerFileKey {
+			manifest.Metadata[key] = value
+		}
+	}
+
+	var changedNodes, changedConnections []*Node
+	for id, node := range h.nodes {
+		isNew := id >= h.persisted
+		if node.rowDirty.Swap(false) || isNew {
+			changedNodes = append(changedNodes, node)
+		}
+		if node.connsDirty.Swap(false) || isNew {
+			changedConnections = append(changedConnections, node)
+		}
+	}
+	h.persistDir = ""
+
+	if len(changedNodes) > 0 {
+		name := versionedFile("nodes", manifest.Version)
+		if err := h.saveNodes(filepath.Join(baseDir, name), changedNodes); err != nil {
+			return fmt.Errorf("save nodes failed: %w", err)
+		}
+		if err := recordChecksum(manifest, baseDir, name); err != nil {
+			return fmt.Errorf("checksum %s failed: %w", name, err)
+		}
+		manifest.AddDataFile(name)
+	}
+
+	if len(changedConnections) > 0 {
+		name := versionedFile("connections", manifest.Version)
+		if err := h.saveConnections(filepath.Join(baseDir, name), changedConnections); err != nil {
+			return fmt.Errorf("save connections failed: %w", err)
+		}
+		if err := recordChecksum(manifest, baseDir, name); err != nil {
+			return fmt.Errorf("checksum %s failed: %w", name, err)
+		}
+		manifest.AddIndexFile(name)
+	}
+
+	if err := h.commitManifest(baseDir, manifest); err != nil {
+		return fmt.Errorf("save manifest failed: %w", err)
+	}
+
+	h.persistDir = baseDir
+	h.persisted = len(h.nodes)
+	return nil
+}
+
+// commitManifest 记录入口点后提交清单
+func (h *HNSWIndex) commitManifest(baseDir string, manifest *format.Manifest) error {
+	manifest.Metadata["purpose"] = "hnsw_fragments"
+	manifest.Metadata["entry_point"] = strconv.Itoa(int(h.entryPoint))
+	manifest.Metadata["max_level"] = strconv.Itoa(int(h.maxLevel))
+	manifest.Metadata["num_nodes"] = strconv.Itoa(len(h.nodes))
+	return writeManifest(baseDir, manifest)
+}
+
+// loadFragments 按清单顺序加载节点片段和连接片段，并恢复清单中记录的入口点。
+// 文件的校验和已经由调用方验证过
+func (h *HNSWIndex) loadFragments(baseDir string, manifest *format.Manifest) error {
+	for _, name := range manifest.DataFiles {
+		if err := h.loadNodes(filepath.Join(baseDir, name)); err != nil {
+			return fmt.Errorf("load nodes failed: %s: %w", name, err)
+		}
+	}
+	if err := h.indexNodes(); err != nil {
+		return fmt.Errorf("load nodes failed: %w", err)
+	}
+
+	for _, name := range manifest.IndexFiles {
+		if err := h.loadConnections(filepath.Join(baseDir, name)); err != nil {
+			return fmt.Errorf("load connections failed: %s: %w", name, err)
+		}
+	}
+
+	intValue := func(name string) (int, error) {
+		v, err := strconv.Atoi(manifest.Metadata[name])
+		if err != nil {
+			return 0, fmt.Errorf("load manifest failed: invalid %s: %w", name, err)
+		}
+		return v, nil
+	}
+	numNodes, err := intValue("num_nodes")
+	if err != nil {
+		return err
+	}
+	if numNodes != len(h.nodes) {
+		return fmt.Errorf("load manifest failed: manifest records %d nodes, fragments hold %d", numNodes, len(h.nodes))
+	}
+	entryPoint, err := intValue("entry_point")
+	if err != nil {
+		return err
+	}
+	if entryPoint < -1 || entryPoint >= len(h.nodes) {
+		return fmt.Errorf("load manifest failed: invalid entry point %d", entryPoint)
+	}
+	maxLevel, err := intValue("max_level")
+	if err != nil {
+		return err
+	}
+	h.entryPoint = int32(entryPoint)
+	h.maxLevel = int32(maxLevel)
+
+	// 加载连接时留下的脏标记不代表未保存的修改
+	for _, node := range h.nodes {
+		node.connsDirty.Store(false)
+	}
+	h.persistDir = baseDir
+	h.persisted = len(h.nodes)
+
+	return nil
+}
+
+// persistedPatterns 是索引目录中保存的文件：各版本的数据文件、旧目录中固定命名的文件，
+// 以及早期版本的完整保存使用的临时目录
+var persistedPatterns = []string{"nodes*.lance", "connections*.lance", "metadata*.lance", "quantizer*.lance", stagingPrefix + "*"}
+
+// removeUnreferenced 删除 baseDir 中清单没有引用的已保存文件
+func removeUnreferenced(baseDir string, manifest *format.Manifest) error {
+	for _, pattern := range persistedPatterns {
+		matches, err := filepath.Glob(filepath.Join(baseDir, pattern))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if hasChecksum(manifest, filepath.Base(match)) {
+				continue
+			}
+			if err := os.RemoveAll(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// versionedFile 返回清单版本 version 写出的 kind 文件名，例如 nodes-000003.lance
+func versionedFile(kind string, version int64) string {
+	return fmt.Sprintf("%s-%06d.lance", kind, version)
+}
+
+// nextManifestVersion 返回下一个清单版本号，没有旧清单时从 1 开始
+func nextManifestVersion(old *format.Manifest) int64 {
+	if old == nil {
+		return 1
+	}
+	return old.Version + 1
+}
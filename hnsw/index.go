@@ -0,0 +1,243 @@
+package hnsw
+
+import (
+	"fmt"
+	"ollama-demo/lance/arrow"
+	"ollama-demo/lance/column"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// VectorIndex is the interface shared by the vector index implementations, so
+// callers can swap HNSWIndex, FlatIndex and indexes from other packages without
+// code changes. Save records the index type in the metadata file, and LoadIndex
+// uses it to pick the implementation when loading.
+type VectorIndex interface {
+	// Add inserts a vector and returns its assigned ID.
+	Add(vector []float32) (int, error)
+	// Search returns the k nearest neighbours of query, closest first. ef is
+	// the search width: ef for HNSWIndex, the number of probed clusters for
+	// IVF indexes, and ignored by exact indexes such as FlatIndex. 0 uses the
+	// index default.
+	Search(query []float32, k int, ef int) ([]SearchResult, error)
+	// Delete removes the vector with the given ID from search results.
+	Delete(id int) error
+	// Len returns the number of live vectors.
+	Len() int
+	// Save writes the index to Lance files in baseDir. Implementations that do
+	// not write an HNSW manifest call RemoveHNSWFiles once their files are saved.
+	Save(baseDir string) error
+	// Load replaces the contents of the index with the one saved in baseDir.
+	// It must not be called concurrently with other methods.
+	Load(baseDir string) error
+}
+
+var (
+	_ VectorIndex = (*HNSWIndex)(nil)
+	_ VectorIndex = (*FlatIndex)(nil)
+)
+
+// KeyedIndex is implemented by indexes that store caller-supplied external keys
+// and return them in SearchResult.Key.
+type KeyedIndex interface {
+	AddWithKey(key Key, vector []float32) (int, error)
+	Lookup(key Key) (int, bool)
+}
+
+// PayloadIndex is implemented by indexes that store a typed payload with every
+// vector and return it in SearchResult.Payload.
+type PayloadIndex interface {
+	AddWithPayload(key Key, vector []float32, payload Payload) (int, error)
+	PayloadSchema() *arrow.Schema
+}
+
+var (
+	_ KeyedIndex   = (*HNSWIndex)(nil)
+	_ PayloadIndex = (*HNSWIndex)(nil)
+)
+
+// Trainer is implemented by indexes that learn their parameters from a sample
+// of vectors. Indexes created with NewIndex accept Add without it, training
+// themselves on the first vectors added; calling Train with a representative
+// sample beforehand is optional.
+type Trainer interface {
+	Train(vectors [][]float32) error
+}
+
+// Names of the built-in index types, as recorded in saved indexes.
+const (
+	IndexTypeHNSW = "hnsw"
+	IndexTypeFlat = "flat"
+)
+
+// IndexFactory creates an empty index from config. Implementations ignore the
+// fields that do not apply to them. The index must be usable through
+// VectorIndex alone: Add must work without further setup.
+type IndexFactory func(config Config) VectorIndex
+
+// 索引类型注册表：保存时记录类型名，加载时按类型名创建实例
+var (
+	indexMu       sync.RWMutex
+	indexRegistry = map[string]IndexFactory{
+		IndexTypeHNSW: func(config Config) VectorIndex { return NewHNSW(config) },
+		IndexTypeFlat: newFlatIndexFromConfig,
+	}
+)
+
+// newFlatIndexFromConfig 是平坦索引的工厂函数，距离名由 NewIndex 解析后随配置传入
+func newFlatIndexFromConfig(config Config) VectorIndex {
+	f := NewFlatIndex(config.Dimension, config.DistanceFunc)
+	if config.DistanceName != "" {
+		f.distName = config.DistanceName
+	}
+	return f
+}
+
+// RegisterIndexType registers factory under name so that NewIndex and
+// LoadIndex can create indexes of that type. Packages providing other index
+// implementations call it from init.
+func RegisterIndexType(name string, factory IndexFactory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("%w: index type name and factory must be set", ErrInvalidParameter)
+	}
+
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	if _, exists := indexRegistry[name]; exists {
+		return fmt.Errorf("%w: index type %q already registered", ErrInvalidParameter, name)
+	}
+	indexRegistry[name] = factory
+	return nil
+}
+
+// NewIndex creates an empty index of the registered type indexType.
+func NewIndex(indexType string, config Config) (VectorIndex, error) {
+	indexMu.RLock()
+	factory, ok := indexRegistry[indexType]
+	indexMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown index type %q (registered: %v)",
+			ErrInvalidParameter, indexType, registeredIndexTypes())
+	}
+
+	if config.Dimension <= 0 {
+		return nil, fmt.Errorf("%w: dimension must be positive", ErrInvalidParameter)
+	}
+	// 工厂函数按约定在配置错误时 panic，这里先把距离名解析掉，以错误的形式返回
+	if config.DistanceName != "" {
+		fn, err := LookupDistanceFunc(config.DistanceName)
+		if err != nil {
+			return nil, err
+		}
+		config.DistanceFunc = fn
+	}
+
+	return factory(config), nil
+}
+
+// LoadIndex loads the index saved in baseDir, creating it with the factory
+// registered for the index type recorded in its metadata. Indexes saved
+// before the type was recorded load as HNSW.
+func LoadIndex(baseDir string) (VectorIndex, error) {
+	manifest, err := readManifest(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest failed: %w", err)
+	}
+	indexType, dimension, err := readIndexType(filepath.Join(baseDir, manifestFileName(manifest, metadataFileKey, "metadata.lance")))
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+
+	index, err := NewIndex(indexType, Config{Dimension: dimension})
+	if err != nil {
+		return nil, err
+	}
+	if err := index.Load(baseDir); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// IndexExists reports whether baseDir holds an index saved by any of the
+// registered index types.
+func IndexExists(baseDir string) bool {
+	manifest, err := readManifest(baseDir)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(baseDir, manifestFileName(manifest, metadataFileKey, "metadata.lance")))
+	return err == nil
+}
+
+// RemoveHNSWFiles removes the manifest and data files an HNSW save left in
+// baseDir. Indexes saved without a manifest, such as FlatIndex and indexes
+// from other packages, call it after writing their own files: otherwise the
+// manifest of an HNSW index saved to the same directory earlier would still
+// name the files LoadIndex reads, and it would load the old index. Their own
+// metadata.lance is kept.
+func RemoveHNSWFiles(baseDir string) error {
+	// 先删除清单：之后加载的就是新保存的 metadata.lance，再删除清单引用过的文件
+	if err := os.Remove(filepath.Join(baseDir, manifestFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove manifest failed: %w", err)
+	}
+	for _, pattern := range persistedPatterns {
+		matches, err := filepath.Glob(filepath.Join(baseDir, pattern))
+		if err != nil {
+			return fmt.Errorf("remove stale files failed: %w", err)
+		}
+		for _, match := range matches {
+			if filepath.Base(match) == "metadata.lance" {
+				continue
+			}
+			if err := os.RemoveAll(match); err != nil {
+				return fmt.Errorf("remove stale files failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// readIndexType 从元数据文件读取索引类型和向量维度，所有索引类型的元数据都有 dimension 列
+func readIndexType(filename string) (string, int, error) {
+	reader, err := column.NewReader(filename)
+	if err != nil {
+		return "", 0, fmt.Errorf("create reader failed: %w", err)
+	}
+	defer reader.Close()
+
+	batch, err := reader.ReadRecordBatch()
+	if err != nil {
+		return "", 0, fmt.Errorf("read metadata failed: %w", err)
+	}
+
+	col, ok := batch.ColumnByName("dimension")
+	if !ok {
+		return "", 0, fmt.Errorf("metadata column %q missing", "dimension")
+	}
+	array, ok := col.(*arrow.Int32Array)
+	if !ok || array.Len() == 0 {
+		return "", 0, fmt.Errorf("metadata column %q is not a non-empty int32 column", "dimension")
+	}
+
+	indexType := reader.Schema().Metadata()["index_type"]
+	if indexType == "" {
+		indexType = IndexTypeHNSW
+	}
+	return indexType, int(array.Value(0)), nil
+}
+
+// registeredIndexTypes returns the sorted registry keys.
+func registeredIndexTypes() []string {
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+
+	names := make([]string, 0, len(indexRegistry))
+	for name := range indexRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,6 +1,8 @@
 package hnsw
 
 // insert handles the insertion of a new node into the HNSW index.
+// The node must already be published in h.nodes. insert only takes per-node
+// locks while linking, so several inserts can run concurrently.
 func (h *HNSWIndex) insert(newNode *Node) {
 	h.globalLock.RLock()
 	ep := int(h.entryPoint)
@@ -9,63 +11,52 @@ func (h *HNSWIndex) insert(newNode *Node) {
 
 	newNodeLevel := newNode.Level()
 	newNodeID := newNode.ID()
+	vec := newNode.vectorRef()
 
-	// 阶段1：从顶层到 newNodeLevel+1，使用贪心搜索找到入口点
-	currentNearest := ep
-	for lc := maxLvl; lc > newNodeLevel; lc-- {
-		nearest := h.searchLayer(newNode.Vector(), currentNearest, 1, lc)
-		if len(nearest) == 0 {
-			// 理论上不会发生，但添加保护
-			break
-		}
-		currentNearest = nearest[0].ID
+	// 各层搜索复用同一份临时空间
+	scratch := getSearchScratch()
+	defer putSearchScratch(scratch)
+	if h.quantizedOnly {
+		// 其他节点只剩量化编码，用非对称距离代替逐个解码
+		defer h.attachDistancer(scratch, vec)()
 	}
 
+	// 阶段1：从顶层到 newNodeLevel+1，使用贪心搜索找到入口点
+	currentNearest := h.descend(vec, ep, maxLvl, newNodeLevel, scratch)
+
 	// 阶段2：从 newNodeLevel 到第 0 层，建立连接
 	for lc := min(newNodeLevel, maxLvl); lc >= 0; lc-- {
 		// 在当前层搜索最近邻
-		candidates := h.searchLayer(newNode.Vector(), currentNearest, h.efConstruction, lc)
+		candidates := h.searchLayer(vec, currentNearest, h.efConstruction, lc, nil, scratch)
 
-		// 选择 M 个邻居（启发式剪枝）
-		m := h.Mmax
-		if lc == 0 {
-			m = h.Mmax0
-		}
-
-		neighbors := h.selectNeighborsHeuristic(newNode.Vector(), candidates, m)
+		// 用索引的选择策略选出 M 个邻居
+		neighbors := h.selectNeighbors(vec, newNodeID, lc, candidates, h.maxConnections(lc), false)
 
-		// 添加双向连接
-		for _, neighbor := range neighbors {
-			// 新节点 -> 邻居
-			newNode.AddConnection(lc, neighbor.ID)
+		neighborIDs := make([]int, len(neighbors))
+		for i, neighbor := range neighbors {
+			neighborIDs[i] = neighbor.ID
+		}
 
-			// 邻居 -> 新节点
-			neighborNode := h.nodes[neighbor.ID]
-			neighborNode.AddConnection(lc, newNodeID)
+		// 新节点 -> 邻居。其他并发插入可能已经连到了新节点，所以这里是合并而不是覆盖
+		h.connect(newNode, lc, neighborIDs...)
 
-			// 如果邻居的连接数超过限制，需要剪枝
-			maxConn := h.Mmax
-			if lc == 0 {
-				maxConn = h.Mmax0
+		// 邻居 -> 新节点。邻居剪枝时可能都把新节点去掉，新节点就没有入边、搜索无法到达，
+		// 这时在最近的邻居中强制保留到新节点的边
+		nodes := h.snapshot()
+		linked := false
+		for _, neighborID := range neighborIDs {
+			if h.connect(nodes[neighborID], lc, newNodeID) {
+				linked = true
 			}
-
-			if neighborNode.ConnectionCount(lc) > maxConn {
-				// 重新选择邻居
-				neighborConnections := neighborNode.GetConnections(lc)
-				candidatesForPrune := make([]SearchResult, len(neighborConnections))
-
-				for i, connID := range neighborConnections {
-					dist := h.distFunc(neighborNode.Vector(), h.nodes[connID].Vector())
-					candidatesForPrune[i] = SearchResult{ID: connID, Distance: dist}
-				}
-
-				prunedNeighbors := h.selectNeighborsHeuristic(neighborNode.Vector(), candidatesForPrune, maxConn)
-				prunedIDs := make([]int, len(prunedNeighbors))
-				for i, n := range prunedNeighbors {
-					prunedIDs[i] = n.ID
+		}
+		if !linked && len(neighbors) > 0 {
+			closest := neighbors[0]
+			for _, neighbor := range neighbors[1:] {
+				if neighbor.Distance < closest.Distance {
+					closest = neighbor
 				}
-				neighborNode.SetConnections(lc, prunedIDs)
 			}
+			h.connectPinned(nodes[closest.ID], lc, newNodeID)
 		}
 
 		// 更新下一层的入口点
@@ -74,11 +65,81 @@ func (h *HNSWIndex) insert(newNode *Node) {
 		}
 	}
 
-	// 如果新节点的层级更高，更新全局入口点和最大层级
+	// 如果新节点的层级更高，更新全局入口点和最大层级。
+	// 必须在写锁内重新比较：并发插入可能已经把 maxLevel 提得更高
 	if newNodeLevel > maxLvl {
 		h.globalLock.Lock()
-		h.entryPoint = int32(newNodeID)
-		h.maxLevel = int32(newNodeLevel)
+		if int32(newNodeLevel) > h.maxLevel {
+			h.entryPoint = int32(newNodeID)
+			h.maxLevel = int32(newNodeLevel)
+		}
 		h.globalLock.Unlock()
 	}
 }
+
+// connect 把 ids 加入 node 第 lc 层的连接，超过连接上限时用索引的选择策略重新选择邻居，
+// 返回 ids 是否都保留在连接中。加入和剪枝在同一个节点锁内完成，并发插入不会丢失彼此的连接。
+// 剪枝只读取其他节点的向量（原子指针），不获取其他锁，因此不会死锁
+func (h *HNSWIndex) connect(node *Node, lc int, ids ...int) bool {
+	nodeID := node.ID()
+	maxConn := h.maxConnections(lc)
+	kept := true
+
+	node.updateConnections(lc, func(current []int) []int {
+		for _, id := range ids {
+			if id != nodeID && !containsID(current, id) {
+				current = append(current, id)
+			}
+		}
+		if len(current) <= maxConn {
+			return current
+		}
+
+		// 重新选择邻居
+		buf := getDecodeBuffer()
+		defer putDecodeBuffer(buf)
+		vec := buf.vector(h, node)
+		candidates := h.mergeCandidates(vec, nodeID, nil, current)
+		pruned := h.selectNeighbors(vec, nodeID, lc, candidates, maxConn, true)
+
+		prunedIDs := make([]int, len(pruned))
+		for i, n := range pruned {
+			prunedIDs[i] = n.ID
+		}
+		for _, id := range ids {
+			if id != nodeID && !containsID(prunedIDs, id) {
+				kept = false
+			}
+		}
+		return prunedIDs
+	})
+	return kept
+}
+
+// connectPinned 把 id 加入 node 第 lc 层的连接并保证它不被剪掉：超过连接上限时
+// 只从其余连接中选出 maxConn-1 个。insert 用它保证新节点至少有一条入边
+func (h *HNSWIndex) connectPinned(node *Node, lc int, id int) {
+	nodeID := node.ID()
+	maxConn := h.maxConnections(lc)
+
+	node.updateConnections(lc, func(current []int) []int {
+		if containsID(current, id) {
+			return current
+		}
+		if len(current) < maxConn {
+			return append(current, id)
+		}
+
+		buf := getDecodeBuffer()
+		defer putDecodeBuffer(buf)
+		vec := buf.vector(h, node)
+		candidates := h.mergeCandidates(vec, nodeID, nil, current)
+		selected := h.selectNeighbors(vec, nodeID, lc, candidates, maxConn-1, true)
+
+		selectedIDs := make([]int, 0, len(selected)+1)
+		for _, s := range selected {
+			selectedIDs = append(selectedIDs, s.ID)
+		}
+		return append(selectedIDs, id)
+	})
+}
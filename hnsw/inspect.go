@@ -0,0 +1,310 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+	"ollama-demo/lance/arrow"
+	"strings"
+)
+
+// GraphStats summarises the structure of an HNSW graph, as computed by Stats.
+type GraphStats struct {
+	Nodes      int          // Nodes in the graph, including tombstones.
+	Deleted    int          // Tombstoned nodes.
+	EntryPoint int          // Entry point node ID, -1 if the index is empty.
+	MaxLevel   int          // Highest level, -1 if the index is empty.
+	Levels     []LevelStats // Per-level statistics, indexed by level.
+
+	Unreachable     []int  // Live nodes a search from the entry point cannot reach at level 0.
+	AsymmetricEdges int    // Edges without a reverse edge; common in moderation, since neighbour lists are pruned independently.
+	InvalidEdges    []Edge // Edges to IDs out of range, to nodes not on the edge's level, or to the node itself.
+}
+
+// LevelStats summarises one level of an HNSW graph.
+type LevelStats struct {
+	Nodes      int     // Nodes living on the level, including tombstones.
+	Edges      int     // Directed edges at the level.
+	MinDegree  int     // Smallest out-degree of the level's nodes.
+	MaxDegree  int     // Largest out-degree of the level's nodes.
+	MeanDegree float64 // Mean out-degree of the level's nodes.
+	Degrees    []int   // Degrees[d] is the number of the level's nodes with out-degree d.
+}
+
+// Edge is a directed edge of an HNSW graph.
+type Edge struct {
+	From  int
+	To    int
+	Level int
+}
+
+// Stats walks the whole graph and reports per-level node counts and degree
+// distributions along with the defects that degrade search: live nodes that
+// cannot be reached from the entry point, asymmetric edges and invalid edges.
+// It holds the index's read lock for the duration of the walk.
+func (h *HNSWIndex) Stats() GraphStats {
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	stats := GraphStats{
+		Nodes:      len(h.nodes),
+		EntryPoint: int(h.entryPoint),
+		MaxLevel:   int(h.maxLevel),
+	}
+
+	topLevel := -1
+	for _, node := range h.nodes {
+		topLevel = max(topLevel, node.Level())
+		if node.IsDeleted() {
+			stats.Deleted++
+		}
+	}
+	stats.Levels = make([]LevelStats, topLevel+1)
+	for level := range stats.Levels {
+		stats.Levels[level].MinDegree = math.MaxInt
+	}
+
+	var neighbors, reverse []int
+	for id, node := range h.nodes {
+		for level := 0; level <= node.Level(); level++ {
+			neighbors = node.appendConnections(neighbors[:0], level)
+
+			ls := &stats.Levels[level]
+			degree := len(neighbors)
+			ls.Nodes++
+			ls.Edges += degree
+			ls.MinDegree = min(ls.MinDegree, degree)
+			ls.MaxDegree = max(ls.MaxDegree, degree)
+			for len(ls.Degrees) <= degree {
+				ls.Degrees = append(ls.Degrees, 0)
+			}
+			ls.Degrees[degree]++
+
+			for _, neighborID := range neighbors {
+				if edgeProblem(h.nodes, id, neighborID, level) != 0 {
+					stats.InvalidEdges = append(stats.InvalidEdges, Edge{From: id, To: neighborID, Level: level})
+					continue
+				}
+				reverse = h.nodes[neighborID].appendConnections(reverse[:0], level)
+				if !containsID(reverse, id) {
+					stats.AsymmetricEdges++
+				}
+			}
+		}
+	}
+	for level := range stats.Levels {
+		ls := &stats.Levels[level]
+		if ls.Nodes == 0 {
+			ls.MinDegree = 0
+			continue
+		}
+		ls.MeanDegree = float64(ls.Edges) / float64(ls.Nodes)
+	}
+
+	stats.Unreachable = h.unreachableNodes()
+	return stats
+}
+
+// unreachableNodes 返回第0层无法从入口点到达的存活节点（遍历与搜索一样经过墓碑节点）。
+// 调用方持有 globalLock 读锁
+func (h *HNSWIndex) unreachableNodes() []int {
+	ep := int(h.entryPoint)
+	if ep >= len(h.nodes) {
+		ep = -1
+	}
+	reached := h.reachable(ep)
+
+	var unreachable []int
+	for id, node := range h.nodes {
+		if !reached[id] && !node.IsDeleted() {
+			unreachable = append(unreachable, id)
+		}
+	}
+	return unreachable
+}
+
+// GraphErrorKind classifies the problems reported by Validate.
+type GraphErrorKind int
+
+const (
+	NeighborOutOfRange   GraphErrorKind = iota + 1 // Edge to an ID outside the index.
+	NeighborNotOnLevel                             // Edge to a node that does not live on the edge's level.
+	SelfLoop                                       // Edge from a node to itself.
+	DuplicateNeighbor                              // Same neighbour listed twice at one level.
+	InvalidEntryPoint                              // Entry point missing, out of range or not on the top level.
+	DeletedCountMismatch                           // Tombstone count differs from the tombstoned nodes.
+	InvalidVector                                  // Vector of the wrong dimension or with NaN or infinite components.
+)
+
+// String returns the name of the kind.
+func (k GraphErrorKind) String() string {
+	switch k {
+	case NeighborOutOfRange:
+		return "neighbor out of range"
+	case NeighborNotOnLevel:
+		return "neighbor not on level"
+	case SelfLoop:
+		return "self loop"
+	case DuplicateNeighbor:
+		return "duplicate neighbor"
+	case InvalidEntryPoint:
+		return "invalid entry point"
+	case DeletedCountMismatch:
+		return "deleted count mismatch"
+	case InvalidVector:
+		return "invalid vector"
+	default:
+		return fmt.Sprintf("GraphErrorKind(%d)", int(k))
+	}
+}
+
+// GraphError is one problem found by Validate. It wraps ErrCorruptIndex.
+type GraphError struct {
+	Kind     GraphErrorKind
+	Node     int    // Node the problem was found on, -1 for index-wide problems.
+	Level    int    // Level of the offending edge, -1 if the problem is not about an edge.
+	Neighbor int    // Target of the offending edge; only meaningful when Level is set.
+	Detail   string // Additional description, may be empty.
+}
+
+func (e *GraphError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.String())
+	if e.Node >= 0 {
+		fmt.Fprintf(&b, ": node %d", e.Node)
+	}
+	if e.Level >= 0 {
+		fmt.Fprintf(&b, " level %d -> %d", e.Level, e.Neighbor)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, " (%s)", e.Detail)
+	}
+	return b.String()
+}
+
+func (e *GraphError) Unwrap() error {
+	return ErrCorruptIndex
+}
+
+// ValidationError lists every problem found by Validate. errors.Is reports
+// ErrCorruptIndex for it, and errors.As finds the first GraphError.
+type ValidationError struct {
+	Errors []*GraphError
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("%v: %v", ErrCorruptIndex, e.Errors[0])
+	}
+	return fmt.Sprintf("%v: %d problems, first: %v", ErrCorruptIndex, len(e.Errors), e.Errors[0])
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Validate checks the graph for corruption, typically right after loading:
+// edges to IDs outside the index, to nodes not on the edge's level, to the
+// node itself or listed twice; an entry point that is missing or not on the
+// top level; a tombstone count that does not match the nodes; and vectors of
+// the wrong dimension or with NaN or infinite components. It returns nil for
+// a sound index and a *ValidationError listing every problem otherwise.
+// Unreachable nodes and asymmetric edges occur in healthy graphs too, so they
+// are left to Stats.
+func (h *HNSWIndex) Validate() error {
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	var errs []*GraphError
+	report := func(kind GraphErrorKind, node, level, neighbor int, detail string) {
+		errs = append(errs, &GraphError{Kind: kind, Node: node, Level: level, Neighbor: neighbor, Detail: detail})
+	}
+
+	// 入口点：有存活节点时必须在范围内、未被删除且位于最高层
+	live := len(h.nodes) - h.numDeleted
+	ep := int(h.entryPoint)
+	switch {
+	case ep == -1:
+		if live > 0 {
+			report(InvalidEntryPoint, -1, -1, -1, fmt.Sprintf("no entry point for %d live nodes", live))
+		}
+	case ep < 0 || ep >= len(h.nodes):
+		report(InvalidEntryPoint, ep, -1, -1, fmt.Sprintf("valid range: [0, %d)", len(h.nodes)))
+	case h.nodes[ep].Level() != int(h.maxLevel):
+		report(InvalidEntryPoint, ep, -1, -1, fmt.Sprintf("node level %d, max level %d", h.nodes[ep].Level(), h.maxLevel))
+	case h.nodes[ep].IsDeleted() && live > 0:
+		report(InvalidEntryPoint, ep, -1, -1, "entry point is deleted")
+	}
+
+	deleted := 0
+	var neighbors []int
+	seen := make(map[int]bool)
+	for id, node := range h.nodes {
+		if node.IsDeleted() {
+			deleted++
+		}
+
+		vector := node.vectorRef()
+		if half := node.halfRef(); vector == nil && half != nil {
+			// 超出半精度范围的分量变成无穷大，同样要报告
+			vector = arrow.Float16sToFloat32(nil, half)
+		}
+		if vector != nil {
+			if len(vector) != h.dimension {
+				report(InvalidVector, id, -1, -1, fmt.Sprintf("dimension %d, expected %d", len(vector), h.dimension))
+			} else if i := nonFiniteIndex(vector); i >= 0 {
+				report(InvalidVector, id, -1, -1, fmt.Sprintf("component %d is %v", i, vector[i]))
+			}
+		}
+
+		for level := 0; level <= node.Level(); level++ {
+			neighbors = node.appendConnections(neighbors[:0], level)
+			clear(seen)
+			for _, neighborID := range neighbors {
+				if kind := edgeProblem(h.nodes, id, neighborID, level); kind != 0 {
+					report(kind, id, level, neighborID, "")
+					continue
+				}
+				if seen[neighborID] {
+					report(DuplicateNeighbor, id, level, neighborID, "")
+				}
+				seen[neighborID] = true
+			}
+		}
+	}
+	if deleted != h.numDeleted {
+		report(DeletedCountMismatch, -1, -1, -1, fmt.Sprintf("%d tombstoned nodes, count is %d", deleted, h.numDeleted))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+// edgeProblem 返回第 level 层的边 from -> to 的结构问题，没有问题时返回 0
+func edgeProblem(nodes []*Node, from, to, level int) GraphErrorKind {
+	switch {
+	case to < 0 || to >= len(nodes):
+		return NeighborOutOfRange
+	case to == from:
+		return SelfLoop
+	case nodes[to].Level() < level:
+		return NeighborNotOnLevel
+	default:
+		return 0
+	}
+}
+
+// nonFiniteIndex 返回第一个 NaN 或无穷大分量的下标，没有时返回 -1
+func nonFiniteIndex(vector []float32) int {
+	for i, v := range vector {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return i
+		}
+	}
+	return -1
+}
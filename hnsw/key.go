@@ -0,0 +1,82 @@
+package hnsw
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// keyKind 区分外部键的类型
+type keyKind uint8
+
+const (
+	keyNone keyKind = iota
+	keyInt
+	keyString
+)
+
+// Key is a caller-supplied external identifier of a vector, either an int64 or
+// a string. Unlike node IDs, keys are chosen by the caller and stored with the
+// index, so callers do not need their own ID mapping. The zero Key means "no key".
+// Keys are comparable and can be used as map keys.
+type Key struct {
+	kind keyKind
+	num  int64
+	str  string
+}
+
+// IntKey returns an int64 key.
+func IntKey(v int64) Key { return Key{kind: keyInt, num: v} }
+
+// StringKey returns a string key.
+func StringKey(s string) Key { return Key{kind: keyString, str: s} }
+
+// IsZero reports whether k is the zero Key, which vectors added without a key have.
+func (k Key) IsZero() bool { return k.kind == keyNone }
+
+// Int returns the value of an int64 key; ok is false for other keys.
+func (k Key) Int() (v int64, ok bool) { return k.num, k.kind == keyInt }
+
+// Str returns the value of a string key; ok is false for other keys.
+func (k Key) Str() (s string, ok bool) { return k.str, k.kind == keyString }
+
+// String implements fmt.Stringer.
+func (k Key) String() string {
+	switch k.kind {
+	case keyInt:
+		return strconv.FormatInt(k.num, 10)
+	case keyString:
+		return strconv.Quote(k.str)
+	default:
+		return "<none>"
+	}
+}
+
+// AddWithKey inserts a vector like Add and associates it with key. The key is
+// returned in SearchResult.Key, persisted by SaveToLance, and can be resolved
+// back to the node ID with Lookup. Keys are unique among live nodes; a key
+// becomes free again once its node is deleted.
+func (h *HNSWIndex) AddWithKey(key Key, vector []float32) (int, error) {
+	if key.IsZero() {
+		return -1, fmt.Errorf("%w: key must be set", ErrInvalidParameter)
+	}
+	return h.add(key, Key{}, vector, nil)
+}
+
+// Lookup returns the ID of the live node with the given key.
+func (h *HNSWIndex) Lookup(key Key) (int, bool) {
+	h.globalLock.RLock()
+	defer h.globalLock.RUnlock()
+
+	id, ok := h.keys[key]
+	return id, ok
+}
+
+// attachKeys 为结果填上节点的外部键、文档键和负载。它们在节点发布前设置且之后不变，不需要加锁
+func (h *HNSWIndex) attachKeys(results []SearchResult) {
+	nodes := h.snapshot()
+	for i := range results {
+		results[i].Key = nodes[results[i].ID].key
+		results[i].Payload = nodes[results[i].ID].payload
+		results[i].Document = nodes[results[i].ID].doc
+	}
+}
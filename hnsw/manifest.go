@@ -0,0 +1,170 @@
+package hnsw
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"ollama-demo/lance/format"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 保存的提交协议：所有文件写成按清单版本命名的新文件，同步到磁盘并把 CRC32
+// 校验和记录到清单中，最后替换清单，这是唯一的提交点。加载时先校验清单中的每个文件，
+// 任何一个缺失或与校验和不符都拒绝加载。
+
+const (
+	manifestFile   = "_manifest" // Manifest listing the files of a saved index with their checksums.
+	stagingPrefix  = ".staging-" // Prefix of the temporary directories earlier versions wrote full saves to.
+	checksumPrefix = "crc32:"    // Prefix of the manifest metadata keys holding file checksums.
+
+	metadataFileKey  = "metadata_file"  // Manifest metadata key naming the metadata file.
+	quantizerFileKey = "quantizer_file" // Manifest metadata key naming the quantizer file, absent without a quantizer.
+)
+
+// readManifest 读取 baseDir 中的清单，不存在时返回 nil
+func readManifest(baseDir string) (*format.Manifest, error) {
+	file, err := os.Open(filepath.Join(baseDir, manifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest := &format.Manifest{}
+	if _, err := manifest.ReadFrom(file); err != nil {
+		return nil, err
+	}
+	if !manifest.Committed {
+		return nil, fmt.Errorf("manifest version %d is not committed", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// writeManifest 提交清单：先写临时文件并同步，再改名替换旧清单，读者看到的总是完整的清单
+func writeManifest(baseDir string, manifest *format.Manifest) error {
+	manifest.Commit()
+
+	filename := filepath.Join(baseDir, manifestFile)
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := manifest.WriteTo(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	syncDir(baseDir)
+	return nil
+}
+
+// recordChecksum 把 dir 中文件 name 同步到磁盘，并将其校验和记录到清单
+func recordChecksum(manifest *format.Manifest, dir string, name string) error {
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	manifest.Metadata[checksumPrefix+name] = strconv.FormatUint(uint64(hash.Sum32()), 16)
+	return nil
+}
+
+// verifyChecksums 校验清单中记录的每个文件，片段文件必须都有校验和。
+// fragments 为 false 时片段文件只检查存在而不读取内容：只读加载按需映射片段，
+// 计算校验和要把整个索引读一遍，抵消了映射的意义
+func verifyChecksums(baseDir string, manifest *format.Manifest, fragments bool) error {
+	isFragment := make(map[string]bool)
+	for _, name := range append(append([]string(nil), manifest.DataFiles...), manifest.IndexFiles...) {
+		if _, ok := manifest.Metadata[checksumPrefix+name]; !ok {
+			return fmt.Errorf("%w: no checksum recorded for %s", ErrChecksumMismatch, name)
+		}
+		isFragment[name] = true
+	}
+
+	for key, expected := range manifest.Metadata {
+		name, ok := strings.CutPrefix(key, checksumPrefix)
+		if !ok {
+			continue
+		}
+		if isFragment[name] && !fragments {
+			if _, err := os.Stat(filepath.Join(baseDir, name)); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrChecksumMismatch, name, err)
+			}
+			continue
+		}
+		actual, err := fileChecksum(filepath.Join(baseDir, name))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrChecksumMismatch, name, err)
+		}
+		if actual != expected {
+			return fmt.Errorf("%w: %s: manifest records %s, file has %s", ErrChecksumMismatch, name, expected, actual)
+		}
+	}
+	return nil
+}
+
+// hasChecksum 报告清单是否包含文件 name
+func hasChecksum(manifest *format.Manifest, name string) bool {
+	_, ok := manifest.Metadata[checksumPrefix+name]
+	return ok
+}
+
+// manifestFileName 返回清单在 key 下记录的文件名。没有清单或清单早于按版本命名时，
+// 文件固定命名为 legacy
+func manifestFileName(manifest *format.Manifest, key, legacy string) string {
+	if manifest != nil {
+		if name := manifest.Metadata[key]; name != "" {
+			return name
+		}
+	}
+	return legacy
+}
+
+// fileChecksum 返回文件内容的 CRC32 校验和，格式与 recordChecksum 一致
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(hash.Sum32()), 16), nil
+}
+
+// syncDir 同步目录项，让改名在崩溃后仍然可见。部分平台不支持同步目录，忽略错误
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,285 @@
+package hnsw
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NeighborSelector chooses the neighbours a node is connected to at one level,
+// both when a node is linked into the graph and when a connection list grows
+// beyond its limit and has to be pruned. Strategies trade recall against build
+// time and memory; Config.NeighborSelector sets the one an index uses.
+type NeighborSelector interface {
+	// SelectNeighbors returns at most m of candidates to connect the node with
+	// vector vec to. candidates hold distances to vec, are in no particular
+	// order and must not be modified. Selectors that extend the candidate set
+	// may return nodes from g.Neighbors as well.
+	SelectNeighbors(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult
+}
+
+// NeighborSelectorFunc adapts an ordinary function to a NeighborSelector.
+type NeighborSelectorFunc func(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult
+
+// SelectNeighbors calls f(g, vec, candidates, m).
+func (f NeighborSelectorFunc) SelectNeighbors(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+	return f(g, vec, candidates, m)
+}
+
+// NeighborGraph is the view of the index a NeighborSelector works on. It is
+// only valid during the SelectNeighbors call it is passed to.
+type NeighborGraph struct {
+	h      *HNSWIndex
+	nodes  []*Node
+	buf    *decodeBuffer // Holds the vectors Vector decodes until the selection ends.
+	node   int           // Node whose neighbours are selected.
+	level  int           // Level whose connections are selected.
+	locked bool          // Selection runs under the node's lock; other nodes' connections must not be read.
+}
+
+// Node returns the ID of the node whose neighbours are being selected.
+func (g *NeighborGraph) Node() int { return g.node }
+
+// Level returns the level whose connections are being selected.
+func (g *NeighborGraph) Level() int { return g.level }
+
+// Vector returns the vector of node id, decoded if the index keeps only
+// half-precision vectors or quantized codes. It must not be modified, and a
+// decoded vector is only valid until SelectNeighbors returns.
+func (g *NeighborGraph) Vector(id int) []float32 {
+	return g.buf.vector(g.h, g.nodes[id])
+}
+
+// Distance returns the index's distance between a and b.
+func (g *NeighborGraph) Distance(a, b []float32) float32 {
+	return g.h.distFunc(a, b)
+}
+
+// Neighbors returns the live neighbours of node id at the level being
+// selected, excluding the node being linked. It returns nil while an existing
+// connection list is pruned or relinked, since that happens under the node's
+// lock and reading other nodes' connections there could deadlock; only the
+// neighbours of newly inserted nodes can be selected from an extended set.
+func (g *NeighborGraph) Neighbors(id int) []int {
+	if g.locked || id < 0 || id >= len(g.nodes) {
+		return nil
+	}
+	neighbors := g.nodes[id].appendConnections(nil, g.level)
+	live := neighbors[:0]
+	for _, neighborID := range neighbors {
+		if neighborID != g.node && !g.nodes[neighborID].IsDeleted() {
+			live = append(live, neighborID)
+		}
+	}
+	return live
+}
+
+// SimpleSelector keeps the m candidates closest to the node, the paper's
+// SELECT-NEIGHBORS-SIMPLE. It is the cheapest strategy, but on clustered data
+// the edges stay inside dense regions and recall drops.
+type SimpleSelector struct{}
+
+// SelectNeighbors implements NeighborSelector.
+func (SimpleSelector) SelectNeighbors(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+	sorted := sortedCandidates(candidates)
+	if len(sorted) > m {
+		sorted = sorted[:m]
+	}
+	return sorted
+}
+
+// HeuristicSelector is the paper's SELECT-NEIGHBORS-HEURISTIC: candidates are
+// visited closest first, and one is kept only if it is closer to the node than
+// to every neighbour kept so far, which spreads the edges in different
+// directions. The default selector is HeuristicSelector{KeepPrunedConnections: true}.
+type HeuristicSelector struct {
+	ExtendCandidates      bool // Also consider the neighbours of the candidates; see NeighborGraph.Neighbors.
+	KeepPrunedConnections bool // Fill the remaining slots with the closest discarded candidates.
+}
+
+// SelectNeighbors implements NeighborSelector.
+func (s HeuristicSelector) SelectNeighbors(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+	if s.ExtendCandidates {
+		candidates = extendCandidates(g, vec, candidates)
+	}
+	// 候选不超过 m 个且保留被剪掉的候选时，结果就是全部候选
+	if len(candidates) <= m && s.KeepPrunedConnections {
+		return candidates
+	}
+
+	kept, pruned := pruneCandidates(g, sortedCandidates(candidates), m, func(toSelected, toNode float32) bool {
+		return toSelected < toNode
+	})
+	if s.KeepPrunedConnections {
+		for _, candidate := range pruned {
+			if len(kept) >= m {
+				break
+			}
+			kept = append(kept, candidate)
+		}
+	}
+	return kept
+}
+
+// RNGSelector prunes with the alpha-relaxed relative neighbourhood graph rule
+// of Vamana (DiskANN): a candidate c is discarded if a neighbour s kept so far
+// satisfies Alpha * d(s, c) < d(node, c). Pruned candidates are never added
+// back, so Alpha 1 selects the same neighbours as HeuristicSelector without
+// KeepPrunedConnections; larger values keep more long edges, trading build
+// time and memory for connectivity. Alpha defaults to 1.2. The rule uses the
+// index's distances, so with L2Distance, which is squared, Alpha relaxes
+// squared distances.
+type RNGSelector struct {
+	Alpha float32
+}
+
+// SelectNeighbors implements NeighborSelector.
+func (s RNGSelector) SelectNeighbors(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+	alpha := s.Alpha
+	if alpha == 0 {
+		alpha = 1.2
+	}
+	kept, _ := pruneCandidates(g, sortedCandidates(candidates), m, func(toSelected, toNode float32) bool {
+		return alpha*toSelected < toNode
+	})
+	return kept
+}
+
+// sortedCandidates 返回按距离升序排列的候选副本
+func sortedCandidates(candidates []SearchResult) []SearchResult {
+	sorted := append([]SearchResult(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Distance < sorted[j].Distance
+	})
+	return sorted
+}
+
+// pruneCandidates 按距离顺序遍历候选，dominated(到某个已选邻居的距离, 到节点的距离)
+// 为真的候选被剪掉，直到选够 m 个。返回选中的候选和按距离排列的被剪掉的候选
+func pruneCandidates(g *NeighborGraph, sorted []SearchResult, m int, dominated func(toSelected, toNode float32) bool) (kept, pruned []SearchResult) {
+	kept = make([]SearchResult, 0, m)
+
+	// 已选邻居的向量，避免重复获取（只保留量化编码或半精度向量时每次获取都要解码）
+	keptVecs := make([][]float32, 0, m)
+
+	for i, candidate := range sorted {
+		if len(kept) >= m {
+			pruned = append(pruned, sorted[i:]...)
+			break
+		}
+
+		// 候选点离某个已选邻居比离节点更近时被剪掉，保证邻居的多样性和覆盖范围
+		candidateVec := g.Vector(candidate.ID)
+		good := true
+		for _, keptVec := range keptVecs {
+			if dominated(g.Distance(candidateVec, keptVec), candidate.Distance) {
+				good = false
+				break
+			}
+		}
+
+		if good {
+			kept = append(kept, candidate)
+			keptVecs = append(keptVecs, candidateVec)
+		} else {
+			pruned = append(pruned, candidate)
+		}
+	}
+	return kept, pruned
+}
+
+// extendCandidates 把候选在当前层的邻居加入候选集（论文中的 extendCandidates），
+// 去掉重复的节点，新加入的节点按 vec 计算距离
+func extendCandidates(g *NeighborGraph, vec []float32, candidates []SearchResult) []SearchResult {
+	seen := make(map[int]bool, len(candidates))
+	for _, c := range candidates {
+		seen[c.ID] = true
+	}
+
+	extended := append([]SearchResult(nil), candidates...)
+	for _, c := range candidates {
+		for _, neighborID := range g.Neighbors(c.ID) {
+			if seen[neighborID] {
+				continue
+			}
+			seen[neighborID] = true
+			extended = append(extended, SearchResult{ID: neighborID, Distance: g.Distance(vec, g.Vector(neighborID))})
+		}
+	}
+	return extended
+}
+
+// selectNeighbors 用索引的选择策略从 candidates 中为节点 nodeID 选出第 level 层最多 m 个邻居。
+// locked 表示调用方持有该节点的锁，此时不能读取其他节点的连接
+func (h *HNSWIndex) selectNeighbors(vec []float32, nodeID int, level int, candidates []SearchResult, m int, locked bool) []SearchResult {
+	buf := getDecodeBuffer()
+	defer putDecodeBuffer(buf)
+	g := &NeighborGraph{h: h, nodes: h.snapshot(), buf: buf, node: nodeID, level: level, locked: locked}
+	selected := h.selector.SelectNeighbors(g, vec, candidates, m)
+	if len(selected) > m {
+		selected = selected[:m]
+	}
+	return selected
+}
+
+// 内置选择策略在元数据中的名称，自定义策略不保存，加载后使用默认策略
+const (
+	selectorSimple    = "simple"
+	selectorHeuristic = "heuristic"
+	selectorRNG       = "rng"
+)
+
+// selectorSpec 返回内置选择策略的描述，例如 "heuristic,extend,keep" 或 "rng:1.2"，自定义策略返回空串
+func selectorSpec(selector NeighborSelector) string {
+	switch s := selector.(type) {
+	case SimpleSelector:
+		return selectorSimple
+	case HeuristicSelector:
+		spec := selectorHeuristic
+		if s.ExtendCandidates {
+			spec += ",extend"
+		}
+		if s.KeepPrunedConnections {
+			spec += ",keep"
+		}
+		return spec
+	case RNGSelector:
+		return selectorRNG + ":" + strconv.FormatFloat(float64(s.Alpha), 'g', -1, 32)
+	default:
+		return ""
+	}
+}
+
+// parseSelectorSpec 是 selectorSpec 的逆操作
+func parseSelectorSpec(spec string) (NeighborSelector, error) {
+	name, options, _ := strings.Cut(spec, ",")
+	name, arg, hasArg := strings.Cut(name, ":")
+	switch {
+	case name == selectorSimple && options == "" && !hasArg:
+		return SimpleSelector{}, nil
+	case name == selectorHeuristic && !hasArg:
+		var s HeuristicSelector
+		if options != "" {
+			for _, option := range strings.Split(options, ",") {
+				switch option {
+				case "extend":
+					s.ExtendCandidates = true
+				case "keep":
+					s.KeepPrunedConnections = true
+				default:
+					return nil, fmt.Errorf("unknown neighbor selector option %q", option)
+				}
+			}
+		}
+		return s, nil
+	case name == selectorRNG && options == "" && hasArg:
+		alpha, err := strconv.ParseFloat(arg, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid neighbor selector alpha: %w", err)
+		}
+		return RNGSelector{Alpha: float32(alpha)}, nil
+	default:
+		return nil, fmt.Errorf("unknown neighbor selector %q", spec)
+	}
+}
@@ -1,14 +1,29 @@
 package hnsw
 
-import "sync"
+import (
+	"ollama-demo/lance/arrow"
+	"sync"
+	"sync/atomic"
+)
 
 // Node represents a single node in the HNSW graph.
 type Node struct {
-	id     int       // Unique identifier for the node.
-	vector []float32 // The vector associated with the node.
-	level  int       // The level of the node in the HNSW hierarchy.
+	id      int                             // Unique identifier for the node.
+	vector  atomic.Pointer[[]float32]       // The vector associated with the node, swapped atomically by Update.
+	code    atomic.Pointer[[]byte]          // Quantized vector, nil unless the index has a Quantizer.
+	half    atomic.Pointer[[]arrow.Float16] // Half-precision vector, nil unless the index was created with Float16Vectors.
+	level   int                             // The level of the node in the HNSW hierarchy.
+	key     Key                             // External key, set before the node is published and never changed.
+	doc     Key                             // Document the vector belongs to (AddDocument), set before the node is published and never changed.
+	payload Payload                         // Typed metadata, set before the node is published and never changed.
 
-	connections [][]int // Connections to other nodes at different levels.
+	connections [][]int   // Connections to other nodes at different levels.
+	mapped      [][]int32 // Connections of read-only indexes, viewed in place in a mapped file; used instead of connections when set.
+
+	deleted atomic.Bool // Tombstone flag: deleted nodes are traversed but never returned.
+
+	rowDirty   atomic.Bool // Tombstone or vector changed since the last incremental save.
+	connsDirty atomic.Bool // Connections changed since the last incremental save.
 
 	mu sync.RWMutex // Mutex for concurrent access to the node's connections.
 }
@@ -18,28 +33,95 @@ func NewNode(id int, vector []float32, level int) *Node {
 	for i := range connections {
 		connections[i] = make([]int, 0)
 	}
-	return &Node{
+	node := &Node{
 		id:          id,
-		vector:      vector,
 		level:       level,
 		connections: connections,
 	}
+	node.vector.Store(&vector)
+	return node
 }
 
 func (n *Node) ID() int {
 	return n.id
 }
 
+// Key returns the node's external key, or the zero Key if it has none.
+func (n *Node) Key() Key {
+	return n.key
+}
+
+// Document returns the key of the document the node's vector belongs to, or
+// the zero Key if it was not added with AddDocument.
+func (n *Node) Document() Key {
+	return n.doc
+}
+
+// Payload returns the node's payload, nil if it has none. It must not be modified.
+func (n *Node) Payload() Payload {
+	return n.payload
+}
+
+// Vector returns a copy of the node's vector. Nodes of an index created with
+// Float16Vectors return their half-precision vector converted to float32. It
+// is empty for nodes of an index created with QuantizedOnly, which keeps only
+// the quantized code.
 func (n *Node) Vector() []float32 {
-	result := make([]float32, len(n.vector))
-	copy(result, n.vector)
+	vector := *n.vector.Load()
+	if half := n.halfRef(); vector == nil && half != nil {
+		return arrow.Float16sToFloat32(nil, half)
+	}
+	result := make([]float32, len(vector))
+	copy(result, vector)
 	return result
 }
 
+// vectorRef returns the node's vector without copying. The slice is shared and
+// must not be modified; Update swaps in a new slice instead of writing to it.
+func (n *Node) vectorRef() []float32 {
+	return *n.vector.Load()
+}
+
+// codeRef returns the node's quantized vector without copying, or nil.
+func (n *Node) codeRef() []byte {
+	if p := n.code.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// setCode replaces the node's quantized vector.
+func (n *Node) setCode(code []byte) {
+	n.code.Store(&code)
+}
+
+// halfRef returns the node's half-precision vector without copying, or nil.
+func (n *Node) halfRef() []arrow.Float16 {
+	if p := n.half.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// setHalf replaces the node's half-precision vector.
+func (n *Node) setHalf(half []arrow.Float16) {
+	n.half.Store(&half)
+}
+
+// setVector replaces the node's vector; concurrent readers see either the old or the new one.
+func (n *Node) setVector(vector []float32) {
+	n.vector.Store(&vector)
+}
+
 func (n *Node) Level() int {
 	return n.level
 }
 
+// IsDeleted reports whether the node has been marked as a tombstone.
+func (n *Node) IsDeleted() bool {
+	return n.deleted.Load()
+}
+
 // GetConnections returns the connections of the node at the specified level.
 func (n *Node) GetConnections(level int) []int {
 	n.mu.RLock()
@@ -48,12 +130,31 @@ func (n *Node) GetConnections(level int) []int {
 	if level < 0 || level >= len(n.connections) {
 		return nil
 	}
+	if n.mapped != nil {
+		return appendMapped(make([]int, 0, len(n.mapped[level])), n.mapped[level])
+	}
 
 	result := make([]int, len(n.connections[level]))
 	copy(result, n.connections[level])
 	return result
 }
 
+// appendConnections appends the connections at the specified level to dst and
+// returns the extended slice, letting callers reuse a buffer instead of
+// allocating a copy like GetConnections does.
+func (n *Node) appendConnections(dst []int, level int) []int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if level < 0 || level >= len(n.connections) {
+		return dst
+	}
+	if n.mapped != nil {
+		return appendMapped(dst, n.mapped[level])
+	}
+	return append(dst, n.connections[level]...)
+}
+
 // AddConnection adds a connection to another node at the specified level.
 func (n *Node) AddConnection(level int, neighborID int) {
 	n.mu.Lock()
@@ -63,6 +164,7 @@ func (n *Node) AddConnection(level int, neighborID int) {
 		return
 	}
 	n.connections[level] = append(n.connections[level], neighborID)
+	n.connsDirty.Store(true)
 }
 
 // SetConnections sets the connections of the node at the specified level.
@@ -76,6 +178,21 @@ func (n *Node) SetConnections(level int, neighbors []int) {
 
 	n.connections[level] = make([]int, len(neighbors))
 	copy(n.connections[level], neighbors)
+	n.connsDirty.Store(true)
+}
+
+// updateConnections replaces the connections at the specified level with the
+// result of fn. The read-modify-write happens under the node's write lock, so
+// concurrent updates cannot overwrite each other's edges.
+func (n *Node) updateConnections(level int, fn func(current []int) []int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if level < 0 || level >= len(n.connections) {
+		return
+	}
+	n.connections[level] = fn(n.connections[level])
+	n.connsDirty.Store(true)
 }
 
 // ConnectionCount returns the number of connections at the specified level.
@@ -86,5 +203,31 @@ func (n *Node) ConnectionCount(level int) int {
 	if level < 0 || level >= len(n.connections) {
 		return 0
 	}
+	if n.mapped != nil {
+		return len(n.mapped[level])
+	}
 	return len(n.connections[level])
 }
+
+// mapConnections sets the connections at the specified level to a view of a
+// mapped connection file. It is only used while loading read-only indexes.
+func (n *Node) mapConnections(level int, neighbors []int32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if level < 0 || level >= len(n.connections) {
+		return
+	}
+	if n.mapped == nil {
+		n.mapped = make([][]int32, len(n.connections))
+	}
+	n.mapped[level] = neighbors
+}
+
+// appendMapped appends the neighbor IDs of a mapped connection list to dst.
+func appendMapped(dst []int, neighbors []int32) []int {
+	for _, id := range neighbors {
+		dst = append(dst, int(id))
+	}
+	return dst
+}
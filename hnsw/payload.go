@@ -0,0 +1,208 @@
+package hnsw
+
+import (
+	"fmt"
+	"ollama-demo/lance/arrow"
+	"strings"
+)
+
+// Payload is typed metadata attached to a vector, keyed by field name. Every
+// field must be declared in Config.PayloadSchema, and its value must have the
+// Go type matching the field type: int32, int64 (int is accepted), float32,
+// float64 or string. Fields may be omitted; they are stored as null. Payloads
+// returned in SearchResult are shared with the index and must not be modified.
+type Payload map[string]any
+
+// payloadColumnPrefix 是 nodes.lance 中负载列名的前缀，避免与 id、vector 等列冲突
+const payloadColumnPrefix = "payload."
+
+// AddWithPayload inserts a vector like Add and attaches payload to it, which
+// is returned in SearchResult.Payload and persisted as extra columns in
+// nodes.lance. key may be the zero Key; otherwise it behaves as in AddWithKey.
+func (h *HNSWIndex) AddWithPayload(key Key, vector []float32, payload Payload) (int, error) {
+	checked, err := checkPayload(h.payloadSchema, payload)
+	if err != nil {
+		return -1, err
+	}
+	return h.add(key, Key{}, vector, checked)
+}
+
+// PayloadSchema returns the schema of the payload fields, nil if the index has none.
+func (h *HNSWIndex) PayloadSchema() *arrow.Schema {
+	return h.payloadSchema
+}
+
+// validatePayloadSchema 检查负载Schema只包含列式存储支持的标量类型
+func validatePayloadSchema(schema *arrow.Schema) error {
+	seen := make(map[string]bool, schema.NumFields())
+	for _, field := range schema.Fields() {
+		if field.Name == "" || seen[field.Name] {
+			return fmt.Errorf("%w: payload field names must be unique and non-empty", ErrInvalidParameter)
+		}
+		seen[field.Name] = true
+
+		switch field.Type.ID() {
+		case arrow.INT32, arrow.INT64, arrow.FLOAT32, arrow.FLOAT64, arrow.STRING:
+		default:
+			return fmt.Errorf("%w: payload field %q has unsupported type %s",
+				ErrInvalidParameter, field.Name, field.Type.Name())
+		}
+	}
+	return nil
+}
+
+// checkPayload 按Schema检查负载并返回一份副本，int 统一转换为 int64
+func checkPayload(schema *arrow.Schema, payload Payload) (Payload, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("%w: index has no payload schema", ErrInvalidParameter)
+	}
+
+	checked := make(Payload, len(payload))
+	for name, value := range payload {
+		field, _, ok := schema.FieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: payload field %q is not in the schema", ErrInvalidParameter, name)
+		}
+
+		valid := false
+		switch field.Type.ID() {
+		case arrow.INT32:
+			_, valid = value.(int32)
+		case arrow.INT64:
+			if v, isInt := value.(int); isInt {
+				value = int64(v)
+			}
+			_, valid = value.(int64)
+		case arrow.FLOAT32:
+			_, valid = value.(float32)
+		case arrow.FLOAT64:
+			_, valid = value.(float64)
+		case arrow.STRING:
+			_, valid = value.(string)
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: payload field %q expects %s, got %T",
+				ErrInvalidParameter, name, field.Type.Name(), value)
+		}
+		checked[name] = value
+	}
+	return checked, nil
+}
+
+// appendPayloadColumns 为Schema中的每个负载字段追加一列可空列，没有该字段的节点为 null
+func (h *HNSWIndex) appendPayloadColumns(fields []arrow.Field, columns []arrow.Array, nodes []*Node) ([]arrow.Field, []arrow.Array) {
+	if h.payloadSchema == nil {
+		return fields, columns
+	}
+
+	numNodes := len(nodes)
+	for _, field := range h.payloadSchema.Fields() {
+		valid := arrow.NewBitmap(numNodes)
+		var column arrow.Array
+
+		switch field.Type.ID() {
+		case arrow.INT32:
+			values := make([]int32, numNodes)
+			for i, node := range nodes {
+				if v, ok := node.payload[field.Name].(int32); ok {
+					values[i] = v
+					valid.Set(i)
+				}
+			}
+			column = arrow.NewInt32Array(values, valid)
+		case arrow.INT64:
+			values := make([]int64, numNodes)
+			for i, node := range nodes {
+				if v, ok := node.payload[field.Name].(int64); ok {
+					values[i] = v
+					valid.Set(i)
+				}
+			}
+			column = arrow.NewInt64Array(values, valid)
+		case arrow.FLOAT32:
+			values := make([]float32, numNodes)
+			for i, node := range nodes {
+				if v, ok := node.payload[field.Name].(float32); ok {
+					values[i] = v
+					valid.Set(i)
+				}
+			}
+			column = arrow.NewFloat32Array(values, valid)
+		case arrow.FLOAT64:
+			values := make([]float64, numNodes)
+			for i, node := range nodes {
+				if v, ok := node.payload[field.Name].(float64); ok {
+					values[i] = v
+					valid.Set(i)
+				}
+			}
+			column = arrow.NewFloat64Array(values, valid)
+		case arrow.STRING:
+			values := make([]string, numNodes)
+			for i, node := range nodes {
+				if v, ok := node.payload[field.Name].(string); ok {
+					values[i] = v
+					valid.Set(i)
+				}
+			}
+			column = arrow.NewStringArray(values, valid)
+		}
+
+		fields = append(fields, arrow.NewField(payloadColumnPrefix+field.Name, field.Type, true))
+		columns = append(columns, column)
+	}
+	return fields, columns
+}
+
+// loadPayloads 从带 payload. 前缀的列恢复负载Schema和 nodes 中每个节点的负载，
+// nodes 与 batch 的行一一对应
+func (h *HNSWIndex) loadPayloads(batch *arrow.RecordBatch, nodes []*Node) error {
+	var fields []arrow.Field
+	var arrays []arrow.Array
+	for i, field := range batch.Schema().Fields() {
+		if name, ok := strings.CutPrefix(field.Name, payloadColumnPrefix); ok {
+			fields = append(fields, arrow.NewField(name, field.Type, true))
+			arrays = append(arrays, batch.Column(i))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	schema := arrow.NewSchema(fields, nil)
+	if err := validatePayloadSchema(schema); err != nil {
+		return err
+	}
+
+	for i, node := range nodes {
+		payload := make(Payload)
+		for j, array := range arrays {
+			if array.IsNull(i) {
+				continue
+			}
+			switch a := array.(type) {
+			case *arrow.Int32Array:
+				payload[fields[j].Name] = a.Value(i)
+			case *arrow.Int64Array:
+				payload[fields[j].Name] = a.Value(i)
+			case *arrow.Float32Array:
+				payload[fields[j].Name] = a.Value(i)
+			case *arrow.Float64Array:
+				payload[fields[j].Name] = a.Value(i)
+			case *arrow.StringArray:
+				payload[fields[j].Name] = a.Value(i)
+			default:
+				return fmt.Errorf("payload column %q has unexpected array type %T", fields[j].Name, array)
+			}
+		}
+		if len(payload) > 0 {
+			node.payload = payload
+		}
+	}
+	h.payloadSchema = schema
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Name of the built-in product quantizer, as recorded in saved indexes.
+const QuantizerPQ = "pq"
+
+const (
+	pqMaxCentroids = 256 // One byte per subspace code.
+	pqIterations   = 20  // k-means iterations per subspace.
+)
+
+// ProductQuantizer splits a vector into equal-sized subspaces and replaces each
+// subvector with the index of the nearest of up to 256 centroids learned by
+// k-means, so a vector is stored in one byte per subspace. Distances to a query
+// are looked up in per-query tables (asymmetric distance computation).
+type ProductQuantizer struct {
+	dimension int
+	subspaces int
+	subDim    int
+	centroids int       // Centroids per subspace, 0 until trained.
+	codebook  []float32 // subspaces * centroids * subDim values, subspace-major.
+	seed      int64     // Seed for k-means initialisation.
+}
+
+// NewProductQuantizer creates an untrained product quantizer with the given
+// number of subspaces, which must divide dimension.
+func NewProductQuantizer(dimension int, subspaces int) *ProductQuantizer {
+	if dimension <= 0 {
+		panic("dimension must be positive")
+	}
+	if subspaces <= 0 || dimension%subspaces != 0 {
+		panic("subspaces must be positive and divide dimension")
+	}
+	return &ProductQuantizer{
+		dimension: dimension,
+		subspaces: subspaces,
+		subDim:    dimension / subspaces,
+		seed:      1,
+	}
+}
+
+// newProductQuantizerFromCodebook creates a trained quantizer from saved parameters.
+func newProductQuantizerFromCodebook(subspaces int, subDim int, codebook []float32) (*ProductQuantizer, error) {
+	if subspaces <= 0 || subDim <= 0 || len(codebook) == 0 || len(codebook)%(subspaces*subDim) != 0 {
+		return nil, fmt.Errorf("%w: pq codebook of %d values does not fit %d subspaces of dimension %d",
+			ErrInvalidParameter, len(codebook), subspaces, subDim)
+	}
+	centroids := len(codebook) / (subspaces * subDim)
+	if centroids > pqMaxCentroids {
+		return nil, fmt.Errorf("%w: pq codebook has %d centroids per subspace, at most %d allowed",
+			ErrInvalidParameter, centroids, pqMaxCentroids)
+	}
+
+	q := NewProductQuantizer(subspaces*subDim, subspaces)
+	q.centroids = centroids
+	q.codebook = codebook
+	return q, nil
+}
+
+func (q *ProductQuantizer) Name() string   { return QuantizerPQ }
+func (q *ProductQuantizer) Dimension() int { return q.dimension }
+func (q *ProductQuantizer) Trained() bool  { return q.centroids > 0 }
+
+// Subspaces returns the number of subspaces, which is also the code length in bytes.
+func (q *ProductQuantizer) Subspaces() int { return q.subspaces }
+
+// centroid returns centroid c of subspace m.
+func (q *ProductQuantizer) centroid(m int, c int) []float32 {
+	start := (m*q.centroids + c) * q.subDim
+	return q.codebook[start : start+q.subDim]
+}
+
+// Train learns the codebook of every subspace with k-means over vectors. With
+// fewer than 256 training vectors each subspace gets one centroid per vector.
+func (q *ProductQuantizer) Train(vectors [][]float32) error {
+	if len(vectors) == 0 {
+		return fmt.Errorf("%w: no training vectors", ErrInvalidParameter)
+	}
+	for _, vector := range vectors {
+		if len(vector) != q.dimension {
+			return ErrDimensionMismatch
+		}
+	}
+
+	centroids := min(pqMaxCentroids, len(vectors))
+	codebook := make([]float32, q.subspaces*centroids*q.subDim)
+	rng := rand.New(rand.NewSource(q.seed))
+
+	for m := 0; m < q.subspaces; m++ {
+		points := make([][]float32, len(vectors))
+		for i, vector := range vectors {
+			points[i] = vector[m*q.subDim : (m+1)*q.subDim]
+		}
+		start := m * centroids * q.subDim
+		kmeans(points, codebook[start:start+centroids*q.subDim], centroids, q.subDim, rng)
+	}
+
+	q.centroids = centroids
+	q.codebook = codebook
+	return nil
+}
+
+// kmeans 在 points 上做 Lloyd 迭代，结果按行写入 out（k 个长度为 dim 的中心）
+func kmeans(points [][]float32, out []float32, k int, dim int, rng *rand.Rand) {
+	// 随机选 k 个不同的样本作为初始中心
+	for c, i := range rng.Perm(len(points))[:k] {
+		copy(out[c*dim:(c+1)*dim], points[i])
+	}
+
+	assignment := make([]int, len(points))
+	sums := make([]float32, k*dim)
+	counts := make([]int, k)
+
+	for iter := 0; iter < pqIterations; iter++ {
+		changed := false
+		for i, p := range points {
+			best := nearestCentroid(p, out, k, dim)
+			if best != assignment[i] || iter == 0 {
+				changed = true
+			}
+			assignment[i] = best
+		}
+		if !changed {
+			break
+		}
+
+		clear(sums)
+		clear(counts)
+		for i, p := range points {
+			c := assignment[i]
+			counts[c]++
+			for j, v := range p {
+				sums[c*dim+j] += v
+			}
+		}
+
+		for c := 0; c < k; c++ {
+			centroid := out[c*dim : (c+1)*dim]
+			if counts[c] == 0 {
+				// 空簇重新随机取一个样本，避免浪费编码
+				copy(centroid, points[rng.Intn(len(points))])
+				continue
+			}
+			for j := range centroid {
+				centroid[j] = sums[c*dim+j] / float32(counts[c])
+			}
+		}
+	}
+}
+
+// nearestCentroid 返回 centroids 中离 p 最近（平方 L2）的中心编号
+func nearestCentroid(p []float32, centroids []float32, k int, dim int) int {
+	best, bestDist := 0, float32(math.Inf(1))
+	for c := 0; c < k; c++ {
+		if d := L2Distance(p, centroids[c*dim:(c+1)*dim]); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// Encode replaces every subvector with the index of its nearest centroid.
+func (q *ProductQuantizer) Encode(vector []float32) []byte {
+	code := make([]byte, q.subspaces)
+	for m := range code {
+		start := m * q.centroids * q.subDim
+		sub := vector[m*q.subDim : (m+1)*q.subDim]
+		code[m] = byte(nearestCentroid(sub, q.codebook[start:start+q.centroids*q.subDim], q.centroids, q.subDim))
+	}
+	return code
+}
+
+// Decode reconstructs the approximate vector of code into dst and returns it.
+func (q *ProductQuantizer) Decode(code []byte, dst []float32) []float32 {
+	dst = dst[:0]
+	for m, c := range code {
+		dst = append(dst, q.centroid(m, int(c))...)
+	}
+	return dst
+}
+
+// NewDistancer precomputes a table with the distance from every query
+// subvector to every centroid for the decomposable distances (L2, L2 sqrt and
+// inner product), so each code costs one lookup per subspace. Other distance
+// functions decode the code first.
+func (q *ProductQuantizer) NewDistancer(query []float32, distFunc DistanceFunc, distName string) CodeDistancer {
+	var partial func(a, b []float32) float32
+	sqrt := false
+
+	switch distName {
+	case DistanceL2:
+		partial = L2Distance
+	case DistanceL2Sqrt:
+		partial, sqrt = L2Distance, true
+	case DistanceInnerProduct:
+		partial = InnerProductDistance
+	default:
+		return newDecodingDistancer(q, query, distFunc)
+	}
+
+	table := make([]float32, q.subspaces*q.centroids)
+	for m := 0; m < q.subspaces; m++ {
+		sub := query[m*q.subDim : (m+1)*q.subDim]
+		for c := 0; c < q.centroids; c++ {
+			table[m*q.centroids+c] = partial(sub, q.centroid(m, c))
+		}
+	}
+	return &pqTableDistancer{table: table, centroids: q.centroids, sqrt: sqrt}
+}
+
+// pqTableDistancer 把每个子空间的距离查表相加
+type pqTableDistancer struct {
+	table     []float32
+	centroids int
+	sqrt      bool
+}
+
+func (d *pqTableDistancer) Distance(code []byte) float32 {
+	var sum float32
+	for m, c := range code {
+		sum += d.table[m*d.centroids+int(c)]
+	}
+	if d.sqrt {
+		return float32(math.Sqrt(float64(sum)))
+	}
+	return sum
+}
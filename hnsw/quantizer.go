@@ -0,0 +1,180 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quantizer compresses vectors into compact codes. An index configured with a
+// quantizer traverses the graph on the codes and re-ranks the final candidates
+// with the full-precision vectors, which are kept for re-ranking, Update and
+// persistence.
+type Quantizer interface {
+	// Name identifies the quantizer type in saved indexes.
+	Name() string
+	// Dimension returns the dimensionality of the vectors it encodes.
+	Dimension() int
+	// Train fits the quantizer parameters to a sample of vectors.
+	Train(vectors [][]float32) error
+	// Trained reports whether the parameters have been fitted or loaded.
+	Trained() bool
+	// Encode compresses a vector. The quantizer must be trained.
+	Encode(vector []float32) []byte
+	// Decode reconstructs the approximate vector of code into dst and returns it.
+	Decode(code []byte, dst []float32) []float32
+	// NewDistancer prepares approximate distance computations from query to
+	// encoded vectors, following the semantics of distFunc. distName is the
+	// name distFunc is registered under, as recorded by the index, or "" if it
+	// has none; quantizers use it to pick specialised code distances.
+	NewDistancer(query []float32, distFunc DistanceFunc, distName string) CodeDistancer
+}
+
+// CodeDistancer computes approximate distances from one query to encoded vectors.
+// It is not safe for concurrent use.
+type CodeDistancer interface {
+	Distance(code []byte) float32
+}
+
+// Name of the built-in scalar quantizer, as recorded in saved indexes.
+const QuantizerSQ8 = "sq8"
+
+// ScalarQuantizer maps every dimension linearly onto 256 levels between the
+// per-dimension minimum and maximum seen during training, storing one byte per
+// dimension instead of a float32. Values outside the trained range are clamped.
+type ScalarQuantizer struct {
+	dimension int
+	min       []float32 // Per-dimension minimum.
+	max       []float32 // Per-dimension maximum.
+	step      []float32 // (max - min) / 255, the width of one level.
+}
+
+// NewScalarQuantizer creates an untrained 8-bit scalar quantizer.
+func NewScalarQuantizer(dimension int) *ScalarQuantizer {
+	if dimension <= 0 {
+		panic("dimension must be positive")
+	}
+	return &ScalarQuantizer{dimension: dimension}
+}
+
+// newScalarQuantizerFromRange creates a trained quantizer from saved parameters.
+func newScalarQuantizerFromRange(min, max []float32) (*ScalarQuantizer, error) {
+	if len(min) == 0 || len(min) != len(max) {
+		return nil, fmt.Errorf("%w: sq8 range has %d min and %d max values", ErrInvalidParameter, len(min), len(max))
+	}
+	q := NewScalarQuantizer(len(min))
+	q.setRange(min, max)
+	return q, nil
+}
+
+func (q *ScalarQuantizer) Name() string   { return QuantizerSQ8 }
+func (q *ScalarQuantizer) Dimension() int { return q.dimension }
+func (q *ScalarQuantizer) Trained() bool  { return q.step != nil }
+
+// Train records the per-dimension minimum and maximum of vectors.
+func (q *ScalarQuantizer) Train(vectors [][]float32) error {
+	if len(vectors) == 0 {
+		return fmt.Errorf("%w: no training vectors", ErrInvalidParameter)
+	}
+
+	min := make([]float32, q.dimension)
+	max := make([]float32, q.dimension)
+	for i := range min {
+		min[i] = float32(math.Inf(1))
+		max[i] = float32(math.Inf(-1))
+	}
+
+	for _, vector := range vectors {
+		if len(vector) != q.dimension {
+			return ErrDimensionMismatch
+		}
+		for i, v := range vector {
+			if v < min[i] {
+				min[i] = v
+			}
+			if v > max[i] {
+				max[i] = v
+			}
+		}
+	}
+
+	q.setRange(min, max)
+	return nil
+}
+
+func (q *ScalarQuantizer) setRange(min, max []float32) {
+	q.min = min
+	q.max = max
+	q.step = make([]float32, q.dimension)
+	for i := range q.step {
+		q.step[i] = (max[i] - min[i]) / 255
+	}
+}
+
+// Encode maps every value onto the nearest of the 256 levels of its dimension.
+func (q *ScalarQuantizer) Encode(vector []float32) []byte {
+	code := make([]byte, q.dimension)
+	for i, v := range vector {
+		// 常数维度（max == min）的 step 为 0，统一编码为 0
+		if q.step[i] == 0 {
+			continue
+		}
+		level := math.Round(float64((v - q.min[i]) / q.step[i]))
+		if level < 0 {
+			level = 0
+		} else if level > 255 {
+			level = 255
+		}
+		code[i] = byte(level)
+	}
+	return code
+}
+
+// Decode reconstructs the approximate vector of code into dst and returns it.
+func (q *ScalarQuantizer) Decode(code []byte, dst []float32) []float32 {
+	dst = dst[:0]
+	for i, c := range code {
+		dst = append(dst, q.min[i]+float32(c)*q.step[i])
+	}
+	return dst
+}
+
+// NewDistancer returns a distancer that works directly on the codes for L2
+// and decodes into a reusable buffer for every other distance function.
+func (q *ScalarQuantizer) NewDistancer(query []float32, distFunc DistanceFunc, distName string) CodeDistancer {
+	if distName == DistanceL2 {
+		return &sq8L2Distancer{q: q, query: query}
+	}
+	return newDecodingDistancer(q, query, distFunc)
+}
+
+// sq8L2Distancer 在编码上直接计算平方 L2 距离，不需要先解码
+type sq8L2Distancer struct {
+	q     *ScalarQuantizer
+	query []float32
+}
+
+func (d *sq8L2Distancer) Distance(code []byte) float32 {
+	var sum float32
+	for i, c := range code {
+		diff := d.query[i] - (d.q.min[i] + float32(c)*d.q.step[i])
+		sum += diff * diff
+	}
+	return sum
+}
+
+// decodingDistancer 先把编码解码到复用的缓冲区，再调用任意距离函数
+type decodingDistancer struct {
+	q        Quantizer
+	query    []float32
+	distFunc DistanceFunc
+	buf      []float32
+}
+
+func newDecodingDistancer(q Quantizer, query []float32, distFunc DistanceFunc) *decodingDistancer {
+	return &decodingDistancer{q: q, query: query, distFunc: distFunc, buf: make([]float32, 0, q.Dimension())}
+}
+
+func (d *decodingDistancer) Distance(code []byte) float32 {
+	d.buf = d.q.Decode(code, d.buf)
+	return d.distFunc(d.query, d.buf)
+}
@@ -1,170 +1,332 @@
 package hnsw
 
 import (
-	"container/heap"
 	"sort"
+	"sync"
 )
 
-// 优先队列实现（最小堆）
-type PriorityQueue []*Item
+// candidate 是堆中的元素，按值存放，入堆不需要单独分配
+type candidate struct {
+	id   int     // 节点ID
+	dist float32 // 距离（优先级）
+}
 
-type Item struct {
-	value    int     // 节点ID
-	priority float32 // 距离（优先级）
-	index    int     // 在堆中的索引
+// candidateHeap 是按距离排序的二叉堆，max 为 false 时是最小堆，为 true 时是最大堆。
+// 不走 container/heap，避免每次 Push/Pop 装箱成 interface{} 产生分配
+type candidateHeap struct {
+	items []candidate
+	max   bool
 }
 
-func (pq PriorityQueue) Len() int { return len(pq) }
+func (h *candidateHeap) Len() int { return len(h.items) }
 
-func (pq PriorityQueue) Less(i, j int) bool {
-	return pq[i].priority < pq[j].priority // 最小堆
+func (h *candidateHeap) less(i, j int) bool {
+	if h.max {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
 }
 
-func (pq PriorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+// Top 返回堆顶元素，调用方保证堆非空
+func (h *candidateHeap) Top() candidate {
+	return h.items[0]
 }
 
-func (pq *PriorityQueue) Push(x interface{}) {
-	n := len(*pq)
-	item := x.(*Item)
-	item.index = n
-	*pq = append(*pq, item)
-}
+func (h *candidateHeap) Push(c candidate) {
+	h.items = append(h.items, c)
 
-func (pq *PriorityQueue) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil
-	item.index = -1
-	*pq = old[0 : n-1]
-	return item
+	// 上浮
+	i := len(h.items) - 1
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(i, parent) {
+			break
+		}
+		h.items[i], h.items[parent] = h.items[parent], h.items[i]
+		i = parent
+	}
 }
 
-// 最大堆（用于维护结果集）
-type MaxHeap []*Item
+func (h *candidateHeap) Pop() candidate {
+	top := h.items[0]
+	last := len(h.items) - 1
+	h.items[0] = h.items[last]
+	h.items = h.items[:last]
+
+	// 下沉
+	i := 0
+	for {
+		best := i
+		left, right := 2*i+1, 2*i+2
+		if left < last && h.less(left, best) {
+			best = left
+		}
+		if right < last && h.less(right, best) {
+			best = right
+		}
+		if best == i {
+			break
+		}
+		h.items[i], h.items[best] = h.items[best], h.items[i]
+		i = best
+	}
+	return top
+}
 
-func (h MaxHeap) Len() int { return len(h) }
+func (h *candidateHeap) reset() {
+	h.items = h.items[:0]
+}
 
-func (h MaxHeap) Less(i, j int) bool {
-	return h[i].priority > h[j].priority // 最大堆
+// visitedSet 用代数计数器标记访问过的节点：marks[id] == gen 表示已访问。
+// 清空只需要 gen++，不用像 map 那样逐个删除或重新分配
+type visitedSet struct {
+	marks []uint32
+	gen   uint32
 }
 
-func (h MaxHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-	h[i].index = i
-	h[j].index = j
+// reset 开始新一轮标记，并保证能容纳 n 个节点
+func (v *visitedSet) reset(n int) {
+	if n > len(v.marks) {
+		v.marks = append(v.marks, make([]uint32, n-len(v.marks))...)
+	}
+	v.gen++
+	if v.gen == 0 {
+		// 计数器回绕，旧标记可能与新的 gen 相同，必须真正清空
+		clear(v.marks)
+		v.gen = 1
+	}
 }
 
-func (h *MaxHeap) Push(x interface{}) {
-	n := len(*h)
-	item := x.(*Item)
-	item.index = n
-	*h = append(*h, item)
+// visit 标记 id 为已访问，返回它之前是否已经访问过
+func (v *visitedSet) visit(id int) bool {
+	if v.marks[id] == v.gen {
+		return true
+	}
+	v.marks[id] = v.gen
+	return false
 }
 
-func (h *MaxHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil
-	item.index = -1
-	*h = old[0 : n-1]
-	return item
+// searchScratch 是搜索使用的临时空间。同一个 goroutine 的多次搜索复用它，
+// 避免每次 searchLayer 都重新分配 visited 集合、两个堆和邻居列表。不能并发使用
+type searchScratch struct {
+	visited    visitedSet
+	candidates candidateHeap
+	results    candidateHeap
+	neighbors  []int
+
+	// vec 存放解码后的半精度向量，见 scratchVector
+	vec []float32
+
+	// distancer 不为 nil 时在量化编码上计算近似距离，只在 search 期间设置
+	distancer CodeDistancer
+
+	// distCount 累计距离计算次数，reset 不清零，由 Evaluate 在每个查询前清零
+	distCount int
 }
 
-func (h *MaxHeap) Peek() interface{} {
-	if len(*h) == 0 {
-		return nil
+func newSearchScratch() *searchScratch {
+	return &searchScratch{
+		candidates: candidateHeap{max: false},
+		results:    candidateHeap{max: true},
 	}
-	return (*h)[0]
 }
 
-// search 在索引中搜索 k 个最近邻
-func (h *HNSWIndex) search(query []float32, k int, ef int, ep int, topLevel int) ([]SearchResult, error) {
-	// 阶段1：从顶层到第1层，使用贪心搜索
-	currentNearest := ep
-	for lc := topLevel; lc > 0; lc-- {
-		nearest := h.searchLayer(query, currentNearest, 1, lc)
-		if len(nearest) > 0 {
-			currentNearest = nearest[0].ID
-		}
+// scratchPool 缓存 searchScratch，供没有自带临时空间的单次搜索和插入使用
+var scratchPool = sync.Pool{
+	New: func() any { return newSearchScratch() },
+}
+
+func getSearchScratch() *searchScratch {
+	return scratchPool.Get().(*searchScratch)
+}
+
+func putSearchScratch(s *searchScratch) {
+	scratchPool.Put(s)
+}
+
+// reset 清空上一次搜索留下的状态，保留已分配的容量
+func (s *searchScratch) reset(numNodes int) {
+	s.visited.reset(numNodes)
+	s.candidates.reset()
+	s.results.reset()
+}
+
+// search 在索引中搜索 k 个最近邻，filter 为 nil 时不做过滤，scratch 为 nil 时从 scratchPool 获取
+func (h *HNSWIndex) search(query []float32, k int, ef int, ep int, topLevel int, filter Filter, scratch *searchScratch) ([]SearchResult, error) {
+	query = h.unitQuery(query)
+	if scratch == nil {
+		scratch = getSearchScratch()
+		defer putSearchScratch(scratch)
 	}
 
+	// 配置了量化器时，图遍历在量化编码上进行
+	if h.quantizer != nil {
+		defer h.attachDistancer(scratch, query)()
+	}
+
+	// 阶段1：从顶层到第1层，使用贪心搜索（上层只负责导航，不做过滤）
+	currentNearest := h.descend(query, ep, topLevel, 0, scratch)
+
 	// 阶段2：在第0层使用 ef 进行搜索
-	candidates := h.searchLayer(query, currentNearest, ef, 0)
+	candidates := h.searchLayer(query, currentNearest, ef, 0, filter, scratch)
+
+	// 阶段3：用原始向量对 ef 个候选重新排序，修正量化误差。
+	// 只保留量化编码时没有原始向量，直接返回近似距离
+	if h.quantizer != nil && !h.quantizedOnly {
+		h.rerank(query, candidates, scratch)
+	}
 
 	// 返回前 k 个结果
 	if len(candidates) > k {
-		return candidates[:k], nil
+		candidates = candidates[:k]
 	}
+	h.attachKeys(candidates)
 
 	return candidates, nil
 }
 
-func (h *HNSWIndex) searchLayer(query []float32, ep int, ef int, level int) []SearchResult {
-	visited := make(map[int]bool)
+// searchDistinct 搜索 k 个最近邻，同一文档的向量只保留最近的一个。
+// 过滤或合并文档后不足 k 个时加倍 ef 重试，直到覆盖整个图
+func (h *HNSWIndex) searchDistinct(query []float32, k int, ef int, ep int, topLevel int, numNodes int, filter Filter, scratch *searchScratch) ([]SearchResult, error) {
+	for {
+		results, err := h.search(query, max(k, ef), ef, ep, topLevel, filter, scratch)
+		if err != nil {
+			return nil, err
+		}
+		results = collapseDocuments(results, h.snapshot())
+		if len(results) >= k || ef >= numNodes {
+			if len(results) > k {
+				results = results[:k]
+			}
+			return results, nil
+		}
+		ef = min(ef*2, numNodes)
+	}
+}
+
+// collapseDocuments 按距离升序的结果中每个文档只保留第一个（最近的）向量，不属于文档的节点原样保留
+func collapseDocuments(results []SearchResult, nodes []*Node) []SearchResult {
+	var seen map[Key]bool
+	kept := results[:0]
+	for _, r := range results {
+		if doc := nodes[r.ID].doc; !doc.IsZero() {
+			if seen[doc] {
+				continue
+			}
+			if seen == nil {
+				seen = make(map[Key]bool)
+			}
+			seen[doc] = true
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// attachDistancer 为 scratch 设置 query 的量化距离计算器，返回的函数负责清除它：
+// scratch 可能来自 scratchPool，距离计算器不能留给下一次使用
+func (h *HNSWIndex) attachDistancer(scratch *searchScratch, query []float32) func() {
+	scratch.distancer = h.quantizer.NewDistancer(query, h.distFunc, h.distName)
+	return func() { scratch.distancer = nil }
+}
+
+// rerank 用原始向量（或半精度向量）重新计算候选的距离并排序
+func (h *HNSWIndex) rerank(query []float32, candidates []SearchResult, scratch *searchScratch) {
+	nodes := h.snapshot()
+	for i := range candidates {
+		candidates[i].Distance = h.distFunc(query, h.scratchVector(nodes[candidates[i].ID], scratch))
+		scratch.distCount++
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Distance < candidates[j].Distance
+	})
+}
+
+// distanceTo 计算 query 到节点的距离：scratch 带有量化距离计算器时使用近似距离
+func (h *HNSWIndex) distanceTo(query []float32, node *Node, scratch *searchScratch) float32 {
+	scratch.distCount++
+	if scratch.distancer != nil {
+		return scratch.distancer.Distance(node.codeRef())
+	}
+	return h.distFunc(query, h.scratchVector(node, scratch))
+}
+
+// descend 从 topLevel 贪心下降到 targetLevel+1 层，返回 targetLevel 层的入口点
+func (h *HNSWIndex) descend(query []float32, ep int, topLevel int, targetLevel int, scratch *searchScratch) int {
+	currentNearest := ep
+	for lc := topLevel; lc > targetLevel; lc-- {
+		nearest := h.searchLayer(query, currentNearest, 1, lc, nil, scratch)
+		if len(nearest) > 0 {
+			currentNearest = nearest[0].ID
+		}
+	}
+	return currentNearest
+}
+
+// searchLayer 在单层上做 ef 宽度的最佳优先搜索。
+// 被 filter 拒绝的节点和已删除节点一样：照常遍历，但不会进入结果集
+func (h *HNSWIndex) searchLayer(query []float32, ep int, ef int, level int, filter Filter, scratch *searchScratch) []SearchResult {
+	nodes := h.snapshot()
+	if scratch == nil {
+		scratch = getSearchScratch()
+		defer putSearchScratch(scratch)
+	}
+	scratch.reset(len(nodes))
+	visited := &scratch.visited
 
 	// 候选集，最小堆，按距离从小到大
-	candidates := &PriorityQueue{}
-	heap.Init(candidates)
+	candidates := &scratch.candidates
 
 	// 结果集，最大堆，按距离从大到小
-	results := &MaxHeap{}
-	heap.Init(results)
+	results := &scratch.results
 
 	// 计算入口点距离
-	epDist := h.distFunc(query, h.nodes[ep].Vector())
+	// 已删除（墓碑）节点仍参与图遍历，但不会进入结果集
+	epDist := h.distanceTo(query, nodes[ep], scratch)
 
-	heap.Push(candidates, &Item{value: ep, priority: epDist})
-	heap.Push(results, &Item{value: ep, priority: epDist})
-	visited[ep] = true
+	candidates.Push(candidate{id: ep, dist: epDist})
+	if acceptable(nodes[ep], filter) {
+		results.Push(candidate{id: ep, dist: epDist})
+	}
+	visited.visit(ep)
 
 	for candidates.Len() > 0 {
 		// 取距离最近的候选点
-		current := heap.Pop(candidates).(*Item)
+		current := candidates.Pop()
 
 		// 优化：只在结果集满时检查
-		if results.Len() >= ef {
-			furthest := results.Peek().(*Item)
-			if current.priority > furthest.priority {
-				break
-			}
+		if results.Len() >= ef && current.dist > results.Top().dist {
+			break
 		}
 
-		if current.value < 0 || current.value >= len(h.nodes) {
+		if current.id < 0 || current.id >= len(nodes) {
 			continue // 跳过无效节点
 		}
 
-		// 检查当前节点的所有邻居
-		neighbors := h.nodes[current.value].GetConnections(level)
-
-		for _, neighborID := range neighbors {
-			if visited[neighborID] {
-				continue
-			}
+		// 检查当前节点的所有邻居，复制到复用的缓冲区里
+		scratch.neighbors = nodes[current.id].appendConnections(scratch.neighbors[:0], level)
 
-			if neighborID < 0 || neighborID >= len(h.nodes) {
+		for _, neighborID := range scratch.neighbors {
+			if neighborID < 0 || neighborID >= len(nodes) {
 				continue // 跳过无效邻居
 			}
 
-			visited[neighborID] = true
+			if visited.visit(neighborID) {
+				continue
+			}
 
 			// 计算距离
-			dist := h.distFunc(query, h.nodes[neighborID].Vector())
+			dist := h.distanceTo(query, nodes[neighborID], scratch)
 
 			// 如果结果集未满，或者当前距离更近，添加到候选集
-			if results.Len() < ef {
-				heap.Push(candidates, &Item{value: neighborID, priority: dist})
-				heap.Push(results, &Item{value: neighborID, priority: dist})
-			} else {
-				furthest := results.Peek().(*Item)
-				if dist < furthest.priority {
-					heap.Push(candidates, &Item{value: neighborID, priority: dist})
-					heap.Push(results, &Item{value: neighborID, priority: dist})
-					heap.Pop(results)
+			if results.Len() < ef || dist < results.Top().dist {
+				candidates.Push(candidate{id: neighborID, dist: dist})
+
+				if acceptable(nodes[neighborID], filter) {
+					results.Push(candidate{id: neighborID, dist: dist})
+					if results.Len() > ef {
+						results.Pop()
+					}
 				}
 			}
 		}
@@ -173,74 +335,91 @@ func (h *HNSWIndex) searchLayer(query []float32, ep int, ef int, level int) []Se
 	// 转换为结果数组（从近到远排序）
 	resultArray := make([]SearchResult, results.Len())
 	for i := results.Len() - 1; i >= 0; i-- {
-		item := heap.Pop(results).(*Item)
+		item := results.Pop()
 		resultArray[i] = SearchResult{
-			ID:       item.value,
-			Distance: item.priority,
+			ID:       item.id,
+			Distance: item.dist,
 		}
 	}
 
 	return resultArray
 }
 
-func (h *HNSWIndex) selectNeighborsHeuristic(query []float32, candidates []SearchResult, m int) []SearchResult {
-	if len(candidates) <= m {
-		return candidates
-	}
+// searchLayerRadius 在第0层收集距离 query 不超过 radius 的所有节点。
+// 半径内的候选点总会被展开；半径外的候选点只在 ef 宽度的动态列表内时展开，
+// 用于先走到 query 附近。没有半径内的候选点可展开时停止
+func (h *HNSWIndex) searchLayerRadius(query []float32, ep int, ef int, radius float32, scratch *searchScratch) []SearchResult {
+	nodes := h.snapshot()
+	scratch.reset(len(nodes))
+	visited := &scratch.visited
 
-	result := make([]SearchResult, 0, m)
-	working := make([]SearchResult, len(candidates))
-	copy(working, candidates)
+	// 候选集，最小堆
+	candidates := &scratch.candidates
 
-	sort.Slice(working, func(i, j int) bool {
-		return working[i].Distance < working[j].Distance
-	})
+	// 动态列表，最大堆，只用于判断半径外的候选点是否值得展开
+	beam := &scratch.results
 
-	for _, candidate := range working {
-		if len(result) >= m {
-			break
-		}
+	var results []SearchResult
 
-		good := true
-		candidateVec := h.nodes[candidate.ID].Vector()
+	epDist := h.distanceTo(query, nodes[ep], scratch)
+	candidates.Push(candidate{id: ep, dist: epDist})
+	beam.Push(candidate{id: ep, dist: epDist})
+	visited.visit(ep)
+	if epDist <= radius && acceptable(nodes[ep], nil) {
+		results = append(results, SearchResult{ID: ep, Distance: epDist})
+	}
 
-		// 明确注释启发式逻辑
-		// 拒绝条件：如果候选点更接近已选邻居，而非 query
-		// 目的：保证邻居的多样性和覆盖范围
-		for _, selected := range result {
-			selectedVec := h.nodes[selected.ID].Vector()
-			distToSelected := h.distFunc(candidateVec, selectedVec)
+	for candidates.Len() > 0 {
+		current := candidates.Pop()
 
-			// candidate.Distance 是候选点到 query 的距离
-			if distToSelected < candidate.Distance {
-				good = false
-				break
-			}
+		// 半径内已经没有候选点，且动态列表也无法再改进
+		if current.dist > radius && beam.Len() >= ef && current.dist > beam.Top().dist {
+			break
 		}
 
-		if good {
-			result = append(result, candidate)
-		}
-	}
+		scratch.neighbors = nodes[current.id].appendConnections(scratch.neighbors[:0], 0)
 
-	// 补充逻辑保持不变
-	if len(result) < m {
-		selected := make(map[int]bool, len(result))
-		for _, r := range result {
-			selected[r.ID] = true
-		}
+		for _, neighborID := range scratch.neighbors {
+			if neighborID < 0 || neighborID >= len(nodes) {
+				continue // 跳过无效邻居
+			}
+			if visited.visit(neighborID) {
+				continue
+			}
 
-		for _, candidate := range working {
-			if len(result) >= m {
-				break
+			dist := h.distanceTo(query, nodes[neighborID], scratch)
+
+			if dist <= radius {
+				candidates.Push(candidate{id: neighborID, dist: dist})
+				if acceptable(nodes[neighborID], nil) {
+					results = append(results, SearchResult{ID: neighborID, Distance: dist})
+				}
+			} else if beam.Len() < ef || dist < beam.Top().dist {
+				candidates.Push(candidate{id: neighborID, dist: dist})
 			}
-			if !selected[candidate.ID] {
-				result = append(result, candidate)
+
+			if beam.Len() < ef || dist < beam.Top().dist {
+				beam.Push(candidate{id: neighborID, dist: dist})
+				if beam.Len() > ef {
+					beam.Pop()
+				}
 			}
 		}
 	}
 
-	return result
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+
+	return results
+}
+
+// acceptable 判断节点能否进入结果集：未删除且通过过滤条件
+func acceptable(node *Node, filter Filter) bool {
+	if node.IsDeleted() {
+		return false
+	}
+	return filter == nil || filter.Allow(node.ID())
 }
 
 func min(a, b int) int {
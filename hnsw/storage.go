@@ -1,40 +1,144 @@
 package hnsw
 
 import (
+	"encoding/binary"
 	"fmt"
+	"maps"
 	"ollama-demo/lance/arrow"
 	"ollama-demo/lance/column"
+	"ollama-demo/lance/format"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 // 扁平化存储的Schema定义
 
 // SchemaForNodes 创建节点存储的Schema
 func SchemaForNodes(dimension int) *arrow.Schema {
+	return schemaForNodes(dimension, arrow.VectorType(dimension))
+}
+
+// SchemaForFloat16Nodes 创建以半精度存储向量的节点Schema（Config.Float16Vectors）
+func SchemaForFloat16Nodes(dimension int) *arrow.Schema {
+	return schemaForNodes(dimension, arrow.Float16VectorType(dimension))
+}
+
+// schemaForNodes 创建 vector 列类型为 vectorType 的节点Schema
+func schemaForNodes(dimension int, vectorType arrow.DataType) *arrow.Schema {
 	return arrow.NewSchema([]arrow.Field{
 		arrow.NewField("id", arrow.PrimInt32(), false),
-		arrow.NewField("vector", arrow.VectorType(dimension), false),
+		arrow.NewField("vector", vectorType, false),
 		arrow.NewField("level", arrow.PrimInt32(), false),
+		arrow.NewField("deleted", arrow.PrimInt32(), false), // 墓碑标记：1 表示已删除
 	}, map[string]string{
 		"purpose":   "hnsw_nodes",
 		"dimension": fmt.Sprintf("%d", dimension),
 	})
 }
 
-// SchemaForConnections 创建连接关系存储的Schema
+// SchemaForQuantizedNodes 创建只保留量化编码的节点存储Schema。
+// 列式存储只支持 int32/float32 的定长列表，编码每 4 个字节打包成一个 int32，
+// 原始字节长度记录在Schema元数据中。二值量化的位向量也以这种定长列表存储，每个 int32 装 32 位
+func SchemaForQuantizedNodes(codeLength int) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("id", arrow.PrimInt32(), false),
+		arrow.NewField("code", arrow.FixedSizeListOf(arrow.PrimInt32(), packedCodeWords(codeLength)), false),
+		arrow.NewField("level", arrow.PrimInt32(), false),
+		arrow.NewField("deleted", arrow.PrimInt32(), false),
+	}, map[string]string{
+		"purpose":     "hnsw_nodes",
+		"code_length": strconv.Itoa(codeLength),
+	})
+}
+
+// packedCodeWords 返回 codeLength 字节的编码打包后占用的 int32 个数
+func packedCodeWords(codeLength int) int {
+	return (codeLength + 3) / 4
+}
+
+// packCode 把编码按小端序写入 dst 的 int32 中，末尾不足 4 字节的部分补 0
+func packCode(code []byte, dst []int32) {
+	var word [4]byte
+	for i := range dst {
+		clear(word[:])
+		copy(word[:], code[min(i*4, len(code)):min(i*4+4, len(code))])
+		dst[i] = int32(binary.LittleEndian.Uint32(word[:]))
+	}
+}
+
+// codeColumn 是节点批次中 packCode 打包的 code 列。列数据按小端序存放，
+// 每个节点的编码就是其中连续的 codeLength 个字节，可以不经解包直接切出
+type codeColumn struct {
+	data       []byte
+	stride     int // 每个节点占用的字节数，按 int32 对齐
+	codeLength int
+}
+
+// readCodeColumn 读取 batch 的 code 列，codeLengthValue 是Schema元数据中记录的编码字节数
+func readCodeColumn(batch *arrow.RecordBatch, codeLengthValue string) (*codeColumn, error) {
+	col, ok := batch.ColumnByName("code")
+	if !ok {
+		return nil, fmt.Errorf("nodes column %q missing", "code")
+	}
+	codeLength, err := strconv.Atoi(codeLengthValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid code length: %w", err)
+	}
+	codeListArray, ok := col.(*arrow.FixedSizeListArray)
+	if !ok || packedCodeWords(codeLength) != codeListArray.ListSize() {
+		return nil, fmt.Errorf("nodes column %q does not hold %d byte codes", "code", codeLength)
+	}
+	values, ok := codeListArray.Values().(*arrow.Int32Array)
+	if !ok {
+		return nil, fmt.Errorf("nodes column %q is not an int32 list column", "code")
+	}
+	return &codeColumn{
+		data:       values.Data().Buffers()[0].Bytes(),
+		stride:     codeListArray.ListSize() * 4,
+		codeLength: codeLength,
+	}, nil
+}
+
+// code 返回第 i 个节点的编码。view 为 true 时直接引用列数据（只读索引引用映射的内存），否则复制
+func (c *codeColumn) code(i int, view bool) []byte {
+	start := i * c.stride
+	end := start + c.codeLength
+	if view {
+		return c.data[start:end:end]
+	}
+	return append([]byte(nil), c.data[start:end]...)
+}
+
+// SchemaForProductQuantizer 创建乘积量化码本的Schema：
+// 每一行是一个子空间中心，按子空间依次排列
+func SchemaForProductQuantizer(subspaces int, subDim int) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("centroid", arrow.VectorType(subDim), false),
+	}, map[string]string{
+		"purpose":   "hnsw_quantizer",
+		"quantizer": QuantizerPQ,
+		"subspaces": strconv.Itoa(subspaces),
+	})
+}
+
+// SchemaForConnections 创建连接关系存储的Schema（与 arrow.SchemaForHNSWGraph 的列相同）。
+// 每个节点的每一层一行，按层排序，neighbors 列按 CSR 存储：列表偏移量加扁平的邻居ID。
+// 旧版本每条边一行（node_id, layer, neighbor_id），加载时转换
 func SchemaForConnections() *arrow.Schema {
 	return arrow.NewSchema([]arrow.Field{
 		arrow.NewField("node_id", arrow.PrimInt32(), false),
 		arrow.NewField("layer", arrow.PrimInt32(), false),
-		arrow.NewField("neighbor_id", arrow.PrimInt32(), false),
+		arrow.NewField("neighbors", arrow.ListOf(arrow.PrimInt32()), false),
 	}, map[string]string{
 		"purpose": "hnsw_connections",
+		"layout":  "csr",
 	})
 }
 
 // SchemaForMetadata 创建元数据存储的Schema（使用Int32数组）
-func SchemaForMetadata() *arrow.Schema {
+// 距离函数名写入Schema元数据，加载时通过注册表找回对应的DistanceFunc
+func SchemaForMetadata(distanceName string) *arrow.Schema {
 	return arrow.NewSchema([]arrow.Field{
 		arrow.NewField("M", arrow.PrimInt32(), false),
 		arrow.NewField("Mmax", arrow.PrimInt32(), false),
@@ -44,135 +148,262 @@ func SchemaForMetadata() *arrow.Schema {
 		arrow.NewField("entryPoint", arrow.PrimInt32(), false),
 		arrow.NewField("maxLevel", arrow.PrimInt32(), false),
 		arrow.NewField("numNodes", arrow.PrimInt32(), false),
+		arrow.NewField("seed", arrow.PrimInt64(), false),
+	}, map[string]string{
+		"purpose":    "hnsw_metadata",
+		"distance":   distanceName,
+		"index_type": IndexTypeHNSW,
+	})
+}
+
+// SchemaForBinaryQuantizer 创建二值量化参数的Schema，每一行对应一个维度的阈值
+func SchemaForBinaryQuantizer() *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("threshold", arrow.PrimFloat32(), false),
 	}, map[string]string{
-		"purpose": "hnsw_metadata",
+		"purpose":   "hnsw_quantizer",
+		"quantizer": QuantizerBinary,
 	})
 }
 
+// SchemaForScalarQuantizer 创建标量量化参数的Schema，每一行对应一个维度
+func SchemaForScalarQuantizer() *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("min", arrow.PrimFloat32(), false),
+		arrow.NewField("max", arrow.PrimFloat32(), false),
+	}, map[string]string{
+		"purpose":   "hnsw_quantizer",
+		"quantizer": QuantizerSQ8,
+	})
+}
+
+// indexMetadata 是 metadata.lance 中保存的索引配置
+type indexMetadata struct {
+	M              int
+	Mmax           int
+	Mmax0          int
+	efConstruction int
+	dimension      int
+	entryPoint     int32
+	maxLevel       int32
+	numNodes       int
+	seed           int64
+	distance       string
+	normalize      bool
+	selector       NeighborSelector // nil 表示旧版本文件或自定义策略，使用默认策略
+}
+
 // SaveToLance 将HNSW索引保存到Lance格式文件
+// 文件先写入临时目录，校验和记录到清单后改名提交（见 manifest.go），
+// 保存中途崩溃时加载会拒绝新旧混合的文件。SaveIncremental 追加的片段会被合并
 func (h *HNSWIndex) SaveToLance(baseDir string) error {
+	h.persistMu.Lock()
+	defer h.persistMu.Unlock()
 	h.globalLock.RLock()
 	defer h.globalLock.RUnlock()
 
-	// 保存节点数据
-	if err := h.saveNodes(filepath.Join(baseDir, "nodes.lance")); err != nil {
-		return fmt.Errorf("save nodes failed: %w", err)
+	if err := h.checkSavable(); err != nil {
+		return err
+	}
+
+	// 旧清单损坏时照常完整保存，新清单会替换它
+	old, err := readManifest(baseDir)
+	if err != nil {
+		old = nil
 	}
+	return h.compact(baseDir, old)
+}
 
-	// 保存连接数据
-	if err := h.saveConnections(filepath.Join(baseDir, "connections.lance")); err != nil {
-		return fmt.Errorf("save connections failed: %w", err)
+// checkSavable 在写出任何文件之前检查索引能否被完整保存
+func (h *HNSWIndex) checkSavable() error {
+	if h.closed {
+		return ErrClosed
 	}
 
-	// 保存元数据
-	if err := h.saveMetadata(filepath.Join(baseDir, "metadata.lance")); err != nil {
-		return fmt.Errorf("save metadata failed: %w", err)
+	// 未注册的距离函数无法在加载时还原，提前拒绝
+	if h.distName == "" {
+		return fmt.Errorf("save metadata failed: %w: register it with RegisterDistanceFunc before saving", ErrUnknownDistance)
+	}
+
+	// 量化器参数无法保存时同样提前拒绝，避免写出一半的索引
+	if err := checkQuantizerSavable(h.quantizer); err != nil {
+		return fmt.Errorf("save quantizer failed: %w", err)
 	}
 
 	return nil
 }
 
-// saveNodes 保存所有节点数据
-func (h *HNSWIndex) saveNodes(filename string) error {
-	if len(h.nodes) == 0 {
+// saveNodes 保存 nodes 中的节点数据，完整保存时 nodes 是所有节点，增量保存时是新增或修改过的节点
+func (h *HNSWIndex) saveNodes(filename string, nodes []*Node) error {
+	if len(nodes) == 0 {
 		return fmt.Errorf("no nodes to save")
 	}
 
-	schema := SchemaForNodes(h.dimension)
-
-	// 准备数据数组
-	numNodes := len(h.nodes)
-
-	// ID数组
+	numNodes := len(nodes)
 	ids := make([]int32, numNodes)
-	// Vector数组（扁平化）
-	vectors := make([]float32, numNodes*h.dimension)
-	// Level数组
 	levels := make([]int32, numNodes)
-
-	for i, node := range h.nodes {
+	deleted := make([]int32, numNodes) // 墓碑标记
+	for i, node := range nodes {
 		ids[i] = int32(node.ID())
-
-		// 复制向量数据
-		nodeVector := node.Vector()
-		copy(vectors[i*h.dimension:(i+1)*h.dimension], nodeVector)
-
 		levels[i] = int32(node.Level())
+		if node.IsDeleted() {
+			deleted[i] = 1
+		}
+	}
+
+	// 第二列随索引的存储方式不同：全精度向量、半精度向量或只保留的量化编码
+	schema, data := h.nodeDataColumn(nodes)
+	columns := []arrow.Array{
+		arrow.NewInt32Array(ids, nil),
+		data,
+		arrow.NewInt32Array(levels, nil),
+		arrow.NewInt32Array(deleted, nil),
 	}
 
-	// 创建Arrow数组
-	idArray := arrow.NewInt32Array(ids, nil)
-	vectorArray := arrow.NewFloat32Array(vectors, nil)
-	levelArray := arrow.NewInt32Array(levels, nil)
+	// 有量化编码、外部键或负载时追加对应的列
+	schema, columns = h.appendNodeColumns(schema, columns, nodes)
+	if err := writeRecordBatch(filename, schema, numNodes, columns); err != nil {
+		return fmt.Errorf("write nodes failed: %w", err)
+	}
+	return nil
+}
 
-	// 创建向量的FixedSizeListArray
-	vectorType := arrow.VectorType(h.dimension).(*arrow.FixedSizeListType)
-	vectorListArray := arrow.NewFixedSizeListArray(vectorType, vectorArray, nil)
+// nodeDataColumn 返回节点的Schema和 vector 列，只保留量化编码的索引返回 code 列。
+// 半精度向量不经过 float32 原样写出
+func (h *HNSWIndex) nodeDataColumn(nodes []*Node) (*arrow.Schema, arrow.Array) {
+	dim := h.dimension
+	switch {
+	case h.quantizedOnly:
+		codeLength := len(nodes[0].codeRef())
+		return SchemaForQuantizedNodes(codeLength), packedCodeColumn(nodes, codeLength)
+
+	case h.float16:
+		vectors := make([]arrow.Float16, len(nodes)*dim)
+		for i, node := range nodes {
+			copy(vectors[i*dim:(i+1)*dim], node.halfRef())
+		}
+		vectorType := arrow.Float16VectorType(dim).(*arrow.FixedSizeListType)
+		return SchemaForFloat16Nodes(dim), arrow.NewFixedSizeListArray(vectorType, arrow.NewFloat16Array(vectors, nil), nil)
 
-	// 创建RecordBatch
-	batch, err := arrow.NewRecordBatch(schema, numNodes, []arrow.Array{
-		idArray,
-		vectorListArray,
-		levelArray,
-	})
-	if err != nil {
-		return fmt.Errorf("create record batch failed: %w", err)
+	default:
+		vectors := make([]float32, len(nodes)*dim)
+		for i, node := range nodes {
+			copy(vectors[i*dim:(i+1)*dim], node.Vector())
+		}
+		vectorType := arrow.VectorType(dim).(*arrow.FixedSizeListType)
+		return SchemaForNodes(dim), arrow.NewFixedSizeListArray(vectorType, arrow.NewFloat32Array(vectors, nil), nil)
 	}
+}
 
-	// 写入文件
-	writer, err := column.NewWriter(filename, schema, column.DefaultSerializationOptions())
-	if err != nil {
-		return fmt.Errorf("create writer failed: %w", err)
+// packedCodeColumn 把 nodes 的 codeLength 字节编码用 packCode 打包成 int32 定长列表列
+func packedCodeColumn(nodes []*Node, codeLength int) arrow.Array {
+	words := packedCodeWords(codeLength)
+	codes := make([]int32, len(nodes)*words)
+	for i, node := range nodes {
+		packCode(node.codeRef(), codes[i*words:(i+1)*words])
 	}
-	defer writer.Close()
+	codeType := arrow.FixedSizeListOf(arrow.PrimInt32(), words).(*arrow.FixedSizeListType)
+	return arrow.NewFixedSizeListArray(codeType, arrow.NewInt32Array(codes, nil), nil)
+}
 
-	if err := writer.WriteRecordBatch(batch); err != nil {
-		return fmt.Errorf("write nodes failed: %w", err)
+// appendNodeColumns 在节点Schema后追加量化编码列、外部键列、文档键列和负载列
+func (h *HNSWIndex) appendNodeColumns(schema *arrow.Schema, columns []arrow.Array, nodes []*Node) (*arrow.Schema, []arrow.Array) {
+	fields := append([]arrow.Field(nil), schema.Fields()...)
+	metadata := schema.Metadata()
+
+	// 保留原始向量的索引同样保存量化编码，加载时不必重新编码，只读加载时直接引用映射的编码
+	if h.quantizer != nil && !h.quantizedOnly {
+		codeLength := len(nodes[0].codeRef())
+		codes := packedCodeColumn(nodes, codeLength)
+		fields = append(fields, arrow.NewField("code", codes.DataType(), false))
+		columns = append(columns, codes)
+		metadata = maps.Clone(metadata)
+		metadata["code_length"] = strconv.Itoa(codeLength)
 	}
 
-	return nil
+	fields, columns = appendKeyColumns(fields, columns, nodes, "key", func(n *Node) Key { return n.key })
+	fields, columns = appendKeyColumns(fields, columns, nodes, "doc", func(n *Node) Key { return n.doc })
+	fields, columns = h.appendPayloadColumns(fields, columns, nodes)
+
+	if len(fields) == schema.NumFields() {
+		return schema, columns
+	}
+	return arrow.NewSchema(fields, metadata), columns
 }
 
-// 修改 saveConnections 函数，处理空连接的情况
-func (h *HNSWIndex) saveConnections(filename string) error {
-	schema := SchemaForConnections()
+// appendKeyColumns 追加 keyOf 取出的键：int64 键写入 <prefix>_int，字符串键写入 <prefix>_str，
+// 没有对应类型键的节点为 null，nodes 中没有该类型的键时不写对应的列
+func appendKeyColumns(fields []arrow.Field, columns []arrow.Array, nodes []*Node, prefix string, keyOf func(*Node) Key) ([]arrow.Field, []arrow.Array) {
+	numNodes := len(nodes)
+	intKeys := make([]int64, numNodes)
+	stringKeys := make([]string, numNodes)
+	intValid := arrow.NewBitmap(numNodes)
+	stringValid := arrow.NewBitmap(numNodes)
+	hasInt, hasString := false, false
+
+	for i, node := range nodes {
+		key := keyOf(node)
+		if v, ok := key.Int(); ok {
+			intKeys[i] = v
+			intValid.Set(i)
+			hasInt = true
+		} else if v, ok := key.Str(); ok {
+			stringKeys[i] = v
+			stringValid.Set(i)
+			hasString = true
+		}
+	}
 
-	// 收集所有连接关系
-	var nodeIDs, layers, neighborIDs []int32
+	if hasInt {
+		fields = append(fields, arrow.NewField(prefix+"_int", arrow.PrimInt64(), true))
+		columns = append(columns, arrow.NewInt64Array(intKeys, intValid))
+	}
+	if hasString {
+		fields = append(fields, arrow.NewField(prefix+"_str", arrow.PrimString(), true))
+		columns = append(columns, arrow.NewStringArray(stringKeys, stringValid))
+	}
+	return fields, columns
+}
 
-	for _, node := range h.nodes {
-		nodeID := int32(node.ID())
+// saveConnections 保存 nodes 的连接表，每个节点的每一层一行（包括空表），
+// 加载增量片段时据此替换旧片段中该层的连接
+func (h *HNSWIndex) saveConnections(filename string, nodes []*Node) error {
+	schema := SchemaForConnections()
 
-		// 遍历该节点的所有层级
-		for layer := 0; layer <= node.Level(); layer++ {
-			connections := node.GetConnections(layer)
+	maxLevel := 0
+	for _, node := range nodes {
+		maxLevel = max(maxLevel, node.Level())
+	}
 
-			// 添加该层的所有连接
-			for _, neighborID := range connections {
-				nodeIDs = append(nodeIDs, nodeID)
-				layers = append(layers, int32(layer))
-				neighborIDs = append(neighborIDs, int32(neighborID))
+	// 按层收集连接表，同一层的行连续存放
+	var nodeIDs, layers, neighbors []int32
+	offsets := []int32{0}
+	var scratch []int
+	for layer := 0; layer <= maxLevel; layer++ {
+		for _, node := range nodes {
+			if node.Level() < layer {
+				continue
+			}
+			scratch = node.appendConnections(scratch[:0], layer)
+			for _, neighborID := range scratch {
+				neighbors = append(neighbors, int32(neighborID))
 			}
+			nodeIDs = append(nodeIDs, int32(node.ID()))
+			layers = append(layers, int32(layer))
+			offsets = append(offsets, int32(len(neighbors)))
 		}
 	}
 
-	// 如果没有连接关系，不创建文件（避免空数组验证错误）
+	// 如果没有节点，不创建文件（避免空数组验证错误），加载时检测文件是否存在
 	if len(nodeIDs) == 0 {
-		// 创建一个空文件标记，或者直接返回
-		// 在加载时需要检测文件是否存在
 		return nil
 	}
 
-	// 创建Arrow数组
-	nodeIDArray := arrow.NewInt32Array(nodeIDs, nil)
-	layerArray := arrow.NewInt32Array(layers, nil)
-	neighborIDArray := arrow.NewInt32Array(neighborIDs, nil)
-
-	// 创建RecordBatch
+	listType := schema.Field(2).Type.(*arrow.ListType)
 	batch, err := arrow.NewRecordBatch(schema, len(nodeIDs), []arrow.Array{
-		nodeIDArray,
-		layerArray,
-		neighborIDArray,
+		arrow.NewInt32Array(nodeIDs, nil),
+		arrow.NewInt32Array(layers, nil),
+		arrow.NewListArray(listType, offsets, arrow.NewInt32Array(neighbors, nil), nil),
 	})
 	if err != nil {
 		return fmt.Errorf("create record batch failed: %w", err)
@@ -194,40 +425,31 @@ func (h *HNSWIndex) saveConnections(filename string) error {
 
 // saveMetadata 保存HNSW配置元数据
 func (h *HNSWIndex) saveMetadata(filename string) error {
-	schema := SchemaForMetadata()
-
-	// 准备元数据（单行记录）
-	metadata := []int32{
-		int32(h.M),
-		int32(h.Mmax),
-		int32(h.Mmax0),
-		int32(h.efConstruction),
-		int32(h.dimension),
-		h.entryPoint,
-		h.maxLevel,
-		int32(len(h.nodes)),
-	}
-
-	// 创建Arrow数组（每个字段都是长度为1的数组）
-	mArray := arrow.NewInt32Array([]int32{metadata[0]}, nil)
-	mmaxArray := arrow.NewInt32Array([]int32{metadata[1]}, nil)
-	mmax0Array := arrow.NewInt32Array([]int32{metadata[2]}, nil)
-	efConstructionArray := arrow.NewInt32Array([]int32{metadata[3]}, nil)
-	dimensionArray := arrow.NewInt32Array([]int32{metadata[4]}, nil)
-	entryPointArray := arrow.NewInt32Array([]int32{metadata[5]}, nil)
-	maxLevelArray := arrow.NewInt32Array([]int32{metadata[6]}, nil)
-	numNodesArray := arrow.NewInt32Array([]int32{metadata[7]}, nil)
+	schema := SchemaForMetadata(h.distName)
+	if h.normalize {
+		schema.Metadata()["normalize"] = "true"
+	}
+	// 只保存内置的邻居选择策略，自定义策略加载后使用默认策略
+	if spec := selectorSpec(h.selector); spec != "" {
+		schema.Metadata()["neighbor_selector"] = spec
+	}
+
+	// 准备元数据（单行记录），每个字段都是长度为1的数组
+	int32Column := func(v int32) arrow.Array {
+		return arrow.NewInt32Array([]int32{v}, nil)
+	}
 
 	// 创建RecordBatch
 	batch, err := arrow.NewRecordBatch(schema, 1, []arrow.Array{
-		mArray,
-		mmaxArray,
-		mmax0Array,
-		efConstructionArray,
-		dimensionArray,
-		entryPointArray,
-		maxLevelArray,
-		numNodesArray,
+		int32Column(int32(h.M)),
+		int32Column(int32(h.Mmax)),
+		int32Column(int32(h.Mmax0)),
+		int32Column(int32(h.efConstruction)),
+		int32Column(int32(h.dimension)),
+		int32Column(h.entryPoint),
+		int32Column(h.maxLevel),
+		int32Column(int32(len(h.nodes))),
+		arrow.NewInt64Array([]int64{h.seed}, nil),
 	})
 	if err != nil {
 		return fmt.Errorf("create record batch failed: %w", err)
@@ -247,43 +469,387 @@ func (h *HNSWIndex) saveMetadata(filename string) error {
 	return nil
 }
 
+// checkQuantizerSavable 检查量化器参数是否有对应的存储格式
+func checkQuantizerSavable(q Quantizer) error {
+	switch q.(type) {
+	case nil, *ScalarQuantizer, *ProductQuantizer, *BinaryQuantizer:
+		return nil
+	default:
+		return fmt.Errorf("%w: quantizer %q has no storage format", ErrInvalidParameter, q.Name())
+	}
+}
+
+// saveQuantizer 保存量化参数，没有量化器时删除旧的参数文件
+func (h *HNSWIndex) saveQuantizer(filename string) error {
+	var schema *arrow.Schema
+	var batch *arrow.RecordBatch
+	var err error
+
+	switch q := h.quantizer.(type) {
+	case nil:
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove stale quantizer file failed: %w", err)
+		}
+		return nil
+	case *ScalarQuantizer:
+		schema = SchemaForScalarQuantizer()
+		batch, err = arrow.NewRecordBatch(schema, q.dimension, []arrow.Array{
+			arrow.NewFloat32Array(q.min, nil),
+			arrow.NewFloat32Array(q.max, nil),
+		})
+	case *ProductQuantizer:
+		schema = SchemaForProductQuantizer(q.subspaces, q.subDim)
+		centroidType := arrow.VectorType(q.subDim).(*arrow.FixedSizeListType)
+		batch, err = arrow.NewRecordBatch(schema, q.subspaces*q.centroids, []arrow.Array{
+			arrow.NewFixedSizeListArray(centroidType, arrow.NewFloat32Array(q.codebook, nil), nil),
+		})
+	case *BinaryQuantizer:
+		schema = SchemaForBinaryQuantizer()
+		batch, err = arrow.NewRecordBatch(schema, q.dimension, []arrow.Array{
+			arrow.NewFloat32Array(q.thresholds, nil),
+		})
+	default:
+		return checkQuantizerSavable(q)
+	}
+	if err != nil {
+		return fmt.Errorf("create record batch failed: %w", err)
+	}
+
+	writer, err := column.NewWriter(filename, schema, column.DefaultSerializationOptions())
+	if err != nil {
+		return fmt.Errorf("create writer failed: %w", err)
+	}
+	defer writer.Close()
+
+	if err := writer.WriteRecordBatch(batch); err != nil {
+		return fmt.Errorf("write quantizer failed: %w", err)
+	}
+
+	return nil
+}
+
+// loadQuantizer 按Schema元数据中的量化器类型恢复量化参数
+func loadQuantizer(filename string) (Quantizer, error) {
+	reader, err := column.NewReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("create reader failed: %w", err)
+	}
+	defer reader.Close()
+
+	batch, err := reader.ReadRecordBatch()
+	if err != nil {
+		return nil, fmt.Errorf("read quantizer failed: %w", err)
+	}
+
+	float32Column := func(name string) ([]float32, error) {
+		col, ok := batch.ColumnByName(name)
+		if !ok {
+			return nil, fmt.Errorf("quantizer column %q missing", name)
+		}
+		array, ok := col.(*arrow.Float32Array)
+		if !ok {
+			return nil, fmt.Errorf("quantizer column %q is not a float32 column", name)
+		}
+		values := make([]float32, array.Len())
+		copy(values, array.Values())
+		return values, nil
+	}
+
+	switch name := reader.Schema().Metadata()["quantizer"]; name {
+	case QuantizerSQ8:
+		min, err := float32Column("min")
+		if err != nil {
+			return nil, err
+		}
+		max, err := float32Column("max")
+		if err != nil {
+			return nil, err
+		}
+		return newScalarQuantizerFromRange(min, max)
+	case QuantizerPQ:
+		subspaces, err := strconv.Atoi(reader.Schema().Metadata()["subspaces"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pq subspaces: %w", err)
+		}
+		col, ok := batch.ColumnByName("centroid")
+		if !ok {
+			return nil, fmt.Errorf("quantizer column %q missing", "centroid")
+		}
+		centroids, ok := col.(*arrow.FixedSizeListArray)
+		if !ok {
+			return nil, fmt.Errorf("quantizer column %q is not a fixed size list column", "centroid")
+		}
+		values, ok := centroids.Values().(*arrow.Float32Array)
+		if !ok {
+			return nil, fmt.Errorf("quantizer column %q is not a float32 list", "centroid")
+		}
+		codebook := make([]float32, values.Len())
+		copy(codebook, values.Values())
+		return newProductQuantizerFromCodebook(subspaces, centroids.ListSize(), codebook)
+	case QuantizerBinary:
+		thresholds, err := float32Column("threshold")
+		if err != nil {
+			return nil, err
+		}
+		return newBinaryQuantizerFromThresholds(thresholds)
+	default:
+		return nil, fmt.Errorf("%w: unknown quantizer %q", ErrInvalidParameter, name)
+	}
+}
+
 // LoadFromLance 从Lance格式文件加载HNSW索引
 func LoadHNSWFromLance(baseDir string) (*HNSWIndex, error) {
+	return loadHNSW(baseDir, false)
+}
+
+// LoadHNSWReadOnly opens the index saved in baseDir without copying it onto the
+// heap: the node and connection files are memory-mapped, and vectors and
+// adjacency lists are read in place through the page cache, which processes
+// opening the same index share. Opening reads only the metadata: the node and
+// connection files must exist, but unlike LoadHNSWFromLance their checksums
+// are not verified, since that would read the whole index. Searches work as on
+// a loaded index; Add, AddBatch, Delete, Update and Load return ErrReadOnly.
+// Call Close to release the mappings once the index is no longer used.
+func LoadHNSWReadOnly(baseDir string) (*HNSWIndex, error) {
+	return loadHNSW(baseDir, true)
+}
+
+// loadHNSW 加载 baseDir 中的索引，readOnly 时映射节点和连接文件而不是复制
+func loadHNSW(baseDir string, readOnly bool) (*HNSWIndex, error) {
+	// 有清单时先校验其中记录的文件，文件集合与清单不一致时拒绝加载；只读加载不读取片段文件的内容。
+	// 没有清单的是旧版本保存的目录，按文件名直接加载
+	manifest, err := readManifest(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest failed: %w", err)
+	}
+	if manifest != nil {
+		if err := verifyChecksums(baseDir, manifest, !readOnly); err != nil {
+			return nil, err
+		}
+	}
+
 	// 加载元数据，确定HNSW配置
-	metadata, err := loadMetadata(filepath.Join(baseDir, "metadata.lance"))
+	metadata, err := loadMetadata(filepath.Join(baseDir, manifestFileName(manifest, metadataFileKey, "metadata.lance")))
 	if err != nil {
 		return nil, fmt.Errorf("load metadata failed: %w", err)
 	}
 
+	distFunc, err := LookupDistanceFunc(metadata.distance)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+
+	// 加载量化参数（可选），量化器必须在创建实例前确定
+	var quantizer Quantizer
+	quantizerName := manifestFileName(manifest, quantizerFileKey, "quantizer.lance")
+	quantizerFile := filepath.Join(baseDir, quantizerName)
+	_, statErr := os.Stat(quantizerFile)
+	hasQuantizer := statErr == nil
+	if manifest != nil {
+		hasQuantizer = hasChecksum(manifest, quantizerName)
+	}
+	if hasQuantizer {
+		if quantizer, err = loadQuantizer(quantizerFile); err != nil {
+			return nil, fmt.Errorf("load quantizer failed: %w", err)
+		}
+		if quantizer.Dimension() != metadata.dimension {
+			return nil, fmt.Errorf("load quantizer failed: %w: quantizer dimension %d, index dimension %d",
+				ErrDimensionMismatch, quantizer.Dimension(), metadata.dimension)
+		}
+	}
+
 	// 创建HNSW实例
 	config := Config{
-		M:              int(metadata[0]),
-		EfConstruction: int(metadata[3]),
-		Dimension:      int(metadata[4]),
-		DistanceFunc:   L2Distance,
+		M:                metadata.M,
+		EfConstruction:   metadata.efConstruction,
+		Dimension:        metadata.dimension,
+		DistanceFunc:     distFunc,
+		DistanceName:     metadata.distance,
+		Seed:             metadata.seed,
+		Quantizer:        quantizer,
+		Normalize:        metadata.normalize,
+		NeighborSelector: metadata.selector,
 	}
 
 	hnsw := NewHNSW(config)
 
 	// 设置从元数据加载的状态
-	hnsw.entryPoint = metadata[5]
-	hnsw.maxLevel = metadata[6]
+	if metadata.Mmax > 0 {
+		hnsw.Mmax = metadata.Mmax
+	}
+	if metadata.Mmax0 > 0 {
+		hnsw.Mmax0 = metadata.Mmax0
+	}
+	hnsw.entryPoint = metadata.entryPoint
+	hnsw.maxLevel = metadata.maxLevel
+	hnsw.readOnly = readOnly
+
+	if err := hnsw.loadGraph(baseDir, manifest); err != nil {
+		hnsw.Close()
+		return nil, err
+	}
+	return hnsw, nil
+}
+
+// loadGraph 加载节点和连接：有清单时按清单加载片段，否则加载旧版本写出的完整文件
+func (h *HNSWIndex) loadGraph(baseDir string, manifest *format.Manifest) error {
+	if manifest != nil {
+		return h.loadFragments(baseDir, manifest)
+	}
 
 	// 加载节点数据
-	if err := hnsw.loadNodes(filepath.Join(baseDir, "nodes.lance")); err != nil {
-		return nil, fmt.Errorf("load nodes failed: %w", err)
+	if err := h.loadNodes(filepath.Join(baseDir, "nodes.lance")); err != nil {
+		return fmt.Errorf("load nodes failed: %w", err)
+	}
+	if err := h.indexNodes(); err != nil {
+		return fmt.Errorf("load nodes failed: %w", err)
 	}
 
 	// 加载连接数据
-	if err := hnsw.loadConnections(filepath.Join(baseDir, "connections.lance")); err != nil {
-		return nil, fmt.Errorf("load connections failed: %w", err)
+	if err := h.loadConnections(filepath.Join(baseDir, "connections.lance")); err != nil {
+		return fmt.Errorf("load connections failed: %w", err)
 	}
 
-	return hnsw, nil
+	return nil
+}
+
+// Close releases the memory mappings of an index opened with LoadHNSWReadOnly.
+// Searches and saves started after Close return ErrClosed and the index
+// appears empty to every other method; Close must not be called while
+// searches are still running. Close does nothing for other indexes.
+func (h *HNSWIndex) Close() error {
+	h.globalLock.Lock()
+	defer h.globalLock.Unlock()
+
+	if !h.readOnly || h.closed {
+		return nil
+	}
+
+	// 映射释放后节点的向量和连接表都不能再访问，清空节点和键，按 ID 或键的访问报告节点不存在
+	h.closed = true
+	h.nodes = nil
+	h.keys = make(map[Key]int)
+	h.docs = make(map[Key][]int)
+	h.entryPoint = -1
+	h.maxLevel = -1
+	h.numDeleted = 0
+	h.publishNodes()
+
+	var firstErr error
+	for _, reader := range h.mappings {
+		if err := reader.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	h.mappings = nil
+	return firstErr
+}
+
+// openReader 打开 Lance 文件。只读索引映射文件，读出的数组直接引用映射的内存，
+// 映射保留到 Close 时释放
+func (h *HNSWIndex) openReader(filename string) (*column.Reader, error) {
+	if !h.readOnly {
+		return column.NewReader(filename)
+	}
+	reader, err := column.NewMappedReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	h.mappings = append(h.mappings, reader)
+	return reader, nil
+}
+
+// closeReader 关闭 openReader 打开的文件，只读索引的映射保留到 Close
+func (h *HNSWIndex) closeReader(reader *column.Reader) {
+	if !h.readOnly {
+		reader.Close()
+	}
+}
+
+// Save implements VectorIndex by calling SaveToLance.
+func (h *HNSWIndex) Save(baseDir string) error {
+	return h.SaveToLance(baseDir)
+}
+
+// Load implements VectorIndex: it loads the index saved in baseDir with
+// LoadHNSWFromLance and replaces the contents of h with it.
+func (h *HNSWIndex) Load(baseDir string) error {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	loaded, err := LoadHNSWFromLance(baseDir)
+	if err != nil {
+		return err
+	}
+
+	// persistMu 必须在 globalLock 之前获取，与 SaveToLance 和 SaveIncremental 的顺序一致
+	h.persistMu.Lock()
+	defer h.persistMu.Unlock()
+	h.globalLock.Lock()
+	defer h.globalLock.Unlock()
+
+	// MaxFragments 是调用方的配置，不随保存的索引变化
+	maxFragments := h.maxFragments
+	h.mu.Lock()
+	h.indexState = loaded.indexState
+	h.mu.Unlock()
+	h.maxFragments = maxFragments
+	h.publishNodes()
+
+	return nil
+}
+
+// UpgradeStorage rewrites the HNSW index saved in baseDir in the current file
+// layout if any of its connection files still stores one row per edge, as
+// written by earlier versions, and reports whether it did. Both layouts load;
+// upgrading shrinks the connection files to roughly a third and lets
+// LoadHNSWReadOnly reference adjacency lists in place instead of converting
+// them on open.
+func UpgradeStorage(baseDir string) (bool, error) {
+	manifest, err := readManifest(baseDir)
+	if err != nil {
+		return false, fmt.Errorf("load manifest failed: %w", err)
+	}
+	files := []string{"connections.lance"}
+	if manifest != nil {
+		files = manifest.IndexFiles
+	}
+
+	legacy := false
+	for _, name := range files {
+		filename := filepath.Join(baseDir, name)
+		if _, err := os.Stat(filename); os.IsNotExist(err) && manifest == nil {
+			continue
+		}
+		reader, err := column.NewReader(filename)
+		if err != nil {
+			return false, fmt.Errorf("open %s failed: %w", name, err)
+		}
+		_, _, ok := reader.Schema().FieldByName("neighbors")
+		reader.Close()
+		if !ok {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return false, nil
+	}
+
+	index, err := LoadHNSWFromLance(baseDir)
+	if err != nil {
+		return false, err
+	}
+	if err := index.SaveToLance(baseDir); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // loadMetadata 加载元数据
-func loadMetadata(filename string) ([]int32, error) {
+// 旧版本文件没有 seed 列和 distance 元数据，分别按 0 和 L2 处理（旧版加载时总是使用L2）
+func loadMetadata(filename string) (*indexMetadata, error) {
 	reader, err := column.NewReader(filename)
 	if err != nil {
 		return nil, fmt.Errorf("create reader failed: %w", err)
@@ -295,63 +861,295 @@ func loadMetadata(filename string) ([]int32, error) {
 		return nil, fmt.Errorf("read metadata failed: %w", err)
 	}
 
-	// 提取所有元数据值
-	metadata := make([]int32, 8)
-	for i := 0; i < 8; i++ {
-		array := batch.Column(i).(*arrow.Int32Array)
-		metadata[i] = array.Value(0)
+	// 按列名读取，兼容新旧两种Schema
+	int32Value := func(name string) (int32, error) {
+		col, ok := batch.ColumnByName(name)
+		if !ok {
+			return 0, fmt.Errorf("metadata column %q missing", name)
+		}
+		array, ok := col.(*arrow.Int32Array)
+		if !ok || array.Len() == 0 {
+			return 0, fmt.Errorf("metadata column %q is not a non-empty int32 column", name)
+		}
+		return array.Value(0), nil
+	}
+
+	names := []string{"M", "Mmax", "Mmax0", "efConstruction", "dimension", "entryPoint", "maxLevel", "numNodes"}
+	values := make([]int32, len(names))
+	for i, name := range names {
+		if values[i], err = int32Value(name); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := &indexMetadata{
+		M:              int(values[0]),
+		Mmax:           int(values[1]),
+		Mmax0:          int(values[2]),
+		efConstruction: int(values[3]),
+		dimension:      int(values[4]),
+		entryPoint:     values[5],
+		maxLevel:       values[6],
+		numNodes:       int(values[7]),
+		distance:       reader.Schema().Metadata()["distance"],
+		normalize:      reader.Schema().Metadata()["normalize"] == "true",
+	}
+
+	if col, ok := batch.ColumnByName("seed"); ok {
+		if array, ok := col.(*arrow.Int64Array); ok && array.Len() > 0 {
+			metadata.seed = array.Value(0)
+		}
+	}
+	if metadata.distance == "" {
+		metadata.distance = DistanceL2
+	}
+	if spec := reader.Schema().Metadata()["neighbor_selector"]; spec != "" {
+		if metadata.selector, err = parseSelectorSpec(spec); err != nil {
+			return nil, err
+		}
 	}
 
 	return metadata, nil
 }
 
-// loadNodes 加载节点数据 - 修复版
+// loadNodes 加载一个节点文件并按 ID 放入 h.nodes：ID 等于当前节点数的节点追加到末尾，
+// 更小的 ID 替换已有节点（增量片段中被删除或更新过的节点）并沿用其连接。
+// 所有节点文件加载完后调用 indexNodes
 func (h *HNSWIndex) loadNodes(filename string) error {
-	reader, err := column.NewReader(filename)
+	reader, err := h.openReader(filename)
 	if err != nil {
 		return fmt.Errorf("create reader failed: %w", err)
 	}
-	defer reader.Close()
+	defer h.closeReader(reader)
 
 	batch, err := reader.ReadRecordBatch()
 	if err != nil {
 		return fmt.Errorf("read nodes failed: %w", err)
 	}
 
+	// 只保留量化编码的索引没有 vector 列
+	var nodes []*Node
+	codeLength := reader.Schema().Metadata()["code_length"]
+	if _, ok := batch.ColumnByName("vector"); !ok {
+		nodes, err = h.decodeQuantizedNodes(batch, codeLength)
+	} else {
+		nodes, err = h.decodeNodes(batch, codeLength)
+	}
+	if err != nil {
+		return err
+	}
+	if err := loadKeys(batch, nodes); err != nil {
+		return err
+	}
+	if err := h.loadPayloads(batch, nodes); err != nil {
+		return err
+	}
+
+	// 同一文件中的节点ID严格递增，且新节点的ID必须连续
+	for i, node := range nodes {
+		id := node.ID()
+		if i > 0 && id <= nodes[i-1].ID() {
+			return fmt.Errorf("node ID mismatch at index %d: %d does not follow %d", i, id, nodes[i-1].ID())
+		}
+		switch {
+		case id == len(h.nodes):
+			h.nodes = append(h.nodes, node)
+		case id >= 0 && id < len(h.nodes):
+			node.connections = h.nodes[id].connections
+			node.mapped = h.nodes[id].mapped
+			h.nodes[id] = node
+		default:
+			return fmt.Errorf("node ID mismatch at index %d: expected at most %d, got %d", i, len(h.nodes), id)
+		}
+	}
+
+	return nil
+}
+
+// decodeNodes 从节点批次中恢复带原始向量的节点，半精度的 vector 列说明索引以 Float16Vectors 创建。
+// 有量化器的索引同时保存了 code 列，直接使用保存的编码，旧文件中没有时重新编码
+func (h *HNSWIndex) decodeNodes(batch *arrow.RecordBatch, codeLengthValue string) ([]*Node, error) {
 	idArray := batch.Column(0).(*arrow.Int32Array)
 	vectorListArray := batch.Column(1).(*arrow.FixedSizeListArray)
 	levelArray := batch.Column(2).(*arrow.Int32Array)
 
+	// 墓碑列是后加的，旧文件中没有
+	var deletedArray *arrow.Int32Array
+	if col, ok := batch.ColumnByName("deleted"); ok {
+		deletedArray, _ = col.(*arrow.Int32Array)
+	}
+
 	// 获取底层的float数组
-	vectorArray := vectorListArray.Values().(*arrow.Float32Array)
-	vectorValues := vectorArray.Values()
+	var vectorValues []float32
+	var halfValues []arrow.Float16
+	switch values := vectorListArray.Values().(type) {
+	case *arrow.Float32Array:
+		vectorValues = values.Values()
+	case *arrow.Float16Array:
+		halfValues = values.Values()
+		h.float16 = true
+	default:
+		return nil, fmt.Errorf("unsupported vector element type: %s", values.DataType().Name())
+	}
 
-	// 验证节点ID的连续性
-	numNodes := idArray.Len()
-	for i := 0; i < numNodes; i++ {
-		id := int(idArray.Value(i))
-		if id != i {
-			return fmt.Errorf("node ID mismatch at index %d: expected %d, got %d", i, i, id)
+	var codes *codeColumn
+	if _, ok := batch.ColumnByName("code"); ok && h.quantizer != nil {
+		var err error
+		if codes, err = readCodeColumn(batch, codeLengthValue); err != nil {
+			return nil, err
 		}
 	}
 
 	// 重构节点
-	h.nodes = make([]*Node, numNodes)
+	numNodes := idArray.Len()
+	nodes := make([]*Node, numNodes)
 
 	for i := 0; i < numNodes; i++ {
 		id := int(idArray.Value(i))
 		level := int(levelArray.Value(i))
 
-		// 提取向量
+		// 提取向量，只读索引直接引用映射的内存
 		start := i * h.dimension
 		end := start + h.dimension
-		vector := make([]float32, h.dimension)
-		copy(vector, vectorValues[start:end])
+		var node *Node
+		if halfValues != nil {
+			half := halfValues[start:end:end]
+			if !h.readOnly {
+				half = append([]arrow.Float16(nil), half...)
+			}
+			node = NewNode(id, nil, level)
+			node.setHalf(half)
+		} else {
+			vector := vectorValues[start:end:end]
+			if !h.readOnly {
+				vector = make([]float32, h.dimension)
+				copy(vector, vectorValues[start:end])
+			}
+			node = NewNode(id, vector, level)
+		}
+
+		// 创建节点，只读索引的编码同样直接引用映射的内存
+		if codes != nil {
+			node.setCode(codes.code(i, h.readOnly))
+		} else {
+			h.encode(node)
+		}
+		h.dropVector(node)
+		if deletedArray != nil && deletedArray.Value(i) != 0 {
+			node.deleted.Store(true)
+		}
+		nodes[i] = node
+	}
+
+	return nodes, nil
+}
+
+// decodeQuantizedNodes 从量化编码恢复节点，节点没有原始向量
+func (h *HNSWIndex) decodeQuantizedNodes(batch *arrow.RecordBatch, codeLengthValue string) ([]*Node, error) {
+	if h.quantizer == nil {
+		return nil, fmt.Errorf("nodes are stored as quantized codes but quantizer.lance is missing")
+	}
+	codes, err := readCodeColumn(batch, codeLengthValue)
+	if err != nil {
+		return nil, err
+	}
+
+	columnByName := func(name string) (arrow.Array, error) {
+		col, ok := batch.ColumnByName(name)
+		if !ok {
+			return nil, fmt.Errorf("nodes column %q missing", name)
+		}
+		return col, nil
+	}
+	idCol, err := columnByName("id")
+	if err != nil {
+		return nil, err
+	}
+	levelCol, err := columnByName("level")
+	if err != nil {
+		return nil, err
+	}
+	deletedCol, err := columnByName("deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	idArray := idCol.(*arrow.Int32Array)
+	levelArray := levelCol.(*arrow.Int32Array)
+	deletedArray := deletedCol.(*arrow.Int32Array)
 
-		// 创建节点
-		node := NewNode(id, vector, level)
-		h.nodes[i] = node
+	numNodes := idArray.Len()
+	nodes := make([]*Node, numNodes)
+	for i := 0; i < numNodes; i++ {
+		node := NewNode(int(idArray.Value(i)), nil, int(levelArray.Value(i)))
+		node.setCode(codes.code(i, h.readOnly))
+		if deletedArray.Value(i) != 0 {
+			node.deleted.Store(true)
+		}
+		nodes[i] = node
 	}
+	h.quantizedOnly = true
+
+	return nodes, nil
+}
+
+// loadKeys 从可选的 key_int / key_str 列恢复 nodes 的外部键，从 doc_int / doc_str 列恢复文档键，
+// 旧文件中没有这些列
+func loadKeys(batch *arrow.RecordBatch, nodes []*Node) error {
+	if err := loadKeyColumns(batch, nodes, "key", func(n *Node, k Key) { n.key = k }); err != nil {
+		return err
+	}
+	return loadKeyColumns(batch, nodes, "doc", func(n *Node, k Key) { n.doc = k })
+}
+
+// loadKeyColumns 读取 appendKeyColumns 写出的 <prefix>_int / <prefix>_str 列，用 set 设置节点的键
+func loadKeyColumns(batch *arrow.RecordBatch, nodes []*Node, prefix string, set func(*Node, Key)) error {
+	var intKeys *arrow.Int64Array
+	if col, ok := batch.ColumnByName(prefix + "_int"); ok {
+		if intKeys, ok = col.(*arrow.Int64Array); !ok {
+			return fmt.Errorf("nodes column %q is not an int64 column", prefix+"_int")
+		}
+	}
+	var stringKeys *arrow.StringArray
+	if col, ok := batch.ColumnByName(prefix + "_str"); ok {
+		if stringKeys, ok = col.(*arrow.StringArray); !ok {
+			return fmt.Errorf("nodes column %q is not a string column", prefix+"_str")
+		}
+	}
+
+	for i, node := range nodes {
+		switch {
+		case intKeys != nil && intKeys.IsValid(i):
+			set(node, IntKey(intKeys.Value(i)))
+		case stringKeys != nil && stringKeys.IsValid(i):
+			set(node, StringKey(stringKeys.Value(i)))
+		}
+	}
+	return nil
+}
+
+// indexNodes 在所有节点文件加载完后统计墓碑数、重建外部键映射并发布节点
+func (h *HNSWIndex) indexNodes() error {
+	h.numDeleted = 0
+	clear(h.keys)
+	clear(h.docs)
+	for id, node := range h.nodes {
+		if node.IsDeleted() {
+			h.numDeleted++
+			continue
+		}
+		if !node.doc.IsZero() {
+			h.docs[node.doc] = append(h.docs[node.doc], id)
+		}
+		if node.key.IsZero() {
+			continue
+		}
+		if existing, ok := h.keys[node.key]; ok {
+			return fmt.Errorf("%w: key %v is used by nodes %d and %d", ErrDuplicateKey, node.key, existing, id)
+		}
+		h.keys[node.key] = id
+	}
+	h.publishNodes()
 
 	return nil
 }
@@ -364,45 +1162,282 @@ func (h *HNSWIndex) loadConnections(filename string) error {
 		return nil
 	}
 
-	reader, err := column.NewReader(filename)
+	reader, err := h.openReader(filename)
 	if err != nil {
 		return fmt.Errorf("create reader failed: %w", err)
 	}
-	defer reader.Close()
+	defer h.closeReader(reader)
 
 	batch, err := reader.ReadRecordBatch()
 	if err != nil {
 		return fmt.Errorf("read connections failed: %w", err)
 	}
 
-	nodeIDArray := batch.Column(0).(*arrow.Int32Array)
-	layerArray := batch.Column(1).(*arrow.Int32Array)
-	neighborIDArray := batch.Column(2).(*arrow.Int32Array)
-
-	numConnections := nodeIDArray.Len()
+	nodeIDs, layers, offsets, neighbors, err := connectionLists(batch)
+	if err != nil {
+		return err
+	}
 
-	// 重建连接关系
-	for i := 0; i < numConnections; i++ {
-		nodeID := int(nodeIDArray.Value(i))
-		layer := int(layerArray.Value(i))
-		neighborID := int(neighborIDArray.Value(i))
+	// 重建连接关系。每个列表替换该节点该层已有的连接，这样后面的增量片段覆盖前面的
+	for i := range nodeIDs {
+		nodeID := int(nodeIDs[i])
+		layer := int(layers[i])
 
 		if nodeID < 0 || nodeID >= len(h.nodes) {
-			return fmt.Errorf("invalid node_id %d at connection index %d (valid range: [0, %d))",
+			return fmt.Errorf("invalid node_id %d at connection list %d (valid range: [0, %d))",
 				nodeID, i, len(h.nodes))
 		}
-		if neighborID < 0 || neighborID >= len(h.nodes) {
-			return fmt.Errorf("invalid neighbor_id %d at connection index %d (valid range: [0, %d))",
-				neighborID, i, len(h.nodes))
-		}
 		if layer < 0 || layer > h.nodes[nodeID].Level() {
-			return fmt.Errorf("invalid layer %d for node %d at connection index %d (valid range: [0, %d])",
+			return fmt.Errorf("invalid layer %d for node %d at connection list %d (valid range: [0, %d])",
 				layer, nodeID, i, h.nodes[nodeID].Level())
 		}
 
+		start, end := offsets[i], offsets[i+1]
+		if start < 0 || start > end || int(end) > len(neighbors) {
+			return fmt.Errorf("invalid offsets [%d, %d) at connection list %d", start, end, i)
+		}
+		group := neighbors[start:end:end]
+		for _, neighborID := range group {
+			if neighborID < 0 || int(neighborID) >= len(h.nodes) {
+				return fmt.Errorf("invalid neighbor_id %d at connection list %d (valid range: [0, %d))",
+					neighborID, i, len(h.nodes))
+			}
+		}
+
+		// 只读索引的连接表直接引用映射的 neighbors 列
 		node := h.nodes[nodeID]
-		node.AddConnection(layer, neighborID)
+		if h.readOnly {
+			node.mapConnections(layer, group)
+		} else {
+			node.SetConnections(layer, appendMapped(make([]int, 0, len(group)), group))
+		}
+	}
+
+	return nil
+}
+
+// connectionLists 以 CSR 形式返回连接文件中的连接表：第 i 个列表属于节点 nodeIDs[i] 的
+// layers[i] 层，邻居为 neighbors[offsets[i]:offsets[i+1]]
+func connectionLists(batch *arrow.RecordBatch) (nodeIDs, layers, offsets, neighbors []int32, err error) {
+	int32Column := func(name string) ([]int32, error) {
+		col, ok := batch.ColumnByName(name)
+		if !ok {
+			return nil, fmt.Errorf("connections column %q missing", name)
+		}
+		array, ok := col.(*arrow.Int32Array)
+		if !ok {
+			return nil, fmt.Errorf("connections column %q is not an int32 column", name)
+		}
+		return array.Values(), nil
 	}
+	if nodeIDs, err = int32Column("node_id"); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if layers, err = int32Column("layer"); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if col, ok := batch.ColumnByName("neighbors"); ok {
+		lists, ok := col.(*arrow.ListArray)
+		if !ok {
+			return nil, nil, nil, nil, fmt.Errorf("connections column %q is not a list column", "neighbors")
+		}
+		values, ok := lists.Values().(*arrow.Int32Array)
+		if !ok {
+			return nil, nil, nil, nil, fmt.Errorf("connections column %q is not a list<int32> column", "neighbors")
+		}
+		return nodeIDs, layers, lists.Offsets(), values.Values(), nil
+	}
+
+	// 旧版本每条边一行
+	neighborIDs, err := int32Column("neighbor_id")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	nodeIDs, layers, offsets, neighbors = edgeLists(nodeIDs, layers, neighborIDs)
+	return nodeIDs, layers, offsets, neighbors, nil
+}
+
+// edgeLists 把旧版本每条边一行的连接转换成 CSR：同一节点同一层的连续行合并成一个列表，
+// 只有一行 neighbor_id = -1 的组是空列表
+func edgeLists(edgeNodeIDs, edgeLayers, edgeNeighborIDs []int32) (nodeIDs, layers, offsets, neighbors []int32) {
+	offsets = []int32{0}
+	for start := 0; start < len(edgeNodeIDs); {
+		end := start + 1
+		for end < len(edgeNodeIDs) && edgeNodeIDs[end] == edgeNodeIDs[start] && edgeLayers[end] == edgeLayers[start] {
+			end++
+		}
+		group := edgeNeighborIDs[start:end]
+		if len(group) == 1 && group[0] == emptyConnections {
+			group = nil
+		}
+
+		nodeIDs = append(nodeIDs, edgeNodeIDs[start])
+		layers = append(layers, edgeLayers[start])
+		neighbors = append(neighbors, group...)
+		offsets = append(offsets, int32(len(neighbors)))
+		start = end
+	}
+	return nodeIDs, layers, offsets, neighbors
+}
+
+// SchemaForFlatVectors 创建精确索引向量存储的Schema
+func SchemaForFlatVectors(dimension int) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("id", arrow.PrimInt32(), false),
+		arrow.NewField("vector", arrow.VectorType(dimension), false),
+		arrow.NewField("deleted", arrow.PrimInt32(), false),
+	}, map[string]string{
+		"purpose":   "flat_vectors",
+		"dimension": fmt.Sprintf("%d", dimension),
+	})
+}
+
+// SchemaForFlatMetadata 创建精确索引元数据的Schema
+func SchemaForFlatMetadata(distanceName string) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("dimension", arrow.PrimInt32(), false),
+		arrow.NewField("numVectors", arrow.PrimInt32(), false),
+	}, map[string]string{
+		"purpose":    "flat_metadata",
+		"distance":   distanceName,
+		"index_type": IndexTypeFlat,
+	})
+}
+
+// SaveToLance 将精确索引保存到Lance格式文件
+func (f *FlatIndex) SaveToLance(baseDir string) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// 未注册的距离函数无法在加载时还原，提前拒绝
+	if f.distName == "" {
+		return fmt.Errorf("save metadata failed: %w: register it with RegisterDistanceFunc before saving", ErrUnknownDistance)
+	}
+	if len(f.vectors) == 0 {
+		return fmt.Errorf("save vectors failed: no vectors to save")
+	}
+
+	numVectors := len(f.vectors)
+	ids := make([]int32, numVectors)
+	vectors := make([]float32, 0, numVectors*f.dimension)
+	deleted := make([]int32, numVectors)
+	for i, vector := range f.vectors {
+		ids[i] = int32(i)
+		vectors = append(vectors, vector...)
+		if f.deleted[i] {
+			deleted[i] = 1
+		}
+	}
+
+	vectorType := arrow.VectorType(f.dimension).(*arrow.FixedSizeListType)
+	if err := writeRecordBatch(filepath.Join(baseDir, "vectors.lance"), SchemaForFlatVectors(f.dimension), numVectors, []arrow.Array{
+		arrow.NewInt32Array(ids, nil),
+		arrow.NewFixedSizeListArray(vectorType, arrow.NewFloat32Array(vectors, nil), nil),
+		arrow.NewInt32Array(deleted, nil),
+	}); err != nil {
+		return fmt.Errorf("save vectors failed: %w", err)
+	}
+
+	if err := writeRecordBatch(filepath.Join(baseDir, "metadata.lance"), SchemaForFlatMetadata(f.distName), 1, []arrow.Array{
+		arrow.NewInt32Array([]int32{int32(f.dimension)}, nil),
+		arrow.NewInt32Array([]int32{int32(numVectors)}, nil),
+	}); err != nil {
+		return fmt.Errorf("save metadata failed: %w", err)
+	}
+
+	// 同一目录中之前保存的 HNSW 索引的清单会让 LoadIndex 加载旧索引
+	return RemoveHNSWFiles(baseDir)
+}
+
+// writeRecordBatch 把单个RecordBatch写入文件
+func writeRecordBatch(filename string, schema *arrow.Schema, numRows int, columns []arrow.Array) error {
+	batch, err := arrow.NewRecordBatch(schema, numRows, columns)
+	if err != nil {
+		return fmt.Errorf("create record batch failed: %w", err)
+	}
+
+	writer, err := column.NewWriter(filename, schema, column.DefaultSerializationOptions())
+	if err != nil {
+		return fmt.Errorf("create writer failed: %w", err)
+	}
+	defer writer.Close()
+
+	if err := writer.WriteRecordBatch(batch); err != nil {
+		return fmt.Errorf("write record batch failed: %w", err)
+	}
+	return nil
+}
+
+// LoadFlatFromLance 从Lance格式文件加载精确索引
+func LoadFlatFromLance(baseDir string) (*FlatIndex, error) {
+	reader, err := column.NewReader(filepath.Join(baseDir, "metadata.lance"))
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+	distance := reader.Schema().Metadata()["distance"]
+	reader.Close()
+
+	distFunc, err := LookupDistanceFunc(distance)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+
+	reader, err = column.NewReader(filepath.Join(baseDir, "vectors.lance"))
+	if err != nil {
+		return nil, fmt.Errorf("load vectors failed: %w", err)
+	}
+	defer reader.Close()
+
+	batch, err := reader.ReadRecordBatch()
+	if err != nil {
+		return nil, fmt.Errorf("load vectors failed: %w", err)
+	}
+
+	idArray := batch.Column(0).(*arrow.Int32Array)
+	vectorListArray := batch.Column(1).(*arrow.FixedSizeListArray)
+	deletedArray := batch.Column(2).(*arrow.Int32Array)
+	vectorValues := vectorListArray.Values().(*arrow.Float32Array).Values()
+	dimension := vectorListArray.ListSize()
+
+	f := NewFlatIndex(dimension, distFunc)
+	f.distName = distance
+
+	numVectors := idArray.Len()
+	f.vectors = make([][]float32, numVectors)
+	f.deleted = make([]bool, numVectors)
+	for i := 0; i < numVectors; i++ {
+		// 验证向量ID的连续性
+		if id := int(idArray.Value(i)); id != i {
+			return nil, fmt.Errorf("load vectors failed: vector ID mismatch at index %d: expected %d, got %d", i, i, id)
+		}
+		f.vectors[i] = append([]float32(nil), vectorValues[i*dimension:(i+1)*dimension]...)
+		f.deleted[i] = deletedArray.Value(i) != 0
+	}
+
+	return f, nil
+}
+
+// Save implements VectorIndex by calling SaveToLance.
+func (f *FlatIndex) Save(baseDir string) error {
+	return f.SaveToLance(baseDir)
+}
+
+// Load implements VectorIndex: it loads the index saved in baseDir with
+// LoadFlatFromLance and replaces the contents of f with it.
+func (f *FlatIndex) Load(baseDir string) error {
+	loaded, err := LoadFlatFromLance(baseDir)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
+	f.dimension = loaded.dimension
+	f.distFunc = loaded.distFunc
+	f.distName = loaded.distName
+	f.vectors = loaded.vectors
+	f.deleted = loaded.deleted
 	return nil
 }
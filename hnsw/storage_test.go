@@ -1,9 +1,17 @@
 package hnsw
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"ollama-demo/lance/arrow"
+	"ollama-demo/lance/column"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestHNSWStorageBasic(t *testing.T) {
@@ -15,7 +23,7 @@ func TestHNSWStorageBasic(t *testing.T) {
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      4,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -42,11 +50,10 @@ func TestHNSWStorageBasic(t *testing.T) {
 	}
 
 	// 验证文件是否创建
-	expectedFiles := []string{"nodes.lance", "connections.lance", "metadata.lance"}
-	for _, filename := range expectedFiles {
-		fullPath := filepath.Join(tempDir, filename)
+	for _, kind := range []string{"nodes", "connections", "metadata"} {
+		fullPath := savedFile(t, tempDir, kind)
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			t.Errorf("Expected file %s was not created", filename)
+			t.Errorf("Expected file %s was not created", fullPath)
 		}
 	}
 
@@ -155,7 +162,7 @@ func TestHNSWStorageEmptyIndex(t *testing.T) {
 		M:              8,
 		EfConstruction: 100,
 		Dimension:      3,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -180,7 +187,7 @@ func TestHNSWStorageLargeDataset(t *testing.T) {
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      128,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -241,7 +248,7 @@ func TestHNSWStorageHighDimensional(t *testing.T) {
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      768, // 常见的BERT嵌入维度
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -305,7 +312,7 @@ func TestHNSWStorageConnectionIntegrity(t *testing.T) {
 		M:              4, // 较小的M值，更容易测试连接
 		EfConstruction: 100,
 		Dimension:      3,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -413,7 +420,7 @@ func TestHNSWStorageMultipleSaveLoad(t *testing.T) {
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      4,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	// 第一次：创建并保存
@@ -476,7 +483,7 @@ func TestHNSWStorageSearchConsistency(t *testing.T) {
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      8,
-		DistanceFunc:   L2Distance,
+		DistanceName:   DistanceL2,
 	}
 
 	hnsw := NewHNSW(config)
@@ -538,10 +545,1564 @@ func TestHNSWStorageSearchConsistency(t *testing.T) {
 	t.Logf("✓ Search consistency test passed: results match after save/load")
 }
 
+func TestHNSWStorageDistanceMetric(t *testing.T) {
+	// 测试距离函数和搜索相关配置的持久化
+	tempDir := t.TempDir()
+
+	config := Config{
+		M:              8,
+		EfConstruction: 100,
+		Dimension:      4,
+		DistanceName:   DistanceCosine,
+		Seed:           7,
+	}
+
+	hnsw := NewHNSW(config)
+	hnsw.Mmax0 = 12 // 非默认值，验证加载后不会被重新推导
+	for i := 0; i < 100; i++ {
+		hnsw.Add([]float32{float32(i), 1, float32(i % 3), 0.5})
+	}
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loadedHNSW.distName != DistanceCosine {
+		t.Errorf("Distance mismatch: got %q, want %q", loadedHNSW.distName, DistanceCosine)
+	}
+	if got := loadedHNSW.distFunc([]float32{1, 0, 0, 0}, []float32{0, 1, 0, 0}); abs(got-1) > 1e-6 {
+		t.Errorf("Loaded distance function is not cosine: orthogonal distance = %f", got)
+	}
+	if loadedHNSW.Mmax != hnsw.Mmax || loadedHNSW.Mmax0 != hnsw.Mmax0 {
+		t.Errorf("Mmax/Mmax0 mismatch: got %d/%d, want %d/%d",
+			loadedHNSW.Mmax, loadedHNSW.Mmax0, hnsw.Mmax, hnsw.Mmax0)
+	}
+	if loadedHNSW.seed != 7 {
+		t.Errorf("Seed mismatch: got %d, want 7", loadedHNSW.seed)
+	}
+}
+
+func TestHNSWStorageCustomDistance(t *testing.T) {
+	// 自定义距离函数注册后可以保存和加载
+	manhattan := func(a, b []float32) float32 {
+		var sum float32
+		for i := range a {
+			sum += abs(a[i] - b[i])
+		}
+		return sum
+	}
+	if err := RegisterDistanceFunc("test_manhattan", manhattan); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := RegisterDistanceFunc("test_manhattan", manhattan); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter on duplicate registration, got %v", err)
+	}
+
+	tempDir := t.TempDir()
+	hnsw := NewHNSW(Config{Dimension: 2, DistanceName: "test_manhattan"})
+	hnsw.Add([]float32{0, 0})
+	hnsw.Add([]float32{3, 4})
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	results, err := loadedHNSW.Search([]float32{0, 0}, 2, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 || results[1].Distance != 7 {
+		t.Errorf("Expected manhattan distance 7 for second result, got %+v", results)
+	}
+}
+
+func TestDistanceNameClosures(t *testing.T) {
+	// 同一字面量创建的闭包按注册名区分，索引记录的是配置中的名字
+	weighted := func(w float32) DistanceFunc {
+		return func(a, b []float32) float32 { return w * L2Distance(a, b) }
+	}
+	if err := RegisterDistanceFunc("test_double", weighted(2)); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := RegisterDistanceFunc("test_triple", weighted(3)); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	index := NewHNSW(Config{Dimension: 2, DistanceName: "test_triple"})
+	index.Add([]float32{0, 0})
+	index.Add([]float32{1, 0})
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.distName != "test_triple" {
+		t.Errorf("Loaded distance name %q, want test_triple", loaded.distName)
+	}
+	results, _ := loaded.Search([]float32{0, 0}, 2, 0)
+	if len(results) != 2 || results[1].Distance != 3 {
+		t.Errorf("Expected the tripled distance 3 for the second result, got %+v", results)
+	}
+
+	// 只给出函数时即使它已注册也不猜测名字，索引不能保存
+	unnamed := NewHNSW(Config{Dimension: 2, DistanceFunc: weighted(3)})
+	unnamed.Add([]float32{1, 2})
+	if err := unnamed.SaveToLance(t.TempDir()); !errors.Is(err, ErrUnknownDistance) {
+		t.Errorf("Expected ErrUnknownDistance saving an unnamed distance function, got %v", err)
+	}
+}
+
+func TestHNSWStorageUnregisteredDistance(t *testing.T) {
+	// 未注册的距离函数：保存时拒绝
+	hnsw := NewHNSW(Config{
+		Dimension:    2,
+		DistanceFunc: func(a, b []float32) float32 { return 0 },
+	})
+	hnsw.Add([]float32{1, 2})
+
+	if err := hnsw.SaveToLance(t.TempDir()); !errors.Is(err, ErrUnknownDistance) {
+		t.Errorf("Expected ErrUnknownDistance when saving, got %v", err)
+	}
+
+	// 文件中记录的距离名未注册：加载时给出明确错误
+	tempDir := t.TempDir()
+	hnsw = NewHNSW(Config{Dimension: 2})
+	hnsw.Add([]float32{1, 2})
+	hnsw.distName = "not_registered"
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	_, err := LoadHNSWFromLance(tempDir)
+	if !errors.Is(err, ErrUnknownDistance) {
+		t.Errorf("Expected ErrUnknownDistance when loading, got %v", err)
+	}
+}
+
+func TestHNSWStorageTombstones(t *testing.T) {
+	// 测试墓碑标记的持久化
+	tempDir := t.TempDir()
+
+	hnsw := NewHNSW(Config{Dimension: 4, Seed: 3})
+	for i := 0; i < 30; i++ {
+		hnsw.Add([]float32{float32(i), float32(i * 2), 1, 0})
+	}
+	for _, id := range []int{0, 5, 17} {
+		if err := hnsw.Delete(id); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", id, err)
+		}
+	}
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if loadedHNSW.Len() != 27 {
+		t.Errorf("Len mismatch after load: got %d, want 27", loadedHNSW.Len())
+	}
+	for i, node := range loadedHNSW.nodes {
+		if node.IsDeleted() != hnsw.nodes[i].IsDeleted() {
+			t.Errorf("Node %d tombstone mismatch: got %v", i, node.IsDeleted())
+		}
+	}
+
+	results, err := loadedHNSW.Search([]float32{5, 10, 1, 0}, 5, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, r := range results {
+		if r.ID == 0 || r.ID == 5 || r.ID == 17 {
+			t.Errorf("Loaded index returned deleted node %d", r.ID)
+		}
+	}
+}
+
+func TestHNSWStorageQuantizer(t *testing.T) {
+	// 测试量化参数的持久化
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(9))
+	vectors := make([][]float32, 300)
+	for i := range vectors {
+		vector := make([]float32, 18)
+		for j := range vector {
+			vector[j] = rng.Float32()*2 - 1
+		}
+		vectors[i] = vector
+	}
+
+	quantizer := NewScalarQuantizer(18)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	hnsw := NewHNSW(Config{Dimension: 18, Seed: 5, Quantizer: quantizer})
+	hnsw.AddBatch(vectors, 1)
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	loadedQuantizer, ok := loadedHNSW.quantizer.(*ScalarQuantizer)
+	if !ok {
+		t.Fatalf("Expected *ScalarQuantizer after load, got %T", loadedHNSW.quantizer)
+	}
+	for i := 0; i < 18; i++ {
+		if loadedQuantizer.min[i] != quantizer.min[i] || loadedQuantizer.max[i] != quantizer.max[i] {
+			t.Fatalf("Dimension %d range mismatch: got [%f, %f], want [%f, %f]", i,
+				loadedQuantizer.min[i], loadedQuantizer.max[i], quantizer.min[i], quantizer.max[i])
+		}
+	}
+	readOnly, err := LoadHNSWReadOnly(tempDir)
+	if err != nil {
+		t.Fatalf("LoadHNSWReadOnly failed: %v", err)
+	}
+	defer readOnly.Close()
+
+	// 只读加载使用保存的编码而不重新编码：编码直接引用映射的 code 列，
+	// 18 字节的编码按 int32 对齐存放，相邻节点的编码相隔 20 字节
+	first := uintptr(unsafe.Pointer(&readOnly.nodes[0].codeRef()[0]))
+	for i, node := range readOnly.nodes {
+		if uintptr(unsafe.Pointer(&node.codeRef()[0])) != first+uintptr(i*packedCodeWords(18)*4) {
+			t.Fatalf("Expected the read-only code of node %d to reference the mapped file", i)
+		}
+	}
+
+	for _, loadedIndex := range []*HNSWIndex{loadedHNSW, readOnly} {
+		for i, node := range loadedIndex.nodes {
+			if string(node.codeRef()) != string(hnsw.nodes[i].codeRef()) {
+				t.Fatalf("Node %d code mismatch after load", i)
+			}
+		}
+
+		original, _ := hnsw.Search(vectors[3], 10, 50)
+		loaded, _ := loadedIndex.Search(vectors[3], 10, 50)
+		for i := range original {
+			if original[i].ID != loaded[i].ID || original[i].Distance != loaded[i].Distance {
+				t.Errorf("Result %d mismatch: original %+v, loaded %+v", i, original[i], loaded[i])
+			}
+		}
+	}
+
+	// 覆盖保存一个没有量化器的索引后，旧的参数文件必须被删除
+	plain := NewHNSW(Config{Dimension: 18, Seed: 5})
+	plain.AddBatch(vectors, 1)
+	if err := plain.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(tempDir, "quantizer*.lance")); len(matches) != 0 {
+		t.Errorf("Expected the quantizer file to be removed, found %v", matches)
+	}
+	reloaded, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if reloaded.quantizer != nil {
+		t.Errorf("Expected no quantizer, got %T", reloaded.quantizer)
+	}
+}
+
+func TestHNSWStorageBinaryQuantizer(t *testing.T) {
+	// 测试二值量化阈值和只保留位向量的节点的持久化
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(19))
+	vectors := make([][]float32, 300)
+	for i := range vectors {
+		vector := make([]float32, 100)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	quantizer := NewBinaryQuantizer(100)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	hnsw := NewHNSW(Config{Dimension: 100, Seed: 5, DistanceName: DistanceHamming, Quantizer: quantizer, QuantizedOnly: true})
+	hnsw.AddBatch(vectors, 1)
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	loadedQuantizer, ok := loadedHNSW.quantizer.(*BinaryQuantizer)
+	if !ok {
+		t.Fatalf("Expected *BinaryQuantizer after load, got %T", loadedHNSW.quantizer)
+	}
+	for i, threshold := range quantizer.thresholds {
+		if loadedQuantizer.thresholds[i] != threshold {
+			t.Fatalf("Dimension %d threshold mismatch: got %f, want %f", i, loadedQuantizer.thresholds[i], threshold)
+		}
+	}
+	for i, node := range loadedHNSW.nodes {
+		if node.vectorRef() != nil {
+			t.Fatalf("Node %d has a full-precision vector in a quantized-only index", i)
+		}
+		if string(node.codeRef()) != string(hnsw.nodes[i].codeRef()) {
+			t.Fatalf("Node %d code mismatch after load", i)
+		}
+	}
+
+	original, _ := hnsw.Search(vectors[3], 10, 50)
+	loaded, _ := loadedHNSW.Search(vectors[3], 10, 50)
+	if len(loaded) != len(original) {
+		t.Fatalf("Expected %d results after load, got %d", len(original), len(loaded))
+	}
+	for i := range original {
+		if original[i].ID != loaded[i].ID || original[i].Distance != loaded[i].Distance {
+			t.Errorf("Result %d mismatch: original %+v, loaded %+v", i, original[i], loaded[i])
+		}
+	}
+}
+
+func TestHNSWStorageFloat16Vectors(t *testing.T) {
+	// 测试半精度向量的持久化：vector 列以 float16 存储，加载和只读映射后向量逐位相同
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(29))
+	vectors := make([][]float32, 300)
+	for i := range vectors {
+		vector := make([]float32, 48)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	hnsw := NewHNSW(Config{Dimension: 48, Seed: 5, Float16Vectors: true})
+	hnsw.AddBatch(vectors, 2)
+	hnsw.Delete(4)
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reader, err := column.NewReader(savedFile(t, tempDir, "nodes"))
+	if err != nil {
+		t.Fatalf("Open nodes failed: %v", err)
+	}
+	vectorType := reader.Schema().Field(1).Type.(*arrow.FixedSizeListType)
+	reader.Close()
+	if vectorType.Elem().ID() != arrow.FLOAT16 {
+		t.Fatalf("Expected a float16 vector column, got %s", vectorType.Name())
+	}
+
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	readOnly, err := LoadHNSWReadOnly(tempDir)
+	if err != nil {
+		t.Fatalf("LoadHNSWReadOnly failed: %v", err)
+	}
+	defer readOnly.Close()
+
+	// 只读加载的半精度向量直接引用映射的节点文件，相邻节点的向量在内存中相邻
+	first, second := readOnly.nodes[0].halfRef(), readOnly.nodes[1].halfRef()
+	if uintptr(unsafe.Pointer(&first[0]))+uintptr(len(first))*unsafe.Sizeof(first[0]) != uintptr(unsafe.Pointer(&second[0])) {
+		t.Error("Expected read-only float16 vectors to reference the mapped file")
+	}
+
+	for _, loaded := range []*HNSWIndex{loadedHNSW, readOnly} {
+		if !loaded.float16 {
+			t.Fatal("Expected the loaded index to keep half-precision vectors")
+		}
+		for i, node := range loaded.nodes {
+			if node.vectorRef() != nil {
+				t.Fatalf("Node %d has a float32 vector after load", i)
+			}
+			if fmt.Sprint(node.halfRef()) != fmt.Sprint(hnsw.nodes[i].halfRef()) {
+				t.Fatalf("Node %d vector mismatch after load", i)
+			}
+		}
+
+		original, _ := hnsw.Search(vectors[3], 10, 50)
+		results, _ := loaded.Search(vectors[3], 10, 50)
+		if len(results) != len(original) {
+			t.Fatalf("Expected %d results after load, got %d", len(original), len(results))
+		}
+		for i := range original {
+			if original[i].ID != results[i].ID || original[i].Distance != results[i].Distance {
+				t.Errorf("Result %d mismatch: original %+v, loaded %+v", i, original[i], results[i])
+			}
+		}
+	}
+
+	// 增量保存的片段同样以半精度写出
+	loadedHNSW.Add(vectors[0])
+	if err := loadedHNSW.SaveIncremental(tempDir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+	reloaded, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded.Len() != 300 || !reloaded.float16 {
+		t.Errorf("Reloaded index has %d live nodes, float16=%v", reloaded.Len(), reloaded.float16)
+	}
+}
+
+func TestHNSWStorageDocuments(t *testing.T) {
+	// 测试文档分组的持久化：文档键随节点写出，完整保存和增量保存后都能按文档搜索
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(37))
+	randomVector := func() []float32 {
+		vector := make([]float32, 12)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		return vector
+	}
+
+	hnsw := NewHNSW(Config{Dimension: 12, Seed: 5})
+	for d := 0; d < 40; d++ {
+		doc := StringKey(fmt.Sprintf("doc-%d", d))
+		if d%2 == 1 {
+			doc = IntKey(int64(d))
+		}
+		hnsw.AddDocument(doc, [][]float32{randomVector(), randomVector(), randomVector()})
+	}
+	hnsw.Add(randomVector())
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for _, doc := range []Key{StringKey("doc-4"), IntKey(9)} {
+		if fmt.Sprint(loaded.DocumentNodes(doc)) != fmt.Sprint(hnsw.DocumentNodes(doc)) {
+			t.Errorf("Document %v: got nodes %v, want %v", doc, loaded.DocumentNodes(doc), hnsw.DocumentNodes(doc))
+		}
+	}
+	query := [][]float32{randomVector(), randomVector()}
+	original, _ := hnsw.SearchDocuments(query, 5, 100)
+	results, err := loaded.SearchDocuments(query, 5, 100)
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if fmt.Sprint(results) != fmt.Sprint(original) {
+		t.Errorf("Results mismatch after load: got %v, want %v", results, original)
+	}
+
+	// 删除和新增的文档随增量片段保存
+	if err := loaded.DeleteDocument(IntKey(9)); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	loaded.AddDocument(IntKey(100), [][]float32{randomVector()})
+	if err := loaded.SaveIncremental(tempDir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+	reloaded, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded.DocumentNodes(IntKey(9)) != nil {
+		t.Error("Expected deleted document to stay deleted after reload")
+	}
+	if nodes := reloaded.DocumentNodes(IntKey(100)); len(nodes) != 1 {
+		t.Errorf("Expected the new document to have 1 vector after reload, got %v", nodes)
+	}
+
+	// 只读索引在插入任何向量之前拒绝添加文档
+	readOnly, err := LoadHNSWReadOnly(tempDir)
+	if err != nil {
+		t.Fatalf("LoadHNSWReadOnly failed: %v", err)
+	}
+	defer readOnly.Close()
+	if ids, err := readOnly.AddDocument(IntKey(101), [][]float32{randomVector(), randomVector()}); !errors.Is(err, ErrReadOnly) || ids != nil {
+		t.Errorf("Expected ErrReadOnly and no IDs, got %v, %v", ids, err)
+	}
+	if readOnly.DocumentNodes(IntKey(101)) != nil {
+		t.Error("Expected the rejected document to have no vectors")
+	}
+}
+
+func TestHNSWStorageProductQuantizer(t *testing.T) {
+	// 测试乘积量化码本和只保留编码的节点的持久化
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(11))
+	vectors := make([][]float32, 400)
+	for i := range vectors {
+		vector := make([]float32, 24)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	// 3 字节的编码打包时需要补齐到一个 int32
+	quantizer := NewProductQuantizer(24, 3)
+	if err := quantizer.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	hnsw := NewHNSW(Config{Dimension: 24, Seed: 5, Quantizer: quantizer, QuantizedOnly: true})
+	hnsw.AddBatch(vectors, 1)
+	hnsw.Delete(10)
+
+	if err := hnsw.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loadedHNSW, err := LoadHNSWFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !loadedHNSW.quantizedOnly {
+		t.Fatal("Expected loaded index to keep only quantized codes")
+	}
+	loadedQuantizer, ok := loadedHNSW.quantizer.(*ProductQuantizer)
+	if !ok {
+		t.Fatalf("Expected *ProductQuantizer after load, got %T", loadedHNSW.quantizer)
+	}
+	if loadedQuantizer.subspaces != 3 || loadedQuantizer.centroids != quantizer.centroids {
+		t.Fatalf("Quantizer shape mismatch: %d subspaces, %d centroids",
+			loadedQuantizer.subspaces, loadedQuantizer.centroids)
+	}
+	for i := range quantizer.codebook {
+		if loadedQuantizer.codebook[i] != quantizer.codebook[i] {
+			t.Fatalf("Codebook value %d mismatch", i)
+		}
+	}
+
+	for i, node := range loadedHNSW.nodes {
+		if string(node.codeRef()) != string(hnsw.nodes[i].codeRef()) {
+			t.Fatalf("Node %d code mismatch after load", i)
+		}
+		if node.IsDeleted() != hnsw.nodes[i].IsDeleted() {
+			t.Fatalf("Node %d tombstone mismatch after load", i)
+		}
+	}
+
+	original, _ := hnsw.Search(vectors[3], 10, 50)
+	loaded, _ := loadedHNSW.Search(vectors[3], 10, 50)
+	for i := range original {
+		if original[i].ID != loaded[i].ID || original[i].Distance != loaded[i].Distance {
+			t.Errorf("Result %d mismatch: original %+v, loaded %+v", i, original[i], loaded[i])
+		}
+	}
+
+	// 加载后的索引可以继续插入
+	if _, err := loadedHNSW.Add(vectors[0]); err != nil {
+		t.Errorf("Add after load failed: %v", err)
+	}
+}
+
 // 辅助函数
 func abs(x float32) float32 {
 	if x < 0 {
 		return -x
 	}
-	return x
+	return x
+}
+
+func TestVectorIndexSaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(17))
+	vectors := make([][]float32, 200)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+
+	for _, indexType := range []string{IndexTypeHNSW, IndexTypeFlat} {
+		t.Run(indexType, func(t *testing.T) {
+			index, err := NewIndex(indexType, Config{Dimension: 8, DistanceName: DistanceCosine, Seed: 3})
+			if err != nil {
+				t.Fatalf("NewIndex failed: %v", err)
+			}
+			for _, vector := range vectors {
+				if _, err := index.Add(vector); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+			}
+			if err := index.Delete(7); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+
+			dir := t.TempDir()
+			if err := index.Save(dir); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			loaded, err := LoadIndex(dir)
+			if err != nil {
+				t.Fatalf("LoadIndex failed: %v", err)
+			}
+
+			// 加载出的实例类型与保存时一致
+			switch indexType {
+			case IndexTypeHNSW:
+				if _, ok := loaded.(*HNSWIndex); !ok {
+					t.Fatalf("Expected *HNSWIndex, got %T", loaded)
+				}
+			case IndexTypeFlat:
+				if _, ok := loaded.(*FlatIndex); !ok {
+					t.Fatalf("Expected *FlatIndex, got %T", loaded)
+				}
+			}
+			if loaded.Len() != index.Len() {
+				t.Errorf("Expected %d live vectors, got %d", index.Len(), loaded.Len())
+			}
+
+			expected, _ := index.Search(vectors[7], 5, 0)
+			got, err := loaded.Search(vectors[7], 5, 0)
+			if err != nil {
+				t.Fatalf("Search on loaded index failed: %v", err)
+			}
+			for i := range expected {
+				if got[i].ID != expected[i].ID || got[i].ID == 7 {
+					t.Errorf("Result %d: expected ID %d, got %d", i, expected[i].ID, got[i].ID)
+				}
+			}
+		})
+	}
+
+	if _, err := NewIndex("no_such_index", Config{Dimension: 8}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for unknown index type, got %v", err)
+	}
+	if err := RegisterIndexType(IndexTypeHNSW, func(Config) VectorIndex { return nil }); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for duplicate index type, got %v", err)
+	}
+}
+
+func TestVectorIndexSaveOverHNSW(t *testing.T) {
+	rng := rand.New(rand.NewSource(19))
+	randomVector := func() []float32 {
+		v := make([]float32, 8)
+		for j := range v {
+			v[j] = rng.Float32()
+		}
+		return v
+	}
+
+	dir := t.TempDir()
+	h := NewHNSW(Config{Dimension: 8, Seed: 3})
+	for i := 0; i < 50; i++ {
+		h.Add(randomVector())
+	}
+	if err := h.Save(dir); err != nil {
+		t.Fatalf("HNSW Save failed: %v", err)
+	}
+
+	// 在同一目录保存平坦索引后，加载的应是平坦索引而不是清单中的旧 HNSW 索引
+	flat := NewFlatIndex(8, nil)
+	for i := 0; i < 20; i++ {
+		flat.Add(randomVector())
+	}
+	if err := flat.Save(dir); err != nil {
+		t.Fatalf("Flat Save failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the HNSW manifest to be removed, stat returned %v", err)
+	}
+	loaded, err := LoadIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	if _, ok := loaded.(*FlatIndex); !ok {
+		t.Fatalf("Expected *FlatIndex, got %T", loaded)
+	}
+	if loaded.Len() != 20 {
+		t.Errorf("Expected 20 vectors, got %d", loaded.Len())
+	}
+
+	// 再保存回 HNSW 索引也能正常加载
+	if err := h.Save(dir); err != nil {
+		t.Fatalf("HNSW Save failed: %v", err)
+	}
+	loaded, err = LoadIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	if _, ok := loaded.(*HNSWIndex); !ok {
+		t.Fatalf("Expected *HNSWIndex, got %T", loaded)
+	}
+	if loaded.Len() != 50 {
+		t.Errorf("Expected 50 vectors, got %d", loaded.Len())
+	}
+}
+
+func TestHNSWStorageKeys(t *testing.T) {
+	for _, quantizedOnly := range []bool{false, true} {
+		rng := rand.New(rand.NewSource(23))
+		vectors := make([][]float32, 100)
+		for i := range vectors {
+			vectors[i] = make([]float32, 8)
+			for j := range vectors[i] {
+				vectors[i][j] = rng.Float32()
+			}
+		}
+
+		config := Config{Dimension: 8, Seed: 9}
+		if quantizedOnly {
+			quantizer := NewScalarQuantizer(8)
+			quantizer.Train(vectors)
+			config.Quantizer, config.QuantizedOnly = quantizer, true
+		}
+		index := NewHNSW(config)
+
+		// 偶数用 int64 键，3 的倍数用字符串键，其余没有键
+		keyOf := func(i int) Key {
+			switch {
+			case i%2 == 0:
+				return IntKey(int64(i) * 1000)
+			case i%3 == 0:
+				return StringKey(fmt.Sprintf("chunk-%d", i))
+			default:
+				return Key{}
+			}
+		}
+		for i, vector := range vectors {
+			if key := keyOf(i); !key.IsZero() {
+				index.AddWithKey(key, vector)
+			} else {
+				index.Add(vector)
+			}
+		}
+		index.Delete(4)
+
+		dir := t.TempDir()
+		if err := index.SaveToLance(dir); err != nil {
+			t.Fatalf("Failed to save HNSW: %v", err)
+		}
+		loaded, err := LoadHNSWFromLance(dir)
+		if err != nil {
+			t.Fatalf("Failed to load HNSW: %v", err)
+		}
+
+		for i := range vectors {
+			key := keyOf(i)
+			if got := loaded.nodes[i].Key(); got != key {
+				t.Errorf("quantizedOnly=%v node %d: expected key %v, got %v", quantizedOnly, i, key, got)
+			}
+			id, ok := loaded.Lookup(key)
+			if expected := !key.IsZero() && i != 4; ok != expected || (ok && id != i) {
+				t.Errorf("quantizedOnly=%v Lookup(%v) returned (%d, %v)", quantizedOnly, key, id, ok)
+			}
+		}
+
+		results, err := loaded.Search(vectors[6], 1, 0)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].Key != keyOf(6) {
+			t.Errorf("Expected key %v in search result, got %v", keyOf(6), results[0].Key)
+		}
+	}
+}
+
+func TestHNSWStoragePayload(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		arrow.NewField("source", arrow.PrimString(), true),
+		arrow.NewField("chunk", arrow.PrimInt64(), true),
+		arrow.NewField("page", arrow.PrimInt32(), true),
+		arrow.NewField("weight", arrow.PrimFloat32(), true),
+		arrow.NewField("score", arrow.PrimFloat64(), true),
+	}, nil)
+	index := NewHNSW(Config{Dimension: 4, Seed: 2, PayloadSchema: schema})
+
+	rng := rand.New(rand.NewSource(31))
+	payloads := make([]Payload, 50)
+	for i := range payloads {
+		vector := []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+		if i%5 == 0 {
+			// 部分节点没有负载
+			index.Add(vector)
+			continue
+		}
+		payloads[i] = Payload{
+			"source": fmt.Sprintf("doc-%d.md", i%3),
+			"chunk":  int64(i),
+			"page":   int32(i / 10),
+			"weight": float32(i) / 2,
+		}
+		if i%2 == 0 {
+			payloads[i]["score"] = float64(i) / 7
+		}
+		if _, err := index.AddWithPayload(Key{}, vector, payloads[i]); err != nil {
+			t.Fatalf("AddWithPayload failed: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("Failed to save HNSW: %v", err)
+	}
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Failed to load HNSW: %v", err)
+	}
+
+	if loaded.PayloadSchema() == nil || loaded.PayloadSchema().NumFields() != schema.NumFields() {
+		t.Fatalf("Expected payload schema with %d fields, got %v", schema.NumFields(), loaded.PayloadSchema())
+	}
+	for i, expected := range payloads {
+		got := loaded.nodes[i].Payload()
+		if len(got) != len(expected) {
+			t.Errorf("Node %d: expected payload %v, got %v", i, expected, got)
+			continue
+		}
+		for name, value := range expected {
+			if got[name] != value {
+				t.Errorf("Node %d field %q: expected %v (%T), got %v (%T)", i, name, value, value, got[name], got[name])
+			}
+		}
+	}
+
+	// 加载后的索引可以继续添加带负载的向量
+	if _, err := loaded.AddWithPayload(Key{}, []float32{0, 0, 0, 0}, Payload{"source": "new.md"}); err != nil {
+		t.Errorf("AddWithPayload on loaded index failed: %v", err)
+	}
+}
+
+func TestHNSWStorageIncremental(t *testing.T) {
+	// 测试增量保存：只追加变化的节点和连接，加载结果与内存中的索引一致
+	dir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(41))
+	randomVector := func() []float32 {
+		return []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	index := NewHNSW(Config{Dimension: 6, Seed: 4, MaxFragments: 3})
+	for i := 0; i < 300; i++ {
+		index.AddWithKey(IntKey(int64(i)), randomVector())
+	}
+
+	// 第一次保存压缩成一个片段
+	if err := index.SaveIncremental(dir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+	manifest, err := readManifest(dir)
+	if err != nil || manifest == nil {
+		t.Fatalf("Expected a manifest after SaveIncremental, got %v, %v", manifest, err)
+	}
+	if len(manifest.DataFiles) != 1 || len(manifest.IndexFiles) != 1 {
+		t.Fatalf("Expected one node and one connection fragment, got %v and %v", manifest.DataFiles, manifest.IndexFiles)
+	}
+
+	// 新增、删除和更新之后增量保存，节点片段只包含变化的节点
+	for i := 300; i < 310; i++ {
+		index.AddWithKey(IntKey(int64(i)), randomVector())
+	}
+	for _, id := range []int{3, 150} {
+		if err := index.Delete(id); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", id, err)
+		}
+	}
+	if err := index.Update(42, randomVector()); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := index.SaveIncremental(dir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+	if manifest, err = readManifest(dir); err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if len(manifest.DataFiles) != 2 {
+		t.Fatalf("Expected two node fragments, got %v", manifest.DataFiles)
+	}
+	reader, err := column.NewReader(filepath.Join(dir, manifest.DataFiles[1]))
+	if err != nil {
+		t.Fatalf("Open fragment failed: %v", err)
+	}
+	if rows := reader.NumRows(); rows != 13 {
+		t.Errorf("Expected 13 rows in the node fragment, got %d", rows)
+	}
+	reader.Close()
+
+	checkLoaded := func() {
+		t.Helper()
+		loaded, err := LoadHNSWFromLance(dir)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if loaded.Len() != index.Len() || len(loaded.nodes) != len(index.nodes) {
+			t.Fatalf("Expected %d live of %d nodes, got %d of %d", index.Len(), len(index.nodes), loaded.Len(), len(loaded.nodes))
+		}
+		if loaded.entryPoint != index.entryPoint || loaded.maxLevel != index.maxLevel {
+			t.Errorf("Entry point mismatch: got %d/%d, want %d/%d", loaded.entryPoint, loaded.maxLevel, index.entryPoint, index.maxLevel)
+		}
+		for id, node := range index.nodes {
+			got := loaded.nodes[id]
+			if got.IsDeleted() != node.IsDeleted() || got.Key() != node.Key() {
+				t.Errorf("Node %d state mismatch", id)
+			}
+			for layer := 0; layer <= node.Level(); layer++ {
+				if fmt.Sprint(got.GetConnections(layer)) != fmt.Sprint(node.GetConnections(layer)) {
+					t.Errorf("Node %d layer %d connections mismatch: got %v, want %v",
+						id, layer, got.GetConnections(layer), node.GetConnections(layer))
+				}
+			}
+			if fmt.Sprint(got.Vector()) != fmt.Sprint(node.Vector()) {
+				t.Errorf("Node %d vector mismatch", id)
+			}
+		}
+		if _, ok := loaded.Lookup(IntKey(3)); ok {
+			t.Errorf("Deleted key 3 still resolves after load")
+		}
+	}
+	checkLoaded()
+
+	// 片段数达到 MaxFragments 后压缩，增量片段被删除
+	oldFiles := []string{manifest.DataFiles[1], manifest.IndexFiles[1]}
+	for round := 0; round < 2; round++ {
+		index.Add(randomVector())
+		if err := index.SaveIncremental(dir); err != nil {
+			t.Fatalf("SaveIncremental failed: %v", err)
+		}
+	}
+	if manifest, err = readManifest(dir); err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if len(manifest.DataFiles) != 1 {
+		t.Errorf("Expected compaction into one node fragment, got %v", manifest.DataFiles)
+	}
+	for _, name := range oldFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected compacted fragment %s to be removed", name)
+		}
+	}
+	checkLoaded()
+
+	// 完整保存之后可以继续增量保存
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("SaveToLance failed: %v", err)
+	}
+	index.Delete(7)
+	if err := index.SaveIncremental(dir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+	if manifest, err = readManifest(dir); err != nil || len(manifest.DataFiles) != 2 {
+		t.Errorf("Expected SaveIncremental to append to the full save, got %v, %v", manifest, err)
+	}
+	checkLoaded()
+}
+
+func TestHNSWStorageChecksums(t *testing.T) {
+	// 测试保存中途崩溃留下新旧混合的文件时拒绝加载
+	newIndex := func(seed int64) *HNSWIndex {
+		index := NewHNSW(Config{Dimension: 4, Seed: seed})
+		rng := rand.New(rand.NewSource(seed))
+		for i := 0; i < 50; i++ {
+			index.Add([]float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()})
+		}
+		return index
+	}
+
+	dir := t.TempDir()
+	if err := newIndex(1).SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// 中断的保存留下的临时目录在下次保存时被清理
+	if err := os.Mkdir(filepath.Join(dir, stagingPrefix+"crashed"), 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	other := t.TempDir()
+	if err := newIndex(2).SaveToLance(other); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := newIndex(3).SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, stagingPrefix+"*")); len(matches) != 0 {
+		t.Errorf("Expected staging directories to be removed, found %v", matches)
+	}
+	if _, err := LoadHNSWFromLance(dir); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// 清单引用的连接文件被换成另一次保存的文件
+	data, err := os.ReadFile(savedFile(t, other, "connections"))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	connections := savedFile(t, dir, "connections")
+	if err := os.WriteFile(connections, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := LoadHNSWFromLance(dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch for mixed files, got %v", err)
+	}
+	if _, err := LoadIndex(dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected LoadIndex to return ErrChecksumMismatch, got %v", err)
+	}
+
+	// 缺少清单中记录的文件同样拒绝加载
+	if err := os.Remove(connections); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := LoadHNSWFromLance(dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch for a missing file, got %v", err)
+	}
+	if _, err := LoadHNSWReadOnly(dir); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected read-only load to reject a missing file, got %v", err)
+	}
+
+	// 没有清单的旧目录按文件名加载
+	toLegacyLayout(t, other)
+	loaded, err := LoadHNSWFromLance(other)
+	if err != nil {
+		t.Fatalf("Load without manifest failed: %v", err)
+	}
+	if loaded.Len() != 50 {
+		t.Errorf("Expected 50 nodes, got %d", loaded.Len())
+	}
+}
+
+func TestHNSWStorageFailedCommit(t *testing.T) {
+	// 测试清单提交失败时旧版本保持可加载，写出的新文件在下次保存时被清理
+	newIndex := func(n int) *HNSWIndex {
+		index := NewHNSW(Config{Dimension: 4, Seed: 1})
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < n; i++ {
+			index.Add([]float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()})
+		}
+		return index
+	}
+
+	dir := t.TempDir()
+	if err := newIndex(50).SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// 清单的临时文件位置被目录占住，新文件全部写完后提交失败
+	blocker := filepath.Join(dir, manifestFile+".tmp")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	bigger := newIndex(80)
+	if err := bigger.SaveToLance(dir); err == nil {
+		t.Fatal("Expected the manifest commit to fail")
+	}
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Load after a failed commit failed: %v", err)
+	}
+	if loaded.Len() != 50 {
+		t.Errorf("Expected the previous save with 50 nodes, got %d", loaded.Len())
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := bigger.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if loaded, err = LoadHNSWFromLance(dir); err != nil || loaded.Len() != 80 {
+		t.Fatalf("Load failed: %v, expected 80 nodes", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 4 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("Expected only the manifest and the files it references, found %v", names)
+	}
+}
+
+func TestHNSWStorageReadOnly(t *testing.T) {
+	dim := 16
+	index := NewHNSW(Config{Dimension: dim, M: 8, Seed: 42})
+	rng := rand.New(rand.NewSource(42))
+	randomVector := func() []float32 {
+		vector := make([]float32, dim)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		return vector
+	}
+	for i := 0; i < 300; i++ {
+		index.AddWithKey(IntKey(int64(i)), randomVector())
+	}
+
+	// 基础片段加上一个增量片段，只读加载同样要按顺序覆盖
+	dir := t.TempDir()
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		index.Add(randomVector())
+	}
+	index.Delete(3)
+	index.Delete(150)
+	if err := index.SaveIncremental(dir); err != nil {
+		t.Fatalf("SaveIncremental failed: %v", err)
+	}
+
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	readOnly, err := LoadHNSWReadOnly(dir)
+	if err != nil {
+		t.Fatalf("LoadHNSWReadOnly failed: %v", err)
+	}
+	defer readOnly.Close()
+
+	if readOnly.Len() != loaded.Len() {
+		t.Errorf("Expected %d live nodes, got %d", loaded.Len(), readOnly.Len())
+	}
+	for id, node := range loaded.nodes {
+		mapped := readOnly.nodes[id]
+		for level := 0; level <= node.Level(); level++ {
+			if fmt.Sprint(mapped.GetConnections(level)) != fmt.Sprint(node.GetConnections(level)) {
+				t.Fatalf("Node %d level %d: connections %v, expected %v",
+					id, level, mapped.GetConnections(level), node.GetConnections(level))
+			}
+		}
+		if fmt.Sprint(mapped.Vector()) != fmt.Sprint(node.Vector()) {
+			t.Fatalf("Node %d: vector mismatch", id)
+		}
+	}
+
+	for q := 0; q < 10; q++ {
+		query := randomVector()
+		expected, err := loaded.Search(query, 10, 50)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		results, err := readOnly.Search(query, 10, 50)
+		if err != nil {
+			t.Fatalf("Read-only search failed: %v", err)
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("Query %d: expected %d results, got %d", q, len(expected), len(results))
+		}
+		for i := range expected {
+			if results[i].ID != expected[i].ID || results[i].Distance != expected[i].Distance || results[i].Key != expected[i].Key {
+				t.Errorf("Query %d result %d: got %+v, expected %+v", q, i, results[i], expected[i])
+			}
+		}
+	}
+
+	// 修改操作都被拒绝
+	if _, err := readOnly.Add(randomVector()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Add, got %v", err)
+	}
+	if _, err := readOnly.AddBatch([][]float32{randomVector()}, 1); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from AddBatch, got %v", err)
+	}
+	if err := readOnly.Delete(0); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Delete, got %v", err)
+	}
+	if err := readOnly.Update(0, randomVector()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Update, got %v", err)
+	}
+	if err := readOnly.Load(dir); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Load, got %v", err)
+	}
+
+	// 只读索引仍然可以保存到其他目录
+	copyDir := t.TempDir()
+	if err := readOnly.SaveToLance(copyDir); err != nil {
+		t.Fatalf("Save of read-only index failed: %v", err)
+	}
+	copied, err := LoadHNSWFromLance(copyDir)
+	if err != nil {
+		t.Fatalf("Load of copy failed: %v", err)
+	}
+	if copied.Len() != loaded.Len() {
+		t.Errorf("Expected copy with %d live nodes, got %d", loaded.Len(), copied.Len())
+	}
+
+	if err := readOnly.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := loaded.Close(); err != nil {
+		t.Errorf("Close of a loaded index failed: %v", err)
+	}
+
+	// 关闭后映射已经释放，搜索和保存返回错误而不是访问释放的内存
+	if _, err := readOnly.Search(randomVector(), 10, 50); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from Search after Close, got %v", err)
+	}
+	if _, err := readOnly.SearchWithFilter(randomVector(), 10, 50, FilterFunc(func(int) bool { return true })); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from SearchWithFilter after Close, got %v", err)
+	}
+	if err := readOnly.SaveToLance(t.TempDir()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed from SaveToLance after Close, got %v", err)
+	}
+	if readOnly.Len() != 0 {
+		t.Errorf("Expected a closed index to be empty, got %d nodes", readOnly.Len())
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+	if _, err := loaded.Search(randomVector(), 10, 50); err != nil {
+		t.Errorf("Close must not affect a loaded index, got %v", err)
+	}
+}
+
+// savedFile 返回 dir 中清单引用的 kind 文件的路径，kind 是 nodes、connections、metadata 或 quantizer；
+// nodes 和 connections 取第一个片段
+func savedFile(t *testing.T, dir string, kind string) string {
+	t.Helper()
+	manifest, err := readManifest(dir)
+	if err != nil || manifest == nil {
+		t.Fatalf("readManifest: %v, %v", manifest, err)
+	}
+	var name string
+	switch kind {
+	case "nodes":
+		name = manifest.DataFiles[0]
+	case "connections":
+		name = manifest.IndexFiles[0]
+	default:
+		name = manifest.Metadata[kind+"_file"]
+	}
+	if name == "" {
+		t.Fatalf("Manifest references no %s file", kind)
+	}
+	return filepath.Join(dir, name)
+}
+
+// toLegacyLayout 把 dir 中完整保存的索引改成旧版本的目录：没有清单，文件固定命名
+func toLegacyLayout(t *testing.T, dir string) {
+	t.Helper()
+	for _, kind := range []string{"nodes", "connections", "metadata"} {
+		if err := os.Rename(savedFile(t, dir, kind), filepath.Join(dir, kind+".lance")); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, manifestFile)); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+}
+
+// writeEdgeConnections 按旧版本每条边一行的格式写出 index 的连接
+func writeEdgeConnections(t *testing.T, filename string, index *HNSWIndex) {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{
+		arrow.NewField("node_id", arrow.PrimInt32(), false),
+		arrow.NewField("layer", arrow.PrimInt32(), false),
+		arrow.NewField("neighbor_id", arrow.PrimInt32(), false),
+	}, map[string]string{"purpose": "hnsw_connections"})
+
+	var nodeIDs, layers, neighborIDs []int32
+	for _, node := range index.nodes {
+		for layer := 0; layer <= node.Level(); layer++ {
+			for _, neighborID := range node.GetConnections(layer) {
+				nodeIDs = append(nodeIDs, int32(node.ID()))
+				layers = append(layers, int32(layer))
+				neighborIDs = append(neighborIDs, int32(neighborID))
+			}
+		}
+	}
+	columns := []arrow.Array{
+		arrow.NewInt32Array(nodeIDs, nil),
+		arrow.NewInt32Array(layers, nil),
+		arrow.NewInt32Array(neighborIDs, nil),
+	}
+	if err := writeRecordBatch(filename, schema, len(nodeIDs), columns); err != nil {
+		t.Fatalf("write edge connections failed: %v", err)
+	}
+}
+
+func TestHNSWStorageCSRConnections(t *testing.T) {
+	index := NewHNSW(Config{Dimension: 8, M: 8, Seed: 7})
+	rng := rand.New(rand.NewSource(7))
+	for i := 0; i < 200; i++ {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		index.Add(vector)
+	}
+
+	dir := t.TempDir()
+	if err := index.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// 每个节点的每一层一行，按层排序
+	reader, err := column.NewReader(savedFile(t, dir, "connections"))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	batch, err := reader.ReadRecordBatch()
+	reader.Close()
+	if err != nil {
+		t.Fatalf("ReadRecordBatch failed: %v", err)
+	}
+	expectedRows := 0
+	for _, node := range index.nodes {
+		expectedRows += node.Level() + 1
+	}
+	if batch.NumRows() != expectedRows {
+		t.Errorf("Expected %d connection lists, got %d", expectedRows, batch.NumRows())
+	}
+	if _, ok := batch.Column(2).(*arrow.ListArray); !ok {
+		t.Fatalf("Expected neighbors to be a list column, got %T", batch.Column(2))
+	}
+	layers := batch.Column(1).(*arrow.Int32Array).Values()
+	for i := 1; i < len(layers); i++ {
+		if layers[i] < layers[i-1] {
+			t.Fatalf("Expected lists sorted by layer, layer %d follows %d at row %d", layers[i], layers[i-1], i)
+		}
+	}
+
+	checkConnections := func(loaded *HNSWIndex) {
+		t.Helper()
+		for id, node := range index.nodes {
+			for level := 0; level <= node.Level(); level++ {
+				if fmt.Sprint(loaded.nodes[id].GetConnections(level)) != fmt.Sprint(node.GetConnections(level)) {
+					t.Fatalf("Node %d level %d: connections %v, expected %v",
+						id, level, loaded.nodes[id].GetConnections(level), node.GetConnections(level))
+				}
+			}
+		}
+	}
+	loaded, err := LoadHNSWFromLance(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	checkConnections(loaded)
+
+	// 旧版本的目录：没有清单，连接每条边一行
+	legacyDir := t.TempDir()
+	for _, kind := range []string{"nodes", "metadata"} {
+		data, err := os.ReadFile(savedFile(t, dir, kind))
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(legacyDir, kind+".lance"), data, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	writeEdgeConnections(t, filepath.Join(legacyDir, "connections.lance"), index)
+	legacyInfo, err := os.Stat(filepath.Join(legacyDir, "connections.lance"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	loaded, err = LoadHNSWFromLance(legacyDir)
+	if err != nil {
+		t.Fatalf("Load of edge layout failed: %v", err)
+	}
+	checkConnections(loaded)
+	readOnly, err := LoadHNSWReadOnly(legacyDir)
+	if err != nil {
+		t.Fatalf("Read-only load of edge layout failed: %v", err)
+	}
+	checkConnections(readOnly)
+	readOnly.Close()
+
+	upgraded, err := UpgradeStorage(legacyDir)
+	if err != nil || !upgraded {
+		t.Fatalf("UpgradeStorage: upgraded=%v err=%v, expected an upgrade", upgraded, err)
+	}
+	info, err := os.Stat(savedFile(t, legacyDir, "connections"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() >= legacyInfo.Size() {
+		t.Errorf("Expected CSR connections to be smaller than %d bytes, got %d", legacyInfo.Size(), info.Size())
+	}
+	loaded, err = LoadHNSWFromLance(legacyDir)
+	if err != nil {
+		t.Fatalf("Load after upgrade failed: %v", err)
+	}
+	checkConnections(loaded)
+
+	if upgraded, err := UpgradeStorage(legacyDir); err != nil || upgraded {
+		t.Errorf("UpgradeStorage: upgraded=%v err=%v, expected nothing to do", upgraded, err)
+	}
+}
+
+func TestHNSWStorageNeighborSelector(t *testing.T) {
+	// 测试邻居选择策略的持久化：内置策略随索引保存，自定义策略加载后使用默认策略
+	rng := rand.New(rand.NewSource(31))
+	vectors := make([][]float32, 100)
+	for i := range vectors {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+
+	custom := NeighborSelectorFunc(func(g *NeighborGraph, vec []float32, candidates []SearchResult, m int) []SearchResult {
+		return SimpleSelector{}.SelectNeighbors(g, vec, candidates, m)
+	})
+	tests := []struct {
+		name     string
+		selector NeighborSelector
+		want     NeighborSelector
+	}{
+		{"default", nil, HeuristicSelector{KeepPrunedConnections: true}},
+		{"simple", SimpleSelector{}, SimpleSelector{}},
+		{"heuristic", HeuristicSelector{}, HeuristicSelector{}},
+		{"heuristic+extend+keep", HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true}, HeuristicSelector{ExtendCandidates: true, KeepPrunedConnections: true}},
+		{"rng", RNGSelector{Alpha: 1.3}, RNGSelector{Alpha: 1.3}},
+		{"custom", custom, HeuristicSelector{KeepPrunedConnections: true}},
+	}
+	for _, tt := range tests {
+		tempDir := t.TempDir()
+		hnsw := NewHNSW(Config{Dimension: 8, Seed: 5, NeighborSelector: tt.selector})
+		for _, v := range vectors {
+			hnsw.Add(v)
+		}
+		if err := hnsw.SaveToLance(tempDir); err != nil {
+			t.Fatalf("%s: Save failed: %v", tt.name, err)
+		}
+
+		loaded, err := LoadHNSWFromLance(tempDir)
+		if err != nil {
+			t.Fatalf("%s: Load failed: %v", tt.name, err)
+		}
+		if loaded.selector != tt.want {
+			t.Errorf("%s: loaded selector %#v, want %#v", tt.name, loaded.selector, tt.want)
+		}
+
+		// 加载后继续插入使用保存的策略
+		if _, err := loaded.Add(vectors[0]); err != nil {
+			t.Fatalf("%s: Add after load failed: %v", tt.name, err)
+		}
+	}
+
+	for _, spec := range []string{"heuristic,wide", "rng", "rng:x", "simple,keep", "greedy"} {
+		if _, err := parseSelectorSpec(spec); err == nil {
+			t.Errorf("parseSelectorSpec(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestHNSWLoadConcurrentSave(t *testing.T) {
+	// Load 与保存并发执行：两者按相同顺序获取 persistMu 和 globalLock，不会死锁
+	rng := rand.New(rand.NewSource(16))
+	hnsw := NewHNSW(Config{Dimension: 8, Seed: 5})
+	for i := 0; i < 200; i++ {
+		vector := make([]float32, 8)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		hnsw.Add(vector)
+	}
+	source := t.TempDir()
+	if err := hnsw.SaveToLance(source); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	target := t.TempDir()
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := hnsw.Load(source); err != nil {
+				errs <- fmt.Errorf("Load failed: %w", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := hnsw.SaveToLance(target); err != nil {
+				errs <- fmt.Errorf("Save failed: %w", err)
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Load and SaveToLance deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHNSWLoadReplacesState(t *testing.T) {
+	// Load 整体替换索引状态：保存时的配置随索引加载，MaxFragments 沿用调用方的配置
+	rng := rand.New(rand.NewSource(21))
+	source := NewHNSW(Config{Dimension: 8, M: 6, Seed: 9, DistanceName: DistanceCosine,
+		Float16Vectors: true, NeighborSelector: SimpleSelector{}})
+	vectors := make([][]float32, 50)
+	for i := range vectors {
+		vectors[i] = make([]float32, 8)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+	source.AddWithKey(StringKey("first"), vectors[0])
+	source.AddDocument(StringKey("doc"), vectors[1:3])
+	for _, v := range vectors[3:] {
+		source.Add(v)
+	}
+	dir := t.TempDir()
+	if err := source.SaveToLance(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	target := NewHNSW(Config{Dimension: 4, MaxFragments: 3})
+	target.Add([]float32{1, 2, 3, 4})
+	if err := target.Load(dir); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if target.dimension != 8 || target.M != 6 || target.seed != 9 || target.distName != DistanceCosine {
+		t.Errorf("Loaded config: dimension %d, M %d, seed %d, distance %q", target.dimension, target.M, target.seed, target.distName)
+	}
+	if !target.float16 || target.selector != (SimpleSelector{}) {
+		t.Errorf("Loaded float16=%v selector=%#v", target.float16, target.selector)
+	}
+	if target.Len() != 50 || len(target.snapshot()) != 50 {
+		t.Errorf("Loaded %d nodes, published %d, want 50", target.Len(), len(target.snapshot()))
+	}
+	if id, ok := target.Lookup(StringKey("first")); !ok || id != 0 {
+		t.Errorf("Lookup after Load = %d, %v", id, ok)
+	}
+	if ids := target.DocumentNodes(StringKey("doc")); len(ids) != 2 {
+		t.Errorf("Document after Load has nodes %v", ids)
+	}
+	if target.maxFragments != 3 {
+		t.Errorf("MaxFragments %d, want the caller's 3", target.maxFragments)
+	}
+	if results, err := target.Search(vectors[10], 1, 0); err != nil || results[0].ID != 10 {
+		t.Errorf("Search after Load returned %v, %v", results, err)
+	}
 }
@@ -0,0 +1,112 @@
+package hnsw
+
+import "fmt"
+
+// Update replaces the vector of an existing node and re-links the node at every
+// level it lives on. The node keeps its ID, so any mapping from node IDs to
+// external data stays valid.
+func (h *HNSWIndex) Update(id int, vector []float32) error {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+	if len(vector) != h.dimension {
+		return ErrDimensionMismatch
+	}
+
+	h.globalLock.Lock()
+	defer h.globalLock.Unlock()
+
+	if id < 0 || id >= len(h.nodes) {
+		return fmt.Errorf("%w: id %d (valid range: [0, %d))", ErrNodeNotFound, id, len(h.nodes))
+	}
+
+	node := h.nodes[id]
+	if node.IsDeleted() {
+		return fmt.Errorf("%w: id %d", ErrNodeDeleted, id)
+	}
+
+	node.setVector(h.unitVector(vector))
+	h.encode(node)
+	node.rowDirty.Store(true)
+
+	h.relink(node)
+	h.dropVector(node)
+
+	return nil
+}
+
+// relink 按节点的新向量重新建立它在每一层的连接。调用方持有 globalLock。
+func (h *HNSWIndex) relink(node *Node) {
+	nodeID := node.ID()
+	nodeLevel := node.Level()
+	vec := h.nodeVector(node)
+
+	ep, topLevel := int(h.entryPoint), int(h.maxLevel)
+
+	// 阶段1：从顶层贪心下降到 nodeLevel+1
+	// 节点自身仍在图中，可以作为路径上的一跳，只是最终不能成为自己的邻居
+	scratch := getSearchScratch()
+	defer putSearchScratch(scratch)
+	if h.quantizedOnly {
+		defer h.attachDistancer(scratch, vec)()
+	}
+	currentNearest := h.descend(vec, ep, topLevel, nodeLevel, scratch)
+
+	// 阶段2：逐层重新选择邻居，候选集 = 新的搜索结果 + 原有邻居
+	for lc := nodeLevel; lc >= 0; lc-- {
+		var candidates []SearchResult
+		if lc <= topLevel {
+			candidates = h.searchLayer(vec, currentNearest, h.efConstruction, lc, nil, scratch)
+		}
+
+		// 在节点锁内合并当前连接后重新选择，保留并发插入刚加上的反向连接
+		var neighborIDs []int
+		node.updateConnections(lc, func(current []int) []int {
+			merged := h.mergeCandidates(vec, nodeID, candidates, current)
+			neighbors := h.selectNeighbors(vec, nodeID, lc, merged, h.maxConnections(lc), true)
+
+			neighborIDs = make([]int, len(neighbors))
+			for i, neighbor := range neighbors {
+				neighborIDs[i] = neighbor.ID
+			}
+			return append([]int(nil), neighborIDs...)
+		})
+
+		nodes := h.snapshot()
+		for _, neighborID := range neighborIDs {
+			h.connect(nodes[neighborID], lc, nodeID)
+		}
+
+		if len(neighborIDs) > 0 {
+			currentNearest = neighborIDs[0]
+		}
+	}
+}
+
+// mergeCandidates 把 extraIDs 合并进候选集（按新向量计算距离），
+// 去掉重复、节点自身和已删除节点
+func (h *HNSWIndex) mergeCandidates(vec []float32, selfID int, candidates []SearchResult, extraIDs []int) []SearchResult {
+	nodes := h.snapshot()
+	buf := getDecodeBuffer()
+	defer putDecodeBuffer(buf)
+	seen := make(map[int]bool, len(candidates)+len(extraIDs))
+	merged := make([]SearchResult, 0, len(candidates)+len(extraIDs))
+
+	for _, c := range candidates {
+		if c.ID == selfID || seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+		merged = append(merged, c)
+	}
+
+	for _, extraID := range extraIDs {
+		if extraID == selfID || seen[extraID] || nodes[extraID].IsDeleted() {
+			continue
+		}
+		seen[extraID] = true
+		merged = append(merged, SearchResult{ID: extraID, Distance: h.distFunc(vec, buf.vector(h, nodes[extraID]))})
+	}
+
+	return merged
+}
@@ -0,0 +1,410 @@
+package ivf
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"ollama-demo/hnsw"
+)
+
+// IndexType is the name IVFIndex is registered under with hnsw.RegisterIndexType.
+const IndexType = "ivf"
+
+func init() {
+	if err := hnsw.RegisterIndexType(IndexType, func(config hnsw.Config) hnsw.VectorIndex {
+		return NewIVF(Config{
+			Dimension:    config.Dimension,
+			DistanceFunc: config.DistanceFunc,
+			DistanceName: config.DistanceName,
+			Seed:         config.Seed,
+			TrainSize:    autoTrainSize,
+		})
+	}); err != nil {
+		panic(err.Error())
+	}
+}
+
+var _ hnsw.VectorIndex = (*IVFIndex)(nil)
+
+// ErrNotTrained is returned when vectors are added to or searched in an index
+// whose centroids have not been trained yet.
+var ErrNotTrained = errors.New("ivf: index not trained")
+
+const kmeansIterations = 25 // Lloyd iterations when training centroids.
+
+// autoTrainSize 是通过 hnsw.NewIndex 创建的索引自动训练前收集的向量数，
+// 默认 100 个簇时每个簇约 40 个训练样本
+const autoTrainSize = 4000
+
+// IVFIndex is an inverted file index: vectors are partitioned into nlist
+// clusters by k-means and a query only scans the nprobe clusters whose
+// centroids are closest to it.
+type IVFIndex struct {
+	dimension int
+	nlist     int // Number of clusters.
+	nprobe    int // Default number of clusters scanned per query.
+
+	centroids  [][]float32 // Cluster centroids, nil until trained.
+	lists      [][]int     // Vector IDs in each cluster.
+	vectors    [][]float32 // All vectors, indexed by ID.
+	assigned   []int32     // Cluster of each vector.
+	deleted    []bool      // Tombstones; deleted vectors are removed from lists.
+	numDeleted int
+	trainSize  int // Vectors collected before training automatically, 0 if Train must be called.
+
+	distFunc hnsw.DistanceFunc // Distance function used for measuring similarity.
+	distName string            // Registered name of distFunc, persisted with the index.
+	seed     int64             // Seed for k-means initialisation, persisted with the index.
+
+	mu sync.RWMutex // Protects the whole index.
+}
+
+// Config holds the configuration parameters for the IVF index.
+type Config struct {
+	Dimension    int               // Vector dimensionality.
+	NList        int               // Number of clusters, default 100.
+	NProbe       int               // Clusters scanned per query, default 8.
+	DistanceFunc hnsw.DistanceFunc // default hnsw.L2Distance; an index given only a DistanceFunc cannot be saved.
+	DistanceName string            // Registered distance name, persisted with the index; takes precedence over DistanceFunc.
+	Seed         int64             // Seed for k-means initialisation.
+
+	// TrainSize lets an index that has not been trained accept vectors: Add
+	// collects them, and once TrainSize live vectors are collected the centroids
+	// are trained on them. Until then Search scans every vector exactly, and
+	// SaveToLance trains on the vectors collected so far. 0 means Add and Search
+	// return ErrNotTrained until Train is called. Indexes created with
+	// hnsw.NewIndex collect 4000 vectors.
+	TrainSize int
+}
+
+func NewIVF(config Config) *IVFIndex {
+	if config.Dimension <= 0 {
+		panic("dimension must be positive")
+	}
+	if config.NList <= 0 {
+		config.NList = 100
+	}
+	if config.NProbe <= 0 {
+		config.NProbe = 8
+	}
+	if config.DistanceName != "" {
+		fn, err := hnsw.LookupDistanceFunc(config.DistanceName)
+		if err != nil {
+			panic(err.Error())
+		}
+		config.DistanceFunc = fn
+	}
+	// 距离函数只按注册名记录：只给出函数时索引没有名字，保存时拒绝
+	if config.DistanceFunc == nil {
+		config.DistanceFunc = hnsw.L2Distance
+		config.DistanceName = hnsw.DistanceL2
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	return &IVFIndex{
+		dimension: config.Dimension,
+		nlist:     config.NList,
+		nprobe:    config.NProbe,
+		distFunc:  config.DistanceFunc,
+		distName:  config.DistanceName,
+		seed:      config.Seed,
+		trainSize: max(config.TrainSize, 0),
+	}
+}
+
+// Train learns the cluster centroids from a sample of vectors with k-means.
+// If the sample has fewer than nlist vectors, nlist shrinks to the sample size.
+// Vectors already in the index are reassigned to the new clusters.
+func (x *IVFIndex) Train(sample [][]float32) error {
+	if len(sample) == 0 {
+		return fmt.Errorf("%w: no training vectors", hnsw.ErrInvalidParameter)
+	}
+	for _, vector := range sample {
+		if len(vector) != x.dimension {
+			return hnsw.ErrDimensionMismatch
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.train(sample)
+	return nil
+}
+
+// train 用 sample 训练聚类中心并重新分配已有向量；调用方持有写锁
+func (x *IVFIndex) train(sample [][]float32) {
+	x.nlist = min(x.nlist, len(sample))
+	x.centroids = x.kmeans(sample, x.nlist)
+
+	// 重新分配已有向量
+	x.lists = make([][]int, x.nlist)
+	for id, vector := range x.vectors {
+		if !x.deleted[id] {
+			x.assign(id, vector)
+		}
+	}
+}
+
+// liveVectors 返回所有未删除的向量；调用方持有锁
+func (x *IVFIndex) liveVectors() [][]float32 {
+	live := make([][]float32, 0, len(x.vectors)-x.numDeleted)
+	for id, vector := range x.vectors {
+		if !x.deleted[id] {
+			live = append(live, vector)
+		}
+	}
+	return live
+}
+
+// kmeans 用 Lloyd 迭代把 sample 聚成 k 类，分配阶段使用索引的距离函数
+func (x *IVFIndex) kmeans(sample [][]float32, k int) [][]float32 {
+	rng := rand.New(rand.NewSource(x.seed))
+
+	// 随机选 k 个不同的样本作为初始中心
+	centroids := make([][]float32, k)
+	for c, i := range rng.Perm(len(sample))[:k] {
+		centroids[c] = append([]float32(nil), sample[i]...)
+	}
+
+	assignment := make([]int, len(sample))
+	counts := make([]int, k)
+	for iter := 0; iter < kmeansIterations; iter++ {
+		changed := false
+		for i, vector := range sample {
+			best := nearest(vector, centroids, x.distFunc)
+			if best != assignment[i] || iter == 0 {
+				changed = true
+			}
+			assignment[i] = best
+		}
+		if !changed {
+			break
+		}
+
+		clear(counts)
+		for _, centroid := range centroids {
+			clear(centroid)
+		}
+		for i, vector := range sample {
+			c := assignment[i]
+			counts[c]++
+			for j, v := range vector {
+				centroids[c][j] += v
+			}
+		}
+		for c, centroid := range centroids {
+			if counts[c] == 0 {
+				// 空簇重新随机取一个样本
+				copy(centroid, sample[rng.Intn(len(sample))])
+				continue
+			}
+			for j := range centroid {
+				centroid[j] /= float32(counts[c])
+			}
+		}
+	}
+
+	return centroids
+}
+
+// nearest 返回 centroids 中离 vector 最近的中心编号
+func nearest(vector []float32, centroids [][]float32, distFunc hnsw.DistanceFunc) int {
+	best, bestDist := 0, float32(math.Inf(1))
+	for c, centroid := range centroids {
+		if d := distFunc(vector, centroid); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// assign 把向量放入最近的簇；调用方持有写锁
+func (x *IVFIndex) assign(id int, vector []float32) {
+	c := nearest(vector, x.centroids, x.distFunc)
+	x.lists[c] = append(x.lists[c], id)
+	if id < len(x.assigned) {
+		x.assigned[id] = int32(c)
+	} else {
+		x.assigned = append(x.assigned, int32(c))
+	}
+}
+
+// Add inserts a vector into its nearest cluster and returns its assigned ID.
+// The index must be trained first, unless it was created with a TrainSize.
+func (x *IVFIndex) Add(vector []float32) (int, error) {
+	if len(vector) != x.dimension {
+		return -1, hnsw.ErrDimensionMismatch
+	}
+
+	vectorCopy := make([]float32, len(vector))
+	copy(vectorCopy, vector)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.centroids == nil {
+		if x.trainSize == 0 {
+			return -1, ErrNotTrained
+		}
+		// 训练之前只收集向量，不分配到簇；收集够 trainSize 个后用它们训练
+		id := len(x.vectors)
+		x.vectors = append(x.vectors, vectorCopy)
+		x.deleted = append(x.deleted, false)
+		x.assigned = append(x.assigned, -1)
+		if len(x.vectors)-x.numDeleted >= x.trainSize {
+			x.train(x.liveVectors())
+		}
+		return id, nil
+	}
+
+	id := len(x.vectors)
+	x.vectors = append(x.vectors, vectorCopy)
+	x.deleted = append(x.deleted, false)
+	x.assign(id, vectorCopy)
+	return id, nil
+}
+
+// Delete removes the vector with the given ID from its cluster. The vector
+// keeps its ID, which is never reused.
+func (x *IVFIndex) Delete(id int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if id < 0 || id >= len(x.vectors) {
+		return fmt.Errorf("%w: id %d (valid range: [0, %d))", hnsw.ErrNodeNotFound, id, len(x.vectors))
+	}
+	if x.deleted[id] {
+		return fmt.Errorf("%w: id %d", hnsw.ErrNodeDeleted, id)
+	}
+
+	// 训练之前收集的向量还不属于任何簇
+	if c := x.assigned[id]; c >= 0 {
+		list := x.lists[c]
+		for i, member := range list {
+			if member == id {
+				x.lists[c] = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+	}
+	x.deleted[id] = true
+	x.numDeleted++
+	return nil
+}
+
+// Search returns the k nearest neighbours of query among the vectors of the
+// nprobe clusters closest to it, sorted by distance. nprobe == 0 uses the
+// index default; larger values trade speed for recall. An index still
+// collecting vectors for automatic training scans all of them.
+func (x *IVFIndex) Search(query []float32, k int, nprobe int) ([]hnsw.SearchResult, error) {
+	if len(query) != x.dimension {
+		return nil, hnsw.ErrDimensionMismatch
+	}
+	if k <= 0 || nprobe < 0 {
+		return nil, hnsw.ErrInvalidParameter
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.centroids == nil && x.trainSize == 0 {
+		return nil, ErrNotTrained
+	}
+	if len(x.vectors) == 0 {
+		return nil, hnsw.ErrEmptyIndex
+	}
+
+	// 最大堆保留当前最近的 k 个
+	best := &resultHeap{}
+	consider := func(id int) {
+		dist := x.distFunc(query, x.vectors[id])
+		if best.Len() < k || dist < (*best)[0].Distance {
+			heap.Push(best, hnsw.SearchResult{ID: id, Distance: dist})
+			if best.Len() > k {
+				heap.Pop(best)
+			}
+		}
+	}
+
+	if x.centroids == nil {
+		// 自动训练之前向量还没有分到簇，精确扫描所有向量
+		for id := range x.vectors {
+			if !x.deleted[id] {
+				consider(id)
+			}
+		}
+		return popResults(best), nil
+	}
+
+	if nprobe == 0 {
+		nprobe = x.nprobe
+	}
+	nprobe = min(nprobe, len(x.centroids))
+
+	// 选出离 query 最近的 nprobe 个簇
+	clusters := make([]hnsw.SearchResult, len(x.centroids))
+	for c, centroid := range x.centroids {
+		clusters[c] = hnsw.SearchResult{ID: c, Distance: x.distFunc(query, centroid)}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Distance < clusters[j].Distance
+	})
+
+	for _, cluster := range clusters[:nprobe] {
+		for _, id := range x.lists[cluster.ID] {
+			consider(id)
+		}
+	}
+	return popResults(best), nil
+}
+
+// popResults 把最大堆中的结果按距离从近到远取出
+func popResults(best *resultHeap) []hnsw.SearchResult {
+	results := make([]hnsw.SearchResult, best.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(best).(hnsw.SearchResult)
+	}
+	return results
+}
+
+// Len returns the number of live (non-deleted) vectors in the index.
+func (x *IVFIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.vectors) - x.numDeleted
+}
+
+// NList returns the number of clusters.
+func (x *IVFIndex) NList() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.nlist
+}
+
+// resultHeap 是按距离排序的最大堆
+type resultHeap []hnsw.SearchResult
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].Distance > h[j].Distance }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *resultHeap) Push(x interface{}) {
+	*h = append(*h, x.(hnsw.SearchResult))
+}
+
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
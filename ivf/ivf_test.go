@@ -0,0 +1,167 @@
+package ivf
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"ollama-demo/hnsw"
+)
+
+func randomVectors(rng *rand.Rand, n int, dim int) [][]float32 {
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vector := make([]float32, dim)
+		for j := range vector {
+			vector[j] = rng.Float32()
+		}
+		vectors[i] = vector
+	}
+	return vectors
+}
+
+func TestIVFSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 2000, 16)
+	queries := randomVectors(rng, 50, 16)
+
+	index := NewIVF(Config{Dimension: 16, NList: 32, NProbe: 4, Seed: 1})
+	if _, err := index.Add(vectors[0]); !errors.Is(err, ErrNotTrained) {
+		t.Fatalf("Expected ErrNotTrained before Train, got %v", err)
+	}
+	if err := index.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+
+	flat := hnsw.NewFlatIndex(16, nil)
+	for i, vector := range vectors {
+		if id, err := index.Add(vector); err != nil || id != i {
+			t.Fatalf("Add returned (%d, %v), expected (%d, nil)", id, err, i)
+		}
+		flat.Add(vector)
+	}
+	if index.Len() != len(vectors) {
+		t.Fatalf("Expected %d vectors, got %d", len(vectors), index.Len())
+	}
+
+	truth, err := hnsw.GroundTruth(flat, queries, 10)
+	if err != nil {
+		t.Fatalf("GroundTruth failed: %v", err)
+	}
+
+	// 探测更多的簇召回率不应下降，探测全部簇时结果与暴力搜索一致
+	previous := 0.0
+	for _, nprobe := range []int{1, 4, 16, 32} {
+		recall := 0.0
+		for i, query := range queries {
+			results, err := index.Search(query, 10, nprobe)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			recall += hnsw.Recall(results, truth[i]) / float64(len(queries))
+		}
+		t.Logf("nprobe=%d recall=%.3f", nprobe, recall)
+		if recall < previous {
+			t.Errorf("Recall dropped from %.3f to %.3f at nprobe=%d", previous, recall, nprobe)
+		}
+		previous = recall
+	}
+	if previous < 0.999 {
+		t.Errorf("Expected exact results when probing every list, got recall %.3f", previous)
+	}
+
+	results, _ := index.Search(queries[0], 10, 0)
+	for i := 1; i < len(results); i++ {
+		if results[i].Distance < results[i-1].Distance {
+			t.Fatalf("Results not sorted by distance at %d", i)
+		}
+	}
+}
+
+func TestIVFErrors(t *testing.T) {
+	index := NewIVF(Config{Dimension: 4, NList: 8})
+
+	if _, err := index.Search([]float32{1, 2, 3, 4}, 1, 0); !errors.Is(err, ErrNotTrained) {
+		t.Errorf("Expected ErrNotTrained, got %v", err)
+	}
+
+	// 样本少于 nlist 时簇数随之减少
+	sample := [][]float32{{0, 0, 0, 0}, {1, 1, 1, 1}, {2, 2, 2, 2}}
+	if err := index.Train(sample); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if index.NList() != 3 {
+		t.Errorf("Expected nlist to shrink to 3, got %d", index.NList())
+	}
+
+	if _, err := index.Search([]float32{1, 2, 3, 4}, 1, 0); !errors.Is(err, hnsw.ErrEmptyIndex) {
+		t.Errorf("Expected ErrEmptyIndex, got %v", err)
+	}
+	if _, err := index.Add([]float32{1, 2}); !errors.Is(err, hnsw.ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch from Add, got %v", err)
+	}
+	if err := index.Train([][]float32{{1, 2}}); !errors.Is(err, hnsw.ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch from Train, got %v", err)
+	}
+	if _, err := index.Search([]float32{1, 2}, 1, 0); !errors.Is(err, hnsw.ErrDimensionMismatch) {
+		t.Errorf("Expected ErrDimensionMismatch from Search, got %v", err)
+	}
+
+	index.Add([]float32{1, 1, 1, 1})
+	if _, err := index.Search([]float32{1, 1, 1, 1}, 0, 0); !errors.Is(err, hnsw.ErrInvalidParameter) {
+		t.Errorf("Expected ErrInvalidParameter for k=0, got %v", err)
+	}
+}
+
+func TestIVFAutoTrain(t *testing.T) {
+	rng := rand.New(rand.NewSource(19))
+	vectors := randomVectors(rng, 600, 8)
+	index := NewIVF(Config{Dimension: 8, NList: 10, Seed: 3, TrainSize: 500})
+
+	// 收集够 TrainSize 个向量之前精确扫描
+	for _, vector := range vectors[:499] {
+		if _, err := index.Add(vector); err != nil {
+			t.Fatalf("Add before training failed: %v", err)
+		}
+	}
+	if err := index.Delete(3); err != nil {
+		t.Fatalf("Delete before training failed: %v", err)
+	}
+	if index.centroids != nil {
+		t.Fatal("Index trained before collecting TrainSize vectors")
+	}
+	results, err := index.Search(vectors[7], 3, 0)
+	if err != nil || results[0].ID != 7 || results[0].Distance != 0 {
+		t.Fatalf("Exact search before training returned %v, %v", results, err)
+	}
+	for _, r := range results {
+		if r.ID == 3 {
+			t.Error("Deleted vector returned before training")
+		}
+	}
+
+	// 删除的向量不计入，第 501 个向量加入时训练
+	index.Add(vectors[499])
+	if index.centroids != nil {
+		t.Fatal("Deleted vector counted towards TrainSize")
+	}
+	for _, vector := range vectors[500:] {
+		index.Add(vector)
+	}
+	if index.centroids == nil || index.NList() != 10 {
+		t.Fatalf("Index not trained after collecting TrainSize vectors")
+	}
+	if index.Len() != 599 {
+		t.Errorf("Expected 599 live vectors, got %d", index.Len())
+	}
+	assigned := 0
+	for _, list := range index.lists {
+		assigned += len(list)
+	}
+	if assigned != 599 {
+		t.Errorf("Expected all 599 live vectors in lists, got %d", assigned)
+	}
+	if results, _ := index.Search(vectors[550], 1, 10); results[0].ID != 550 {
+		t.Errorf("Expected vector 550 first after training, got %d", results[0].ID)
+	}
+}
@@ -0,0 +1,399 @@
+package ivf
+
+import (
+	"fmt"
+	"ollama-demo/hnsw"
+	"ollama-demo/lance/arrow"
+	"ollama-demo/lance/column"
+	"path/filepath"
+)
+
+// SchemaForCentroids 创建聚类中心存储的Schema，第 i 行是第 i 个簇的中心
+func SchemaForCentroids(dimension int) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("centroid", arrow.VectorType(dimension), false),
+	}, map[string]string{
+		"purpose":   "ivf_centroids",
+		"dimension": fmt.Sprintf("%d", dimension),
+	})
+}
+
+// SchemaForVectors 创建向量存储的Schema，list 列记录向量所属的簇
+func SchemaForVectors(dimension int) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("id", arrow.PrimInt32(), false),
+		arrow.NewField("vector", arrow.VectorType(dimension), false),
+		arrow.NewField("list", arrow.PrimInt32(), false),
+		arrow.NewField("deleted", arrow.PrimInt32(), false), // 墓碑标记：1 表示已删除
+	}, map[string]string{
+		"purpose":   "ivf_vectors",
+		"dimension": fmt.Sprintf("%d", dimension),
+	})
+}
+
+// SchemaForMetadata 创建元数据存储的Schema，距离函数名写入Schema元数据
+func SchemaForMetadata(distanceName string) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("nlist", arrow.PrimInt32(), false),
+		arrow.NewField("nprobe", arrow.PrimInt32(), false),
+		arrow.NewField("dimension", arrow.PrimInt32(), false),
+		arrow.NewField("numVectors", arrow.PrimInt32(), false),
+		arrow.NewField("seed", arrow.PrimInt64(), false),
+	}, map[string]string{
+		"purpose":    "ivf_metadata",
+		"distance":   distanceName,
+		"index_type": IndexType,
+	})
+}
+
+// indexMetadata 是 metadata.lance 中保存的索引配置
+type indexMetadata struct {
+	nlist      int
+	nprobe     int
+	dimension  int
+	numVectors int
+	seed       int64
+	distance   string
+}
+
+// SaveToLance 将IVF索引保存到Lance格式文件。
+// 自动训练的索引收集够向量之前保存时，先用已收集的向量训练，加载后的索引总是已训练的
+func (x *IVFIndex) SaveToLance(baseDir string) error {
+	x.mu.Lock()
+	if x.centroids == nil && x.trainSize > 0 && len(x.vectors) > x.numDeleted {
+		x.train(x.liveVectors())
+	}
+	x.mu.Unlock()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// 未注册的距离函数无法在加载时还原，提前拒绝
+	if x.distName == "" {
+		return fmt.Errorf("save metadata failed: %w: register it with RegisterDistanceFunc before saving", hnsw.ErrUnknownDistance)
+	}
+	if x.centroids == nil {
+		return fmt.Errorf("save centroids failed: %w", ErrNotTrained)
+	}
+
+	// 保存聚类中心
+	if err := x.saveCentroids(filepath.Join(baseDir, "centroids.lance")); err != nil {
+		return fmt.Errorf("save centroids failed: %w", err)
+	}
+
+	// 保存向量及其所属的簇
+	if err := x.saveVectors(filepath.Join(baseDir, "vectors.lance")); err != nil {
+		return fmt.Errorf("save vectors failed: %w", err)
+	}
+
+	// 保存元数据
+	if err := x.saveMetadata(filepath.Join(baseDir, "metadata.lance")); err != nil {
+		return fmt.Errorf("save metadata failed: %w", err)
+	}
+
+	// 同一目录中之前保存的 HNSW 索引的清单会让 LoadIndex 加载旧索引
+	return hnsw.RemoveHNSWFiles(baseDir)
+}
+
+// writeBatch 把单个RecordBatch写入文件
+func writeBatch(filename string, schema *arrow.Schema, numRows int, columns []arrow.Array) error {
+	batch, err := arrow.NewRecordBatch(schema, numRows, columns)
+	if err != nil {
+		return fmt.Errorf("create record batch failed: %w", err)
+	}
+
+	writer, err := column.NewWriter(filename, schema, column.DefaultSerializationOptions())
+	if err != nil {
+		return fmt.Errorf("create writer failed: %w", err)
+	}
+	defer writer.Close()
+
+	if err := writer.WriteRecordBatch(batch); err != nil {
+		return fmt.Errorf("write record batch failed: %w", err)
+	}
+	return nil
+}
+
+// flatten 把等长向量依次拼接成一个 FixedSizeList 列
+func flatten(vectors [][]float32, dimension int) arrow.Array {
+	values := make([]float32, 0, len(vectors)*dimension)
+	for _, vector := range vectors {
+		values = append(values, vector...)
+	}
+	listType := arrow.VectorType(dimension).(*arrow.FixedSizeListType)
+	return arrow.NewFixedSizeListArray(listType, arrow.NewFloat32Array(values, nil), nil)
+}
+
+// saveCentroids 保存所有聚类中心
+func (x *IVFIndex) saveCentroids(filename string) error {
+	return writeBatch(filename, SchemaForCentroids(x.dimension), len(x.centroids), []arrow.Array{
+		flatten(x.centroids, x.dimension),
+	})
+}
+
+// saveVectors 保存所有向量
+func (x *IVFIndex) saveVectors(filename string) error {
+	if len(x.vectors) == 0 {
+		return fmt.Errorf("no vectors to save")
+	}
+
+	ids := make([]int32, len(x.vectors))
+	deleted := make([]int32, len(x.vectors))
+	for i := range ids {
+		ids[i] = int32(i)
+		if x.deleted[i] {
+			deleted[i] = 1
+		}
+	}
+
+	return writeBatch(filename, SchemaForVectors(x.dimension), len(x.vectors), []arrow.Array{
+		arrow.NewInt32Array(ids, nil),
+		flatten(x.vectors, x.dimension),
+		arrow.NewInt32Array(x.assigned, nil),
+		arrow.NewInt32Array(deleted, nil),
+	})
+}
+
+// saveMetadata 保存IVF配置元数据
+func (x *IVFIndex) saveMetadata(filename string) error {
+	int32Column := func(v int) arrow.Array {
+		return arrow.NewInt32Array([]int32{int32(v)}, nil)
+	}
+
+	return writeBatch(filename, SchemaForMetadata(x.distName), 1, []arrow.Array{
+		int32Column(x.nlist),
+		int32Column(x.nprobe),
+		int32Column(x.dimension),
+		int32Column(len(x.vectors)),
+		arrow.NewInt64Array([]int64{x.seed}, nil),
+	})
+}
+
+// LoadIVFFromLance 从Lance格式文件加载IVF索引
+func LoadIVFFromLance(baseDir string) (*IVFIndex, error) {
+	metadata, err := loadMetadata(filepath.Join(baseDir, "metadata.lance"))
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+
+	distFunc, err := hnsw.LookupDistanceFunc(metadata.distance)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata failed: %w", err)
+	}
+
+	index := NewIVF(Config{
+		Dimension:    metadata.dimension,
+		NList:        metadata.nlist,
+		NProbe:       metadata.nprobe,
+		DistanceFunc: distFunc,
+		DistanceName: metadata.distance,
+		Seed:         metadata.seed,
+	})
+
+	if err := index.loadCentroids(filepath.Join(baseDir, "centroids.lance")); err != nil {
+		return nil, fmt.Errorf("load centroids failed: %w", err)
+	}
+
+	if err := index.loadVectors(filepath.Join(baseDir, "vectors.lance")); err != nil {
+		return nil, fmt.Errorf("load vectors failed: %w", err)
+	}
+	if len(index.vectors) != metadata.numVectors {
+		return nil, fmt.Errorf("load vectors failed: metadata records %d vectors, found %d",
+			metadata.numVectors, len(index.vectors))
+	}
+
+	return index, nil
+}
+
+// Save implements hnsw.VectorIndex by calling SaveToLance.
+func (x *IVFIndex) Save(baseDir string) error {
+	return x.SaveToLance(baseDir)
+}
+
+// Load implements hnsw.VectorIndex: it loads the index saved in baseDir with
+// LoadIVFFromLance and replaces the contents of x with it.
+func (x *IVFIndex) Load(baseDir string) error {
+	loaded, err := LoadIVFFromLance(baseDir)
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.dimension = loaded.dimension
+	x.nlist = loaded.nlist
+	x.nprobe = loaded.nprobe
+	x.centroids = loaded.centroids
+	x.lists = loaded.lists
+	x.vectors = loaded.vectors
+	x.assigned = loaded.assigned
+	x.deleted = loaded.deleted
+	x.numDeleted = loaded.numDeleted
+	x.distFunc = loaded.distFunc
+	x.distName = loaded.distName
+	x.seed = loaded.seed
+	return nil
+}
+
+// readBatch 读取文件中的RecordBatch和Schema元数据
+func readBatch(filename string) (*arrow.RecordBatch, map[string]string, error) {
+	reader, err := column.NewReader(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create reader failed: %w", err)
+	}
+	defer reader.Close()
+
+	batch, err := reader.ReadRecordBatch()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read record batch failed: %w", err)
+	}
+	return batch, reader.Schema().Metadata(), nil
+}
+
+// loadMetadata 加载元数据
+func loadMetadata(filename string) (*indexMetadata, error) {
+	batch, schemaMetadata, err := readBatch(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	int32Value := func(name string) (int, error) {
+		col, ok := batch.ColumnByName(name)
+		if !ok {
+			return 0, fmt.Errorf("metadata column %q missing", name)
+		}
+		array, ok := col.(*arrow.Int32Array)
+		if !ok || array.Len() == 0 {
+			return 0, fmt.Errorf("metadata column %q is not a non-empty int32 column", name)
+		}
+		return int(array.Value(0)), nil
+	}
+
+	metadata := &indexMetadata{distance: schemaMetadata["distance"]}
+	for name, dst := range map[string]*int{
+		"nlist":      &metadata.nlist,
+		"nprobe":     &metadata.nprobe,
+		"dimension":  &metadata.dimension,
+		"numVectors": &metadata.numVectors,
+	} {
+		if *dst, err = int32Value(name); err != nil {
+			return nil, err
+		}
+	}
+
+	col, ok := batch.ColumnByName("seed")
+	if !ok {
+		return nil, fmt.Errorf("metadata column %q missing", "seed")
+	}
+	seedArray, ok := col.(*arrow.Int64Array)
+	if !ok || seedArray.Len() == 0 {
+		return nil, fmt.Errorf("metadata column %q is not a non-empty int64 column", "seed")
+	}
+	metadata.seed = seedArray.Value(0)
+
+	return metadata, nil
+}
+
+// vectorColumn 取出 FixedSizeList<float32> 列的底层数据并检查维度
+func vectorColumn(batch *arrow.RecordBatch, name string, dimension int) ([]float32, error) {
+	col, ok := batch.ColumnByName(name)
+	if !ok {
+		return nil, fmt.Errorf("column %q missing", name)
+	}
+	list, ok := col.(*arrow.FixedSizeListArray)
+	if !ok {
+		return nil, fmt.Errorf("column %q is not a fixed size list column", name)
+	}
+	if list.ListSize() != dimension {
+		return nil, fmt.Errorf("%w: column %q has dimension %d, expected %d",
+			hnsw.ErrDimensionMismatch, name, list.ListSize(), dimension)
+	}
+	values, ok := list.Values().(*arrow.Float32Array)
+	if !ok {
+		return nil, fmt.Errorf("column %q is not a float32 list column", name)
+	}
+	return values.Values(), nil
+}
+
+// loadCentroids 加载聚类中心
+func (x *IVFIndex) loadCentroids(filename string) error {
+	batch, _, err := readBatch(filename)
+	if err != nil {
+		return err
+	}
+
+	values, err := vectorColumn(batch, "centroid", x.dimension)
+	if err != nil {
+		return err
+	}
+	numCentroids := len(values) / x.dimension
+	if numCentroids != x.nlist {
+		return fmt.Errorf("metadata records %d lists, found %d centroids", x.nlist, numCentroids)
+	}
+
+	x.centroids = make([][]float32, numCentroids)
+	for c := range x.centroids {
+		x.centroids[c] = append([]float32(nil), values[c*x.dimension:(c+1)*x.dimension]...)
+	}
+	x.lists = make([][]int, numCentroids)
+
+	return nil
+}
+
+// loadVectors 加载向量，并按保存的簇编号重建倒排列表
+func (x *IVFIndex) loadVectors(filename string) error {
+	batch, _, err := readBatch(filename)
+	if err != nil {
+		return err
+	}
+
+	values, err := vectorColumn(batch, "vector", x.dimension)
+	if err != nil {
+		return err
+	}
+	idCol, ok := batch.ColumnByName("id")
+	if !ok {
+		return fmt.Errorf("column %q missing", "id")
+	}
+	listCol, ok := batch.ColumnByName("list")
+	if !ok {
+		return fmt.Errorf("column %q missing", "list")
+	}
+	deletedCol, ok := batch.ColumnByName("deleted")
+	if !ok {
+		return fmt.Errorf("column %q missing", "deleted")
+	}
+	idArray, ok1 := idCol.(*arrow.Int32Array)
+	listArray, ok2 := listCol.(*arrow.Int32Array)
+	deletedArray, ok3 := deletedCol.(*arrow.Int32Array)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("id, list and deleted columns must be int32 columns")
+	}
+
+	numVectors := idArray.Len()
+	x.vectors = make([][]float32, numVectors)
+	x.assigned = make([]int32, numVectors)
+	x.deleted = make([]bool, numVectors)
+	for i := 0; i < numVectors; i++ {
+		// 验证向量ID的连续性
+		if id := int(idArray.Value(i)); id != i {
+			return fmt.Errorf("vector ID mismatch at index %d: expected %d, got %d", i, i, id)
+		}
+		x.vectors[i] = append([]float32(nil), values[i*x.dimension:(i+1)*x.dimension]...)
+		list := listArray.Value(i)
+		x.assigned[i] = list
+		if deletedArray.Value(i) != 0 {
+			// 已删除的向量不放回倒排列表；训练之前删除的向量没有所属的簇（-1）
+			x.deleted[i] = true
+			x.numDeleted++
+			continue
+		}
+		if list < 0 || int(list) >= len(x.lists) {
+			return fmt.Errorf("vector %d assigned to list %d, index has %d lists", i, list, len(x.lists))
+		}
+		x.lists[list] = append(x.lists[list], i)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package ivf
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ollama-demo/hnsw"
+)
+
+func TestIVFStorage(t *testing.T) {
+	tempDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(11))
+	vectors := randomVectors(rng, 500, 8)
+
+	index := NewIVF(Config{Dimension: 8, NList: 16, NProbe: 3, DistanceName: hnsw.DistanceCosine, Seed: 5})
+	if err := index.Train(vectors); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	for _, vector := range vectors {
+		index.Add(vector)
+	}
+
+	if err := index.SaveToLance(tempDir); err != nil {
+		t.Fatalf("Failed to save IVF: %v", err)
+	}
+	for _, filename := range []string{"centroids.lance", "vectors.lance", "metadata.lance"} {
+		if _, err := os.Stat(filepath.Join(tempDir, filename)); os.IsNotExist(err) {
+			t.Errorf("Expected file %s was not created", filename)
+		}
+	}
+
+	loaded, err := LoadIVFFromLance(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to load IVF: %v", err)
+	}
+	if loaded.Len() != index.Len() || loaded.NList() != index.NList() {
+		t.Fatalf("Loaded index has %d vectors in %d lists, expected %d in %d",
+			loaded.Len(), loaded.NList(), index.Len(), index.NList())
+	}
+	if loaded.distName != hnsw.DistanceCosine || loaded.seed != 5 || loaded.nprobe != 3 {
+		t.Errorf("Loaded config mismatch: distance %q, seed %d, nprobe %d", loaded.distName, loaded.seed, loaded.nprobe)
+	}
+
+	// 加载后的索引搜索结果与原索引一致
+	for _, query := range randomVectors(rng, 20, 8) {
+		expected, _ := index.Search(query, 5, 0)
+		got, err := loaded.Search(query, 5, 0)
+		if err != nil {
+			t.Fatalf("Search on loaded index failed: %v", err)
+		}
+		for i := range expected {
+			if got[i].ID != expected[i].ID || got[i].Distance != expected[i].Distance {
+				t.Fatalf("Result %d: expected %+v, got %+v", i, expected[i], got[i])
+			}
+		}
+	}
+
+	// 未训练的索引不能保存
+	untrained := NewIVF(Config{Dimension: 8})
+	if err := untrained.SaveToLance(t.TempDir()); !errors.Is(err, ErrNotTrained) {
+		t.Errorf("Expected ErrNotTrained, got %v", err)
+	}
+}
+
+func TestIVFLoadIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	vectors := randomVectors(rng, 300, 8)
+
+	// 只通过 VectorIndex 使用：不调用 Train，Add 和搜索照常工作
+	index, err := hnsw.NewIndex(IndexType, hnsw.Config{Dimension: 8, Seed: 2})
+	if err != nil {
+		t.Fatalf("NewIndex failed: %v", err)
+	}
+	for _, vector := range vectors {
+		if _, err := index.Add(vector); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if results, err := index.Search(vectors[9], 1, 0); err != nil || results[0].ID != 9 {
+		t.Fatalf("Search before training returned %v, %v", results, err)
+	}
+	if err := index.Delete(4); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := index.Delete(4); !errors.Is(err, hnsw.ErrNodeDeleted) {
+		t.Errorf("Expected ErrNodeDeleted, got %v", err)
+	}
+
+	// 目录中先保存一个 HNSW 索引：它的清单不能让 LoadIndex 加载到旧索引
+	dir := t.TempDir()
+	old := hnsw.NewHNSW(hnsw.Config{Dimension: 8, Seed: 2})
+	for _, vector := range vectors[:20] {
+		old.Add(vector)
+	}
+	if err := old.Save(dir); err != nil {
+		t.Fatalf("HNSW Save failed: %v", err)
+	}
+	if err := index.Save(dir); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := hnsw.LoadIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	if _, ok := loaded.(*IVFIndex); !ok {
+		t.Fatalf("Expected *IVFIndex, got %T", loaded)
+	}
+	if loaded.Len() != 299 {
+		t.Errorf("Expected 299 live vectors, got %d", loaded.Len())
+	}
+
+	if loaded.(*IVFIndex).centroids == nil {
+		t.Fatal("Save did not train the index")
+	}
+	results, err := loaded.(*IVFIndex).Search(vectors[4], 5, 1000)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, r := range results {
+		if r.ID == 4 {
+			t.Error("Deleted vector returned by search")
+		}
+	}
+}
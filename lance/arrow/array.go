@@ -81,6 +81,14 @@ func NewInt32Array(data []int32, nullBitmap *Bitmap) *Int32Array {
 	return &Int32Array{data: arrayData}
 }
 
+// NewInt32ArrayFromBuffer creates an int32 array backed by buf without copying.
+// buf holds little-endian values, must be 4-byte aligned, and must stay
+// valid and unmodified for the lifetime of the array.
+func NewInt32ArrayFromBuffer(buf *Buffer, nullBitmap *Bitmap) *Int32Array {
+	arrayData := NewArrayData(PrimInt32(), buf.Len()/4, []*Buffer{buf}, nullBitmap, nil)
+	return &Int32Array{data: arrayData}
+}
+
 func (a *Int32Array) DataType() DataType { return a.data.dtype }
 func (a *Int32Array) Len() int           { return a.data.length }
 func (a *Int32Array) NullN() int         { return a.data.nulls }
@@ -122,6 +130,14 @@ func NewInt64Array(data []int64, nullBitmap *Bitmap) *Int64Array {
 	return &Int64Array{data: arrayData}
 }
 
+// NewInt64ArrayFromBuffer creates an int64 array backed by buf without copying.
+// buf holds little-endian values, must be 8-byte aligned, and must stay
+// valid and unmodified for the lifetime of the array.
+func NewInt64ArrayFromBuffer(buf *Buffer, nullBitmap *Bitmap) *Int64Array {
+	arrayData := NewArrayData(PrimInt64(), buf.Len()/8, []*Buffer{buf}, nullBitmap, nil)
+	return &Int64Array{data: arrayData}
+}
+
 func (a *Int64Array) DataType() DataType { return a.data.dtype }
 func (a *Int64Array) Len() int           { return a.data.length }
 func (a *Int64Array) NullN() int         { return a.data.nulls }
@@ -143,6 +159,46 @@ func (a *Int64Array) Values() []int64 {
 	return a.data.buffers[0].Int64()
 }
 
+// --- Float16Array ---
+type Float16Array struct {
+	data *ArrayData
+}
+
+func NewFloat16Array(data []Float16, nullBitmap *Bitmap) *Float16Array {
+	buf := NewFloat16Buffer(data)
+	arrayData := NewArrayData(PrimFloat16(), len(data), []*Buffer{buf}, nullBitmap, nil)
+	return &Float16Array{data: arrayData}
+}
+
+// NewFloat16ArrayFromBuffer creates a half-precision array backed by buf without
+// copying. buf holds little-endian values, must be 2-byte aligned, and must
+// stay valid and unmodified for the lifetime of the array.
+func NewFloat16ArrayFromBuffer(buf *Buffer, nullBitmap *Bitmap) *Float16Array {
+	arrayData := NewArrayData(PrimFloat16(), buf.Len()/2, []*Buffer{buf}, nullBitmap, nil)
+	return &Float16Array{data: arrayData}
+}
+
+func (a *Float16Array) DataType() DataType { return a.data.dtype }
+func (a *Float16Array) Len() int           { return a.data.length }
+func (a *Float16Array) NullN() int         { return a.data.nulls }
+func (a *Float16Array) Data() *ArrayData   { return a.data }
+func (a *Float16Array) Release()           {}
+func (a *Float16Array) IsNull(i int) bool {
+	if a.data.nullBitmap == nil {
+		return false
+	}
+	return !a.data.nullBitmap.IsSet(i)
+}
+func (a *Float16Array) IsValid(i int) bool { return !a.IsNull(i) }
+
+func (a *Float16Array) Value(i int) Float16 {
+	return a.data.buffers[0].Float16()[i]
+}
+
+func (a *Float16Array) Values() []Float16 {
+	return a.data.buffers[0].Float16()
+}
+
 // --- Float32Array ---
 type Float32Array struct {
 	data *ArrayData
@@ -154,6 +210,14 @@ func NewFloat32Array(data []float32, nullBitmap *Bitmap) *Float32Array {
 	return &Float32Array{data: arrayData}
 }
 
+// NewFloat32ArrayFromBuffer creates a float32 array backed by buf without copying.
+// buf holds little-endian values, must be 4-byte aligned, and must stay
+// valid and unmodified for the lifetime of the array.
+func NewFloat32ArrayFromBuffer(buf *Buffer, nullBitmap *Bitmap) *Float32Array {
+	arrayData := NewArrayData(PrimFloat32(), buf.Len()/4, []*Buffer{buf}, nullBitmap, nil)
+	return &Float32Array{data: arrayData}
+}
+
 func (a *Float32Array) DataType() DataType { return a.data.dtype }
 func (a *Float32Array) Len() int           { return a.data.length }
 func (a *Float32Array) NullN() int         { return a.data.nulls }
@@ -186,6 +250,14 @@ func NewFloat64Array(data []float64, nullBitmap *Bitmap) *Float64Array {
 	return &Float64Array{data: arrayData}
 }
 
+// NewFloat64ArrayFromBuffer creates a float64 array backed by buf without copying.
+// buf holds little-endian values, must be 8-byte aligned, and must stay
+// valid and unmodified for the lifetime of the array.
+func NewFloat64ArrayFromBuffer(buf *Buffer, nullBitmap *Bitmap) *Float64Array {
+	arrayData := NewArrayData(PrimFloat64(), buf.Len()/8, []*Buffer{buf}, nullBitmap, nil)
+	return &Float64Array{data: arrayData}
+}
+
 func (a *Float64Array) DataType() DataType { return a.data.dtype }
 func (a *Float64Array) Len() int           { return a.data.length }
 func (a *Float64Array) NullN() int         { return a.data.nulls }
@@ -256,6 +328,8 @@ func (a *FixedSizeListArray) ValueSlice(i int) interface{} {
 	switch arr := a.values.(type) {
 	case *Float32Array:
 		return arr.Values()[start:end]
+	case *Float16Array:
+		return arr.Values()[start:end]
 	case *Int32Array:
 		return arr.Values()[start:end]
 	default:
@@ -284,6 +358,21 @@ func NewListArray(listType *ListType, offsets []int32, values Array, nullBitmap
 	}
 }
 
+// NewListArrayFromBuffer creates a variable-length list array that uses offsets
+// as its int32 offsets without copying them. offsets holds little-endian
+// values, must be 4-byte aligned, and must stay valid and unmodified for the
+// lifetime of the array.
+func NewListArrayFromBuffer(listType *ListType, offsets *Buffer, values Array, nullBitmap *Bitmap) *ListArray {
+	length := offsets.Len()/4 - 1 // number of lists
+	arrayData := NewArrayData(listType, length, []*Buffer{offsets}, nullBitmap, []*ArrayData{values.Data()})
+
+	return &ListArray{
+		data:    arrayData,
+		offsets: offsets,
+		values:  values,
+	}
+}
+
 func (a *ListArray) DataType() DataType { return a.data.dtype }
 func (a *ListArray) Len() int           { return a.data.length }
 func (a *ListArray) NullN() int         { return a.data.nulls }
@@ -312,3 +401,68 @@ func (a *ListArray) ValueOffsets(i int) (start, end int32) {
 	offsets := a.Offsets()
 	return offsets[i], offsets[i+1]
 }
+
+// --- StringArray (variable-length UTF-8) ---
+
+type StringArray struct {
+	data    *ArrayData
+	offsets *Buffer // int32 offsets, len = length + 1
+	values  *Buffer // concatenated UTF-8 bytes
+}
+
+// NewStringArray creates a string array. Null slots still occupy an (empty)
+// entry in values.
+func NewStringArray(values []string, nullBitmap *Bitmap) *StringArray {
+	offsets := make([]int32, len(values)+1)
+	size := 0
+	for i, v := range values {
+		size += len(v)
+		offsets[i+1] = int32(size)
+	}
+
+	data := make([]byte, 0, size)
+	for _, v := range values {
+		data = append(data, v...)
+	}
+
+	offsetBuf := NewInt32Buffer(offsets)
+	valueBuf := NewBufferBytes(data)
+	arrayData := NewArrayData(PrimString(), len(values), []*Buffer{offsetBuf, valueBuf}, nullBitmap, nil)
+	return &StringArray{
+		data:    arrayData,
+		offsets: offsetBuf,
+		values:  valueBuf,
+	}
+}
+
+func (a *StringArray) DataType() DataType { return a.data.dtype }
+func (a *StringArray) Len() int           { return a.data.length }
+func (a *StringArray) NullN() int         { return a.data.nulls }
+func (a *StringArray) Data() *ArrayData   { return a.data }
+func (a *StringArray) Release()           {}
+func (a *StringArray) IsNull(i int) bool {
+	if a.data.nullBitmap == nil {
+		return false
+	}
+	return !a.data.nullBitmap.IsSet(i)
+}
+func (a *StringArray) IsValid(i int) bool { return !a.IsNull(i) }
+
+// Value returns the string at index i
+func (a *StringArray) Value(i int) string {
+	if i < 0 || i >= a.Len() {
+		panic("index out of range")
+	}
+	offsets := a.offsets.Int32()
+	return string(a.values.Bytes()[offsets[i]:offsets[i+1]])
+}
+
+// Offsets returns the offset buffer
+func (a *StringArray) Offsets() []int32 {
+	return a.offsets.Int32()
+}
+
+// ValueBytes returns the concatenated bytes of all strings
+func (a *StringArray) ValueBytes() []byte {
+	return a.values.Bytes()
+}
@@ -1,6 +1,9 @@
 package arrow
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestInt32Array(t *testing.T) {
 	data := []int32{1, 2, 3, 4, 5}
@@ -75,6 +78,103 @@ func TestFloat32Array(t *testing.T) {
 	}
 }
 
+func TestFloat32ArrayFromBuffer(t *testing.T) {
+	buf := NewFloat32Buffer([]float32{1.5, 2.5, 3.5})
+	arr := NewFloat32ArrayFromBuffer(buf, nil)
+
+	if arr.Len() != 3 {
+		t.Errorf("expected length 3, got %d", arr.Len())
+	}
+
+	// The array shares the buffer's memory
+	buf.Float32()[1] = 9
+	if arr.Value(1) != 9 {
+		t.Errorf("expected array to reflect buffer writes, got %f", arr.Value(1))
+	}
+	if &arr.Values()[0] != &buf.Float32()[0] {
+		t.Error("expected Values to alias the buffer")
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	cases := []struct {
+		in   float32
+		bits Float16
+		out  float32
+	}{
+		{0, 0x0000, 0},
+		{1, 0x3c00, 1},
+		{-2, 0xc000, -2},
+		{0.5, 0x3800, 0.5},
+		{65504, 0x7bff, 65504},                // largest normal
+		{65520, 0x7c00, float32(math.Inf(1))}, // rounds up to Inf
+		{1e10, 0x7c00, float32(math.Inf(1))},  // overflow
+		{float32(math.Inf(-1)), 0xfc00, float32(math.Inf(-1))},
+		{6.103515625e-05, 0x0400, 6.103515625e-05}, // smallest normal
+		{5.9604645e-08, 0x0001, 5.9604645e-08},     // smallest subnormal
+		{2e-8, 0x0000, 0},                          // underflow
+		{1 + 1.0/2048, 0x3c00, 1},                  // tie rounds to even
+		{1 + 3.0/2048, 0x3c02, 1 + 2.0/1024},       // tie rounds to even
+		{0.1, 0x2e66, 0.0999755859375},
+	}
+	for _, c := range cases {
+		h := NewFloat16(c.in)
+		if h != c.bits {
+			t.Errorf("NewFloat16(%v) = %#04x, want %#04x", c.in, uint16(h), uint16(c.bits))
+		}
+		if got := h.Float32(); got != c.out {
+			t.Errorf("Float16(%#04x).Float32() = %v, want %v", uint16(h), got, c.out)
+		}
+	}
+	if !math.IsNaN(float64(NewFloat16(float32(math.NaN())).Float32())) {
+		t.Error("expected NaN to stay NaN")
+	}
+
+	// Every half-precision value converts to float32 and back unchanged
+	for bits := 0; bits < 1<<16; bits++ {
+		h := Float16(bits)
+		if f := h.Float32(); !math.IsNaN(float64(f)) && NewFloat16(f) != h {
+			t.Fatalf("%#04x -> %v -> %#04x", bits, f, uint16(NewFloat16(f)))
+		}
+	}
+}
+
+func TestFloat16ArrayFromBuffer(t *testing.T) {
+	data := Float16sFromFloat32(nil, []float32{1.5, -2.5, 3})
+	buf := NewFloat16Buffer(data)
+	arr := NewFloat16ArrayFromBuffer(buf, nil)
+
+	if arr.Len() != 3 {
+		t.Errorf("expected length 3, got %d", arr.Len())
+	}
+	if got := Float16sToFloat32(nil, arr.Values()); got[0] != 1.5 || got[1] != -2.5 || got[2] != 3 {
+		t.Errorf("expected [1.5 -2.5 3], got %v", got)
+	}
+	if &arr.Values()[0] != &buf.Float16()[0] {
+		t.Error("expected Values to alias the buffer")
+	}
+
+	listType := Float16VectorType(3).(*FixedSizeListType)
+	list := NewFixedSizeListArray(listType, arr, nil)
+	if vec := list.ValueSlice(0).([]Float16); len(vec) != 3 || vec[1] != data[1] {
+		t.Errorf("unexpected vector %v", vec)
+	}
+}
+
+func TestInt32ArrayFromBufferWithNulls(t *testing.T) {
+	bitmap := NewBitmap(3)
+	bitmap.Set(0)
+	bitmap.Set(2)
+	arr := NewInt32ArrayFromBuffer(NewInt32Buffer([]int32{7, 0, 9}), bitmap)
+
+	if arr.Len() != 3 || arr.NullN() != 1 {
+		t.Errorf("expected length 3 with 1 null, got %d with %d", arr.Len(), arr.NullN())
+	}
+	if !arr.IsNull(1) || arr.Value(2) != 9 {
+		t.Errorf("unexpected values: null(1)=%v value(2)=%d", arr.IsNull(1), arr.Value(2))
+	}
+}
+
 func TestInt64Array(t *testing.T) {
 	data := []int64{100, 200, 300, 400}
 	arr := NewInt64Array(data, nil)
@@ -208,6 +308,31 @@ func TestListArray(t *testing.T) {
 	}
 }
 
+func TestStringArray(t *testing.T) {
+	data := []string{"alpha", "", "数据块", "z"}
+	nullBitmap := NewBitmapAllSet(4)
+	nullBitmap.Clear(1)
+	arr := NewStringArray(data, nullBitmap)
+
+	if arr.DataType().ID() != STRING {
+		t.Errorf("expected STRING type, got %v", arr.DataType().ID())
+	}
+	if arr.Len() != 4 || arr.NullN() != 1 || !arr.IsNull(1) {
+		t.Errorf("expected length 4 with null at 1, got length %d, %d nulls", arr.Len(), arr.NullN())
+	}
+
+	for i, expected := range data {
+		if arr.Value(i) != expected {
+			t.Errorf("element %d: expected %q, got %q", i, expected, arr.Value(i))
+		}
+	}
+
+	offsets := arr.Offsets()
+	if len(offsets) != 5 || offsets[4] != int32(len(arr.ValueBytes())) {
+		t.Errorf("unexpected offsets %v for %d value bytes", offsets, len(arr.ValueBytes()))
+	}
+}
+
 func TestArrayValueOutOfBounds(t *testing.T) {
 	data := []int32{1, 2, 3}
 	arr := NewInt32Array(data, nil)
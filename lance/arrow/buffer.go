@@ -33,6 +33,16 @@ func (b *Buffer) Len() int {
 	return len(b.buf)
 }
 
+// IsAligned reports whether the buffer starts at a multiple of align bytes,
+// as the typed views require for elements of that size. Empty buffers are
+// always aligned.
+func (b *Buffer) IsAligned(align int) bool {
+	if len(b.buf) == 0 {
+		return true
+	}
+	return uintptr(unsafe.Pointer(&b.buf[0]))%uintptr(align) == 0
+}
+
 // Resize changes the buffer size (may allocate new memory)
 func (b *Buffer) Resize(newSize int) {
 	if newSize > len(b.buf) {
@@ -68,6 +78,17 @@ func (b *Buffer) Int64() []int64 {
 	return unsafe.Slice((*int64)(unsafe.Pointer(&b.buf[0])), len(b.buf)/8)
 }
 
+// Float16 returns a half-precision view of the buffer
+func (b *Buffer) Float16() []Float16 {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	if len(b.buf)%2 != 0 {
+		panic(fmt.Sprintf("buffer size %d not aligned to float16", len(b.buf)))
+	}
+	return unsafe.Slice((*Float16)(unsafe.Pointer(&b.buf[0])), len(b.buf)/2)
+}
+
 // Float32 returns a float32 view of the buffer
 func (b *Buffer) Float32() []float32 {
 	if len(b.buf) == 0 {
@@ -110,6 +131,15 @@ func NewInt64Buffer(data []int64) *Buffer {
 	return &Buffer{buf: buf}
 }
 
+// NewFloat16Buffer creates a buffer from half-precision slice
+func NewFloat16Buffer(data []Float16) *Buffer {
+	buf := make([]byte, len(data)*2)
+	for i, v := range data {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(v))
+	}
+	return &Buffer{buf: buf}
+}
+
 // NewFloat32Buffer creates a buffer from float32 slice
 func NewFloat32Buffer(data []float32) *Buffer {
 	buf := make([]byte, len(data)*4)
@@ -92,6 +92,24 @@ func TestBufferFloat64View(t *testing.T) {
 	}
 }
 
+func TestBufferIsAligned(t *testing.T) {
+	buf := NewBuffer(16)
+	if !buf.IsAligned(8) {
+		t.Error("expected allocated buffer to be 8-byte aligned")
+	}
+
+	view := NewBufferBytes(buf.Bytes()[1:])
+	if view.IsAligned(4) {
+		t.Error("expected buffer at odd offset to be unaligned")
+	}
+	if !view.IsAligned(1) {
+		t.Error("expected every buffer to be 1-byte aligned")
+	}
+	if !NewBufferBytes(nil).IsAligned(8) {
+		t.Error("expected empty buffer to be aligned")
+	}
+}
+
 // Test empty buffer handling (critical fix verification)
 func TestBufferEmptyInt32(t *testing.T) {
 	buf := NewBuffer(0)
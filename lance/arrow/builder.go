@@ -148,6 +148,75 @@ func (b *Int64Builder) NewArray() Array {
 
 func (b *Int64Builder) Release() {}
 
+// --- Float16Builder ---
+
+type Float16Builder struct {
+	data     []Float16
+	nulls    *Bitmap
+	hasNulls bool
+}
+
+func NewFloat16Builder() *Float16Builder {
+	return &Float16Builder{
+		data:  make([]Float16, 0, 16),
+		nulls: NewBitmap(0),
+	}
+}
+
+func (b *Float16Builder) Reserve(n int) {
+	if cap(b.data)-len(b.data) < n {
+		newCap := len(b.data) + n
+		newData := make([]Float16, len(b.data), newCap)
+		copy(newData, b.data)
+		b.data = newData
+	}
+}
+
+func (b *Float16Builder) Append(v Float16) {
+	b.data = append(b.data, v)
+	if b.hasNulls {
+		b.nulls.Resize(len(b.data))
+		b.nulls.Set(len(b.data) - 1)
+	}
+}
+
+// AppendFloat32 appends v rounded to the nearest half-precision value.
+func (b *Float16Builder) AppendFloat32(v float32) {
+	b.Append(NewFloat16(v))
+}
+
+func (b *Float16Builder) AppendNull() {
+	if !b.hasNulls {
+		b.hasNulls = true
+		b.nulls = NewBitmap(len(b.data))
+		b.nulls.SetAll()
+	}
+	b.data = append(b.data, 0)
+	b.nulls.Resize(len(b.data))
+	b.nulls.Clear(len(b.data) - 1)
+}
+
+func (b *Float16Builder) Len() int {
+	return len(b.data)
+}
+
+func (b *Float16Builder) NewArray() Array {
+	var nullBitmap *Bitmap
+	if b.hasNulls {
+		nullBitmap = b.nulls
+	}
+
+	arr := NewFloat16Array(b.data, nullBitmap)
+
+	b.data = make([]Float16, 0, 16)
+	b.nulls = NewBitmap(0)
+	b.hasNulls = false
+
+	return arr
+}
+
+func (b *Float16Builder) Release() {}
+
 // --- Float32Builder ---
 
 type Float32Builder struct {
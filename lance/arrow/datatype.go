@@ -15,6 +15,7 @@ const (
 	FIXED_SIZE_LIST
 	LIST
 	STRUCT
+	FLOAT16
 )
 
 // DataType represents the type of data stored in a column
@@ -44,6 +45,12 @@ func (t *Float32Type) ID() TypeID     { return FLOAT32 }
 func (t *Float32Type) Name() string   { return "float32" }
 func (t *Float32Type) ByteWidth() int { return 4 }
 
+type Float16Type struct{}
+
+func (t *Float16Type) ID() TypeID     { return FLOAT16 }
+func (t *Float16Type) Name() string   { return "float16" }
+func (t *Float16Type) ByteWidth() int { return 2 }
+
 type Float64Type struct{}
 
 func (t *Float64Type) ID() TypeID     { return FLOAT64 }
@@ -109,6 +116,7 @@ func (t *StructType) Fields() []Field { return t.fields }
 
 func PrimInt32() DataType   { return &Int32Type{} }
 func PrimInt64() DataType   { return &Int64Type{} }
+func PrimFloat16() DataType { return &Float16Type{} }
 func PrimFloat32() DataType { return &Float32Type{} }
 func PrimFloat64() DataType { return &Float64Type{} }
 func PrimBinary() DataType  { return &BinaryType{} }
@@ -130,3 +138,8 @@ func StructOf(fields []Field) DataType {
 func VectorType(dim int) DataType {
 	return FixedSizeListOf(PrimFloat32(), dim)
 }
+
+// Float16VectorType creates a fixed-size half-precision vector type
+func Float16VectorType(dim int) DataType {
+	return FixedSizeListOf(PrimFloat16(), dim)
+}
@@ -0,0 +1,107 @@
+package arrow
+
+import "math"
+
+// Float16 is an IEEE 754 half-precision floating-point number, stored as its
+// bit pattern. It has 11 significant bits and a range of about ±65504, and is
+// used to halve the storage of embeddings.
+type Float16 uint16
+
+// NewFloat16 converts f to the nearest half-precision value, rounding ties to
+// even. Values beyond the half-precision range become infinities, values too
+// small for it become (signed) zero, and NaN stays NaN.
+func NewFloat16(f float32) Float16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	// Inf and NaN; NaN keeps a quiet bit so it does not turn into Inf
+	if exp == 0xff {
+		if mant != 0 {
+			return Float16(sign | 0x7e00)
+		}
+		return Float16(sign | 0x7c00)
+	}
+
+	// Rebias the exponent from 127 to 15
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return Float16(sign | 0x7c00)
+	}
+
+	if e <= 0 {
+		// Subnormal result: the value is mant * 2^-24 with the implicit bit made explicit
+		if e < -10 {
+			return Float16(sign)
+		}
+		mant |= 0x800000
+		shift := uint(14 - e)
+		half := mant >> shift
+		rest := mant & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if rest > halfway || (rest == halfway && half&1 == 1) {
+			half++ // may carry into the smallest normal, which is still correct
+		}
+		return Float16(sign | uint16(half))
+	}
+
+	// Normal result: keep the top 10 mantissa bits and round the remaining 13
+	half := uint32(e)<<10 | mant>>13
+	rest := mant & 0x1fff
+	if rest > 0x1000 || (rest == 0x1000 && half&1 == 1) {
+		half++ // a carry out of the mantissa bumps the exponent, up to Inf
+	}
+	return Float16(sign | uint16(half))
+}
+
+// Float32 returns h as a float32. The conversion is exact.
+func (h Float16) Float32() float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Subnormal: shift the mantissa until the implicit bit appears
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	default:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+	}
+}
+
+// Float16sFromFloat32 converts src to half precision, reusing dst if it has
+// enough capacity.
+func Float16sFromFloat32(dst []Float16, src []float32) []Float16 {
+	if cap(dst) < len(src) {
+		dst = make([]Float16, len(src))
+	}
+	dst = dst[:len(src)]
+	for i, v := range src {
+		dst[i] = NewFloat16(v)
+	}
+	return dst
+}
+
+// Float16sToFloat32 converts src to float32, reusing dst if it has enough
+// capacity.
+func Float16sToFloat32(dst []float32, src []Float16) []float32 {
+	if cap(dst) < len(src) {
+		dst = make([]float32, len(src))
+	}
+	dst = dst[:len(src)]
+	for i, v := range src {
+		dst[i] = v.Float32()
+	}
+	return dst
+}
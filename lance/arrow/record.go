@@ -158,6 +158,8 @@ func NewBuilderForType(dtype DataType) Builder {
 		return NewInt32Builder()
 	case INT64:
 		return NewInt64Builder()
+	case FLOAT16:
+		return NewFloat16Builder()
 	case FLOAT32:
 		return NewFloat32Builder()
 	case FLOAT64:
@@ -0,0 +1,23 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package column
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of file into memory on platforms without
+// mmap support, so mapped readers behave the same without sharing pages.
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(file, 0, size), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases a mapping created by mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
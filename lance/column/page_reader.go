@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"ollama-demo/lance/arrow"
 	"ollama-demo/lance/format"
 )
@@ -37,6 +38,8 @@ func (r *PageReader) deserializeArray(data []byte, dataType arrow.DataType, numV
 		return r.deserializeInt32Array(data, numValues)
 	case arrow.INT64:
 		return r.deserializeInt64Array(data, numValues)
+	case arrow.FLOAT16:
+		return r.deserializeFloat16Array(data, numValues)
 	case arrow.FLOAT32:
 		return r.deserializeFloat32Array(data, numValues)
 	case arrow.FLOAT64:
@@ -44,6 +47,11 @@ func (r *PageReader) deserializeArray(data []byte, dataType arrow.DataType, numV
 	case arrow.FIXED_SIZE_LIST:
 		listType := dataType.(*arrow.FixedSizeListType)
 		return r.deserializeFixedSizeListArray(data, listType, numValues)
+	case arrow.LIST:
+		listType := dataType.(*arrow.ListType)
+		return r.deserializeListArray(data, listType, numValues)
+	case arrow.STRING:
+		return r.deserializeStringArray(data, numValues)
 	default:
 		return nil, fmt.Errorf("unsupported data type: %s", dataType.Name())
 	}
@@ -133,6 +141,105 @@ func (r *PageReader) deserializeInt64Array(data []byte, numValues int) (*arrow.I
 	return arrow.NewInt64Array(values, nullBitmap), nil
 }
 
+// deserializeStringArray deserializes StringArray
+func (r *PageReader) deserializeStringArray(data []byte, numValues int) (*arrow.StringArray, error) {
+	reader := bytes.NewReader(data)
+
+	var hasNulls bool
+	if err := binary.Read(reader, binary.LittleEndian, &hasNulls); err != nil {
+		return nil, err
+	}
+
+	var nullBitmap *arrow.Bitmap
+	if hasNulls {
+		var bitmapBytes int32
+		if err := binary.Read(reader, binary.LittleEndian, &bitmapBytes); err != nil {
+			return nil, err
+		}
+
+		bitmapData := make([]byte, bitmapBytes)
+		if _, err := reader.Read(bitmapData); err != nil {
+			return nil, err
+		}
+
+		nullBitmap = arrow.NewBitmap(numValues)
+		copy(nullBitmap.Bytes(), bitmapData)
+	}
+
+	var offsetCount int32
+	if err := binary.Read(reader, binary.LittleEndian, &offsetCount); err != nil {
+		return nil, err
+	}
+	if offsetCount < 1 {
+		return nil, fmt.Errorf("invalid string offset count %d", offsetCount)
+	}
+	offsets := make([]int32, offsetCount)
+	if err := binary.Read(reader, binary.LittleEndian, offsets); err != nil {
+		return nil, err
+	}
+
+	var dataLen int32
+	if err := binary.Read(reader, binary.LittleEndian, &dataLen); err != nil {
+		return nil, err
+	}
+	stringData := make([]byte, dataLen)
+	if _, err := io.ReadFull(reader, stringData); err != nil {
+		return nil, err
+	}
+
+	values := make([]string, offsetCount-1)
+	for i := range values {
+		start, end := offsets[i], offsets[i+1]
+		if start < 0 || start > end || end > dataLen {
+			return nil, fmt.Errorf("invalid string offsets [%d, %d) at index %d", start, end, i)
+		}
+		values[i] = string(stringData[start:end])
+	}
+
+	return arrow.NewStringArray(values, nullBitmap), nil
+}
+
+// deserializeFloat16Array deserializes Float16Array
+func (r *PageReader) deserializeFloat16Array(data []byte, numValues int) (*arrow.Float16Array, error) {
+	reader := bytes.NewReader(data)
+
+	var hasNulls bool
+	if err := binary.Read(reader, binary.LittleEndian, &hasNulls); err != nil {
+		return nil, err
+	}
+
+	var nullBitmap *arrow.Bitmap
+	if hasNulls {
+		var bitmapBytes int32
+		if err := binary.Read(reader, binary.LittleEndian, &bitmapBytes); err != nil {
+			return nil, err
+		}
+
+		bitmapData := make([]byte, bitmapBytes)
+		if _, err := io.ReadFull(reader, bitmapData); err != nil {
+			return nil, err
+		}
+
+		nullBitmap = arrow.NewBitmap(numValues)
+		copy(nullBitmap.Bytes(), bitmapData)
+	}
+
+	var valCount int32
+	if err := binary.Read(reader, binary.LittleEndian, &valCount); err != nil {
+		return nil, err
+	}
+	if valCount < 0 || int(valCount) > reader.Len()/2 {
+		return nil, fmt.Errorf("invalid float16 value count %d", valCount)
+	}
+
+	values := make([]arrow.Float16, valCount)
+	if err := binary.Read(reader, binary.LittleEndian, values); err != nil {
+		return nil, err
+	}
+
+	return arrow.NewFloat16Array(values, nullBitmap), nil
+}
+
 // deserializeFloat32Array deserializes Float32Array
 func (r *PageReader) deserializeFloat32Array(data []byte, numValues int) (*arrow.Float32Array, error) {
 	reader := bytes.NewReader(data)
@@ -297,7 +404,273 @@ func (r *PageReader) deserializeFixedSizeListArray(data []byte, listType *arrow.
 		valuesArray := arrow.NewInt32Array(values, nil)
 		return arrow.NewFixedSizeListArray(listType, valuesArray, nullBitmap), nil
 
+	case arrow.FLOAT16:
+		var totalValues int32
+		if err := binary.Read(reader, binary.LittleEndian, &totalValues); err != nil {
+			return nil, err
+		}
+		if totalValues < 0 || int(totalValues) > reader.Len()/2 {
+			return nil, fmt.Errorf("invalid float16 value count %d", totalValues)
+		}
+
+		values := make([]arrow.Float16, totalValues)
+		if err := binary.Read(reader, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+
+		valuesArray := arrow.NewFloat16Array(values, nil)
+		return arrow.NewFixedSizeListArray(listType, valuesArray, nullBitmap), nil
+
 	default:
 		return nil, fmt.Errorf("unsupported FixedSizeList element type: %s", elemType.Name())
 	}
 }
+
+// deserializeListArray deserializes ListArray
+func (r *PageReader) deserializeListArray(data []byte, listType *arrow.ListType, numValues int) (*arrow.ListArray, error) {
+	reader := bytes.NewReader(data)
+
+	var hasNulls bool
+	if err := binary.Read(reader, binary.LittleEndian, &hasNulls); err != nil {
+		return nil, err
+	}
+
+	var nullBitmap *arrow.Bitmap
+	if hasNulls {
+		var bitmapBytes int32
+		if err := binary.Read(reader, binary.LittleEndian, &bitmapBytes); err != nil {
+			return nil, err
+		}
+
+		bitmapData := make([]byte, bitmapBytes)
+		if _, err := io.ReadFull(reader, bitmapData); err != nil {
+			return nil, err
+		}
+
+		nullBitmap = arrow.NewBitmap(numValues)
+		copy(nullBitmap.Bytes(), bitmapData)
+	}
+
+	var offsetCount int32
+	if err := binary.Read(reader, binary.LittleEndian, &offsetCount); err != nil {
+		return nil, err
+	}
+	if offsetCount < 1 || int(offsetCount) > reader.Len()/4 {
+		return nil, fmt.Errorf("invalid list offset count %d", offsetCount)
+	}
+	offsets := make([]int32, offsetCount)
+	if err := binary.Read(reader, binary.LittleEndian, offsets); err != nil {
+		return nil, err
+	}
+
+	var totalValues int32
+	if err := binary.Read(reader, binary.LittleEndian, &totalValues); err != nil {
+		return nil, err
+	}
+	if err := checkListOffsets(offsets, int(totalValues)); err != nil {
+		return nil, err
+	}
+	if int(totalValues) > reader.Len()/4 {
+		return nil, fmt.Errorf("invalid list value count %d", totalValues)
+	}
+
+	var values arrow.Array
+	switch listType.Elem().ID() {
+	case arrow.INT32:
+		data := make([]int32, totalValues)
+		if err := binary.Read(reader, binary.LittleEndian, data); err != nil {
+			return nil, err
+		}
+		values = arrow.NewInt32Array(data, nil)
+	case arrow.FLOAT32:
+		data := make([]float32, totalValues)
+		if err := binary.Read(reader, binary.LittleEndian, data); err != nil {
+			return nil, err
+		}
+		values = arrow.NewFloat32Array(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported List element type: %s", listType.Elem().Name())
+	}
+
+	return arrow.NewListArray(listType, offsets, values, nullBitmap), nil
+}
+
+// checkListOffsets verifies that list offsets start at 0, never decrease and
+// end at the number of values
+func checkListOffsets(offsets []int32, numValues int) error {
+	if offsets[0] != 0 || int(offsets[len(offsets)-1]) != numValues {
+		return fmt.Errorf("invalid list offsets: [%d, %d] for %d values", offsets[0], offsets[len(offsets)-1], numValues)
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			return fmt.Errorf("invalid list offsets: %d follows %d at index %d", offsets[i], offsets[i-1], i)
+		}
+	}
+	return nil
+}
+
+// viewPage is ReadPage for pages whose data stays valid and unmodified for the
+// lifetime of the returned array, such as pages of a mapped file. Fixed-width
+// values (and list offsets) that are aligned for their type are referenced in
+// place; other pages are deserialized as by ReadPage.
+func (r *PageReader) viewPage(page *format.Page, dataType arrow.DataType) (arrow.Array, error) {
+	if page == nil {
+		return nil, fmt.Errorf("page is nil")
+	}
+
+	layout, ok := parseLayout(page.Data, dataType)
+	if !ok {
+		return r.ReadPage(page, dataType)
+	}
+	buf := arrow.NewBufferBytes(page.Data[layout.values:layout.end:layout.end])
+	if !buf.IsAligned(layout.size) {
+		return r.ReadPage(page, dataType)
+	}
+
+	var nullBitmap *arrow.Bitmap
+	if layout.bitmap != nil {
+		nullBitmap = arrow.NewBitmap(int(page.NumValues))
+		copy(nullBitmap.Bytes(), layout.bitmap)
+	}
+
+	switch t := dataType.(type) {
+	case *arrow.Int32Type:
+		return arrow.NewInt32ArrayFromBuffer(buf, nullBitmap), nil
+	case *arrow.Int64Type:
+		return arrow.NewInt64ArrayFromBuffer(buf, nullBitmap), nil
+	case *arrow.Float16Type:
+		return arrow.NewFloat16ArrayFromBuffer(buf, nullBitmap), nil
+	case *arrow.Float32Type:
+		return arrow.NewFloat32ArrayFromBuffer(buf, nullBitmap), nil
+	case *arrow.Float64Type:
+		return arrow.NewFloat64ArrayFromBuffer(buf, nullBitmap), nil
+	case *arrow.FixedSizeListType:
+		return arrow.NewFixedSizeListArray(t, viewValues(buf, t.Elem()), nullBitmap), nil
+	case *arrow.ListType:
+		offsetBuf := arrow.NewBufferBytes(layout.offsets)
+		if int(page.NumValues) != len(layout.offsets)/4-1 {
+			return nil, fmt.Errorf("list offset count %d does not match %d lists", len(layout.offsets)/4, page.NumValues)
+		}
+		if err := checkListOffsets(offsetBuf.Int32(), layout.count); err != nil {
+			return nil, err
+		}
+		return arrow.NewListArrayFromBuffer(t, offsetBuf, viewValues(buf, t.Elem()), nullBitmap), nil
+	default:
+		return r.ReadPage(page, dataType)
+	}
+}
+
+// viewValues wraps the flattened values of a list page of element type elem
+func viewValues(buf *arrow.Buffer, elem arrow.DataType) arrow.Array {
+	switch elem.ID() {
+	case arrow.FLOAT32:
+		return arrow.NewFloat32ArrayFromBuffer(buf, nil)
+	case arrow.FLOAT16:
+		return arrow.NewFloat16ArrayFromBuffer(buf, nil)
+	default:
+		return arrow.NewInt32ArrayFromBuffer(buf, nil)
+	}
+}
+
+// pageLayout locates the parts of the data of a page of fixed-width values
+type pageLayout struct {
+	bitmap  []byte // Null bitmap, nil if the page has no nulls
+	offsets []byte // List offsets, nil unless the page holds a ListArray
+	values  int    // Offset of the values
+	end     int    // Offset just past the values
+	count   int    // Number of values (flattened for lists)
+	size    int    // Size of a value in bytes
+}
+
+// parseLayout locates the null bitmap, list offsets and values in the data of
+// a page of type dataType. ok is false for types without fixed-width values
+// and for malformed pages. FixedSizeList pages start with the list size; all
+// pages then hold the null flag and optional bitmap; lists follow with their
+// offsets and FixedSizeList with the number of lists; last come the value
+// count and the values.
+func parseLayout(data []byte, dataType arrow.DataType) (pageLayout, bool) {
+	var layout pageLayout
+	int32At := func(pos int) (int, bool) {
+		if pos < 0 || pos+4 > len(data) {
+			return 0, false
+		}
+		return int(int32(binary.LittleEndian.Uint32(data[pos:]))), true
+	}
+
+	pos := 0
+	switch t := dataType.(type) {
+	case *arrow.Float16Type:
+		layout.size = 2
+	case *arrow.Int32Type, *arrow.Float32Type:
+		layout.size = 4
+	case *arrow.Int64Type, *arrow.Float64Type:
+		layout.size = 8
+	case *arrow.FixedSizeListType:
+		if listSize, ok := int32At(0); !ok || listSize != t.Size() {
+			return layout, false
+		}
+		layout.size = listElemSize(t.Elem())
+		pos += 4
+	case *arrow.ListType:
+		layout.size = listElemSize(t.Elem())
+	}
+	if layout.size == 0 || pos >= len(data) {
+		return layout, false
+	}
+
+	hasNulls := data[pos] != 0
+	pos++
+	if hasNulls {
+		bitmapBytes, ok := int32At(pos)
+		if !ok || bitmapBytes < 0 || pos+4+bitmapBytes > len(data) {
+			return layout, false
+		}
+		layout.bitmap = data[pos+4 : pos+4+bitmapBytes]
+		pos += 4 + bitmapBytes
+	}
+
+	switch dataType.ID() {
+	case arrow.FIXED_SIZE_LIST:
+		pos += 4
+	case arrow.LIST:
+		offsetCount, ok := int32At(pos)
+		if !ok || offsetCount < 1 || offsetCount > (len(data)-pos-4)/4 {
+			return layout, false
+		}
+		pos += 4
+		layout.offsets = data[pos : pos+4*offsetCount : pos+4*offsetCount]
+		pos += 4 * offsetCount
+	}
+
+	count, ok := int32At(pos)
+	if !ok || count < 0 || count > (len(data)-pos-4)/layout.size {
+		return layout, false
+	}
+	layout.count = count
+	layout.values = pos + 4
+	layout.end = layout.values + count*layout.size
+	return layout, true
+}
+
+// listElemSize returns the size of the list element types pages can hold, 0
+// for other types. Only FixedSizeList pages hold float16 values.
+func listElemSize(elem arrow.DataType) int {
+	switch elem.ID() {
+	case arrow.FLOAT16:
+		return 2
+	case arrow.INT32, arrow.FLOAT32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// valuesOffset returns the offset of the fixed-width values in the data of a
+// page of type dataType, or -1 if the page has no such values
+func valuesOffset(data []byte, dataType arrow.DataType) int {
+	layout, ok := parseLayout(data, dataType)
+	if !ok {
+		return -1
+	}
+	return layout.values
+}
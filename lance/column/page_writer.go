@@ -53,12 +53,18 @@ func (w *PageWriter) serializeArray(array arrow.Array) ([]byte, error) {
 		return w.serializeInt32Array(arr)
 	case *arrow.Int64Array:
 		return w.serializeInt64Array(arr)
+	case *arrow.Float16Array:
+		return w.serializeFloat16Array(arr)
 	case *arrow.Float32Array:
 		return w.serializeFloat32Array(arr)
 	case *arrow.Float64Array:
 		return w.serializeFloat64Array(arr)
 	case *arrow.FixedSizeListArray:
 		return w.serializeFixedSizeListArray(arr)
+	case *arrow.ListArray:
+		return w.serializeListArray(arr)
+	case *arrow.StringArray:
+		return w.serializeStringArray(arr)
 	default:
 		return nil, fmt.Errorf("unsupported array type: %T", array)
 	}
@@ -130,6 +136,74 @@ func (w *PageWriter) serializeInt64Array(array *arrow.Int64Array) ([]byte, error
 	return buf.Bytes(), nil
 }
 
+// serializeStringArray serializes StringArray as offsets followed by the
+// concatenated UTF-8 bytes
+func (w *PageWriter) serializeStringArray(array *arrow.StringArray) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	hasNulls := array.NullN() > 0
+	if err := binary.Write(buf, binary.LittleEndian, hasNulls); err != nil {
+		return nil, err
+	}
+
+	if hasNulls {
+		nullBitmap := array.Data().NullBitmap()
+		bitmapBytes := (array.Len() + 7) / 8
+		if err := binary.Write(buf, binary.LittleEndian, int32(bitmapBytes)); err != nil {
+			return nil, err
+		}
+		buf.Write(nullBitmap.Bytes()[:bitmapBytes])
+	}
+
+	// Write offsets (length + 1 values)
+	offsets := array.Offsets()
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(offsets))); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, offsets); err != nil {
+		return nil, err
+	}
+
+	// Write string bytes
+	data := array.ValueBytes()
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(data))); err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+
+	return buf.Bytes(), nil
+}
+
+// serializeFloat16Array serializes Float16Array with the layout of the other
+// fixed-width arrays, two bytes per value
+func (w *PageWriter) serializeFloat16Array(array *arrow.Float16Array) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	hasNulls := array.NullN() > 0
+	if err := binary.Write(buf, binary.LittleEndian, hasNulls); err != nil {
+		return nil, err
+	}
+
+	if hasNulls {
+		nullBitmap := array.Data().NullBitmap()
+		bitmapBytes := (array.Len() + 7) / 8
+		if err := binary.Write(buf, binary.LittleEndian, int32(bitmapBytes)); err != nil {
+			return nil, err
+		}
+		buf.Write(nullBitmap.Bytes()[:bitmapBytes])
+	}
+
+	values := array.Values()
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(values))); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, values); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 // serializeFloat32Array serializes Float32Array
 func (w *PageWriter) serializeFloat32Array(array *arrow.Float32Array) ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -252,9 +326,73 @@ func (w *PageWriter) serializeFixedSizeListArray(array *arrow.FixedSizeListArray
 				return nil, err
 			}
 		}
+	case *arrow.Float16Array:
+		values := arr.Values()
+		if err := binary.Write(buf, binary.LittleEndian, int32(len(values))); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unsupported FixedSizeList element type: %T", valuesArray)
 	}
 
 	return buf.Bytes(), nil
 }
+
+// serializeListArray serializes ListArray as offsets followed by the flattened
+// values, like StringArray. Offsets are rebased to start at 0.
+func (w *PageWriter) serializeListArray(array *arrow.ListArray) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	hasNulls := array.NullN() > 0
+	if err := binary.Write(buf, binary.LittleEndian, hasNulls); err != nil {
+		return nil, err
+	}
+
+	if hasNulls {
+		nullBitmap := array.Data().NullBitmap()
+		bitmapBytes := (array.Len() + 7) / 8
+		if err := binary.Write(buf, binary.LittleEndian, int32(bitmapBytes)); err != nil {
+			return nil, err
+		}
+		buf.Write(nullBitmap.Bytes()[:bitmapBytes])
+	}
+
+	// Write offsets (length + 1 values)
+	offsets := array.Offsets()
+	first, last := offsets[0], offsets[len(offsets)-1]
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(offsets))); err != nil {
+		return nil, err
+	}
+	for _, offset := range offsets {
+		if err := binary.Write(buf, binary.LittleEndian, offset-first); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write flattened values
+	switch arr := array.Values().(type) {
+	case *arrow.Int32Array:
+		values := arr.Values()[first:last]
+		if err := binary.Write(buf, binary.LittleEndian, int32(len(values))); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+	case *arrow.Float32Array:
+		values := arr.Values()[first:last]
+		if err := binary.Write(buf, binary.LittleEndian, int32(len(values))); err != nil {
+			return nil, err
+		}
+		if err := binary.Write(buf, binary.LittleEndian, values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported List element type: %T", arr)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -1,6 +1,7 @@
 package column
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"ollama-demo/lance/arrow"
@@ -11,6 +12,8 @@ import (
 // Reader reads RecordBatch data from a Lance file
 type Reader struct {
 	file       *os.File
+	size       int64  // File size in bytes
+	data       []byte // Mapped file contents, nil unless opened with NewMappedReader
 	header     *format.Header
 	footer     *format.Footer
 	pageReader *PageReader
@@ -24,36 +27,98 @@ func NewReader(filename string) (*Reader, error) {
 		return nil, fmt.Errorf("open file failed: %w", err)
 	}
 
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("stat file failed: %w", err)
+	}
+
 	reader := &Reader{
 		file:       file,
+		size:       fileInfo.Size(),
 		pageReader: NewPageReader(),
 		closed:     false,
 	}
 
-	// Read header
-	if err := reader.readHeader(); err != nil {
+	if err := reader.readMetadata(); err != nil {
 		file.Close()
-		return nil, fmt.Errorf("read header failed: %w", err)
+		return nil, err
 	}
 
-	// Read footer
-	if err := reader.readFooter(); err != nil {
-		file.Close()
-		return nil, fmt.Errorf("read footer failed: %w", err)
+	return reader, nil
+}
+
+// NewMappedReader creates a column reader over a read-only memory mapping of
+// the file. Single-page INT32, INT64, FLOAT16, FLOAT32, FLOAT64 and FixedSizeList
+// columns whose values are aligned in the file are returned as views of the
+// mapping rather than copies, and page checksums are not verified. Arrays read
+// from the reader must not be used after Close.
+func NewMappedReader(filename string) (*Reader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open file failed: %w", err)
+	}
+	// The mapping stays valid after the file is closed
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat file failed: %w", err)
+	}
+
+	data, err := mapFile(file, fileInfo.Size())
+	if err != nil {
+		return nil, fmt.Errorf("map file failed: %w", err)
+	}
+
+	reader := &Reader{
+		size:       fileInfo.Size(),
+		data:       data,
+		pageReader: NewPageReader(),
+		closed:     false,
+	}
+
+	if err := reader.readMetadata(); err != nil {
+		unmapFile(data)
+		return nil, err
 	}
 
 	return reader, nil
 }
 
+// readMetadata reads the header and footer
+func (r *Reader) readMetadata() error {
+	if err := r.readHeader(); err != nil {
+		return fmt.Errorf("read header failed: %w", err)
+	}
+
+	if err := r.readFooter(); err != nil {
+		return fmt.Errorf("read footer failed: %w", err)
+	}
+
+	return nil
+}
+
+// section returns a reader of the file contents from offset to the end
+func (r *Reader) section(offset int64) (io.Reader, error) {
+	if offset < 0 || offset > r.size {
+		return nil, fmt.Errorf("offset %d out of range (file size %d)", offset, r.size)
+	}
+	if r.data != nil {
+		return bytes.NewReader(r.data[offset:]), nil
+	}
+	return io.NewSectionReader(r.file, offset, r.size-offset), nil
+}
+
 // readHeader reads the file header
 func (r *Reader) readHeader() error {
-	// Seek to beginning
-	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+	src, err := r.section(0)
+	if err != nil {
 		return err
 	}
 
 	r.header = &format.Header{}
-	if _, err := r.header.ReadFrom(r.file); err != nil {
+	if _, err := r.header.ReadFrom(src); err != nil {
 		return err
 	}
 
@@ -62,20 +127,14 @@ func (r *Reader) readHeader() error {
 
 // readFooter reads the file footer
 func (r *Reader) readFooter() error {
-	// Get file size
-	fileInfo, err := r.file.Stat()
+	// Footer is the last FooterSize bytes
+	src, err := r.section(r.size - format.FooterSize)
 	if err != nil {
 		return err
 	}
 
-	// Seek to footer (last FooterSize bytes)
-	footerOffset := fileInfo.Size() - format.FooterSize
-	if _, err := r.file.Seek(footerOffset, io.SeekStart); err != nil {
-		return err
-	}
-
 	r.footer = &format.Footer{}
-	if _, err := r.footer.ReadFrom(r.file); err != nil {
+	if _, err := r.footer.ReadFrom(src); err != nil {
 		return err
 	}
 
@@ -142,7 +201,12 @@ func (r *Reader) readColumn(columnIndex int32) (arrow.Array, error) {
 			return nil, fmt.Errorf("read page failed: %w", err)
 		}
 
-		array, err := r.pageReader.ReadPage(page, field.Type)
+		var array arrow.Array
+		if r.data != nil && len(pageIndices) == 1 {
+			array, err = r.pageReader.viewPage(page, field.Type)
+		} else {
+			array, err = r.pageReader.ReadPage(page, field.Type)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("deserialize page failed: %w", err)
 		}
@@ -174,12 +238,18 @@ func (r *Reader) mergeArrays(arrays []arrow.Array, dataType arrow.DataType) (arr
 		return r.mergeInt32Arrays(arrays)
 	case arrow.INT64:
 		return r.mergeInt64Arrays(arrays)
+	case arrow.FLOAT16:
+		return r.mergeFloat16Arrays(arrays)
 	case arrow.FLOAT32:
 		return r.mergeFloat32Arrays(arrays)
 	case arrow.FLOAT64:
 		return r.mergeFloat64Arrays(arrays)
 	case arrow.FIXED_SIZE_LIST:
 		return r.mergeFixedSizeListArrays(arrays, dataType.(*arrow.FixedSizeListType))
+	case arrow.LIST:
+		return r.mergeListArrays(arrays, dataType.(*arrow.ListType))
+	case arrow.STRING:
+		return r.mergeStringArrays(arrays)
 	default:
 		return nil, fmt.Errorf("unsupported array type for merging: %s", dataType.Name())
 	}
@@ -236,6 +306,59 @@ func (r *Reader) mergeInt64Arrays(arrays []arrow.Array) (arrow.Array, error) {
 	return builder.NewArray(), nil
 }
 
+// mergeStringArrays merges multiple StringArray into one
+func (r *Reader) mergeStringArrays(arrays []arrow.Array) (arrow.Array, error) {
+	totalSize := 0
+	for _, arr := range arrays {
+		totalSize += arr.Len()
+	}
+
+	values := make([]string, 0, totalSize)
+	nullBitmap := arrow.NewBitmap(totalSize)
+	hasNulls := false
+	for _, arr := range arrays {
+		stringArr := arr.(*arrow.StringArray)
+		for i := 0; i < stringArr.Len(); i++ {
+			if stringArr.IsNull(i) {
+				hasNulls = true
+			} else {
+				nullBitmap.Set(len(values))
+			}
+			values = append(values, stringArr.Value(i))
+		}
+	}
+
+	if !hasNulls {
+		nullBitmap = nil
+	}
+	return arrow.NewStringArray(values, nullBitmap), nil
+}
+
+// mergeFloat16Arrays merges multiple Float16Array into one
+func (r *Reader) mergeFloat16Arrays(arrays []arrow.Array) (arrow.Array, error) {
+	builder := arrow.NewFloat16Builder()
+	defer builder.Release()
+
+	totalSize := 0
+	for _, arr := range arrays {
+		totalSize += arr.Len()
+	}
+	builder.Reserve(totalSize)
+
+	for _, arr := range arrays {
+		float16Arr := arr.(*arrow.Float16Array)
+		for i := 0; i < float16Arr.Len(); i++ {
+			if float16Arr.IsNull(i) {
+				builder.AppendNull()
+			} else {
+				builder.Append(float16Arr.Value(i))
+			}
+		}
+	}
+
+	return builder.NewArray(), nil
+}
+
 // mergeFloat32Arrays merges multiple Float32Array into one
 func (r *Reader) mergeFloat32Arrays(arrays []arrow.Array) (arrow.Array, error) {
 	builder := arrow.NewFloat32Builder()
@@ -285,69 +408,127 @@ func (r *Reader) mergeFloat64Arrays(arrays []arrow.Array) (arrow.Array, error) {
 	return builder.NewArray(), nil
 }
 
-// mergeFixedSizeListArrays merges multiple FixedSizeListArray into one
+// mergeFixedSizeListArrays merges multiple FixedSizeListArray into one,
+// keeping the element type: int32 elements such as packed codes must not be
+// converted to float32, which is exact only up to 2^24
 func (r *Reader) mergeFixedSizeListArrays(arrays []arrow.Array, listType *arrow.FixedSizeListType) (arrow.Array, error) {
-	builder := arrow.NewFixedSizeListBuilder(listType)
-	defer builder.Release()
-
 	totalSize := 0
 	for _, arr := range arrays {
 		totalSize += arr.Len()
 	}
-	builder.Reserve(totalSize)
 
+	nullBitmap := arrow.NewBitmap(totalSize)
+	hasNulls := false
+	var int32Values []int32
+	var float32Values []float32
+	var float16Values []arrow.Float16
+	row := 0
 	for _, arr := range arrays {
 		listArr := arr.(*arrow.FixedSizeListArray)
-
 		for i := 0; i < listArr.Len(); i++ {
 			if listArr.IsNull(i) {
-				builder.AppendNull()
+				hasNulls = true
 			} else {
-				// Get values for this list
-				values := r.getFixedSizeListValues(listArr, i)
-				builder.AppendValues(values)
+				nullBitmap.Set(row)
 			}
+			row++
+		}
+
+		// Null lists keep their placeholder values so list i starts at i*size
+		switch values := listArr.Values().(type) {
+		case *arrow.Int32Array:
+			int32Values = append(int32Values, values.Values()...)
+		case *arrow.Float32Array:
+			float32Values = append(float32Values, values.Values()...)
+		case *arrow.Float16Array:
+			float16Values = append(float16Values, values.Values()...)
 		}
 	}
 
-	return builder.NewArray(), nil
-}
+	var values arrow.Array
+	switch listType.Elem().ID() {
+	case arrow.INT32:
+		values = arrow.NewInt32Array(int32Values, nil)
+	case arrow.FLOAT32:
+		values = arrow.NewFloat32Array(float32Values, nil)
+	case arrow.FLOAT16:
+		values = arrow.NewFloat16Array(float16Values, nil)
+	default:
+		return nil, fmt.Errorf("unsupported FixedSizeList element type for merging: %s", listType.Elem().Name())
+	}
+	if values.Len() != totalSize*listType.Size() {
+		return nil, fmt.Errorf("fixed size list pages hold %d values, expected %d", values.Len(), totalSize*listType.Size())
+	}
 
-// getFixedSizeListValues extracts values from a FixedSizeListArray at index i
-func (r *Reader) getFixedSizeListValues(arr *arrow.FixedSizeListArray, index int) []float32 {
-	listSize := arr.ListSize()
-	values := make([]float32, listSize)
+	if !hasNulls {
+		nullBitmap = nil
+	}
+	return arrow.NewFixedSizeListArray(listType, values, nullBitmap), nil
+}
 
-	// Get the underlying values array
-	valuesArray := arr.Values()
+// mergeListArrays merges multiple ListArray into one
+func (r *Reader) mergeListArrays(arrays []arrow.Array, listType *arrow.ListType) (arrow.Array, error) {
+	totalSize := 0
+	for _, arr := range arrays {
+		totalSize += arr.Len()
+	}
 
-	// Calculate offset in values array
-	startOffset := index * listSize
+	offsets := make([]int32, 1, totalSize+1)
+	nullBitmap := arrow.NewBitmap(totalSize)
+	hasNulls := false
+	var int32Values []int32
+	var float32Values []float32
+	for _, arr := range arrays {
+		listArr := arr.(*arrow.ListArray)
+		for i := 0; i < listArr.Len(); i++ {
+			if listArr.IsNull(i) {
+				hasNulls = true
+			} else {
+				nullBitmap.Set(len(offsets) - 1)
+			}
 
-	switch valArr := valuesArray.(type) {
-	case *arrow.Float32Array:
-		for j := 0; j < listSize; j++ {
-			values[j] = valArr.Value(startOffset + j)
-		}
-	case *arrow.Int32Array:
-		for j := 0; j < listSize; j++ {
-			values[j] = float32(valArr.Value(startOffset + j))
+			start, end := listArr.ValueOffsets(i)
+			switch values := listArr.Values().(type) {
+			case *arrow.Int32Array:
+				int32Values = append(int32Values, values.Values()[start:end]...)
+			case *arrow.Float32Array:
+				float32Values = append(float32Values, values.Values()[start:end]...)
+			}
+			offsets = append(offsets, int32(len(int32Values)+len(float32Values)))
 		}
 	}
 
-	return values
+	var values arrow.Array
+	switch listType.Elem().ID() {
+	case arrow.INT32:
+		values = arrow.NewInt32Array(int32Values, nil)
+	case arrow.FLOAT32:
+		values = arrow.NewFloat32Array(float32Values, nil)
+	default:
+		return nil, fmt.Errorf("unsupported List element type for merging: %s", listType.Elem().Name())
+	}
+
+	if !hasNulls {
+		nullBitmap = nil
+	}
+	return arrow.NewListArray(listType, offsets, values, nullBitmap), nil
 }
 
 // readPage reads a single page from the file
 func (r *Reader) readPage(pageIndex format.PageIndex) (*format.Page, error) {
-	// Seek to page offset
-	if _, err := r.file.Seek(pageIndex.Offset, io.SeekStart); err != nil {
+	src, err := r.section(pageIndex.Offset)
+	if err != nil {
 		return nil, err
 	}
 
+	// Pages of mapped files reference the mapping
+	if r.data != nil {
+		return format.PageView(r.data[pageIndex.Offset:])
+	}
+
 	// Read page
 	page := &format.Page{}
-	if _, err := page.ReadFrom(r.file); err != nil {
+	if _, err := page.ReadFrom(src); err != nil {
 		return nil, err
 	}
 
@@ -361,5 +542,8 @@ func (r *Reader) Close() error {
 	}
 
 	r.closed = true
+	if r.data != nil {
+		return unmapFile(r.data)
+	}
 	return r.file.Close()
 }
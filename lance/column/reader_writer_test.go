@@ -2,10 +2,12 @@ package column
 
 import (
 	"fmt"
+	"math"
 	"ollama-demo/lance/arrow"
 	"os"
 	"path/filepath"
 	"testing"
+	"unsafe"
 )
 
 // ====================
@@ -121,6 +123,84 @@ func TestPageWriterReader_Int64Array(t *testing.T) {
 	}
 }
 
+func TestPageWriterReader_StringArray(t *testing.T) {
+	nullBitmap := arrow.NewBitmapAllSet(4)
+	nullBitmap.Clear(2)
+	originalArray := arrow.NewStringArray([]string{"doc-1", "", "", "长一点的键"}, nullBitmap)
+
+	writer := NewPageWriter(DefaultSerializationOptions())
+	pages, err := writer.WritePages(originalArray, 0)
+	if err != nil {
+		t.Fatalf("WritePages failed: %v", err)
+	}
+
+	reader := NewPageReader()
+	resultArray, err := reader.ReadPage(pages[0], arrow.PrimString())
+	if err != nil {
+		t.Fatalf("ReadPage failed: %v", err)
+	}
+
+	if !arraysEqual(originalArray, resultArray) {
+		t.Errorf("arrays not equal after roundtrip")
+	}
+}
+
+func TestWriterReader_StringColumnMultipleBatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test_strings.lance")
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "key", Type: arrow.PrimString(), Nullable: false},
+	}, nil)
+
+	writer, err := NewWriter(filename, schema, DefaultSerializationOptions())
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	var allValues []string
+	for batchNum := 0; batchNum < 3; batchNum++ {
+		values := make([]string, 20)
+		for i := range values {
+			values[i] = fmt.Sprintf("key-%d-%d", batchNum, i)
+		}
+		allValues = append(allValues, values...)
+
+		batch, err := arrow.NewRecordBatch(schema, len(values), []arrow.Array{arrow.NewStringArray(values, nil)})
+		if err != nil {
+			t.Fatalf("NewRecordBatch failed: %v", err)
+		}
+		if err := writer.WriteRecordBatch(batch); err != nil {
+			t.Fatalf("WriteRecordBatch %d failed: %v", batchNum, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failed: %v", err)
+	}
+
+	reader, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	resultBatch, err := reader.ReadRecordBatch()
+	if err != nil {
+		t.Fatalf("ReadRecordBatch failed: %v", err)
+	}
+
+	resultArray := resultBatch.Column(0).(*arrow.StringArray)
+	if resultArray.Len() != len(allValues) {
+		t.Fatalf("expected %d values, got %d", len(allValues), resultArray.Len())
+	}
+	for i, expected := range allValues {
+		if resultArray.Value(i) != expected {
+			t.Errorf("value mismatch at index %d: expected %q, got %q", i, expected, resultArray.Value(i))
+		}
+	}
+}
+
 func TestPageWriterReader_Float32Array(t *testing.T) {
 	builder := arrow.NewFloat32Builder()
 	defer builder.Release()
@@ -150,6 +230,38 @@ func TestPageWriterReader_Float32Array(t *testing.T) {
 	}
 }
 
+func TestPageWriterReader_Float16Array(t *testing.T) {
+	builder := arrow.NewFloat16Builder()
+	defer builder.Release()
+
+	for _, v := range []float32{1.5, -2.25, 65504} {
+		builder.AppendFloat32(v)
+	}
+	builder.AppendNull()
+	builder.AppendFloat32(0.1)
+
+	originalArray := builder.NewArray()
+
+	writer := NewPageWriter(DefaultSerializationOptions())
+	pages, err := writer.WritePages(originalArray, 0)
+	if err != nil {
+		t.Fatalf("WritePages failed: %v", err)
+	}
+
+	reader := NewPageReader()
+	resultArray, err := reader.ReadPage(pages[0], arrow.PrimFloat16())
+	if err != nil {
+		t.Fatalf("ReadPage failed: %v", err)
+	}
+
+	if !arraysEqual(originalArray, resultArray) {
+		t.Errorf("arrays not equal after roundtrip")
+	}
+	if v := resultArray.(*arrow.Float16Array).Value(2).Float32(); v != 65504 {
+		t.Errorf("expected 65504, got %v", v)
+	}
+}
+
 func TestPageWriterReader_Float64Array(t *testing.T) {
 	builder := arrow.NewFloat64Builder()
 	defer builder.Release()
@@ -262,6 +374,240 @@ func TestPageWriterReader_FixedSizeListArray_WithNulls(t *testing.T) {
 	}
 }
 
+func TestPageWriterReader_ListArray(t *testing.T) {
+	// [[1, 2], null, [], [3, 4, 5]], with offsets that do not start at 0
+	values := arrow.NewInt32Array([]int32{9, 9, 1, 2, 3, 4, 5}, nil)
+	nullBitmap := arrow.NewBitmap(4)
+	nullBitmap.Set(0)
+	nullBitmap.Set(2)
+	nullBitmap.Set(3)
+
+	listType := arrow.ListOf(arrow.PrimInt32()).(*arrow.ListType)
+	originalArray := arrow.NewListArray(listType, []int32{2, 4, 4, 4, 7}, values, nullBitmap)
+
+	// Roundtrip
+	writer := NewPageWriter(DefaultSerializationOptions())
+	pages, err := writer.WritePages(originalArray, 0)
+	if err != nil {
+		t.Fatalf("WritePages failed: %v", err)
+	}
+
+	reader := NewPageReader()
+	resultArray, err := reader.ReadPage(pages[0], listType)
+	if err != nil {
+		t.Fatalf("ReadPage failed: %v", err)
+	}
+
+	if !arraysEqual(originalArray, resultArray) {
+		t.Errorf("arrays not equal after roundtrip")
+	}
+	if offsets := resultArray.(*arrow.ListArray).Offsets(); offsets[0] != 0 || offsets[4] != 5 {
+		t.Errorf("expected offsets rebased to [0, 5], got %v", offsets)
+	}
+}
+
+func TestWriterReader_ListColumnMultipleBatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test_lists.lance")
+
+	listType := arrow.ListOf(arrow.PrimInt32()).(*arrow.ListType)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "neighbors", Type: listType, Nullable: false},
+	}, nil)
+
+	writer, err := NewWriter(filename, schema, DefaultSerializationOptions())
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	var expected [][]int32
+	for batchNum := 0; batchNum < 3; batchNum++ {
+		offsets := []int32{0}
+		var values []int32
+		for i := 0; i < 10; i++ {
+			list := make([]int32, (batchNum+i)%4)
+			for j := range list {
+				list[j] = int32(batchNum*100 + i*10 + j)
+			}
+			expected = append(expected, list)
+			values = append(values, list...)
+			offsets = append(offsets, int32(len(values)))
+		}
+
+		array := arrow.NewListArray(listType, offsets, arrow.NewInt32Array(values, nil), nil)
+		batch, err := arrow.NewRecordBatch(schema, array.Len(), []arrow.Array{array})
+		if err != nil {
+			t.Fatalf("NewRecordBatch failed: %v", err)
+		}
+		if err := writer.WriteRecordBatch(batch); err != nil {
+			t.Fatalf("WriteRecordBatch %d failed: %v", batchNum, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failed: %v", err)
+	}
+
+	reader, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	resultBatch, err := reader.ReadRecordBatch()
+	if err != nil {
+		t.Fatalf("ReadRecordBatch failed: %v", err)
+	}
+
+	resultArray := resultBatch.Column(0).(*arrow.ListArray)
+	if resultArray.Len() != len(expected) {
+		t.Fatalf("expected %d lists, got %d", len(expected), resultArray.Len())
+	}
+	values := resultArray.Values().(*arrow.Int32Array).Values()
+	for i, list := range expected {
+		start, end := resultArray.ValueOffsets(i)
+		if fmt.Sprint(values[start:end]) != fmt.Sprint(list) {
+			t.Errorf("list %d: expected %v, got %v", i, list, values[start:end])
+		}
+	}
+}
+
+func TestWriterReader_Int32FixedSizeListMultipleBatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test_codes.lance")
+
+	// Packed codes use the full int32 range, which float32 cannot represent exactly
+	listType := arrow.FixedSizeListOf(arrow.PrimInt32(), 3).(*arrow.FixedSizeListType)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "code", Type: listType, Nullable: false},
+	}, nil)
+
+	writer, err := NewWriter(filename, schema, DefaultSerializationOptions())
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	var expected []int32
+	for batchNum := 0; batchNum < 3; batchNum++ {
+		values := make([]int32, 4*listType.Size())
+		for i := range values {
+			values[i] = math.MaxInt32 - int32(batchNum*1000+i)
+			if i%2 == 1 {
+				values[i] = -values[i]
+			}
+		}
+		expected = append(expected, values...)
+
+		array := arrow.NewFixedSizeListArray(listType, arrow.NewInt32Array(values, nil), nil)
+		batch, err := arrow.NewRecordBatch(schema, array.Len(), []arrow.Array{array})
+		if err != nil {
+			t.Fatalf("NewRecordBatch failed: %v", err)
+		}
+		if err := writer.WriteRecordBatch(batch); err != nil {
+			t.Fatalf("WriteRecordBatch %d failed: %v", batchNum, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failed: %v", err)
+	}
+
+	reader, err := NewReader(filename)
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	resultBatch, err := reader.ReadRecordBatch()
+	if err != nil {
+		t.Fatalf("ReadRecordBatch failed: %v", err)
+	}
+
+	resultArray := resultBatch.Column(0).(*arrow.FixedSizeListArray)
+	if resultArray.Len() != 12 {
+		t.Fatalf("expected 12 lists, got %d", resultArray.Len())
+	}
+	values, ok := resultArray.Values().(*arrow.Int32Array)
+	if !ok {
+		t.Fatalf("expected int32 values, got %T", resultArray.Values())
+	}
+	if fmt.Sprint(values.Values()) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, values.Values())
+	}
+}
+
+func TestWriterReader_Float16VectorColumn(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test_float16.lance")
+
+	dim := 6
+	listType := arrow.Float16VectorType(dim).(*arrow.FixedSizeListType)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimInt32(), Nullable: false},
+		{Name: "vector", Type: listType, Nullable: false},
+	}, nil)
+
+	writer, err := NewWriter(filename, schema, DefaultSerializationOptions())
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	// Odd row counts leave the values of later pages at odd offsets unless the writer aligns them
+	var expected []arrow.Float16
+	rows := 0
+	for batchNum, numRows := range []int{3, 5} {
+		ids := make([]int32, numRows)
+		values := make([]float32, numRows*dim)
+		for i := range ids {
+			ids[i] = int32(rows + i)
+		}
+		for i := range values {
+			values[i] = float32(batchNum*100+i) / 7
+		}
+		rows += numRows
+		halves := arrow.Float16sFromFloat32(nil, values)
+		expected = append(expected, halves...)
+
+		batch, err := arrow.NewRecordBatch(schema, numRows, []arrow.Array{
+			arrow.NewInt32Array(ids, nil),
+			arrow.NewFixedSizeListArray(listType, arrow.NewFloat16Array(halves, nil), nil),
+		})
+		if err != nil {
+			t.Fatalf("NewRecordBatch failed: %v", err)
+		}
+		if err := writer.WriteRecordBatch(batch); err != nil {
+			t.Fatalf("WriteRecordBatch %d failed: %v", batchNum, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failed: %v", err)
+	}
+
+	for _, open := range []func(string) (*Reader, error){NewReader, NewMappedReader} {
+		reader, err := open(filename)
+		if err != nil {
+			t.Fatalf("open reader failed: %v", err)
+		}
+
+		resultBatch, err := reader.ReadRecordBatch()
+		if err != nil {
+			t.Fatalf("ReadRecordBatch failed: %v", err)
+		}
+		resultArray := resultBatch.Column(1).(*arrow.FixedSizeListArray)
+		if resultArray.Len() != rows {
+			t.Fatalf("expected %d vectors, got %d", rows, resultArray.Len())
+		}
+		values, ok := resultArray.Values().(*arrow.Float16Array)
+		if !ok {
+			t.Fatalf("expected float16 values, got %T", resultArray.Values())
+		}
+		if fmt.Sprint(values.Values()) != fmt.Sprint(expected) {
+			t.Errorf("expected %v, got %v", expected, values.Values())
+		}
+		reader.Close()
+	}
+}
+
 // ====================
 // Writer/Reader Integration Tests
 // ====================
@@ -488,6 +834,113 @@ func TestWriterReader_VectorColumn(t *testing.T) {
 	}
 }
 
+func TestWriterReader_MappedReader(t *testing.T) {
+	tmpDir := t.TempDir()
+	filename := filepath.Join(tmpDir, "test_mapped.lance")
+
+	dim := 5
+	listType := arrow.FixedSizeListOf(arrow.PrimFloat32(), dim)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimInt32(), Nullable: true},
+		{Name: "name", Type: arrow.PrimString(), Nullable: false},
+		{Name: "score", Type: arrow.PrimFloat64(), Nullable: false},
+		{Name: "embedding", Type: listType, Nullable: false},
+		{Name: "neighbors", Type: arrow.ListOf(arrow.PrimInt32()), Nullable: false},
+		{Name: "half", Type: arrow.Float16VectorType(dim), Nullable: false},
+	}, nil)
+
+	numRows := 7
+	ids := make([]int32, numRows)
+	names := make([]string, numRows)
+	scores := make([]float64, numRows)
+	values := make([]float32, numRows*dim)
+	nulls := arrow.NewBitmap(numRows)
+	for i := 0; i < numRows; i++ {
+		ids[i] = int32(i * 3)
+		names[i] = fmt.Sprintf("row-%d", i)
+		scores[i] = float64(i) / 4
+		if i != 2 {
+			nulls.Set(i)
+		}
+	}
+	for i := range values {
+		values[i] = float32(i) * 0.5
+	}
+	offsets := []int32{0}
+	var neighbors []int32
+	for i := 0; i < numRows; i++ {
+		for j := 0; j < i%3; j++ {
+			neighbors = append(neighbors, int32(i+j))
+		}
+		offsets = append(offsets, int32(len(neighbors)))
+	}
+
+	columns := []arrow.Array{
+		arrow.NewInt32Array(ids, nulls),
+		arrow.NewStringArray(names, nil),
+		arrow.NewFloat64Array(scores, nil),
+		arrow.NewFixedSizeListArray(listType.(*arrow.FixedSizeListType), arrow.NewFloat32Array(values, nil), nil),
+		arrow.NewListArray(arrow.ListOf(arrow.PrimInt32()).(*arrow.ListType), offsets, arrow.NewInt32Array(neighbors, nil), nil),
+		arrow.NewFixedSizeListArray(arrow.Float16VectorType(dim).(*arrow.FixedSizeListType), arrow.NewFloat16Array(arrow.Float16sFromFloat32(nil, values), nil), nil),
+	}
+	batch, err := arrow.NewRecordBatch(schema, numRows, columns)
+	if err != nil {
+		t.Fatalf("NewRecordBatch failed: %v", err)
+	}
+
+	writer, err := NewWriter(filename, schema, DefaultSerializationOptions())
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := writer.WriteRecordBatch(batch); err != nil {
+		t.Fatalf("WriteRecordBatch failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close writer failed: %v", err)
+	}
+
+	reader, err := NewMappedReader(filename)
+	if err != nil {
+		t.Fatalf("NewMappedReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	resultBatch, err := reader.ReadRecordBatch()
+	if err != nil {
+		t.Fatalf("ReadRecordBatch failed: %v", err)
+	}
+	for i, expected := range columns {
+		if !arraysEqual(expected, resultBatch.Column(i)) {
+			t.Errorf("column %d mismatch", i)
+		}
+	}
+
+	// Writer aligns fixed-width values, so they are views of the mapping
+	inMapping := func(p unsafe.Pointer) bool {
+		start := uintptr(unsafe.Pointer(&reader.data[0]))
+		return uintptr(p) >= start && uintptr(p) < start+uintptr(len(reader.data))
+	}
+	vectors := resultBatch.Column(3).(*arrow.FixedSizeListArray).Values().(*arrow.Float32Array).Values()
+	if !inMapping(unsafe.Pointer(&vectors[0])) {
+		t.Error("expected vector values to reference the mapping")
+	}
+	scoreValues := resultBatch.Column(2).(*arrow.Float64Array).Values()
+	if !inMapping(unsafe.Pointer(&scoreValues[0])) {
+		t.Error("expected float64 values to reference the mapping")
+	}
+	halfValues := resultBatch.Column(5).(*arrow.FixedSizeListArray).Values().(*arrow.Float16Array).Values()
+	if !inMapping(unsafe.Pointer(&halfValues[0])) {
+		t.Error("expected float16 vector values to reference the mapping")
+	}
+	neighborValues := resultBatch.Column(4).(*arrow.ListArray).Values().(*arrow.Int32Array).Values()
+	if !inMapping(unsafe.Pointer(&neighborValues[0])) {
+		t.Error("expected list values to reference the mapping")
+	}
+	if !resultBatch.Column(0).IsNull(2) {
+		t.Error("expected null bitmap to be read")
+	}
+}
+
 // ====================
 // Multi-Page Tests
 // ====================
@@ -818,6 +1271,16 @@ func arraysEqual(a, b arrow.Array) bool {
 				return false
 			}
 		}
+	case *arrow.Float16Array:
+		barr := b.(*arrow.Float16Array)
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) != b.IsValid(i) {
+				return false
+			}
+			if a.IsValid(i) && arr.Value(i) != barr.Value(i) {
+				return false
+			}
+		}
 	case *arrow.Float64Array:
 		barr := b.(*arrow.Float64Array)
 		for i := 0; i < a.Len(); i++ {
@@ -835,6 +1298,33 @@ func arraysEqual(a, b arrow.Array) bool {
 		}
 		// Compare child arrays
 		return arraysEqual(arr.Values(), barr.Values())
+	case *arrow.ListArray:
+		barr := b.(*arrow.ListArray)
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) != b.IsValid(i) {
+				return false
+			}
+			start, end := arr.ValueOffsets(i)
+			bstart, bend := barr.ValueOffsets(i)
+			if end-start != bend-bstart {
+				return false
+			}
+			for j := int32(0); j < end-start; j++ {
+				if arr.Values().(*arrow.Int32Array).Value(int(start+j)) != barr.Values().(*arrow.Int32Array).Value(int(bstart+j)) {
+					return false
+				}
+			}
+		}
+	case *arrow.StringArray:
+		barr := b.(*arrow.StringArray)
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) != b.IsValid(i) {
+				return false
+			}
+			if a.IsValid(i) && arr.Value(i) != barr.Value(i) {
+				return false
+			}
+		}
 	default:
 		return false
 	}
@@ -132,8 +132,14 @@ func (w *Writer) writeColumn(columnIndex int32, array arrow.Array) error {
 		return fmt.Errorf("create pages failed: %w", err)
 	}
 
+	dataType := w.header.Schema.Field(int(columnIndex)).Type
+
 	// Write each page and record metadata
 	for pageNum, page := range pages {
+		if err := w.alignPage(page, dataType); err != nil {
+			return fmt.Errorf("write page padding failed: %w", err)
+		}
+
 		// Record current position (relative to file start)
 		pageOffset := w.currentPos
 
@@ -159,6 +165,26 @@ func (w *Writer) writeColumn(columnIndex int32, array arrow.Array) error {
 	return nil
 }
 
+// alignPage pads the file so that the fixed-width values of page start at a
+// multiple of 8 bytes, letting mapped readers reference them in place.
+// Readers locate pages through the footer and never see the padding.
+func (w *Writer) alignPage(page *format.Page, dataType arrow.DataType) error {
+	offset := valuesOffset(page.Data, dataType)
+	if offset < 0 {
+		return nil
+	}
+
+	padding := (8 - (w.currentPos+format.PageHeaderSize+int64(offset))%8) % 8
+	if padding == 0 {
+		return nil
+	}
+	if _, err := w.file.Write(make([]byte, padding)); err != nil {
+		return err
+	}
+	w.currentPos += padding
+	return nil
+}
+
 // Close finalizes the file by writing header and footer
 func (w *Writer) Close() error {
 	if w.closed {
@@ -213,6 +213,8 @@ func serializeTypeName(dt arrow.DataType) string {
 		return "int32"
 	case *arrow.Int64Type:
 		return "int64"
+	case *arrow.Float16Type:
+		return "float16"
 	case *arrow.Float32Type:
 		return "float32"
 	case *arrow.Float64Type:
@@ -278,6 +280,8 @@ func parseDataType(typeStr string) (arrow.DataType, error) {
 		return arrow.PrimInt32(), nil
 	case "int64":
 		return arrow.PrimInt64(), nil
+	case "float16":
+		return arrow.PrimFloat16(), nil
 	case "float32":
 		return arrow.PrimFloat32(), nil
 	case "float64":
@@ -293,6 +297,15 @@ func parseDataType(typeStr string) (arrow.DataType, error) {
 		return parseFixedSizeListType(typeStr)
 	}
 
+	// Handle List (e.g., "list<int32>")
+	if strings.HasPrefix(typeStr, "list<") && strings.HasSuffix(typeStr, ">") {
+		elemType, err := parseDataType(typeStr[len("list<") : len(typeStr)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid element type: %w", err)
+		}
+		return arrow.ListOf(elemType), nil
+	}
+
 	return nil, fmt.Errorf("unsupported type: %s", typeStr)
 }
 
@@ -461,6 +461,9 @@ func TestAllDataTypes(t *testing.T) {
 		arrow.NewField("binary_field", arrow.PrimBinary(), false),
 		arrow.NewField("string_field", arrow.PrimString(), false),
 		arrow.NewField("vector_field", arrow.FixedSizeListOf(arrow.PrimFloat32(), 768), false),
+		arrow.NewField("list_field", arrow.ListOf(arrow.PrimInt32()), false),
+		arrow.NewField("float16_field", arrow.PrimFloat16(), false),
+		arrow.NewField("float16_vector_field", arrow.Float16VectorType(384), false),
 	}
 
 	schema := arrow.NewSchema(fields, nil)
@@ -487,6 +490,9 @@ func TestAllDataTypes(t *testing.T) {
 		arrow.BINARY,
 		arrow.STRING,
 		arrow.FIXED_SIZE_LIST,
+		arrow.LIST,
+		arrow.FLOAT16,
+		arrow.FIXED_SIZE_LIST,
 	}
 
 	for i, expected := range expectedTypes {
@@ -495,4 +501,10 @@ func TestAllDataTypes(t *testing.T) {
 			t.Errorf("Field %d type mismatch: got %v, want %v", i, actual, expected)
 		}
 	}
+	if elem := deserialized.Schema.Field(7).Type.(*arrow.ListType).Elem(); elem.ID() != arrow.INT32 {
+		t.Errorf("List element type mismatch: got %v, want %v", elem.ID(), arrow.INT32)
+	}
+	if elem := deserialized.Schema.Field(9).Type.(*arrow.FixedSizeListType).Elem(); elem.ID() != arrow.FLOAT16 {
+		t.Errorf("Vector element type mismatch: got %v, want %v", elem.ID(), arrow.FLOAT16)
+	}
 }
@@ -154,6 +154,33 @@ func (p *Page) ReadFrom(r io.Reader) (int64, error) {
 	return int64(n + dataRead), nil
 }
 
+// PageView parses the page at the start of data without copying it: the Data
+// of the returned page aliases data. The checksum is not verified, so readers
+// of memory-mapped files do not touch every page when opening them; call
+// Validate to verify it.
+func PageView(data []byte) (*Page, error) {
+	if len(data) < PageHeaderSize {
+		return nil, NewFileError("read page header", io.ErrUnexpectedEOF)
+	}
+
+	p := &Page{
+		Type:             PageType(data[0]),
+		Encoding:         EncodingType(data[1]),
+		ColumnIndex:      int32(ByteOrder.Uint32(data[2:])),
+		NumValues:        int32(ByteOrder.Uint32(data[6:])),
+		UncompressedSize: int32(ByteOrder.Uint32(data[10:])),
+		CompressedSize:   int32(ByteOrder.Uint32(data[14:])),
+		Checksum:         ByteOrder.Uint32(data[18:]),
+	}
+	if p.CompressedSize < 0 || int(p.CompressedSize) > len(data)-PageHeaderSize {
+		return nil, NewFileError("read page data", io.ErrUnexpectedEOF)
+	}
+
+	end := PageHeaderSize + int(p.CompressedSize)
+	p.Data = data[PageHeaderSize:end:end]
+	return p, nil
+}
+
 // PageIndex represents an index entry for a page
 type PageIndex struct {
 	ColumnIndex int32 // Column index
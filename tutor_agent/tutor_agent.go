@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"math"
 	"ollama-demo/hnsw"
+	_ "ollama-demo/ivf" // 注册 IVF 索引类型
+	"ollama-demo/lance/arrow"
 	"os"
 	"path/filepath"
 	"strings"
@@ -35,13 +37,13 @@ const (
 )
 
 // 添加持久化元数据结构
+// 支持 payload 的索引把文档块内容存在索引里，此时不再写 DocumentChunks
 type PersistentMetadata struct {
-	DocumentChunks     []DocumentChunk   `json:"document_chunks"`
-	NodeIDToChunkIndex map[int]int       `json:"node_id_to_chunk_index"`
-	DocumentContents   map[string]string `json:"document_contents"`
-	Dimension          int               `json:"dimension"`
-	CreatedAt          string            `json:"created_at"`
-	UpdatedAt          string            `json:"updated_at"`
+	DocumentChunks   []DocumentChunk   `json:"document_chunks,omitempty"`
+	DocumentContents map[string]string `json:"document_contents"`
+	Dimension        int               `json:"dimension"`
+	CreatedAt        string            `json:"created_at"`
+	UpdatedAt        string            `json:"updated_at"`
 }
 
 type DocumentChunk struct {
@@ -51,6 +53,39 @@ type DocumentChunk struct {
 	Metadata map[string]string // 元数据
 }
 
+// chunkPayloadSchema 是文档块随向量存入索引的 payload 字段
+func chunkPayloadSchema() *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		arrow.NewField("content", arrow.PrimString(), false),
+		arrow.NewField("source", arrow.PrimString(), false),
+		arrow.NewField("chunk_id", arrow.PrimInt64(), false),
+	}, nil)
+}
+
+// chunkPayload 把文档块转成索引 payload
+func chunkPayload(chunk DocumentChunk) hnsw.Payload {
+	return hnsw.Payload{
+		"content":  chunk.Content,
+		"source":   chunk.Source,
+		"chunk_id": int64(chunk.ChunkID),
+	}
+}
+
+// chunkFromPayload 从检索结果的 payload 还原文档块
+func chunkFromPayload(payload hnsw.Payload) DocumentChunk {
+	content, _ := payload["content"].(string)
+	source, _ := payload["source"].(string)
+	chunkID, _ := payload["chunk_id"].(int64)
+	return DocumentChunk{
+		Content: content,
+		Source:  source,
+		ChunkID: int(chunkID),
+		Metadata: map[string]string{
+			"source": source,
+		},
+	}
+}
+
 // Tutor agent state definition
 type TutorState struct {
 	// Documents contents
@@ -59,15 +94,12 @@ type TutorState struct {
 	// Document chunks and vector index
 	DocumentChunks []DocumentChunk
 
-	// HNSW Vector Index
-	VectorIndex *hnsw.HNSWIndex
+	// Vector Index, HNSW by default
+	VectorIndex hnsw.VectorIndex
 
 	// Embedding model
 	Embedder embeddings.Embedder
 
-	// 新增：NodeID 到 Chunk 索引的映射
-	NodeIDToChunkIndex map[int]int
-
 	// Generated by the Agent
 	DocumentSummary string
 
@@ -94,6 +126,7 @@ type TutorAgent struct {
 	scanner   *bufio.Scanner
 	dimension int    // Embedding dimension
 	dataDir   string // 新增：数据目录
+	indexType string // 向量索引类型，见 hnsw.NewIndex
 }
 
 func NewTutorAgent(llmType LLMType) (*TutorAgent, error) {
@@ -161,6 +194,7 @@ func newOllamaTutorAgentWithDataDir(dataDir string) (*TutorAgent, error) {
 		scanner:   bufio.NewScanner(os.Stdin),
 		dimension: 768,
 		dataDir:   dataDir, // 保存数据目录
+		indexType: hnsw.IndexTypeHNSW,
 	}
 
 	if err := agent.buildGraph(); err != nil {
@@ -170,6 +204,12 @@ func newOllamaTutorAgentWithDataDir(dataDir string) (*TutorAgent, error) {
 	return agent, nil
 }
 
+// SetIndexType 设置新建向量索引的类型（如 hnsw.IndexTypeHNSW、hnsw.IndexTypeFlat、"ivf"），
+// 加载已保存的索引时类型从元数据读取，不受此设置影响
+func (t *TutorAgent) SetIndexType(indexType string) {
+	t.indexType = indexType
+}
+
 // 保持向后兼容
 func newOllamaTutorAgent() (*TutorAgent, error) {
 	return newOllamaTutorAgentWithDataDir(DefaultDataDir)
@@ -180,12 +220,14 @@ func (t *TutorAgent) saveMetadata(state TutorState) error {
 	metadataPath := filepath.Join(state.DataDir, MetadataFile)
 
 	metadata := PersistentMetadata{
-		DocumentChunks:     state.DocumentChunks,
-		NodeIDToChunkIndex: state.NodeIDToChunkIndex,
-		DocumentContents:   state.DocumentContents,
-		Dimension:          t.dimension,
-		CreatedAt:          time.Now().Format(time.RFC3339),
-		UpdatedAt:          time.Now().Format(time.RFC3339),
+		DocumentContents: state.DocumentContents,
+		Dimension:        t.dimension,
+		CreatedAt:        time.Now().Format(time.RFC3339),
+		UpdatedAt:        time.Now().Format(time.RFC3339),
+	}
+	// 文档块已经作为 payload 存进索引的就不再重复写入 JSON
+	if _, ok := state.VectorIndex.(hnsw.PayloadIndex); !ok {
+		metadata.DocumentChunks = state.DocumentChunks
 	}
 
 	data, err := json.MarshalIndent(metadata, "", "  ")
@@ -226,10 +268,9 @@ func (t *TutorAgent) hasPersistedData(dataDir string) bool {
 	hnswDir := filepath.Join(dataDir, HNSWSubDir)
 
 	// 检查元数据文件和HNSW目录是否都存在
-	_, err1 := os.Stat(metadataPath)
-	_, err2 := os.Stat(filepath.Join(hnswDir, "nodes.lance"))
+	_, err := os.Stat(metadataPath)
 
-	return err1 == nil && err2 == nil
+	return err == nil && hnsw.IndexExists(hnswDir)
 }
 
 func (t *TutorAgent) buildGraph() error {
@@ -300,7 +341,7 @@ func (t *TutorAgent) loadDocuments(ctx context.Context, state TutorState) (Tutor
 					fmt.Printf("⚠️  加载数据失败: %v\n", err)
 					fmt.Println("将重新创建索引...")
 				} else {
-					fmt.Printf("✅ 成功加载 %d 个文档块\n", len(state.DocumentChunks))
+					fmt.Printf("✅ 成功加载 %d 个文档块\n", state.VectorIndex.Len())
 					fmt.Println("💡 提示：输入 'quit' 或 'exit' 可以退出")
 					state.Stage = "documents_loaded"
 					state.Embedder = t.embedder
@@ -359,25 +400,23 @@ func (t *TutorAgent) loadPersistedData(ctx context.Context, state *TutorState) e
 		return fmt.Errorf("元数据不存在")
 	}
 
-	// 2. 恢复文档块和映射
+	// 2. 恢复文档块（新数据的文档块在索引 payload 中，这里可能为空）
 	state.DocumentChunks = metadata.DocumentChunks
-	state.NodeIDToChunkIndex = metadata.NodeIDToChunkIndex
 	state.DocumentContents = metadata.DocumentContents
 
-	// 3. 加载 HNSW 索引
+	// 3. 加载向量索引，索引类型从保存的元数据中读取
 	hnswDir := filepath.Join(state.DataDir, HNSWSubDir)
-	loadedIndex, err := hnsw.LoadHNSWFromLance(hnswDir)
+	loadedIndex, err := hnsw.LoadIndex(hnswDir)
 	if err != nil {
-		return fmt.Errorf("加载 HNSW 索引失败: %v", err)
+		return fmt.Errorf("加载向量索引失败: %v", err)
 	}
 	state.VectorIndex = loadedIndex
 
 	// 4. 设置 embedder
 	state.Embedder = t.embedder
 
-	fmt.Printf("   📊 文档块: %d 个\n", len(state.DocumentChunks))
+	fmt.Printf("   📊 文档块: %d 个\n", state.VectorIndex.Len())
 	fmt.Printf("   🗂️  文档: %d 个\n", len(state.DocumentContents))
-	fmt.Printf("   🔗 映射关系: %d 条\n", len(state.NodeIDToChunkIndex))
 
 	return nil
 }
@@ -443,21 +482,27 @@ func (t *TutorAgent) chunkText(text string, chunkSize int, overlap int) []string
 func (t *TutorAgent) vectorizeDocuments(ctx context.Context, state TutorState) (TutorState, error) {
 	fmt.Println("\n🔄 正在处理文档...")
 
-	// 创建 HNSW 索引
-	state.VectorIndex = hnsw.NewHNSW(hnsw.Config{
+	// 创建向量索引，类型由 t.indexType 决定
+	index, err := hnsw.NewIndex(t.indexType, hnsw.Config{
 		M:              16,
 		EfConstruction: 200,
 		Dimension:      t.dimension,
-		DistanceFunc:   hnsw.CosineDistance,
+		DistanceName:   hnsw.DistanceCosine,
+		PayloadSchema:  chunkPayloadSchema(),
 	})
-	fmt.Printf("✅ 创建向量索引 (dimension=%d)\n", t.dimension)
+	if err != nil {
+		return state, fmt.Errorf("创建向量索引失败: %v", err)
+	}
+	state.VectorIndex = index
+	fmt.Printf("✅ 创建向量索引 (type=%s, dimension=%d)\n", t.indexType, t.dimension)
 
 	// 切块参数
 	chunkSize := 500
 	overlap := 50
 
-	state.DocumentChunks = []DocumentChunk{}
-	nodeIDToChunkIndex := make(map[int]int)
+	// 先向量化所有块，再统一加入索引；需要训练的索引（如 IVF）在加入时自动训练
+	var pendingVectors [][]float32
+	var pendingChunks []DocumentChunk
 	chunkID := 0
 
 	// 处理每个文档（保持原有逻辑）
@@ -490,25 +535,15 @@ func (t *TutorAgent) vectorizeDocuments(ctx context.Context, state TutorState) (
 				vector32[j] = float32(v)
 			}
 
-			nodeID, err := state.VectorIndex.Add(vector32)
-			if err != nil {
-				fmt.Printf("⚠️  块 %d 添加到索引失败: %v\n", i, err)
-				continue
-			}
-
-			chunkIndex := len(state.DocumentChunks)
-			nodeIDToChunkIndex[nodeID] = chunkIndex
-
-			chunk := DocumentChunk{
+			pendingVectors = append(pendingVectors, vector32)
+			pendingChunks = append(pendingChunks, DocumentChunk{
 				Content: chunkText,
 				Source:  filepath.Base(path),
 				ChunkID: chunkID,
 				Metadata: map[string]string{
 					"source": filepath.Base(path),
-					"nodeID": fmt.Sprintf("%d", nodeID),
 				},
-			}
-			state.DocumentChunks = append(state.DocumentChunks, chunk)
+			})
 			chunkID++
 
 			if (i+1)%10 == 0 || i == len(chunks)-1 {
@@ -517,7 +552,33 @@ func (t *TutorAgent) vectorizeDocuments(ctx context.Context, state TutorState) (
 		}
 	}
 
-	state.NodeIDToChunkIndex = nodeIDToChunkIndex
+	// 文档块在 DocumentChunks 中的下标作为外部键存入索引，检索时直接从结果中取回；
+	// 支持 payload 的索引同时保存文档块内容
+	keyed, hasKeys := state.VectorIndex.(hnsw.KeyedIndex)
+	payloads, hasPayloads := state.VectorIndex.(hnsw.PayloadIndex)
+	state.DocumentChunks = []DocumentChunk{}
+	for i, vector32 := range pendingVectors {
+		chunkIndex := len(state.DocumentChunks)
+
+		var nodeID int
+		var err error
+		switch {
+		case hasPayloads:
+			nodeID, err = payloads.AddWithPayload(hnsw.IntKey(int64(chunkIndex)), vector32, chunkPayload(pendingChunks[i]))
+		case hasKeys:
+			nodeID, err = keyed.AddWithKey(hnsw.IntKey(int64(chunkIndex)), vector32)
+		default:
+			nodeID, err = state.VectorIndex.Add(vector32)
+		}
+		if err != nil {
+			fmt.Printf("⚠️  块 %d 添加到索引失败: %v\n", i, err)
+			continue
+		}
+
+		chunk := pendingChunks[i]
+		chunk.Metadata["nodeID"] = fmt.Sprintf("%d", nodeID)
+		state.DocumentChunks = append(state.DocumentChunks, chunk)
+	}
 
 	fmt.Printf("\n✅ 向量化完成！总共处理 %d 个文档块\n", len(state.DocumentChunks))
 
@@ -553,8 +614,8 @@ func (t *TutorAgent) savePersistedData(state TutorState) error {
 		return fmt.Errorf("创建 HNSW 目录失败: %v", err)
 	}
 
-	if err := state.VectorIndex.SaveToLance(hnswDir); err != nil {
-		return fmt.Errorf("保存 HNSW 索引失败: %v", err)
+	if err := state.VectorIndex.Save(hnswDir); err != nil {
+		return fmt.Errorf("保存向量索引失败: %v", err)
 	}
 
 	return nil
@@ -610,7 +671,7 @@ func (t *TutorAgent) analyzeDocuments(ctx context.Context, state TutorState) (Tu
 2. 列出文档中的重点知识点
 3. 说明你将如何帮助学习者理解这些内容
 
-请用友好、易懂的语言回复。`, len(state.DocumentChunks))
+请用友好、易懂的语言回复。`, state.VectorIndex.Len())
 
 	messages := []llms.MessageContent{
 		llms.TextParts(llms.ChatMessageTypeHuman, analysisPrompt),
@@ -683,12 +744,12 @@ func (t *TutorAgent) analyzeDocuments(ctx context.Context, state TutorState) (Tu
 3. 引导学习者思考和探索
 4. 用清晰、友好的语言交流
 
-请基于提供的上下文回答问题。`, len(state.DocumentChunks))),
+请基于提供的上下文回答问题。`, state.VectorIndex.Len())),
 		llms.TextParts(llms.ChatMessageTypeAI, state.DocumentSummary),
 	}
 
 	fmt.Println("\n" + strings.Repeat("=", 60))
-	fmt.Printf("✅ 分析完成！文档已准备就绪（%d 个向量块）\n", len(state.DocumentChunks))
+	fmt.Printf("✅ 分析完成！文档已准备就绪（%d 个向量块）\n", state.VectorIndex.Len())
 	fmt.Println(strings.Repeat("=", 60))
 	fmt.Println("\n💡 提示：输入 'quit' 或 'exit' 可以退出")
 
@@ -712,7 +773,7 @@ func (t *TutorAgent) retrieveRelevantChunks(ctx context.Context, state TutorStat
 	}
 
 	// 在 HNSW 索引中搜索
-	results, err := state.VectorIndex.Search(queryVector32, topK, 100)
+	results, err := state.VectorIndex.Search(queryVector32, topK, 0)
 	if err != nil {
 		return nil, fmt.Errorf("向量搜索失败: %v", err)
 	}
@@ -720,20 +781,30 @@ func (t *TutorAgent) retrieveRelevantChunks(ctx context.Context, state TutorStat
 	// 获取对应的文档块
 	var relevantChunks []DocumentChunk
 	for _, result := range results {
-		// ✨ 使用映射表获取正确的索引
-		chunkIndex, exists := state.NodeIDToChunkIndex[result.ID]
-		if !exists {
-			fmt.Printf("⚠️  警告：NodeID %d 在映射表中不存在\n", result.ID)
+		similarity := 1.0 - result.Distance/2.0
+
+		// 索引中存有 payload 时直接用它还原文档块
+		if result.Payload != nil {
+			chunk := chunkFromPayload(result.Payload)
+			chunk.Metadata["nodeID"] = fmt.Sprintf("%d", result.ID)
+			chunk.Metadata["similarity"] = fmt.Sprintf("%.4f", similarity)
+			relevantChunks = append(relevantChunks, chunk)
 			continue
 		}
 
+		// 外部键就是文档块下标。没有键的索引（旧数据或不支持键的索引类型）
+		// 按添加顺序分配 ID，与文档块下标一致
+		chunkIndex := result.ID
+		if key, ok := result.Key.Int(); ok {
+			chunkIndex = int(key)
+		}
+
 		if chunkIndex >= 0 && chunkIndex < len(state.DocumentChunks) {
-			chunk := state.DocumentChunks[chunkIndex] // ⭐ 使用映射后的索引
-			similarity := 1.0 - result.Distance/2.0
+			chunk := state.DocumentChunks[chunkIndex]
 			chunk.Metadata["similarity"] = fmt.Sprintf("%.4f", similarity)
 			relevantChunks = append(relevantChunks, chunk)
 		} else {
-			fmt.Printf("⚠️  警告：映射后的索引 %d 超出范围 [0, %d)\n", chunkIndex, len(state.DocumentChunks))
+			fmt.Printf("⚠️  警告：文档块下标 %d 超出范围 [0, %d)\n", chunkIndex, len(state.DocumentChunks))
 		}
 	}
 
@@ -998,14 +1069,13 @@ func (t *TutorAgent) Run() error {
 
 	// 初始化状态
 	initialState := TutorState{
-		DocumentContents:   make(map[string]string),
-		DocumentChunks:     []DocumentChunk{},
-		NodeIDToChunkIndex: make(map[int]int),
-		Messages:           []llms.MessageContent{},
-		ShouldContinue:     true,
-		Stage:              "init",
-		VectorIndex:        nil,
-		Embedder:           nil,
+		DocumentContents: make(map[string]string),
+		DocumentChunks:   []DocumentChunk{},
+		Messages:         []llms.MessageContent{},
+		ShouldContinue:   true,
+		Stage:            "init",
+		VectorIndex:      nil,
+		Embedder:         nil,
 	}
 
 	_, err := t.graph.Invoke(ctx, initialState)